@@ -0,0 +1,151 @@
+package smd
+
+import (
+	"math"
+	"time"
+
+	"github.com/ChristopherRabotin/ode"
+)
+
+// MeanOrbit is an ode.Integrable which propagates the mean elements of an orbit with the averaged zonal
+// perturbations: J2 and J4 secular effects, and J3 and J4 long period effects (i.e. semi-analytic propagation).
+// The short period terms are only added back when converting to an osculating orbit, so large time steps
+// (e.g. several hours) can be used.
+type MeanOrbit struct {
+	Origin           CelestialObject // Central body of the orbit
+	Jn               uint8           // Highest zonal harmonic to account for (only up to 4 supported)
+	StopDT           time.Time       // end time of the integration
+	a, e, i, Ω, ω, M float64         // mean elements (angles in radians)
+	dt               time.Time       // current time of the integration
+	step             time.Duration   // time step
+}
+
+// GetState gets the state.
+func (m *MeanOrbit) GetState() []float64 {
+	return []float64{m.a, m.e, m.i, m.Ω, m.ω, m.M}
+}
+
+// SetState sets the next state at time t.
+func (m *MeanOrbit) SetState(t float64, s []float64) {
+	m.a = s[0]
+	m.e = s[1]
+	m.i = s[2]
+	m.Ω = math.Mod(s[3]+2*math.Pi, 2*math.Pi)
+	m.ω = math.Mod(s[4]+2*math.Pi, 2*math.Pi)
+	m.M = math.Mod(s[5]+2*math.Pi, 2*math.Pi)
+	m.dt = m.dt.Add(m.step)
+}
+
+// Stop returns whether we should stop the integration.
+func (m *MeanOrbit) Stop(t float64) bool {
+	return !m.dt.Before(m.StopDT)
+}
+
+// Func returns the averaged rates of the mean elements via the Lagrange planetary equations.
+// The averaged disturbing potential of each zonal is from Kaula (1966), truncated to first order in each Jn.
+func (m *MeanOrbit) Func(t float64, f []float64) (fDot []float64) {
+	fDot = make([]float64, 6)
+	a := f[0]
+	e := math.Max(f[1], eccentricityε) // The Lagrange equations are singular for circular orbits.
+	i := f[2]
+	ω := f[4]
+	μ := m.Origin.μ
+	n := math.Sqrt(μ / math.Pow(a, 3))
+	η2 := 1 - e*e
+	η := math.Sqrt(η2)
+	s, c := math.Sincos(i)
+	s = math.Max(s, math.Sin(angleε)) // Same for equatorial orbits.
+	s2 := s * s
+	sinω, cosω := math.Sincos(ω)
+	sin2ω, cos2ω := math.Sincos(2 * ω)
+	// Partials of the averaged disturbing potential with respect to a, e, i and ω.
+	var dRda, dRde, dRdi, dRdω float64
+	if m.Jn > 1 {
+		// J2 secular
+		k2 := μ * m.Origin.J2 * math.Pow(m.Origin.Radius, 2) / (math.Pow(a, 3) * η2 * η)
+		R2 := k2 * (0.5 - 0.75*s2)
+		dRda += -3 * R2 / a
+		dRde += R2 * 3 * e / η2
+		dRdi += -k2 * 1.5 * s * c
+	}
+	if m.Jn > 2 {
+		// J3 long period
+		k3 := 1.5 * μ * m.Origin.J3 * math.Pow(m.Origin.Radius, 3) / (math.Pow(a, 4) * math.Pow(η, 5))
+		incl := s * (1 - 1.25*s2)
+		R3 := k3 * e * incl * sinω
+		dRda += -4 * R3 / a
+		dRde += k3 * incl * sinω * (1 + 5*e*e/η2)
+		dRdi += k3 * e * sinω * c * (1 - 3.75*s2)
+		dRdω += k3 * e * incl * cosω
+	}
+	if m.Jn > 3 {
+		// J4 secular and long period
+		k4 := μ * m.Origin.J4 * math.Pow(m.Origin.Radius, 4) / (math.Pow(a, 5) * math.Pow(η, 7))
+		secE := 1 + 1.5*e*e
+		secI := 1 - 5*s2 + 4.375*s2*s2
+		R4s := -0.375 * k4 * secE * secI
+		dRda += -5 * R4s / a
+		dRde += -0.375 * k4 * (3*e*secI + secE*secI*7*e/η2)
+		dRdi += -0.375 * k4 * secE * (-10*s*c + 17.5*s2*s*c)
+		lpI := s2 * (1 - 7/6.*s2)
+		R4l := -45 / 32. * k4 * e * e * lpI * cos2ω
+		dRda += -5 * R4l / a
+		dRde += -45 / 32. * k4 * lpI * cos2ω * (2*e + 7*e*e*e/η2)
+		dRdi += -45 / 32. * k4 * e * e * cos2ω * (2*s*c - 14/3.*s2*s*c)
+		dRdω += 45 / 16. * k4 * e * e * lpI * sin2ω
+	}
+	na2 := n * a * a
+	fDot[0] = 0 // No secular nor long period effect on the semi-major axis.
+	fDot[1] = -η / (na2 * e) * dRdω
+	fDot[2] = c / (na2 * η * s) * dRdω
+	fDot[3] = dRdi / (na2 * η * s)
+	fDot[4] = η/(na2*e)*dRde - c/(na2*η*s)*dRdi
+	fDot[5] = n - 2/(n*a)*dRda - η2/(na2*e)*dRde
+	return
+}
+
+// Elements returns the current mean elements a, e, i, Ω, ω, M (angles in radians).
+func (m *MeanOrbit) Elements() (a, e, i, Ω, ω, M float64) {
+	return m.a, m.e, m.i, m.Ω, m.ω, m.M
+}
+
+// Orbit returns the osculating orbit corresponding to the current mean elements.
+func (m *MeanOrbit) Orbit() *Orbit {
+	if m.Jn < 2 {
+		return NewOrbitFromOE(m.a, m.e, m.i*rad2deg, m.Ω*rad2deg, m.ω*rad2deg, Mean2TrueAnomaly(m.M, m.e)*rad2deg, m.Origin)
+	}
+	return NewOrbitFromMeanOE(m.a, m.e, m.i*rad2deg, m.Ω*rad2deg, m.ω*rad2deg, m.M*rad2deg, m.Origin)
+}
+
+// DT returns the current time of the integration.
+func (m *MeanOrbit) DT() time.Time {
+	return m.dt
+}
+
+// PropagateUntil propagates until the given time is reached.
+// The step is shortened if needed such that the propagation ends exactly at the requested time.
+func (m *MeanOrbit) PropagateUntil(dt time.Time) {
+	if !dt.After(m.dt) {
+		return
+	}
+	m.StopDT = dt
+	nSteps := math.Ceil(float64(dt.Sub(m.dt)) / float64(m.step))
+	step := m.step
+	m.step = time.Duration(float64(dt.Sub(m.dt)) / nSteps)
+	ode.NewRK4(0, m.step.Seconds(), m).Solve() // Blocking.
+	m.dt = dt                                  // Remove any rounding error of the shortened step.
+	m.step = step
+}
+
+// NewMeanOrbit returns a new MeanOrbit from the provided osculating orbit, accounting for zonals up to Jn.
+func NewMeanOrbit(o Orbit, Jn uint8, epoch time.Time, step time.Duration) *MeanOrbit {
+	var a, e, i, Ω, ω, M float64
+	if Jn < 2 {
+		var ν float64
+		a, e, i, Ω, ω, ν, _, _, _ = o.Elements()
+		M = True2MeanAnomaly(ν, e)
+	} else {
+		a, e, i, Ω, ω, M = o.MeanElements()
+	}
+	return &MeanOrbit{o.Origin, Jn, epoch, a, e, i, Ω, ω, M, epoch, step}
+}
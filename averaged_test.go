@@ -0,0 +1,93 @@
+package smd
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+)
+
+func TestMeanOrbitTwoBody(t *testing.T) {
+	o := NewOrbitFromOE(7000, 0.01, 50, 20, 30, 0, Earth)
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	mean := NewMeanOrbit(*o, 0, start, time.Hour)
+	end := start.Add(o.Period())
+	mean.PropagateUntil(end)
+	if !mean.DT().Equal(end) {
+		t.Fatalf("propagation ended at %s instead of %s", mean.DT(), end)
+	}
+	if ok, err := mean.Orbit().StrictlyEquals(*o); !ok {
+		t.Fatalf("two body propagation over one period changed the orbit: %s", err)
+	}
+}
+
+func TestMeanOrbitJ2(t *testing.T) {
+	o := NewOrbitFromOE(7000, 0.01, 50, 20, 30, 0, Earth)
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+	mean := NewMeanOrbit(*o, 2, start, time.Hour)
+	_, _, _, Ω0, ω0, _ := mean.Elements()
+	mean.PropagateUntil(end)
+	// Compare with a numerical propagation.
+	NewMission(NewEmptySC("mean", 0), o, start, end, Perturbations{Jn: 2}, false, ExportConfig{}).Propagate()
+	a, e, i, Ω, ω, _ := o.MeanElements()
+	ma, me, mi, mΩ, mω, _ := mean.Elements()
+	t.Logf("ΔΩ=%f deg\tΔω=%f deg", Rad2deg180(mΩ-Ω0), Rad2deg180(mω-ω0))
+	if floats.EqualWithinAbs(Ω0, mΩ, 1e-2) || floats.EqualWithinAbs(ω0, mω, 1e-2) {
+		t.Fatal("J2 should cause the nodes and apsides to drift")
+	}
+	if !floats.EqualWithinAbs(a, ma, 1e-1) {
+		t.Fatalf("a=%f\tmean a=%f", a, ma)
+	}
+	if !floats.EqualWithinAbs(e, me, 1e-5) {
+		t.Fatalf("e=%f\tmean e=%f", e, me)
+	}
+	if ok, err := anglesEqual(i, mi); !ok {
+		t.Fatalf("i: %s", err)
+	}
+	// The averaged theory is first order in J2, so allow for the second order drift over a day.
+	for k, pair := range [][]float64{{Ω, mΩ}, {ω, mω}} {
+		if δ := math.Abs(math.Remainder(pair[0]-pair[1], 2*math.Pi)); δ > 0.05*deg2rad {
+			t.Fatalf("angle #%d: difference of %f degrees", k, δ*rad2deg)
+		}
+	}
+}
+
+func TestMeanOrbitJ3(t *testing.T) {
+	// J3 causes a long period oscillation of the eccentricity, except for frozen orbits.
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	o := NewOrbitFromOE(7000, 0.01, 98, 20, 0, 0, Earth)
+	mean := NewMeanOrbit(*o, 3, start, 6*time.Hour)
+	_, e0, _, _, _, _ := mean.Elements()
+	mean.PropagateUntil(start.Add(10 * 24 * time.Hour))
+	_, e1, _, _, _, _ := mean.Elements()
+	if floats.EqualWithinAbs(e0, e1, 1e-6) {
+		t.Fatalf("J3 did not change the eccentricity: %f -> %f", e0, e1)
+	}
+}
+
+func TestMeanOrbitEquatorial(t *testing.T) {
+	// The Lagrange equations are singular for equatorial orbits, e.g. GEO, whose longitude drifts as a slightly
+	// inclined orbit does.
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+	var λs []float64
+	for _, i := range []float64{0, 0.01} {
+		mean := NewMeanOrbit(*NewOrbitFromOE(42164, 0.001, i, 0, 0, 0, Earth), 4, start, time.Hour)
+		mean.PropagateUntil(end)
+		a, e, mi, Ω, ω, M := mean.Elements()
+		for k, elt := range []float64{a, e, mi, Ω, ω, M} {
+			if math.IsNaN(elt) || math.IsInf(elt, 0) {
+				t.Fatalf("element #%d of i=%f is %f", k, i, elt)
+			}
+		}
+		if R := mean.Orbit().RNorm(); math.Abs(R-42164) > 100 {
+			t.Fatalf("radius of %f km for i=%f", R, i)
+		}
+		λs = append(λs, Ω+ω+M)
+	}
+	if δ := math.Abs(math.Remainder(λs[0]-λs[1], 2*math.Pi)); δ > 1e-2*deg2rad {
+		t.Fatalf("equatorial longitude differs by %f degrees", δ*rad2deg)
+	}
+}
@@ -0,0 +1,260 @@
+package smd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+/* Iterated batch least squares, cf. Tapley, Schutz and Born, "Statistical Orbit Determination", 2004, section 4.6.
+The normal equations are accumulated along the reference trajectory of a Mission with the STM from its start, and the
+reference trajectory is corrected by the estimated initial deviation until convergence. The partials with respect to
+the considered parameters are accumulated as well for the consider covariance (cf. consider.go). */
+
+// BLSCriterion defines the convergence criterion of the batch least squares.
+type BLSCriterion uint8
+
+const (
+	// BLSResidualsRMS converges when the relative change of the RMS of the weighted pre-fit residuals is below the
+	// tolerance.
+	BLSResidualsRMS BLSCriterion = iota + 1
+	// BLSCorrection converges when the norm of the position correction (in km) is below the tolerance.
+	BLSCorrection
+)
+
+func (c BLSCriterion) String() string {
+	switch c {
+	case BLSResidualsRMS:
+		return "residuals"
+	case BLSCorrection:
+		return "correction"
+	default:
+		panic("unknown criterion")
+	}
+}
+
+// BLSCriterionFromString returns the convergence criterion from its name.
+func BLSCriterionFromString(name string) (BLSCriterion, error) {
+	switch strings.ToLower(name) {
+	case "residuals":
+		return BLSResidualsRMS, nil
+	case "correction":
+		return BLSCorrection, nil
+	default:
+		return 0, fmt.Errorf("unknown convergence criterion `%s`", name)
+	}
+}
+
+// blsRecord stores a state of the reference trajectory of a batch iteration.
+type blsRecord struct {
+	state       State
+	Φ           *mat64.Dense  // Φ(t, t0) of the augmented state
+	observed, y *mat64.Vector // Observations and pre-fit residuals, nil without measurement
+	H           *mat64.Dense  // Htilde*Φ(t, t0) of the augmented state
+	rows        []ODRowStatus // Status of each row after the residual editing, nil without measurement
+}
+
+// runBLS estimates the deviation at the start of the mission with an iterated batch least squares, and maps it to
+// each state of the last reference trajectory with its covariance.
+func (od *OrbitDetermination) runBLS(stationRows []int, rowNames []string, noiseR *mat64.SymDense, params *odParameters) (*ODResult, error) {
+	conf := od.Config
+	maxIterations := conf.BLSIterations
+	if maxIterations <= 0 {
+		maxIterations = 10
+	}
+	criterion := conf.BLSCriterion
+	if criterion == 0 {
+		criterion = BLSResidualsRMS
+	}
+	n := 6 + params.size
+	var P0inv *mat64.SymDense
+	if conf.BLSAPriori {
+		var chol mat64.Cholesky
+		if !chol.Factorize(params.covariance(conf.P0)) {
+			return nil, errors.New("the a priori covariance is not positive definite")
+		}
+		P0inv = mat64.NewSymDense(n, nil)
+		if err := P0inv.InverseCholesky(&chol); err != nil {
+			return nil, fmt.Errorf("a priori information: %s", err)
+		}
+	}
+
+	// The reference trajectory of each iteration starts from the corrected initial state and parameters, with the same
+	// maneuvers.
+	mission := od.Mission
+	start := mission.CurrentDT
+	R0, V0 := mission.Orbit.RV()
+	X0 := mat64.NewVector(6, []float64{R0[0], R0[1], R0[2], V0[0], V0[1], V0[2]})
+	maneuvers := make(map[time.Time]Maneuver)
+	for dt, maneuver := range mission.Vehicle.Maneuvers {
+		maneuvers[dt] = maneuver
+	}
+	fuel := mission.Vehicle.FuelMass
+	xBar0 := mat64.NewVector(n, nil) // A priori deviation from the current reference
+	prevRMS := -1.
+	for iteration := 1; ; iteration++ {
+		if iteration > 1 {
+			for dt, maneuver := range maneuvers {
+				mission.Vehicle.Maneuvers[dt] = maneuver
+			}
+			mission.Vehicle.FuelMass = fuel
+			orbit := NewOrbitFromRV([]float64{X0.At(0, 0), X0.At(1, 0), X0.At(2, 0)}, []float64{X0.At(3, 0), X0.At(4, 0), X0.At(5, 0)}, od.Mission.Orbit.Origin)
+			mission = NewPreciseMission(od.Mission.Vehicle, orbit, start, start.Add(-1), od.Mission.perts, od.Mission.step, true, ExportConfig{})
+			params.apply(mission)
+		}
+		result := &ODResult{StateNames: params.stateNames(), ConsiderNames: params.considerNames(), RowNames: rowNames, Iterations: iteration}
+		// Accumulate the normal equations, starting from the a priori information.
+		Λ := mat64.NewSymDense(n, nil)
+		N := mat64.NewVector(n, nil)
+		if P0inv != nil {
+			Λ.CopySym(P0inv)
+			N.MulVec(P0inv, xBar0)
+		}
+		var Mxc *mat64.Dense
+		if params.consider > 0 {
+			Mxc = mat64.NewDense(n, params.consider, nil)
+		}
+		// The residuals are only edited from the second iteration, once the RMS of the reference is known.
+		var threshold float64
+		if conf.EditSigma > 0 && prevRMS >= 0 {
+			threshold = conf.EditSigma * math.Max(prevRMS, 1)
+		}
+		records, rms, err := od.blsPass(mission, stationRows, noiseR, result, Λ, N, Mxc, params, threshold)
+		if err != nil {
+			return nil, err
+		}
+		var chol mat64.Cholesky
+		if !chol.Factorize(Λ) {
+			return nil, fmt.Errorf("iteration #%d: singular normal matrix (more measurements or a priori information needed)", iteration)
+		}
+		x0 := mat64.NewVector(n, nil)
+		if err = x0.SolveCholeskyVec(&chol, N); err != nil {
+			return nil, fmt.Errorf("iteration #%d: %s", iteration, err)
+		}
+		P0 := mat64.NewSymDense(n, nil)
+		if err = P0.InverseCholesky(&chol); err != nil {
+			return nil, fmt.Errorf("iteration #%d: %s", iteration, err)
+		}
+		correction := math.Sqrt(math.Pow(x0.At(0, 0), 2) + math.Pow(x0.At(1, 0), 2) + math.Pow(x0.At(2, 0), 2))
+		log.Printf("[info] BLS iteration #%d: RMS=%f |δr0|=%f km\n", iteration, rms, correction)
+		switch criterion {
+		case BLSResidualsRMS:
+			result.Converged = rms == 0 || (prevRMS >= 0 && math.Abs(rms-prevRMS) <= conf.BLSTolerance*prevRMS)
+		case BLSCorrection:
+			result.Converged = correction <= conf.BLSTolerance
+		}
+		if result.Converged || iteration == maxIterations {
+			if !result.Converged {
+				log.Printf("[WARNING] BLS did not converge after %d iterations\n", iteration)
+			}
+			var S0 mat64.Dense
+			if params.consider > 0 {
+				S0.Mul(P0, Mxc)
+			}
+			// Map the estimate and its covariance to each state, and compute the post-fit residuals.
+			for _, rec := range records {
+				Φ := rec.Φ.View(0, 0, n, n)
+				x := mat64.NewVector(n, nil)
+				x.MulVec(Φ, x0)
+				var ΦP, ΦPΦt mat64.Dense
+				ΦP.Mul(Φ, P0)
+				ΦPΦt.Mul(&ΦP, Φ.T())
+				P := mat64.NewSymDense(n, nil)
+				for i := 0; i < n; i++ {
+					for j := i; j < n; j++ {
+						P.SetSym(i, j, (ΦPΦt.At(i, j)+ΦPΦt.At(j, i))/2)
+					}
+				}
+				est := ODEstimate{Estimate: odEstimate{x, rec.observed, rec.y, P, P}, DT: rec.state.DT, Orbit: rec.state.Orbit}
+				if rec.y != nil {
+					est.Residual = mat64.NewVector(rec.y.Len(), nil)
+					est.Residual.MulVec(rec.H.View(0, 0, rec.y.Len(), n), x0)
+					est.Residual.SubVec(rec.y, est.Residual)
+					est.Prefit, est.Rows = rec.y, rec.rows
+					est.PrefitSigma = mat64.NewVector(rec.y.Len(), nil)
+					for i, status := range rec.rows {
+						if status != RowUnobserved {
+							est.PrefitSigma.SetVec(i, math.Sqrt(noiseR.At(i, i)))
+						}
+					}
+				}
+				if params.consider > 0 {
+					est.Sensitivity = considerMapping(rec.Φ, &S0)
+					est.ConsiderCovariance = params.considerCovariance(P, est.Sensitivity)
+				}
+				result.Estimates = append(result.Estimates, est)
+			}
+			if params.size > 0 {
+				result.Parameters = params.estimated(x0)
+			}
+			return result, nil
+		}
+		X0.AddVec(X0, x0.SliceVec(0, 6))
+		params.correct(x0)
+		xBar0.SubVec(xBar0, x0)
+		prevRMS = rms
+	}
+}
+
+// blsPass propagates the reference trajectory of the provided mission and accumulates the normal equations Λ*x0 = N,
+// and the Hx^T*W*Hc of the considered parameters in Mxc. The rows whose weighted pre-fit residual exceeds the threshold
+// are rejected (no editing if zero). Returns the states of the reference trajectory and the RMS of the weighted
+// pre-fit residuals of the accepted rows.
+func (od *OrbitDetermination) blsPass(mission *Mission, stationRows []int, noiseR *mat64.SymDense, result *ODResult, Λ *mat64.SymDense, N *mat64.Vector, Mxc *mat64.Dense, params *odParameters, threshold float64) (records []blsRecord, rms float64, err error) {
+	numRows, _ := noiseR.Dims()
+	stateChan := make(chan (State), 1)
+	mission.RegisterStateChan(stateChan)
+	go mission.PropagateUntil(od.End.Add(mission.step), true)
+
+	n := 6 + params.size
+	Φ := DenseIdentity(params.augmented())
+	params.prevB = nil
+	rows := 0
+	for state := range stateChan {
+		var Φt0 mat64.Dense
+		Φt0.Mul(params.transition(state), Φ)
+		Φ = &Φt0
+		rec := blsRecord{state: state, Φ: Φ}
+		if measurements, exists := od.Measurements.At(state.DT.Truncate(time.Second)); exists {
+			observed, computed, Htilde := od.stack(state, measurements, stationRows, numRows, result, params)
+			rec.observed = observed
+			rec.y = mat64.NewVector(numRows, nil)
+			rec.y.SubVec(observed, computed)
+			rec.H = mat64.NewDense(numRows, params.augmented(), nil)
+			rec.H.Mul(Htilde, Φ)
+			rec.rows = observedRows(measurements, stationRows, numRows)
+			for i := 0; i < numRows; i++ {
+				w := 1 / noiseR.At(i, i)
+				if rec.rows[i] != RowAccepted {
+					continue
+				} else if threshold > 0 && math.Sqrt(w)*math.Abs(rec.y.At(i, 0)) > threshold {
+					rec.rows[i] = RowRejected
+					result.Rejected++
+					continue
+				}
+				Hx := rec.H.RowView(i).SliceVec(0, n)
+				Λ.SymRankOne(Λ, w, Hx)
+				N.AddScaledVec(N, w*rec.y.At(i, 0), Hx)
+				if params.consider > 0 {
+					Mxc.RankOne(Mxc, w, Hx, rec.H.RowView(i).SliceVec(n, params.augmented()))
+				}
+				rms += w * math.Pow(rec.y.At(i, 0), 2)
+				rows++
+			}
+			result.Measurements++
+		}
+		records = append(records, rec)
+	}
+	if rows == 0 {
+		return nil, 0, errors.New("no accepted measurement along the reference trajectory")
+	}
+	if result.Rejected > 0 {
+		log.Printf("[WARNING] BLS iteration #%d: %d measurement(s) rejected\n", result.Iterations, result.Rejected)
+	}
+	return records, math.Sqrt(rms / float64(rows)), nil
+}
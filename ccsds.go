@@ -0,0 +1,779 @@
+package smd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+/* CCSDS Orbit Data Messages (CCSDS 502.0-B-2) in the Keyword Value Notation (KVN). */
+
+const (
+	ccsdsVersion    = "2.0"
+	ccsdsDateFormat = "2006-01-02T15:04:05.000000"
+	ccsdsOriginator = "SMD"
+)
+
+// ccsdsCovKeys are the OPM and OMM covariance keywords of the lower triangular part of the covariance.
+var ccsdsCovKeys = func() (keys []string) {
+	components := []string{"X", "Y", "Z", "X_DOT", "Y_DOT", "Z_DOT"}
+	for i := 0; i < 6; i++ {
+		for j := 0; j <= i; j++ {
+			keys = append(keys, fmt.Sprintf("C%s_%s", components[i], components[j]))
+		}
+	}
+	return
+}()
+
+// ccsdsCovKeySet is the set of the covariance keywords, which are distinguished from the other keywords starting with
+// a C (e.g. CR_AREA_OVER_MASS).
+var ccsdsCovKeySet = func() map[string]bool {
+	set := make(map[string]bool, len(ccsdsCovKeys))
+	for _, key := range ccsdsCovKeys {
+		set[key] = true
+	}
+	return set
+}()
+
+// CCSDSMetadata is the metadata of a CCSDS orbit data message.
+type CCSDSMetadata struct {
+	ObjectName, ObjectID, CenterName, RefFrame, TimeSystem string
+}
+
+// NewCCSDSMetadata returns the metadata of the provided object orbiting the provided celestial object.
+func NewCCSDSMetadata(name string, c CelestialObject) CCSDSMetadata {
+	frame, _ := ccsdsFrame(defaultFrame(c), c) // The default frames are always supported.
+	return CCSDSMetadata{name, name, strings.ToUpper(c.Name), frame, "UTC"}
+}
+
+// Origin returns the celestial object at the center of the message, after checking that the frame and
+// time system are supported.
+func (m CCSDSMetadata) Origin() (CelestialObject, error) {
+	c, err := CelestialObjectFromString(strings.TrimSuffix(m.CenterName, " BARYCENTER"))
+	if err != nil {
+		return c, err
+	}
+	if m.TimeSystem != UTC.String() {
+		return c, fmt.Errorf("epochs in `%s` instead of UTC", m.TimeSystem)
+	}
+	if _, err := m.Frame(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// Frame returns the reference frame of the message. The body fixed frames are named IAU_<CENTER>.
+func (m CCSDSMetadata) Frame() (Frame, error) {
+	switch frame := strings.ToUpper(m.RefFrame); frame {
+	case "ICRF", "EME2000", "GCRF":
+		// The difference between these frames is well below the accuracy of the dynamics.
+		return ICRF, nil
+	case "ECLIPJ2000":
+		return EclipJ2000, nil
+	case "IAU_" + strings.TrimSuffix(strings.ToUpper(m.CenterName), " BARYCENTER"):
+		return BodyFixed, nil
+	default:
+		return ICRF, fmt.Errorf("unsupported frame `%s` for center `%s`", m.RefFrame, m.CenterName)
+	}
+}
+
+// toUTC converts the provided epochs from the time system of the metadata to UTC, and sets the time system to UTC.
+func (m *CCSDSMetadata) toUTC(epochs ...*time.Time) error {
+	scale, err := TimeScaleFromString(m.TimeSystem)
+	if err != nil {
+		return err
+	}
+	for _, epoch := range epochs {
+		*epoch = NewEpoch(*epoch, scale).UTC()
+	}
+	m.TimeSystem = UTC.String()
+	return nil
+}
+
+func (m CCSDSMetadata) writeKVN(w io.Writer) {
+	fmt.Fprintf(w, "OBJECT_NAME = %s\nOBJECT_ID = %s\nCENTER_NAME = %s\nREF_FRAME = %s\nTIME_SYSTEM = %s\n", m.ObjectName, m.ObjectID, m.CenterName, m.RefFrame, m.TimeSystem)
+}
+
+// set sets the metadata keyword and returns whether it is one.
+func (m *CCSDSMetadata) set(key, value string) bool {
+	switch key {
+	case "OBJECT_NAME":
+		m.ObjectName = value
+	case "OBJECT_ID":
+		m.ObjectID = value
+	case "CENTER_NAME":
+		m.CenterName = value
+	case "REF_FRAME":
+		m.RefFrame = value
+	case "TIME_SYSTEM":
+		m.TimeSystem = value
+	default:
+		return false
+	}
+	return true
+}
+
+// ccsdsFrame returns the CCSDS name of the provided frame for orbits about the provided object.
+// Returns an error for local orbital frames.
+func ccsdsFrame(f Frame, c CelestialObject) (string, error) {
+	switch f {
+	case ICRF:
+		return "ICRF", nil
+	case EclipJ2000:
+		return "ECLIPJ2000", nil
+	case BodyFixed:
+		return "IAU_" + strings.ToUpper(c.Name), nil
+	default:
+		return "", fmt.Errorf("cannot use frame %s in a CCSDS message", f)
+	}
+}
+
+// OEMState is a state of an Orbit Ephemeris Message.
+type OEMState struct {
+	Epoch time.Time
+	R, V  []float64
+}
+
+// OEMCovariance is a covariance of an Orbit Ephemeris Message.
+type OEMCovariance struct {
+	Epoch    time.Time
+	RefFrame string // Empty if the same as the segment.
+	P        *mat64.SymDense
+}
+
+// OEMSegment is a segment of an Orbit Ephemeris Message, i.e. the states about a given center.
+type OEMSegment struct {
+	Metadata    CCSDSMetadata
+	States      []OEMState
+	Covariances []OEMCovariance
+}
+
+// Orbits returns the orbits of all the states of this segment.
+func (s OEMSegment) Orbits() ([]*Orbit, error) {
+	c, err := s.Metadata.Origin()
+	if err != nil {
+		return nil, err
+	}
+	frame, _ := s.Metadata.Frame()
+	orbits := make([]*Orbit, len(s.States))
+	for i, state := range s.States {
+		orbits[i] = NewOrbitFromRV(state.R, state.V, c)
+		orbits[i].Frame = frame
+	}
+	return orbits, nil
+}
+
+// OEM is a CCSDS Orbit Ephemeris Message.
+type OEM struct {
+	Originator string
+	Created    time.Time
+	Segments   []OEMSegment
+}
+
+// NewOEM returns an empty OEM.
+func NewOEM() *OEM {
+	return &OEM{ccsdsOriginator, time.Now().UTC(), nil}
+}
+
+// AddState adds the state to the OEM, in a new segment if the origin of the orbit has changed.
+// Returns an error if the frame of the orbit cannot be used in an OEM.
+func (m *OEM) AddState(name string, dt time.Time, o Orbit, P *mat64.SymDense) (err error) {
+	meta := NewCCSDSMetadata(name, o.Origin)
+	if meta.RefFrame, err = ccsdsFrame(o.Frame, o.Origin); err != nil {
+		return
+	}
+	if len(m.Segments) == 0 || m.Segments[len(m.Segments)-1].Metadata != meta {
+		m.Segments = append(m.Segments, OEMSegment{meta, nil, nil})
+	}
+	seg := &m.Segments[len(m.Segments)-1]
+	R, V := o.RV()
+	seg.States = append(seg.States, OEMState{dt, R, V})
+	if P != nil {
+		seg.Covariances = append(seg.Covariances, OEMCovariance{dt, "", P})
+	}
+	return
+}
+
+// WriteKVN writes the OEM in the KVN format.
+func (m OEM) WriteKVN(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	writeCCSDSHeader(bw, "OEM", m.Created, m.Originator)
+	for _, seg := range m.Segments {
+		if len(seg.States) == 0 {
+			continue
+		}
+		fmt.Fprintln(bw, "\nMETA_START")
+		seg.Metadata.writeKVN(bw)
+		fmt.Fprintf(bw, "START_TIME = %s\nSTOP_TIME = %s\n", ccsdsDate(seg.States[0].Epoch), ccsdsDate(seg.States[len(seg.States)-1].Epoch))
+		fmt.Fprint(bw, "META_STOP\n\n")
+		for _, state := range seg.States {
+			fmt.Fprintf(bw, "%s %s %s\n", ccsdsDate(state.Epoch), ccsdsVector(state.R), ccsdsVector(state.V))
+		}
+		if len(seg.Covariances) > 0 {
+			fmt.Fprintln(bw, "\nCOVARIANCE_START")
+			for _, cov := range seg.Covariances {
+				fmt.Fprintf(bw, "EPOCH = %s\n", ccsdsDate(cov.Epoch))
+				if cov.RefFrame != "" {
+					fmt.Fprintf(bw, "COV_REF_FRAME = %s\n", cov.RefFrame)
+				}
+				for i := 0; i < 6; i++ {
+					row := make([]float64, i+1)
+					for j := 0; j <= i; j++ {
+						row[j] = cov.P.At(i, j)
+					}
+					fmt.Fprintln(bw, ccsdsVector(row))
+				}
+			}
+			fmt.Fprintln(bw, "COVARIANCE_STOP")
+		}
+	}
+	return bw.Flush()
+}
+
+// ParseOEM returns the OEM from its KVN representation.
+// The epochs are converted to UTC from the time system of each segment.
+func ParseOEM(r io.Reader) (*OEM, error) {
+	m := &OEM{}
+	var seg *OEMSegment
+	var cov *OEMCovariance
+	var covValues []float64
+	inMeta, inCov := false, false
+	err := readKVN(r, "OEM", func(key, value string, fields []string) error {
+		switch {
+		case key == "META_START":
+			m.Segments = append(m.Segments, OEMSegment{})
+			seg = &m.Segments[len(m.Segments)-1]
+			inMeta = true
+		case key == "META_STOP":
+			inMeta = false
+		case key == "COVARIANCE_START":
+			inCov = true
+		case key == "COVARIANCE_STOP":
+			if cov != nil {
+				return errors.New("incomplete covariance")
+			}
+			inCov = false
+		case seg == nil:
+			return m.setHeader(key, value)
+		case inMeta:
+			if !seg.Metadata.set(key, value) && key != "START_TIME" && key != "STOP_TIME" && key != "USEABLE_START_TIME" && key != "USEABLE_STOP_TIME" && key != "INTERPOLATION" && key != "INTERPOLATION_DEGREE" && key != "REF_FRAME_EPOCH" {
+				return fmt.Errorf("unknown metadata keyword `%s`", key)
+			}
+		case inCov && key == "EPOCH":
+			epoch, err := parseCCSDSDate(value)
+			if err != nil {
+				return err
+			}
+			seg.Covariances = append(seg.Covariances, OEMCovariance{epoch, "", nil})
+			cov = &seg.Covariances[len(seg.Covariances)-1]
+			covValues = nil
+		case inCov && key == "COV_REF_FRAME":
+			if cov == nil {
+				return errors.New("COV_REF_FRAME before EPOCH")
+			}
+			cov.RefFrame = value
+		case inCov && key == "":
+			if cov == nil {
+				return errors.New("covariance data before EPOCH")
+			}
+			values, err := parseCCSDSFloats(fields)
+			if err != nil {
+				return err
+			}
+			covValues = append(covValues, values...)
+			if len(covValues) == 21 {
+				cov.P = ccsdsCovariance(covValues)
+				cov = nil
+			} else if len(covValues) > 21 {
+				return errors.New("too many covariance values")
+			}
+		case key == "":
+			if len(fields) != 7 && len(fields) != 10 {
+				return fmt.Errorf("invalid ephemeris line `%s`", strings.Join(fields, " "))
+			}
+			epoch, err := parseCCSDSDate(fields[0])
+			if err != nil {
+				return err
+			}
+			values, err := parseCCSDSFloats(fields[1:7])
+			if err != nil {
+				return err
+			}
+			seg.States = append(seg.States, OEMState{epoch, values[0:3], values[3:6]})
+		default:
+			return fmt.Errorf("unexpected keyword `%s`", key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Segments) == 0 {
+		return nil, errors.New("OEM has no segment")
+	}
+	for segNo := range m.Segments {
+		seg := &m.Segments[segNo]
+		epochs := make([]*time.Time, 0, len(seg.States)+len(seg.Covariances))
+		for i := range seg.States {
+			epochs = append(epochs, &seg.States[i].Epoch)
+		}
+		for i := range seg.Covariances {
+			epochs = append(epochs, &seg.Covariances[i].Epoch)
+		}
+		if err := seg.Metadata.toUTC(epochs...); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *OEM) setHeader(key, value string) (err error) {
+	switch key {
+	case "ORIGINATOR":
+		m.Originator = value
+	case "CREATION_DATE":
+		m.Created, err = parseCCSDSDate(value)
+	default:
+		err = fmt.Errorf("unexpected header keyword `%s`", key)
+	}
+	return
+}
+
+// OPM is a CCSDS Orbit Parameter Message.
+type OPM struct {
+	Originator  string
+	Created     time.Time
+	Metadata    CCSDSMetadata
+	Epoch       time.Time
+	R, V        []float64
+	Mass        float64         // kg, zero if unset
+	Covariance  *mat64.SymDense // nil if unset
+	CovRefFrame string          // Empty if the same as the metadata.
+}
+
+// NewOPM returns an OPM of the provided orbit, or an error if its frame cannot be used in an OPM.
+func NewOPM(name string, o Orbit, epoch time.Time) (OPM, error) {
+	R, V := o.RV()
+	meta := NewCCSDSMetadata(name, o.Origin)
+	var err error
+	if meta.RefFrame, err = ccsdsFrame(o.Frame, o.Origin); err != nil {
+		return OPM{}, err
+	}
+	return OPM{ccsdsOriginator, time.Now().UTC(), meta, epoch, R, V, 0, nil, ""}, nil
+}
+
+// Orbit returns the orbit of this OPM.
+func (m OPM) Orbit() (*Orbit, error) {
+	c, err := m.Metadata.Origin()
+	if err != nil {
+		return nil, err
+	}
+	o := NewOrbitFromRV(m.R, m.V, c)
+	o.Frame, _ = m.Metadata.Frame()
+	return o, nil
+}
+
+// WriteKVN writes the OPM in the KVN format. The osculating Keplerian elements are included for elliptical orbits.
+func (m OPM) WriteKVN(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	writeCCSDSHeader(bw, "OPM", m.Created, m.Originator)
+	fmt.Fprintln(bw)
+	m.Metadata.writeKVN(bw)
+	fmt.Fprintf(bw, "\nEPOCH = %s\n", ccsdsDate(m.Epoch))
+	for i, axis := range []string{"X", "Y", "Z"} {
+		fmt.Fprintf(bw, "%s = %s [km]\n", axis, ccsdsFloat(m.R[i]))
+	}
+	for i, axis := range []string{"X", "Y", "Z"} {
+		fmt.Fprintf(bw, "%s_DOT = %s [km/s]\n", axis, ccsdsFloat(m.V[i]))
+	}
+	if o, err := m.Orbit(); err == nil && o.Energyξ() < 0 {
+		a, e, i, Ω, ω, ν, _, _, _ := o.Elements()
+		fmt.Fprintf(bw, "\nSEMI_MAJOR_AXIS = %s [km]\nECCENTRICITY = %s\nINCLINATION = %s [deg]\nRA_OF_ASC_NODE = %s [deg]\nARG_OF_PERICENTER = %s [deg]\nTRUE_ANOMALY = %s [deg]\nGM = %s [km**3/s**2]\n",
+			ccsdsFloat(a), ccsdsFloat(e), ccsdsFloat(Rad2deg(i)), ccsdsFloat(Rad2deg(Ω)), ccsdsFloat(Rad2deg(ω)), ccsdsFloat(Rad2deg(ν)), ccsdsFloat(o.Origin.μ))
+	}
+	if m.Mass > 0 {
+		fmt.Fprintf(bw, "\nMASS = %s [kg]\n", ccsdsFloat(m.Mass))
+	}
+	writeCCSDSCovariance(bw, m.Covariance, m.CovRefFrame)
+	return bw.Flush()
+}
+
+// ParseOPM returns the OPM from its KVN representation.
+// The epoch is converted to UTC from the time system of the message.
+func ParseOPM(r io.Reader) (*OPM, error) {
+	m := &OPM{R: make([]float64, 3), V: make([]float64, 3)}
+	covValues := make(map[string]float64)
+	stateSet := 0
+	err := readKVN(r, "OPM", func(key, value string, fields []string) (err error) {
+		if m.Metadata.set(key, value) {
+			return
+		}
+		switch key {
+		case "ORIGINATOR":
+			m.Originator = value
+		case "CREATION_DATE":
+			m.Created, err = parseCCSDSDate(value)
+		case "EPOCH":
+			m.Epoch, err = parseCCSDSDate(value)
+		case "X", "Y", "Z", "X_DOT", "Y_DOT", "Z_DOT":
+			idx := strings.Index("XYZ", key[:1])
+			vec := m.R
+			if strings.HasSuffix(key, "_DOT") {
+				vec = m.V
+			}
+			vec[idx], err = parseCCSDSFloat(value)
+			stateSet++
+		case "MASS":
+			m.Mass, err = parseCCSDSFloat(value)
+		case "COV_REF_FRAME":
+			m.CovRefFrame = value
+		default:
+			if ccsdsCovKeySet[key] {
+				covValues[key], err = parseCCSDSFloat(value)
+			}
+			// Other keywords (Keplerian elements, spacecraft parameters, maneuvers) are ignored.
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stateSet != 6 || m.Epoch == (time.Time{}) {
+		return nil, errors.New("OPM has no complete state vector")
+	}
+	if err = m.Metadata.toUTC(&m.Epoch); err != nil {
+		return nil, err
+	}
+	m.Covariance, err = ccsdsCovarianceFromKeys(covValues)
+	return m, err
+}
+
+// OMM is a CCSDS Orbit Mean-elements Message.
+// The mean element theory is either SGP4 (in which case the TLE parameters are set), or BROUWER which uses
+// the first order Brouwer-Lyddane mean elements of Orbit.MeanElements.
+type OMM struct {
+	Originator        string
+	Created           time.Time
+	Metadata          CCSDSMetadata
+	MeanElementTheory string
+	Epoch             time.Time
+	SemiMajorAxis     float64 // km, zero if the mean motion is used
+	MeanMotion        float64 // rev/day, zero if the semi-major axis is used
+	Eccentricity      float64
+	Inclination       float64 // deg
+	RAAN              float64 // deg
+	ArgPericenter     float64 // deg
+	MeanAnomaly       float64 // deg
+	GM                float64 // km^3/s^2
+	// TLE parameters
+	EphemerisType  int
+	Classification byte
+	NoradCatID     int
+	ElementSetNo   int
+	RevAtEpoch     int
+	BStar          float64
+	MeanMotionDot  float64
+	MeanMotionDDot float64
+	Covariance     *mat64.SymDense // nil if unset
+	CovRefFrame    string          // Empty if the same as the metadata.
+}
+
+// NewOMM returns the OMM of the Brouwer mean elements of the provided orbit.
+func NewOMM(name string, o Orbit, epoch time.Time) OMM {
+	a, e, i, Ω, ω, M := o.MeanElements()
+	return OMM{Originator: ccsdsOriginator, Created: time.Now().UTC(), Metadata: NewCCSDSMetadata(name, o.Origin), MeanElementTheory: "BROUWER", Epoch: epoch,
+		SemiMajorAxis: a, Eccentricity: e, Inclination: Rad2deg(i), RAAN: Rad2deg(Ω), ArgPericenter: Rad2deg(ω), MeanAnomaly: Rad2deg(M), GM: o.Origin.μ}
+}
+
+// NewOMMFromTLE returns the OMM of the provided TLE.
+func NewOMMFromTLE(t TLE) OMM {
+	name := t.Name
+	if name == "" {
+		name = fmt.Sprintf("%05d", t.SatNum)
+	}
+	return OMM{ccsdsOriginator, time.Now().UTC(), CCSDSMetadata{name, t.IntlDesignator, "EARTH", "TEME", "UTC"}, "SGP4", t.Epoch,
+		0, t.MeanMotion, t.Eccentricity, t.Inclination, t.RAAN, t.ArgPerigee, t.MeanAnomaly, sgp4μ,
+		0, t.Classification, t.SatNum, t.ElementSet, t.RevNum, t.BStar, t.NDot, t.NDDot, nil, ""}
+}
+
+// TLE returns the TLE of this OMM, which must use the SGP4 theory.
+func (m OMM) TLE() (TLE, error) {
+	t := TLE{}
+	if m.MeanElementTheory != "SGP4" {
+		return t, fmt.Errorf("cannot create TLE from %s mean elements", m.MeanElementTheory)
+	}
+	if m.MeanMotion == 0 {
+		return t, errors.New("SGP4 OMM requires the mean motion")
+	}
+	t = TLE{m.Metadata.ObjectName, m.NoradCatID, m.Classification, m.Metadata.ObjectID, m.Epoch, m.MeanMotionDot, m.MeanMotionDDot, m.BStar, m.ElementSetNo,
+		m.Inclination, m.RAAN, m.Eccentricity, m.ArgPericenter, m.MeanAnomaly, m.MeanMotion, m.RevAtEpoch, nil}
+	err := t.initSGP4(timeToJD(m.Epoch))
+	return t, err
+}
+
+// Orbit returns the osculating orbit at the epoch of this OMM.
+func (m OMM) Orbit() (*Orbit, error) {
+	switch m.MeanElementTheory {
+	case "SGP4":
+		t, err := m.TLE()
+		if err != nil {
+			return nil, err
+		}
+		return t.Orbit(m.Epoch)
+	case "BROUWER":
+		c, err := m.Metadata.Origin()
+		if err != nil {
+			return nil, err
+		}
+		a := m.SemiMajorAxis
+		if a == 0 {
+			n := m.MeanMotion * 2 * math.Pi / 86400
+			a = math.Cbrt(c.μ / (n * n))
+		}
+		return NewOrbitFromMeanOE(a, m.Eccentricity, m.Inclination, m.RAAN, m.ArgPericenter, m.MeanAnomaly, c), nil
+	default:
+		return nil, fmt.Errorf("unsupported mean element theory `%s`", m.MeanElementTheory)
+	}
+}
+
+// WriteKVN writes the OMM in the KVN format.
+func (m OMM) WriteKVN(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	writeCCSDSHeader(bw, "OMM", m.Created, m.Originator)
+	fmt.Fprintln(bw)
+	m.Metadata.writeKVN(bw)
+	fmt.Fprintf(bw, "MEAN_ELEMENT_THEORY = %s\n\nEPOCH = %s\n", m.MeanElementTheory, ccsdsDate(m.Epoch))
+	if m.MeanMotion != 0 {
+		fmt.Fprintf(bw, "MEAN_MOTION = %s [rev/day]\n", ccsdsFloat(m.MeanMotion))
+	} else {
+		fmt.Fprintf(bw, "SEMI_MAJOR_AXIS = %s [km]\n", ccsdsFloat(m.SemiMajorAxis))
+	}
+	fmt.Fprintf(bw, "ECCENTRICITY = %s\nINCLINATION = %s [deg]\nRA_OF_ASC_NODE = %s [deg]\nARG_OF_PERICENTER = %s [deg]\nMEAN_ANOMALY = %s [deg]\n",
+		ccsdsFloat(m.Eccentricity), ccsdsFloat(m.Inclination), ccsdsFloat(m.RAAN), ccsdsFloat(m.ArgPericenter), ccsdsFloat(m.MeanAnomaly))
+	if m.GM != 0 {
+		fmt.Fprintf(bw, "GM = %s [km**3/s**2]\n", ccsdsFloat(m.GM))
+	}
+	if m.MeanElementTheory == "SGP4" {
+		fmt.Fprintf(bw, "\nEPHEMERIS_TYPE = %d\nCLASSIFICATION_TYPE = %c\nNORAD_CAT_ID = %d\nELEMENT_SET_NO = %d\nREV_AT_EPOCH = %d\nBSTAR = %s [1/ER]\nMEAN_MOTION_DOT = %s [rev/day**2]\nMEAN_MOTION_DDOT = %s [rev/day**3]\n",
+			m.EphemerisType, m.Classification, m.NoradCatID, m.ElementSetNo, m.RevAtEpoch, ccsdsFloat(m.BStar), ccsdsFloat(m.MeanMotionDot), ccsdsFloat(m.MeanMotionDDot))
+	}
+	writeCCSDSCovariance(bw, m.Covariance, m.CovRefFrame)
+	return bw.Flush()
+}
+
+// ParseOMM returns the OMM from its KVN representation.
+// The epoch is converted to UTC from the time system of the message.
+func ParseOMM(r io.Reader) (*OMM, error) {
+	m := &OMM{}
+	covValues := make(map[string]float64)
+	err := readKVN(r, "OMM", func(key, value string, fields []string) (err error) {
+		if m.Metadata.set(key, value) {
+			return
+		}
+		floatKeys := map[string]*float64{"SEMI_MAJOR_AXIS": &m.SemiMajorAxis, "MEAN_MOTION": &m.MeanMotion, "ECCENTRICITY": &m.Eccentricity,
+			"INCLINATION": &m.Inclination, "RA_OF_ASC_NODE": &m.RAAN, "ARG_OF_PERICENTER": &m.ArgPericenter, "MEAN_ANOMALY": &m.MeanAnomaly,
+			"GM": &m.GM, "BSTAR": &m.BStar, "MEAN_MOTION_DOT": &m.MeanMotionDot, "MEAN_MOTION_DDOT": &m.MeanMotionDDot}
+		intKeys := map[string]*int{"EPHEMERIS_TYPE": &m.EphemerisType, "NORAD_CAT_ID": &m.NoradCatID, "ELEMENT_SET_NO": &m.ElementSetNo, "REV_AT_EPOCH": &m.RevAtEpoch}
+		if ptr, found := floatKeys[key]; found {
+			*ptr, err = parseCCSDSFloat(value)
+			return
+		}
+		if ptr, found := intKeys[key]; found {
+			*ptr, err = strconv.Atoi(value)
+			return
+		}
+		switch key {
+		case "ORIGINATOR":
+			m.Originator = value
+		case "CREATION_DATE":
+			m.Created, err = parseCCSDSDate(value)
+		case "MEAN_ELEMENT_THEORY":
+			m.MeanElementTheory = strings.ToUpper(value)
+		case "EPOCH":
+			m.Epoch, err = parseCCSDSDate(value)
+		case "CLASSIFICATION_TYPE":
+			if len(value) > 0 {
+				m.Classification = value[0]
+			}
+		case "COV_REF_FRAME":
+			m.CovRefFrame = value
+		default:
+			if ccsdsCovKeySet[key] {
+				covValues[key], err = parseCCSDSFloat(value)
+			}
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if m.Epoch == (time.Time{}) || (m.SemiMajorAxis == 0 && m.MeanMotion == 0) {
+		return nil, errors.New("OMM has no mean elements")
+	}
+	if err = m.Metadata.toUTC(&m.Epoch); err != nil {
+		return nil, err
+	}
+	m.Covariance, err = ccsdsCovarianceFromKeys(covValues)
+	return m, err
+}
+
+// LoadOPM returns the OPM stored in the provided file.
+func LoadOPM(filename string) (*OPM, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseOPM(f)
+}
+
+// readKVN calls the provided function for each keyword and value of the KVN message, after checking its type.
+// Lines without a keyword (ephemeris and covariance data) are passed with an empty key and split in fields,
+// and the units are removed from the values.
+func readKVN(r io.Reader, msgType string, f func(key, value string, fields []string) error) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	versionFound := false
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "COMMENT") {
+			continue
+		}
+		var key, value string
+		var fields []string
+		if idx := strings.Index(line, "="); idx > 0 {
+			key = strings.TrimSpace(line[:idx])
+			value = strings.TrimSpace(line[idx+1:])
+			if unitIdx := strings.Index(value, "["); unitIdx > 0 {
+				value = strings.TrimSpace(value[:unitIdx])
+			}
+		} else if strings.HasSuffix(line, "_START") || strings.HasSuffix(line, "_STOP") {
+			key = line
+		} else {
+			fields = strings.Fields(line)
+		}
+		if key == "CCSDS_"+msgType+"_VERS" {
+			versionFound = true
+			continue
+		}
+		if !versionFound {
+			return fmt.Errorf("line %d: not a CCSDS %s (expected CCSDS_%s_VERS first)", lineNo, msgType, msgType)
+		}
+		if err := f(key, value, fields); err != nil {
+			return fmt.Errorf("line %d: %s", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !versionFound {
+		return fmt.Errorf("not a CCSDS %s", msgType)
+	}
+	return nil
+}
+
+func writeCCSDSHeader(w io.Writer, msgType string, created time.Time, originator string) {
+	fmt.Fprintf(w, "CCSDS_%s_VERS = %s\nCREATION_DATE = %s\nORIGINATOR = %s\n", msgType, ccsdsVersion, ccsdsDate(created), originator)
+}
+
+func writeCCSDSCovariance(w io.Writer, P *mat64.SymDense, refFrame string) {
+	if P == nil {
+		return
+	}
+	fmt.Fprintln(w)
+	if refFrame != "" {
+		fmt.Fprintf(w, "COV_REF_FRAME = %s\n", refFrame)
+	}
+	k := 0
+	for i := 0; i < 6; i++ {
+		for j := 0; j <= i; j++ {
+			fmt.Fprintf(w, "%s = %s\n", ccsdsCovKeys[k], ccsdsFloat(P.At(i, j)))
+			k++
+		}
+	}
+}
+
+// ccsdsCovariance returns the covariance from the 21 values of its lower triangular part (row by row).
+func ccsdsCovariance(values []float64) *mat64.SymDense {
+	P := mat64.NewSymDense(6, nil)
+	k := 0
+	for i := 0; i < 6; i++ {
+		for j := 0; j <= i; j++ {
+			P.SetSym(i, j, values[k])
+			k++
+		}
+	}
+	return P
+}
+
+// ccsdsCovarianceFromKeys returns the covariance from the covariance keywords, or nil if none are set.
+func ccsdsCovarianceFromKeys(covValues map[string]float64) (*mat64.SymDense, error) {
+	if len(covValues) == 0 {
+		return nil, nil
+	}
+	values := make([]float64, len(ccsdsCovKeys))
+	for i, key := range ccsdsCovKeys {
+		val, found := covValues[key]
+		if !found {
+			return nil, fmt.Errorf("incomplete covariance: %s is missing", key)
+		}
+		values[i] = val
+	}
+	return ccsdsCovariance(values), nil
+}
+
+func ccsdsDate(dt time.Time) string {
+	return dt.UTC().Format(ccsdsDateFormat)
+}
+
+// parseCCSDSDate parses the calendar (YYYY-MM-DDThh:mm:ss.d) and day of year (YYYY-DDDThh:mm:ss.d) formats.
+func parseCCSDSDate(value string) (time.Time, error) {
+	value = strings.TrimSuffix(value, "Z")
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-002T15:04:05", "2006-01-02"} {
+		if dt, err := time.Parse(layout, value); err == nil {
+			return dt, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid CCSDS date `%s`", value)
+}
+
+func ccsdsFloat(v float64) string {
+	return strconv.FormatFloat(v, 'e', 14, 64)
+}
+
+func ccsdsVector(v []float64) string {
+	str := make([]string, len(v))
+	for i, val := range v {
+		str[i] = ccsdsFloat(val)
+	}
+	return strings.Join(str, " ")
+}
+
+func parseCCSDSFloat(value string) (float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return v, fmt.Errorf("invalid number `%s`", value)
+	}
+	return v, nil
+}
+
+func parseCCSDSFloats(fields []string) ([]float64, error) {
+	values := make([]float64, len(fields))
+	for i, field := range fields {
+		v, err := parseCCSDSFloat(field)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
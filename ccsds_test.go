@@ -0,0 +1,205 @@
+package smd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestOPM(t *testing.T) {
+	kvn := `CCSDS_OPM_VERS = 2.0
+COMMENT Example adapted from CCSDS 502.0-B-2
+CREATION_DATE = 1998-11-06T09:23:57
+ORIGINATOR = JAXA
+
+OBJECT_NAME = GODZILLA 5
+OBJECT_ID = 1998-999A
+CENTER_NAME = EARTH
+REF_FRAME = EME2000
+TIME_SYSTEM = UTC
+
+EPOCH = 1998-352T14:28:15.1172
+X = 6503.514000 [km]
+Y = 1239.647000 [km]
+Z = -717.490000 [km]
+X_DOT = -0.873160 [km/s]
+Y_DOT = 8.740420 [km/s]
+Z_DOT = -4.191076 [km/s]
+
+COMMENT Spacecraft parameters, which are not covariance keywords
+MASS = 3000.000000 [kg]
+SOLAR_RAD_AREA = 18.770000 [m**2]
+SOLAR_RAD_COEFF = 1.000000
+DRAG_AREA = 18.770000 [m**2]
+DRAG_COEFF = 2.500000
+CD_AREA_OVER_MASS = 0.015643 [m**2/kg]
+CR_AREA_OVER_MASS = 0.006257 [m**2/kg]
+`
+	opm, err := ParseOPM(strings.NewReader(kvn))
+	if err != nil {
+		t.Fatalf("could not parse OPM: %s", err)
+	}
+	expEpoch := time.Date(1998, 12, 18, 14, 28, 15, 117200000, time.UTC)
+	if !opm.Epoch.Equal(expEpoch) || opm.Metadata.ObjectID != "1998-999A" || opm.Originator != "JAXA" || opm.Mass != 3000 || opm.Covariance != nil {
+		t.Fatalf("invalid OPM: %+v", opm)
+	}
+	o, err := opm.Orbit()
+	if err != nil {
+		t.Fatalf("could not get orbit: %s", err)
+	}
+	if !o.Origin.Equals(Earth) || !floats.Equal(o.R(), []float64{6503.514, 1239.647, -717.49}) || !floats.Equal(o.V(), []float64{-0.87316, 8.74042, -4.191076}) {
+		t.Fatalf("invalid orbit: %s", o)
+	}
+	// Unsupported frame
+	opm.Metadata.RefFrame = "ITRF-97"
+	if _, err := opm.Orbit(); err == nil {
+		t.Fatal("ITRF OPM accepted")
+	}
+	// A local orbital frame cannot be used in an OPM.
+	oLocal := *o
+	oLocal.Frame = VNC
+	if _, err := NewOPM("test", oLocal, expEpoch); err == nil {
+		t.Fatal("VNC OPM accepted")
+	}
+	// Round trip with a covariance
+	P := mat64.NewSymDense(6, nil)
+	for i := 0; i < 6; i++ {
+		for j := 0; j <= i; j++ {
+			P.SetSym(i, j, float64(i+1)+float64(j)/10)
+		}
+	}
+	opmOut, err := NewOPM("test", *NewOrbitFromOE(8000, 0.1, 30, 40, 50, 60, Mars), expEpoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opmOut.Covariance = P
+	opmOut.CovRefFrame = "RTN"
+	var buf bytes.Buffer
+	if err := opmOut.WriteKVN(&buf); err != nil {
+		t.Fatal(err)
+	}
+	opmIn, err := ParseOPM(&buf)
+	if err != nil {
+		t.Fatalf("could not parse written OPM: %s\n%s", err, buf.String())
+	}
+	oIn, err := opmIn.Orbit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opmIn.Epoch.Equal(expEpoch) || !rvEqual(oIn, NewOrbitFromOE(8000, 0.1, 30, 40, 50, 60, Mars)) || opmIn.CovRefFrame != "RTN" || !mat64.Equal(opmIn.Covariance, P) {
+		t.Fatalf("OPM round trip failed: %+v", opmIn)
+	}
+}
+
+func TestOEM(t *testing.T) {
+	oem := NewOEM()
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	o := NewOrbitFromOE(7000, 0.01, 28, 10, 20, 30, Earth)
+	P := mat64.NewSymDense(6, []float64{
+		1, 0.1, 0, 0, 0, 0,
+		0.1, 2, 0, 0, 0, 0,
+		0, 0, 3, 0, 0, 0,
+		0, 0, 0, 1e-6, 0, 0,
+		0, 0, 0, 0, 2e-6, 1e-7,
+		0, 0, 0, 0, 1e-7, 3e-6})
+	for i := 0; i < 3; i++ {
+		if err := oem.AddState("sc", start.Add(time.Duration(i)*time.Minute), *o, P); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oHelio := NewOrbitFromOE(1.5*AU, 0.1, 1, 10, 20, 30, Sun)
+	if err := oem.AddState("sc", start.Add(time.Hour), *oHelio, nil); err != nil {
+		t.Fatal(err)
+	}
+	// A local orbital frame cannot be used in an OEM.
+	oLocal := *o
+	oLocal.Frame = RIC
+	if err := oem.AddState("sc", start.Add(2*time.Hour), oLocal, nil); err == nil {
+		t.Fatal("RIC state accepted in an OEM")
+	}
+	if len(oem.Segments) != 2 || len(oem.Segments[0].States) != 3 || len(oem.Segments[1].Covariances) != 0 {
+		t.Fatalf("invalid segments: %+v", oem.Segments)
+	}
+	var buf bytes.Buffer
+	if err := oem.WriteKVN(&buf); err != nil {
+		t.Fatal(err)
+	}
+	oemIn, err := ParseOEM(&buf)
+	if err != nil {
+		t.Fatalf("could not parse written OEM: %s\n%s", err, buf.String())
+	}
+	if len(oemIn.Segments) != 2 || len(oemIn.Segments[0].States) != 3 || len(oemIn.Segments[0].Covariances) != 3 || oemIn.Segments[1].Metadata.RefFrame != "ECLIPJ2000" {
+		t.Fatalf("invalid parsed OEM: %+v", oemIn)
+	}
+	if !oemIn.Segments[0].States[2].Epoch.Equal(start.Add(2*time.Minute)) || !mat64.Equal(oemIn.Segments[0].Covariances[1].P, P) {
+		t.Fatalf("invalid parsed state or covariance: %+v", oemIn.Segments[0])
+	}
+	for segNo, exp := range []*Orbit{o, oHelio} {
+		orbits, err := oemIn.Segments[segNo].Orbits()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rvEqual(orbits[0], exp) {
+			t.Fatalf("segment %d: %s != %s", segNo, orbits[0], exp)
+		}
+	}
+	// Invalid data line
+	if _, err := ParseOEM(strings.NewReader("CCSDS_OEM_VERS = 2.0\nMETA_START\nCENTER_NAME = EARTH\nMETA_STOP\n2017-01-01T00:00:00 1 2 3\n")); err == nil {
+		t.Fatal("invalid ephemeris line accepted")
+	}
+	if _, err := ParseOEM(strings.NewReader("CCSDS_OPM_VERS = 2.0\n")); err == nil {
+		t.Fatal("OPM accepted as OEM")
+	}
+}
+
+func TestOMM(t *testing.T) {
+	tle, err := ParseTLE("1 06251U 62025E   06176.82412014  .00008885  00000-0  12808-3 0  3985", "2 06251  58.0579  54.0425 0030035 139.1568 221.1854 15.56387291  6774")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := NewOMMFromTLE(tle).WriteKVN(&buf); err != nil {
+		t.Fatal(err)
+	}
+	omm, err := ParseOMM(&buf)
+	if err != nil {
+		t.Fatalf("could not parse written OMM: %s\n%s", err, buf.String())
+	}
+	tleIn, err := omm.TLE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tleIn.SatNum != 6251 || tleIn.BStar != tle.BStar || tleIn.MeanMotion != tle.MeanMotion || !tleIn.Epoch.Equal(tle.Epoch) {
+		t.Fatalf("invalid TLE from OMM: %s", tleIn)
+	}
+	dt := tle.Epoch.Add(2 * time.Hour)
+	exp, _ := tle.Orbit(dt)
+	got, err := tleIn.Orbit(dt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !floats.EqualApprox(append(exp.R(), exp.V()...), append(got.R(), got.V()...), 1e-8) {
+		t.Fatalf("SGP4 differs after OMM round trip:\n%s\n%s", exp, got)
+	}
+	// Brouwer mean elements
+	o := NewOrbitFromOE(7500, 0.05, 45, 10, 20, 30, Earth)
+	buf.Reset()
+	if err := NewOMM("test", *o, dt).WriteKVN(&buf); err != nil {
+		t.Fatal(err)
+	}
+	omm, err = ParseOMM(&buf)
+	if err != nil {
+		t.Fatalf("could not parse written OMM: %s\n%s", err, buf.String())
+	}
+	oIn, err := omm.Orbit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := oIn.EqualsWithin(*o, 1e-2, 1e-5, 1e-4); !ok {
+		t.Fatalf("Brouwer OMM round trip failed: %s\n%s\n%s", err, o, oIn)
+	}
+}
@@ -28,6 +28,9 @@ type CelestialObject struct {
 	J4      float64
 	RotRate float64
 	PP      *planetposition.V87Planet
+	// Rotation is the IAU rotation model, used for the body fixed frame and the orientation of the Jn harmonics.
+	Rotation   *IAURotation
+	Flattening float64 // Of the reference ellipsoid, cf. Ellipsoid
 }
 
 // GM returns μ (which is unexported because it's a lowercase letter)
@@ -60,7 +63,7 @@ func (c *CelestialObject) Equals(b CelestialObject) bool {
 	return c.Name == b.Name && c.Radius == b.Radius && c.a == b.a && c.μ == b.μ && c.SOI == b.SOI && c.J2 == b.J2
 }
 
-// HelioOrbit returns the heliocentric position and velocity of this planet at a given time in equatorial coordinates.
+// HelioOrbit returns the heliocentric position and velocity of this planet at a given time in the EclipJ2000 frame.
 // Note that the whole file is loaded. In fact, if we don't, then whoever is the first to call this function will
 // set the Epoch at which the ephemeris are available, and that sucks.
 func (c *CelestialObject) HelioOrbit(dt time.Time) Orbit {
@@ -73,6 +76,13 @@ func (c *CelestialObject) HelioOrbit(dt time.Time) Orbit {
 	return *NewOrbitFromRV(R, V, Sun)
 }
 
+// HelioOrbitIn returns the heliocentric orbit of this planet in the provided inertial frame.
+func (c *CelestialObject) HelioOrbitIn(f Frame, dt time.Time) Orbit {
+	o := c.HelioOrbit(dt)
+	o.ToFrame(f, dt)
+	return o
+}
+
 // CelestialObjectFromString returns the object from its name
 func CelestialObjectFromString(name string) (CelestialObject, error) {
 	switch strings.ToLower(name) {
@@ -102,32 +112,32 @@ func CelestialObjectFromString(name string) (CelestialObject, error) {
 /* Definitions */
 
 // Sun is our closest star.
-var Sun = CelestialObject{"Sun", 695700, -1, 1.32712440017987e11, 0.0, 0.0, -1, 0, 0, 0, 0, nil}
+var Sun = CelestialObject{"Sun", 695700, -1, 1.32712440017987e11, 0.0, 0.0, -1, 0, 0, 0, 0, nil, &IAURotation{286.13, 0, 63.87, 0, 84.176, 14.1844000}, 0}
 
 // Venus is poisonous.
-var Venus = CelestialObject{"Venus", 6051.8, 108208601, 3.24858599e5, 117.36, 3.39458, 0.616e6, 0.000027, 0, 0, 0, nil}
+var Venus = CelestialObject{"Venus", 6051.8, 108208601, 3.24858599e5, 117.36, 3.39458, 0.616e6, 0.000027, 0, 0, 0, nil, &IAURotation{272.76, 0, 67.16, 0, 160.20, -1.4813688}, 0}
 
 // Earth is home.
-var Earth = CelestialObject{"Earth", 6378.1363, 149598023, 3.98600433e5, 23.4393, 0.00005, 924645.0, 1082.6269e-6, -2.5324e-6, -1.6204e-6, 7.292115900231276e-5, nil}
+var Earth = CelestialObject{"Earth", 6378.1363, 149598023, 3.98600433e5, 23.4393, 0.00005, 924645.0, 1082.6269e-6, -2.5324e-6, -1.6204e-6, 7.292115900231276e-5, nil, &IAURotation{0, -0.641, 90, -0.557, 190.147, 360.9856235}, 1 / 298.257223563}
 
 // Mars is the vacation place.
-var Mars = CelestialObject{"Mars", 3396.19, 227939282.5616, 4.28283100e4, 25.19, 1.85, 576000, 1964e-6, 36e-6, -18e-6, 3.878785053314509e-05, nil}
+var Mars = CelestialObject{"Mars", 3396.19, 227939282.5616, 4.28283100e4, 25.19, 1.85, 576000, 1964e-6, 36e-6, -18e-6, 7.088218066303858e-05, nil, &IAURotation{317.68143, -0.1061, 52.88650, -0.0609, 176.630, 350.89198226}, 0.005886}
 
 // Jupiter is big.
-var Jupiter = CelestialObject{"Jupiter", 71492.0, 778298361, 1.266865361e8, 3.13, 1.30326966, 48.2e6, 0.01475, 0, -0.00058, 0, nil}
+var Jupiter = CelestialObject{"Jupiter", 71492.0, 778298361, 1.266865361e8, 3.13, 1.30326966, 48.2e6, 0.01475, 0, -0.00058, 0, nil, &IAURotation{268.056595, -0.006499, 64.495303, 0.002413, 284.95, 870.5360000}, 0.06487}
 
 // Saturn floats and that's really cool.
 // TODO: SOI
-var Saturn = CelestialObject{"Saturn", 60268.0, 1429394133, 3.7931208e7, 0.93, 2.485, 0, 0.01645, 0, -0.001, 0, nil}
+var Saturn = CelestialObject{"Saturn", 60268.0, 1429394133, 3.7931208e7, 0.93, 2.485, 0, 0.01645, 0, -0.001, 0, nil, &IAURotation{40.589, -0.036, 83.537, -0.004, 38.90, 810.7939024}, 0.09796}
 
 // Uranus is no joke.
 // TODO: SOI
-var Uranus = CelestialObject{"Uranus", 25559.0, 2875038615, 5.7939513e6, 1.02, 0.773, 0, 0.012, 0, 0, 0, nil}
+var Uranus = CelestialObject{"Uranus", 25559.0, 2875038615, 5.7939513e6, 1.02, 0.773, 0, 0.012, 0, 0, 0, nil, &IAURotation{257.311, 0, -15.175, 0, 203.81, -501.1600928}, 0.02293}
 
 // Neptune is giant.
 // TODO: SOI
-var Neptune = CelestialObject{"Neptune", 24622.0, 30.110387 * AU, 6.8365299e6, 1.767, 0.72, 0, 0, 0, 0, 0, nil}
+var Neptune = CelestialObject{"Neptune", 24622.0, 30.110387 * AU, 6.8365299e6, 1.767, 0.72, 0, 0, 0, 0, 0, nil, &IAURotation{299.36, 0, 43.46, 0, 253.18, 536.3128492}, 0.01708}
 
 // Pluto is not a planet and had that down ranking coming. It should have stayed in its lane.
 // WARNING: Pluto SOI is not defined.
-var Pluto = CelestialObject{"Pluto", 1151.0, 5915799000, 9. * 1e2, 118.0, 17.14216667, 1, 0, 0, 0, 0, nil}
+var Pluto = CelestialObject{"Pluto", 1151.0, 5915799000, 9. * 1e2, 118.0, 17.14216667, 1, 0, 0, 0, 0, nil, &IAURotation{132.993, 0, -6.163, 0, 302.695, 56.3625225}, 0}
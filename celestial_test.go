@@ -33,11 +33,11 @@ func TestCelestialObject(t *testing.T) {
 
 func TestPanics(t *testing.T) {
 	assertPanic(t, func() {
-		fake := CelestialObject{"Fake", -1, -1, -1, -1, -1, -1, -1, -1, -1, -1, nil}
+		fake := CelestialObject{"Fake", -1, -1, -1, -1, -1, -1, -1, -1, -1, -1, nil, nil, 0}
 		fake.HelioOrbit(time.Now())
 	})
 	assertPanic(t, func() {
-		venus := CelestialObject{"Vesta", -1, -1, -1, -1, -1, -1, -1, -1, -1, -1, nil}
+		venus := CelestialObject{"Vesta", -1, -1, -1, -1, -1, -1, -1, -1, -1, -1, nil, nil, 0}
 		venus.HelioOrbit(time.Now())
 	})
 }
@@ -123,7 +123,8 @@ func TestMeeus(t *testing.T) {
 	meeusconfig := smdConfig()
 	meeusconfig.meeus = true
 	config = meeusconfig
-	R := Earth.HelioOrbit(julian.JDToTime(2456346.2539)).R()
+	// The JDE of the Meeus ephemerides is in TDB.
+	R := Earth.HelioOrbit(EpochFromJD(2456346.2539, TDB).UTC()).R()
 	exp := []float64{-0.146377664880867e8, -1.485144921336979e8, -0.000000771092830e8}
 	for i := 0; i < 3; i++ {
 		if !floats.EqualWithinAbs(R[i], exp[i], 1e-6) {
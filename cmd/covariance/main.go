@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/ChristopherRabotin/smd"
+	"github.com/gonum/matrix/mat64"
+	"github.com/spf13/viper"
+)
+
+// This tool propagates the covariance of a mission along its trajectory (linear covariance analysis), exports its RIC
+// 1σ at each step, and maps it to the B-plane of the target at encounter.
+
+const (
+	defaultScenario = "~~unset~~"
+)
+
+var scenario string
+
+func init() {
+	flag.StringVar(&scenario, "scenario", defaultScenario, "covariance analysis scenario TOML file")
+}
+
+func main() {
+	flag.Parse()
+	// Load scenario
+	if scenario == defaultScenario {
+		log.Fatal("no scenario provided")
+	}
+	scenario = strings.Replace(scenario, ".toml", "", 1)
+	viper.AddConfigPath(".")
+	viper.SetConfigName(scenario)
+	if err := viper.ReadInConfig(); err != nil {
+		log.Fatalf("./%s.toml: Error %s", scenario, err)
+	}
+
+	// Initial covariance and process noise, as variances (cf. cmd/od).
+	mission, err := smd.MissionFromConfig(viper.GetViper(), true)
+	if err != nil {
+		log.Fatalf("[error] mission: %s", err)
+	}
+	rSTM, _ := mission.Φ.Dims()
+	P0 := mat64.NewSymDense(rSTM, nil)
+	for i := 0; i < 3; i++ {
+		P0.SetSym(i, i, viper.GetFloat64("covariance.position"))
+		P0.SetSym(i+3, i+3, viper.GetFloat64("covariance.velocity"))
+	}
+	if rSTM > 6 {
+		P0.SetSym(6, 6, viper.GetFloat64("covariance.Cr"))
+	}
+	ricFrame := viper.GetBool("covariance.RICframe")
+	if ricFrame {
+		// The initial covariance is defined in the RIC frame.
+		dcm := smd.LocalFrameDCM(*mission.Orbit, smd.RIC)
+		rot := mat64.NewDense(rSTM, rSTM, nil)
+		rot.View(0, 0, 3, 3).(*mat64.Dense).Copy(dcm.T())
+		rot.View(3, 3, 3, 3).(*mat64.Dense).Copy(dcm.T())
+		for i := 6; i < rSTM; i++ {
+			rot.Set(i, i, 1)
+		}
+		var rotP, P0ECI mat64.Dense
+		rotP.Mul(rot, P0)
+		P0ECI.Mul(&rotP, rot.T())
+		for i := 0; i < rSTM; i++ {
+			for j := i; j < rSTM; j++ {
+				P0.SetSym(i, j, (P0ECI.At(i, j)+P0ECI.At(j, i))/2)
+			}
+		}
+	}
+	var Q *mat64.SymDense
+	if σQ := viper.GetFloat64("covariance.Q"); σQ > 0 {
+		Q = mat64.NewSymDense(3, []float64{σQ, 0, 0, 0, σQ, 0, 0, 0, σQ})
+	}
+
+	stateChan := make(chan (smd.State), 1)
+	mission.RegisterStateChan(stateChan)
+	go mission.PropagateUntil(smd.MustEpochFromConfig(viper.GetViper(), "mission.end"), true)
+	covars, err := smd.PropagateCovariance(stateChan, P0, Q, ricFrame)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	σR, σV := covars[len(covars)-1].RICSigma()
+	log.Printf("[info] final RIC 1σ: position %v km, velocity %v km/s", σR, σV)
+	outputFile := viper.GetString("covariance.output")
+	if err = smd.ExportCovarianceCSV(outputFile, covars); err != nil {
+		log.Fatalf("[error] could not export the covariance: %s", err)
+	}
+	log.Printf("[info] %d covariances exported to %s", len(covars), outputFile)
+
+	// The encounter is either the provided epoch, or the last state of the trajectory.
+	encounter := covars[len(covars)-1]
+	if viper.IsSet("covariance.encounter") {
+		encounterDT := smd.MustEpochFromConfig(viper.GetViper(), "covariance.encounter")
+		for _, covar := range covars {
+			if !covar.DT.After(encounterDT) {
+				encounter = covar
+			}
+		}
+	}
+	target := encounter.Orbit.Origin
+	if targetName := viper.GetString("covariance.target"); targetName != "" {
+		if target, err = smd.CelestialObjectFromString(targetName); err != nil {
+			log.Fatalf("[error] could not understand target `%s`: %s", targetName, err)
+		}
+	}
+	if ellipse, err := encounter.BPlaneAbout(target); err != nil {
+		log.Printf("[WARNING] no B-plane at encounter: %s", err)
+	} else {
+		log.Printf("[info] B-plane about %s at %s: %s", target, encounter.DT, ellipse)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/ChristopherRabotin/smd"
-	"github.com/soniakeys/meeus/julian"
 	"github.com/spf13/viper"
 )
 
@@ -126,15 +125,5 @@ func readArrival() Arrival {
 func confReadFromUntil(mainKey string) (from, until time.Time) {
 	fromKey := fmt.Sprintf("%s.from", mainKey)
 	untilKey := fmt.Sprintf("%s.until", mainKey)
-	return confReadJDEorTime(fromKey), confReadJDEorTime(untilKey)
-}
-
-func confReadJDEorTime(key string) (dt time.Time) {
-	jde := viper.GetFloat64(key)
-	if jde == 0 {
-		dt = viper.GetTime(key)
-	} else {
-		dt = julian.JDToTime(jde)
-	}
-	return
+	return smd.MustEpochFromConfig(viper.GetViper(), fromKey), smd.MustEpochFromConfig(viper.GetViper(), untilKey)
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -50,9 +51,13 @@ func main() {
 	}
 
 	// Read Mission parameters
-	startDT := confReadJDEorTime("mission.start")
-	endDT := confReadJDEorTime("mission.end")
+	startDT := smd.MustEpochFromConfig(viper.GetViper(), "mission.start")
+	endDT := smd.MustEpochFromConfig(viper.GetViper(), "mission.end")
 	timeStep := viper.GetDuration("mission.step")
+	formulation, err := smd.StateFormulationFromString(viper.GetString("mission.formulation"))
+	if err != nil {
+		log.Fatalf("mission.formulation: %s", err)
+	}
 
 	// Read spacecraft
 	scName := viper.GetString("spacecraft.name")
@@ -66,13 +71,45 @@ func main() {
 	if err != nil {
 		log.Fatalf("could not understand body `%s`: %s", centralBodyName, err)
 	}
-	a := viper.GetFloat64("orbit.sma")
-	e := viper.GetFloat64("orbit.ecc")
-	i := viper.GetFloat64("orbit.inc")
-	Ω := viper.GetFloat64("orbit.RAAN")
-	ω := viper.GetFloat64("orbit.argPeri")
-	ν := viper.GetFloat64("orbit.tAnomaly")
-	scOrbit := smd.NewOrbitFromOE(a, e, i, Ω, ω, ν, centralBody)
+	var scOrbit *smd.Orbit
+	tle, err := smd.TLEFromConfig(viper.GetViper())
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	if opmFile := viper.GetString("orbit.opm"); len(opmFile) > 0 {
+		opm, err := smd.LoadOPM(opmFile)
+		if err != nil {
+			log.Fatalf("[error] could not load OPM `%s`: %s", opmFile, err)
+		}
+		if scOrbit, err = opm.Orbit(); err != nil {
+			log.Fatalf("[error] could not initialize orbit from OPM `%s`: %s", opmFile, err)
+		}
+		if !scOrbit.Origin.Equals(centralBody) {
+			log.Fatalf("[error] OPM orbit is about %s, not %s", scOrbit.Origin, centralBody)
+		}
+		if !opm.Epoch.Equal(startDT) {
+			log.Printf("[WARNING] mission starts at the OPM epoch %s instead of %s", opm.Epoch, startDT)
+			startDT = opm.Epoch
+		}
+		log.Printf("[info] orbit initialized from OPM `%s`", opmFile)
+	} else if tle != nil {
+		if !centralBody.Equals(smd.Earth) {
+			log.Fatalf("[error] TLE orbits are about the Earth, not %s", centralBody)
+		}
+		scOrbit, err = tle.Orbit(startDT)
+		if err != nil {
+			log.Fatalf("[error] could not initialize orbit from %s: %s", tle, err)
+		}
+		log.Printf("[info] orbit initialized from %s", tle)
+	} else {
+		a := viper.GetFloat64("orbit.sma")
+		e := viper.GetFloat64("orbit.ecc")
+		i := viper.GetFloat64("orbit.inc")
+		Ω := viper.GetFloat64("orbit.RAAN")
+		ω := viper.GetFloat64("orbit.argPeri")
+		ν := viper.GetFloat64("orbit.tAnomaly")
+		scOrbit = smd.NewOrbitFromOE(a, e, i, Ω, ω, ν, centralBody)
+	}
 
 	// Read perturbations
 	bodies := viper.GetStringSlice("perturbations.bodies")
@@ -102,16 +139,17 @@ func main() {
 	}
 	perts := smd.Perturbations{Jn: jN, PerturbingBody: pertBody}
 
-	// Read randomness
+	// Read randomness: the orbit noise and each station draw from their own stream of the seed.
+	seed := smd.SeedFromConfig(viper.GetViper(), "mission.seed")
 	if probability := viper.GetFloat64("error.probability"); probability > 0 {
 		position := viper.GetFloat64("error.position")
 		velocity := viper.GetFloat64("error.velocity")
-		perts.Noise = smd.NewOrbitNoise(probability, position, velocity)
+		perts.Noise = smd.NewSeededOrbitNoise(probability, position, velocity, smd.NewRand(smd.StreamSeed(seed, 0)))
 	}
 
 	// Maneuvers
 	for burnNo := 0; viper.IsSet(fmt.Sprintf("burns.%d", burnNo)); burnNo++ {
-		burnDT := confReadJDEorTime(fmt.Sprintf("burns.%d.date", burnNo))
+		burnDT := smd.MustEpochFromConfig(viper.GetViper(), fmt.Sprintf("burns.%d.date", burnNo))
 		R := viper.GetFloat64(fmt.Sprintf("burns.%d.R", burnNo))
 		N := viper.GetFloat64(fmt.Sprintf("burns.%d.N", burnNo))
 		C := viper.GetFloat64(fmt.Sprintf("burns.%d.C", burnNo))
@@ -123,40 +161,72 @@ func main() {
 		}
 	}
 
-	exportConf := smd.ExportConfig{AsCSV: false, Cosmo: true, Filename: scName}
+	exportConf := smd.ExportConfig{AsCSV: false, Cosmo: true, OEM: viper.GetBool("mission.oem"), Filename: scName}
+	if viper.GetBool("mission.groundtrack") {
+		// Export the geodetic sub-satellite point with the orbital elements.
+		exportConf.AsCSV = true
+		exportConf.CSVAppend = smd.GroundTrackCSVAppend
+		exportConf.CSVAppendHdr = smd.GroundTrackCSVAppendHdr
+	}
 	mission := smd.NewPreciseMission(sc, scOrbit, startDT, endDT, perts, timeStep, false, exportConf)
+	mission.Formulation = formulation
 
 	// Stations
 	measurementSampling := viper.GetDuration("measurements.sampling")
 	if viper.GetBool("measurements.enabled") {
+		if eopFile := viper.GetString("measurements.eop"); eopFile != "" {
+			if err := smd.LoadEOP(eopFile); err != nil {
+				log.Fatalf("[error] could not load EOP: %s", err)
+			}
+		}
 		// Read stations
 		stationNames := viper.GetStringSlice("measurements.stations")
 		stations := make([]smd.Station, len(stationNames))
+		var network map[string]smd.Station
+		if networkFile := viper.GetString("measurements.network"); networkFile != "" {
+			var err error
+			if network, err = smd.LoadStationNetwork(networkFile); err != nil {
+				log.Fatalf("[error] could not load station network: %s", err)
+			}
+		}
+		var corrections smd.Corrections
+		if viper.IsSet("corrections") {
+			var err error
+			if corrections, err = smd.CorrectionsFromConfig(viper.GetViper(), "corrections"); err != nil {
+				log.Fatalf("[error] corrections: %s", err)
+			}
+		}
 		for stNo, stationName := range stationNames {
 			if len(stationName) > 8 && stationName[0:8] == "builtin." {
 				stations[stNo] = smd.BuiltinStationFromName(stationName[8:len(stationName)])
+			} else if netSt, found := network[strings.ToLower(stationName)]; found {
+				// Station from the network file, with its masks and availability windows.
+				stations[stNo] = netSt
 			} else {
 				// Read provided station.
-				stationKey := fmt.Sprintf("station.%s.", stationName)
-				humanName := viper.GetString(stationKey + "name")
-				altitude := viper.GetFloat64(stationKey + "altitude")
-				elevation := viper.GetFloat64(stationKey + "elevation")
-				latΦ := viper.GetFloat64(stationKey + "latitude")
-				longθ := viper.GetFloat64(stationKey + "longitude")
-				σρ := viper.GetFloat64(stationKey + "range_sigma")
-				σρDot := viper.GetFloat64(stationKey + "rate_sigma")
-				st := smd.NewStation(humanName, altitude, elevation, latΦ, longθ, σρ, σρDot)
-				if planetName := viper.GetString(stationKey + "planet"); len(planetName) > 0 {
-					// A planet was specified, so it might not be Earth
-					if planet, err := smd.CelestialObjectFromString(planetName); err != nil {
-						log.Fatalf("could not use `%s` as planet for station `%s`: %s", planetName, humanName, err)
-					} else {
-						st.Planet = planet
+				st, err := smd.StationFromConfig(viper.GetViper(), stationName)
+				if err != nil {
+					log.Fatalf("[error] station `%s`: %s", stationName, err)
+				}
+				if st.Link.Type == smd.ThreeWay {
+					txName := viper.GetString(fmt.Sprintf("station.%s.transmitter", stationName))
+					tx, found := network[strings.ToLower(txName)]
+					if !found {
+						if tx, err = smd.StationFromConfig(viper.GetViper(), txName); err != nil {
+							log.Fatalf("[error] transmitter `%s` of station `%s`: %s", txName, stationName, err)
+						}
 					}
+					st.Link.Transmitter = &tx
 				}
 				stations[stNo] = st
 			}
-			log.Printf("[info] added station %s", stations[stNo])
+			if err := stations[stNo].SetCorrections(corrections); err != nil {
+				log.Fatalf("[error] corrections of `%s`: %s", stationName, err)
+			}
+			if stations[stNo].Rand == nil {
+				stations[stNo].Rand = smd.NewRand(smd.StreamSeed(seed, stNo+1))
+			}
+			log.Printf("[info] added station %s (%s: %v)", stations[stNo], stations[stNo].Link.Type, stations[stNo].MeasurementTypes())
 		}
 
 		measChan := make(chan (smd.State))
@@ -165,12 +235,33 @@ func main() {
 
 		go func() {
 			// Create measurement file
-			f, err := os.Create(viper.GetString("measurements.output"))
+			outputFile := viper.GetString("measurements.output")
+			f, err := os.Create(outputFile)
 			if err != nil {
-				panic(fmt.Errorf("error creating file `%s`: %s", viper.GetString("measurements.output"), err))
+				panic(fmt.Errorf("error creating file `%s`: %s", outputFile, err))
+			}
+			// Measurements are exported as a CCSDS TDM if the file has a TDM extension, and as CSV otherwise.
+			var tdm *smd.TDM
+			tdmSegments := make(map[string]int)
+			if isTDMFile(outputFile) {
+				tdm = smd.NewTDM()
+				for stNo, st := range stations {
+					seg, err := smd.NewStationTDMSegment(st, scName)
+					if err != nil {
+						log.Fatalf("[error] %s", err)
+					}
+					tdm.Segments = append(tdm.Segments, seg)
+					tdmSegments[st.Name] = stNo
+				}
+			} else {
+				for _, st := range stations {
+					if len(st.Types) > 0 {
+						log.Fatalf("[error] station `%s` has measurement types %v which require a TDM output file", st.Name, st.Types)
+					}
+				}
+				// Header
+				f.WriteString(fmt.Sprintf("# Creation date (UTC): %s\n\"station name\",\"epoch UTC\",\"Julian day\",\"range (km)\",\"range rate (km/s)\"\n", time.Now()))
 			}
-			// Header
-			f.WriteString(fmt.Sprintf("# Creation date (UTC): %s\n\"station name\",\"epoch UTC\",\"Julian day\",\"Theta GST\",\"range (km)\",\"range rate (km/s)\"\n", time.Now()))
 			// Iterate over each state
 			numVis := 0
 			stationSampling := make(map[string]time.Time)
@@ -182,19 +273,31 @@ func main() {
 				if !more {
 					break
 				}
-				Δt := state.DT.Sub(startDT).Seconds()
-				θgst := Δt * scOrbit.Origin.RotRate
 				for _, st := range stations {
 					if state.DT.Sub(stationSampling[st.Name]).Seconds() >= measurementSampling.Seconds() {
 						stationSampling[st.Name] = state.DT
-						measurement := st.PerformMeasurement(θgst, state)
+						measurement := st.PerformMeasurement(state.DT, state)
 						if measurement.Visible {
-							f.WriteString(fmt.Sprintf("\"%s\",\"%s\",%f,%f,%s\n", st.Name, state.DT.Format(dateFormat), julian.TimeToJD(state.DT), θgst, measurement.ShortCSV()))
+							if tdm != nil {
+								tdm.Segments[tdmSegments[st.Name]].AddMeasurement(measurement)
+							} else {
+								f.WriteString(fmt.Sprintf("\"%s\",\"%s\",%f,%s\n", st.Name, state.DT.Format(dateFormat), julian.TimeToJD(state.DT), measurement.ShortCSV()))
+							}
 							numVis++
 						}
 					}
 				}
 			}
+			if tdm != nil {
+				if strings.HasSuffix(strings.ToLower(outputFile), ".xml") {
+					err = tdm.WriteXML(f)
+				} else {
+					err = tdm.WriteKVN(f)
+				}
+				if err != nil {
+					log.Fatalf("[error] could not write TDM `%s`: %s", outputFile, err)
+				}
+			}
 			f.Close()
 			log.Printf("[info] Generated %d measurements", numVis)
 			wg.Done()
@@ -203,17 +306,28 @@ func main() {
 
 	mission.PropagateUntil(endDT, true)
 	wg.Wait()
-}
 
-func confReadJDEorTime(key string) (dt time.Time) {
-	jde := viper.GetFloat64(key)
-	if jde == 0 {
-		dt = viper.GetTime(key)
-	} else {
-		dt = julian.JDToTime(jde)
-	}
-	if dt == (time.Time{}) {
-		log.Fatalf("[error] could not parse date time in `%s`", key)
+	if tle != nil && mission.Orbit.Origin.Equals(smd.Earth) {
+		// Cross-check the numerical propagation with SGP4.
+		sgp4Orbit, err := tle.Orbit(mission.CurrentDT)
+		if err != nil {
+			log.Printf("[WARNING] could not propagate %s with SGP4: %s", tle, err)
+			return
+		}
+		R, V := mission.Orbit.RV()
+		Rs, Vs := sgp4Orbit.RV()
+		ΔR := make([]float64, 3)
+		ΔV := make([]float64, 3)
+		for i := 0; i < 3; i++ {
+			ΔR[i] = R[i] - Rs[i]
+			ΔV[i] = V[i] - Vs[i]
+		}
+		log.Printf("[info] SGP4 @ %s: %s\n|ΔR|=%f km\t|ΔV|=%f km/s", mission.CurrentDT, sgp4Orbit, smd.Norm(ΔR), smd.Norm(ΔV))
 	}
-	return
+}
+
+// isTDMFile returns whether the measurement file is a CCSDS TDM (KVN or XML) from its extension.
+func isTDMFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".tdm" || ext == ".kvn" || ext == ".xml"
 }
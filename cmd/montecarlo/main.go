@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/ChristopherRabotin/smd"
+	"github.com/spf13/viper"
+)
+
+// This tool runs a Monte Carlo dispersion analysis of a mission, and exports the runs and their statistics.
+
+const (
+	defaultScenario = "~~unset~~"
+)
+
+var scenario string
+
+func init() {
+	flag.StringVar(&scenario, "scenario", defaultScenario, "Monte Carlo scenario TOML file")
+}
+
+func main() {
+	flag.Parse()
+	// Load scenario
+	if scenario == defaultScenario {
+		log.Fatal("no scenario provided")
+	}
+	scenario = strings.Replace(scenario, ".toml", "", 1)
+	viper.AddConfigPath(".")
+	viper.SetConfigName(scenario)
+	if err := viper.ReadInConfig(); err != nil {
+		log.Fatalf("./%s.toml: Error %s", scenario, err)
+	}
+
+	// The nominal mission is only used for its spacecraft, initial orbit, perturbations and formulation.
+	nominal, err := smd.MissionFromConfig(viper.GetViper(), false)
+	if err != nil {
+		log.Fatalf("[error] mission: %s", err)
+	}
+	perts, err := smd.PerturbationsFromConfig(viper.GetViper())
+	if err != nil {
+		log.Fatalf("[error] perturbations: %s", err)
+	}
+	dispersions, err := smd.DispersionsFromConfig(viper.GetViper(), "dispersions")
+	if err != nil {
+		log.Fatalf("[error] dispersions: %s", err)
+	}
+	mc := smd.MonteCarlo{
+		Nominal:       nominalRun(nominal),
+		Start:         nominal.StartDT,
+		End:           smd.MustEpochFromConfig(viper.GetViper(), "mission.end"),
+		Step:          viper.GetDuration("mission.step"),
+		Perturbations: perts,
+		Formulation:   nominal.Formulation,
+		Dispersions:   dispersions,
+		Runs:          viper.GetInt("montecarlo.runs"),
+		Workers:       viper.GetInt("montecarlo.workers"),
+		Seed:          smd.SeedFromConfig(viper.GetViper(), "montecarlo.seed"),
+	}
+	log.Printf("[info] %d runs with the dispersions %+v", mc.Runs, dispersions)
+	result, err := mc.Run()
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	if result.Failed > 0 {
+		log.Printf("[WARNING] %d runs failed", result.Failed)
+	}
+	for _, stats := range result.Stats {
+		log.Printf("[info] %-8s mean=%f σ=%f p5=%f p50=%f p95=%f p99=%f", stats.Name, stats.Mean, stats.Std, stats.P5, stats.P50, stats.P95, stats.P99)
+	}
+	if result.BPlane != nil {
+		log.Printf("[info] B-plane of %d runs: BR=%f BT=%f 1σ ellipse %f x %f km @ %f deg", result.BPlane.Runs, result.BPlane.BR, result.BPlane.BT, result.BPlane.SemiMaj, result.BPlane.SemiMin, result.BPlane.Angle)
+	}
+	outPrefix := viper.GetString("montecarlo.output")
+	if err = result.ExportCSV(outPrefix + ".csv"); err != nil {
+		log.Fatalf("[error] could not export the runs: %s", err)
+	}
+	if err = result.ExportJSON(outPrefix + ".json"); err != nil {
+		log.Fatalf("[error] could not export the runs: %s", err)
+	}
+	log.Printf("[info] runs exported to %s.csv (statistics in %s-stats.csv) and %s.json", outPrefix, outPrefix, outPrefix)
+}
+
+// nominalRun returns the function creating a copy of the spacecraft and initial orbit of the nominal mission for each
+// run, since the dispersions alter them.
+func nominalRun(nominal *smd.Mission) func() (*smd.Spacecraft, *smd.Orbit) {
+	vehicle := nominal.Vehicle
+	R, V := nominal.Orbit.RV()
+	origin, frame := nominal.Orbit.Origin, nominal.Orbit.Frame
+	return func() (*smd.Spacecraft, *smd.Orbit) {
+		sc := smd.NewSpacecraft(vehicle.Name, vehicle.DryMass, vehicle.FuelMass, smd.NewUnlimitedEPS(), []smd.EPThruster{}, true, []*smd.Cargo{}, []smd.Waypoint{})
+		sc.Drag = vehicle.Drag
+		for burnDT, burn := range vehicle.Maneuvers {
+			sc.Maneuvers[burnDT] = burn
+		}
+		o := smd.NewOrbitFromRV(R, V, origin)
+		o.Frame = frame
+		return sc, o
+	}
+}
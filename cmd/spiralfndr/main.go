@@ -23,6 +23,8 @@ var (
 	cpus     int
 	planet   string
 	stepSize float64
+	parkDays float64
+	jn       int
 	wg       sync.WaitGroup
 )
 
@@ -31,11 +33,14 @@ func init() {
 	flag.IntVar(&cpus, "cpus", -1, "number of CPUs to use for this simulation (set to 0 for max CPUs)")
 	flag.StringVar(&planet, "planet", "undef", "departure planet to perform the spiral from")
 	flag.Float64Var(&stepSize, "step", 15, "step size (10 to 30 recommended)")
+	flag.Float64Var(&parkDays, "park", 0, "days in the parking orbit before the spiral, with the averaged zonal perturbations")
+	flag.IntVar(&jn, "jn", 2, "highest zonal harmonic of the parking orbit perturbations (2 to 4)")
 }
 
 /*
  * This example shows how to find the greatest heliocentric velocity at the end of a spiral by iterating on the initial
- * true anomaly.
+ * true anomaly. The initial orbit may be a parking orbit, whose drift due to the zonal harmonics until the departure is
+ * computed with the averaged (semi-analytic) propagator, i.e. in a few steps even for months of parking.
  */
 
 func sc() *smd.Spacecraft {
@@ -75,6 +80,11 @@ func main() {
 	} else if stepSize <= 5 {
 		fmt.Println("[WARNING] A small step size will take several days to iterate over all possibilities")
 	}
+	if parkDays < 0 || jn < 2 || jn > 4 {
+		fmt.Println("parking duration must be positive and the zonal harmonic between 2 and 4")
+		flag.Usage()
+		return
+	}
 
 	var orbitPtr func(i, Ω, ω, ν float64) *smd.Orbit
 	planet = strings.ToLower(planet)
@@ -107,6 +117,12 @@ func main() {
 			for ω := 0.0; ω < 360; ω += stepSize {
 				for ν := 0.0; ν < 360; ν += stepSize {
 					initOrbit := orbitPtr(i, Ω, ω, ν)
+					if parkDays > 0 {
+						park := time.Duration(parkDays * 24 * float64(time.Hour))
+						mean := smd.NewMeanOrbit(*initOrbit, uint8(jn), depart.Add(-park), 6*time.Hour)
+						mean.PropagateUntil(depart)
+						initOrbit = mean.Orbit()
+					}
 					astro := smd.NewMission(sc(), initOrbit, depart, depart.Add(-1), smd.Perturbations{}, false, smd.ExportConfig{})
 					astro.Propagate()
 
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ChristopherRabotin/smd"
+	"github.com/gonum/matrix/mat64"
+	"github.com/spf13/viper"
+)
+
+// This tool computes the statistics of the trajectory correction maneuvers (TCM) targeting the B-plane of a mission at
+// encounter, from the initial dispersion, the navigation knowledge at each TCM and the execution errors, and exports
+// the Δv of each sample and their statistics (including the Δv-99).
+
+const (
+	defaultScenario = "~~unset~~"
+)
+
+var scenario string
+
+func init() {
+	flag.StringVar(&scenario, "scenario", defaultScenario, "TCM analysis scenario TOML file")
+}
+
+func main() {
+	flag.Parse()
+	// Load scenario
+	if scenario == defaultScenario {
+		log.Fatal("no scenario provided")
+	}
+	scenario = strings.Replace(scenario, ".toml", "", 1)
+	viper.AddConfigPath(".")
+	viper.SetConfigName(scenario)
+	if err := viper.ReadInConfig(); err != nil {
+		log.Fatalf("./%s.toml: Error %s", scenario, err)
+	}
+
+	// The initial dispersion and the execution errors are 1σ, as in cmd/montecarlo.
+	dispersions, err := smd.DispersionsFromConfig(viper.GetViper(), "dispersions")
+	if err != nil {
+		log.Fatalf("[error] dispersions: %s", err)
+	}
+	if dispersions.P0 == nil {
+		log.Fatal("[error] no initial dispersion of the position and velocity")
+	}
+	var tcms []smd.TCM
+	for tcmNo := 0; viper.IsSet(fmt.Sprintf("tcm.%d", tcmNo)); tcmNo++ {
+		tcm := smd.TCM{DT: smd.MustEpochFromConfig(viper.GetViper(), fmt.Sprintf("tcm.%d.date", tcmNo))}
+		σPos, σVel := viper.GetFloat64(fmt.Sprintf("tcm.%d.position", tcmNo)), viper.GetFloat64(fmt.Sprintf("tcm.%d.velocity", tcmNo))
+		if σPos > 0 || σVel > 0 {
+			tcm.Knowledge = mat64.NewSymDense(6, nil)
+			for i := 0; i < 3; i++ {
+				tcm.Knowledge.SetSym(i, i, σPos*σPos)
+				tcm.Knowledge.SetSym(i+3, i+3, σVel*σVel)
+			}
+		} else {
+			log.Printf("[WARNING] perfect knowledge at `tcm.%d`", tcmNo)
+		}
+		tcms = append(tcms, tcm)
+	}
+	if len(tcms) == 0 {
+		log.Fatal("[error] no TCM defined")
+	}
+	mission, err := smd.MissionFromConfig(viper.GetViper(), true)
+	if err != nil {
+		log.Fatalf("[error] mission: %s", err)
+	}
+	// The B-plane is that of the target at encounter, e.g. of the arrival planet of an interplanetary trajectory.
+	target := mission.Orbit.Origin
+	if targetName := viper.GetString("tcm.target"); targetName != "" {
+		if target, err = smd.CelestialObjectFromString(targetName); err != nil {
+			log.Fatalf("[error] could not understand target `%s`: %s", targetName, err)
+		}
+	}
+	plan := smd.TCMPlan{
+		Mission:   mission,
+		Target:    target,
+		Encounter: smd.MustEpochFromConfig(viper.GetViper(), "tcm.encounter"),
+		P0:        dispersions.P0,
+		TCMs:      tcms,
+		Execution: dispersions,
+		Samples:   viper.GetInt("tcm.samples"),
+		Seed:      smd.SeedFromConfig(viper.GetViper(), "tcm.seed"),
+	}
+	log.Printf("[info] %d samples of %d TCMs targeting the B-plane of %s", plan.Samples, len(tcms), target)
+	result, err := plan.Run()
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	log.Printf("[info] nominal B-plane without TCM: %s", result.Nominal)
+	log.Printf("[info] delivered B-plane: %s", result.Delivery)
+	for _, stats := range append(result.TCMs, smd.TCMStats{MonteCarloStats: result.Total}) {
+		log.Printf("[info] %-6s mean=%f σ=%f p50=%f p95=%f p99=%f max=%f km/s", stats.Name, stats.Mean, stats.Std, stats.P50, stats.P95, stats.P99, stats.Max)
+	}
+	log.Printf("[info] Δv-99 = %f m/s", result.Δv99()*1e3)
+	outPrefix := viper.GetString("tcm.output")
+	if err = result.ExportCSV(outPrefix + ".csv"); err != nil {
+		log.Fatalf("[error] could not export the samples: %s", err)
+	}
+	if err = result.ExportJSON(outPrefix + ".json"); err != nil {
+		log.Fatalf("[error] could not export the samples: %s", err)
+	}
+	log.Printf("[info] samples exported to %s.csv (statistics in %s-stats.csv) and %s.json", outPrefix, outPrefix, outPrefix)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ChristopherRabotin/smd"
+	"github.com/spf13/viper"
+)
+
+// This tool simulates the tracking data of a station network along a truth trajectory, and exports it in a
+// measurement file which cmd/od reads directly.
+
+const (
+	defaultScenario = "~~unset~~"
+)
+
+var scenario string
+
+func init() {
+	flag.StringVar(&scenario, "scenario", defaultScenario, "tracking simulation scenario TOML file")
+}
+
+func main() {
+	flag.Parse()
+	// Load scenario
+	if scenario == defaultScenario {
+		log.Fatal("no scenario provided")
+	}
+	scenario = strings.Replace(scenario, ".toml", "", 1)
+	viper.AddConfigPath(".")
+	viper.SetConfigName(scenario)
+	if err := viper.ReadInConfig(); err != nil {
+		log.Fatalf("./%s.toml: Error %s", scenario, err)
+	}
+
+	// Read stations
+	stationNames := viper.GetStringSlice("measurements.stations")
+	if len(stationNames) == 0 {
+		log.Fatal("[error] no station in `measurements.stations`")
+	}
+	var network map[string]smd.Station
+	var err error
+	if networkFile := viper.GetString("measurements.network"); networkFile != "" {
+		if network, err = smd.LoadStationNetwork(networkFile); err != nil {
+			log.Fatalf("[error] could not load station network: %s", err)
+		}
+	}
+	var corrections smd.Corrections
+	if viper.IsSet("corrections") {
+		if corrections, err = smd.CorrectionsFromConfig(viper.GetViper(), "corrections"); err != nil {
+			log.Fatalf("[error] corrections: %s", err)
+		}
+		log.Printf("[info] measurement corrections: %+v", corrections)
+	}
+	if eopFile := viper.GetString("measurements.eop"); eopFile != "" {
+		if err = smd.LoadEOP(eopFile); err != nil {
+			log.Fatalf("[error] could not load EOP: %s", err)
+		}
+	}
+	stations := make([]smd.Station, len(stationNames))
+	schedules := make([]smd.TrackingSchedule, len(stationNames))
+	for pos, stationName := range stationNames {
+		var st smd.Station
+		if len(stationName) > 8 && stationName[0:8] == "builtin." {
+			st = smd.BuiltinStationFromName(stationName[8:len(stationName)])
+		} else if netSt, found := network[strings.ToLower(stationName)]; found {
+			st = netSt
+		} else {
+			if st, err = smd.StationFromConfig(viper.GetViper(), stationName); err != nil {
+				log.Fatalf("[error] station `%s`: %s", stationName, err)
+			}
+			if st.Link.Type == smd.ThreeWay {
+				txName := viper.GetString(fmt.Sprintf("station.%s.transmitter", stationName))
+				tx, found := network[strings.ToLower(txName)]
+				if !found {
+					if tx, err = smd.StationFromConfig(viper.GetViper(), txName); err != nil {
+						log.Fatalf("[error] transmitter `%s` of station `%s`: %s", txName, stationName, err)
+					}
+				}
+				st.Link.Transmitter = &tx
+			}
+		}
+		if err = st.SetCorrections(corrections); err != nil {
+			log.Fatalf("[error] corrections of `%s`: %s", stationName, err)
+		}
+		stations[pos] = st
+		// The schedule of a station is set by the key used in `measurements.stations` (without the builtin prefix).
+		if schedules[pos], err = smd.TrackingScheduleFromConfig(viper.GetViper(), strings.TrimPrefix(stationName, "builtin.")); err != nil {
+			log.Fatalf("[error] tracking of `%s`: %s", stationName, err)
+		}
+		log.Printf("[info] added station %s (%s: %v) %+v", st, st.Link.Type, st.MeasurementTypes(), schedules[pos])
+	}
+
+	simulator, err := smd.NewTrackingSimulator(stations, schedules, smd.SeedFromConfig(viper.GetViper(), "tracking.seed"))
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+
+	// The truth is either an OEM (e.g. exported by cmd/mission with `mission.oem`), or propagated from the orbit.
+	stateChan := make(chan (smd.State), 1)
+	scName := viper.GetString("spacecraft.name")
+	if oemFile := viper.GetString("truth.oem"); oemFile != "" {
+		states, name := confReadOEM(oemFile)
+		if scName == "" {
+			scName = name
+		}
+		go func() {
+			for _, state := range states {
+				stateChan <- state
+			}
+			close(stateChan)
+		}()
+	} else {
+		mission := confReadMission(scName)
+		mission.RegisterStateChan(stateChan)
+		go mission.PropagateUntil(smd.MustEpochFromConfig(viper.GetViper(), "mission.end"), true)
+	}
+	measurements, err := simulator.Simulate(stateChan)
+	if err != nil {
+		log.Fatalf("[error] simulation: %s", err)
+	}
+	outputFile := viper.GetString("measurements.output")
+	if err = measurements.Export(outputFile, scName); err != nil {
+		log.Fatalf("[error] could not export the measurements: %s", err)
+	}
+	log.Printf("[info] generated %d measurements at %d epochs in %s", measurements.Count, len(measurements.Epochs), outputFile)
+}
+
+// confReadOEM returns the states of the provided OEM, and the name of the object of its first segment.
+func confReadOEM(filename string) ([]smd.State, string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("[error] could not open truth `%s`: %s", filename, err)
+	}
+	defer f.Close()
+	oem, err := smd.ParseOEM(f)
+	if err != nil {
+		log.Fatalf("[error] could not parse truth `%s`: %s", filename, err)
+	}
+	var states []smd.State
+	for _, segment := range oem.Segments {
+		orbits, err := segment.Orbits()
+		if err != nil {
+			log.Fatalf("[error] truth `%s`: %s", filename, err)
+		}
+		for i, state := range segment.States {
+			states = append(states, smd.State{DT: state.Epoch, Orbit: *orbits[i]})
+		}
+	}
+	if len(states) == 0 {
+		log.Fatalf("[error] no state in truth `%s`", filename)
+	}
+	log.Printf("[info] loaded %d true states from %s", len(states), filename)
+	return states, oem.Segments[0].Metadata.ObjectName
+}
+
+// confReadMission returns the mission propagating the orbit from the `mission`, `orbit`, `perturbations` and `burns`
+// sections.
+func confReadMission(scName string) *smd.Mission {
+	startDT := smd.MustEpochFromConfig(viper.GetViper(), "mission.start")
+	sc := smd.NewSpacecraft(scName, viper.GetFloat64("spacecraft.dry"), viper.GetFloat64("spacecraft.fuel"), smd.NewUnlimitedEPS(), []smd.EPThruster{}, true, []*smd.Cargo{}, []smd.Waypoint{})
+	centralBody, err := smd.CelestialObjectFromString(viper.GetString("orbit.body"))
+	if err != nil {
+		log.Fatalf("[error] could not understand body `%s`: %s", viper.GetString("orbit.body"), err)
+	}
+	var scOrbit *smd.Orbit
+	if viper.GetBool("orbit.viaRV") {
+		R := make([]float64, 3)
+		V := make([]float64, 3)
+		for i := 0; i < 3; i++ {
+			R[i] = viper.GetFloat64(fmt.Sprintf("orbit.R%d", i+1))
+			V[i] = viper.GetFloat64(fmt.Sprintf("orbit.V%d", i+1))
+		}
+		scOrbit = smd.NewOrbitFromRV(R, V, centralBody)
+	} else {
+		a := viper.GetFloat64("orbit.sma")
+		if a == 0 {
+			log.Fatalln("[error] semi major axis is nil, check where viaRV should be enabled")
+		}
+		scOrbit = smd.NewOrbitFromOE(a, viper.GetFloat64("orbit.ecc"), viper.GetFloat64("orbit.inc"), viper.GetFloat64("orbit.RAAN"), viper.GetFloat64("orbit.argPeri"), viper.GetFloat64("orbit.tAnomaly"), centralBody)
+	}
+	var jN uint8
+	if viper.GetBool("perturbations.J4") {
+		jN = 4
+	} else if viper.GetBool("perturbations.J3") {
+		jN = 3
+	} else if viper.GetBool("perturbations.J2") {
+		jN = 2
+	}
+	for burnNo := 0; viper.IsSet(fmt.Sprintf("burns.%d", burnNo)); burnNo++ {
+		burnDT := smd.MustEpochFromConfig(viper.GetViper(), fmt.Sprintf("burns.%d.date", burnNo))
+		V := viper.GetFloat64(fmt.Sprintf("burns.%d.V", burnNo))
+		N := viper.GetFloat64(fmt.Sprintf("burns.%d.N", burnNo))
+		C := viper.GetFloat64(fmt.Sprintf("burns.%d.C", burnNo))
+		sc.Maneuvers[burnDT] = smd.NewManeuver(V, N, C)
+	}
+	return smd.NewPreciseMission(sc, scOrbit, startDT, startDT.Add(-1), smd.Perturbations{Jn: jN}, viper.GetDuration("mission.step"), false, smd.ExportConfig{})
+}
@@ -2,6 +2,7 @@ package smd
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -46,22 +47,6 @@ func (c _smdconfig) String() string {
 	return fmt.Sprintf("[smd:config] SPICE: SpiceyPy - %s", c.SPICEDir)
 }
 
-func (c _smdconfig) ChgFrame(toFrame, fromFrame string, epoch time.Time, state []float64) planetstate {
-	conf := smdConfig()
-	stateStr := ""
-	for _, val := range state {
-		stateStr += fmt.Sprintf("%f,", val)
-	}
-	stateStr = fmt.Sprintf("[%s]", stateStr[:len(stateStr)-1]) // Trim the last comma
-	cmd := exec.Command("python", conf.SPICEDir+"/chgframe.py", "-t", toFrame, "-f", fromFrame, "-e", epoch.Format(time.ANSIC), "-s", stateStr)
-	cmdOut, err := cmd.Output()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "command attempted:\npython %s/chgframe.py -t %s -f %s -e \"%s\" -s %s\n", conf.SPICEDir, toFrame, fromFrame, epoch.Format(time.ANSIC), stateStr)
-		panic(fmt.Errorf("error running chgframe: %s \ncheck that you are in the Python virtual environment", err))
-	}
-	return stateFromString(cmdOut)
-}
-
 func (c _smdconfig) HelioState(planet string, epoch time.Time) planetstate {
 	epoch = epoch.UTC()
 	conf := smdConfig()
@@ -69,7 +54,7 @@ func (c _smdconfig) HelioState(planet string, epoch time.Time) planetstate {
 		if planet != "Earth" {
 			panic("Meeus only supports Earth ephemerides")
 		}
-		t := (julian.TimeToJD(epoch) - 2451545.0) / 36525
+		t := (NewEpoch(epoch, UTC).JD(TDB) - 2451545.0) / 36525
 		tVec := []float64{1, t, t * t, t * t * t}
 		/* Earth coeffs */
 		L := []float64{100.466449, 35999.3728519, -0.00000568, 0.0}
@@ -234,3 +219,133 @@ func smdConfig() _smdconfig {
 	config = _smdconfig{SPICEDir: spiceDir, spiceTrunc: spiceTruncation, spiceCSV: spiceCSV, HorizonDir: spiceCSVDir, outputDir: outputDir, testExport: testExport, meeus: meeus}
 	return config
 }
+
+// MissionFromConfig returns the mission of the scenario (e.g. of cmd/mission) from the provided configuration, i.e. the
+// `mission`, `spacecraft`, `orbit`, `perturbations` and `burns` sections. The orbit is either read from an OPM
+// (`orbit.opm`), from the last state of an OEM (`orbit.oem`, e.g. exported by cmd/mission) at the mission start, from
+// its vectors (`orbit.viaRV`) or from its elements. The mission starts at the epoch of the OPM or OEM state if any.
+func MissionFromConfig(v *viper.Viper, computeSTM bool) (*Mission, error) {
+	startDT, err := EpochFromConfig(v, "mission.start")
+	if err != nil && v.GetString("orbit.opm") == "" {
+		return nil, err
+	}
+	sc := NewSpacecraft(v.GetString("spacecraft.name"), v.GetFloat64("spacecraft.dry"), v.GetFloat64("spacecraft.fuel"), NewUnlimitedEPS(), []EPThruster{}, true, []*Cargo{}, []Waypoint{})
+	sc.Drag = v.GetFloat64("spacecraft.Cr")
+	centralBody, err := CelestialObjectFromString(v.GetString("orbit.body"))
+	if err != nil {
+		return nil, fmt.Errorf("could not understand body `%s`: %s", v.GetString("orbit.body"), err)
+	}
+	var scOrbit *Orbit
+	if opmFile := v.GetString("orbit.opm"); opmFile != "" {
+		opm, err := LoadOPM(opmFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load OPM `%s`: %s", opmFile, err)
+		}
+		if scOrbit, err = opm.Orbit(); err != nil {
+			return nil, fmt.Errorf("OPM `%s`: %s", opmFile, err)
+		}
+		startDT = opm.Epoch
+	} else if oemFile := v.GetString("orbit.oem"); oemFile != "" {
+		if scOrbit, startDT, err = oemStateAt(oemFile, startDT); err != nil {
+			return nil, err
+		}
+	} else if v.GetBool("orbit.viaRV") {
+		R := make([]float64, 3)
+		V := make([]float64, 3)
+		for i := 0; i < 3; i++ {
+			R[i] = v.GetFloat64(fmt.Sprintf("orbit.R%d", i+1))
+			V[i] = v.GetFloat64(fmt.Sprintf("orbit.V%d", i+1))
+		}
+		if Norm(R) == 0 {
+			return nil, errors.New("the radius vector is nil, check where viaRV should be enabled")
+		}
+		scOrbit = NewOrbitFromRV(R, V, centralBody)
+	} else {
+		a := v.GetFloat64("orbit.sma")
+		if a == 0 {
+			return nil, errors.New("the semi major axis is nil, check where viaRV should be enabled")
+		}
+		scOrbit = NewOrbitFromOE(a, v.GetFloat64("orbit.ecc"), v.GetFloat64("orbit.inc"), v.GetFloat64("orbit.RAAN"), v.GetFloat64("orbit.argPeri"), v.GetFloat64("orbit.tAnomaly"), centralBody)
+	}
+	if !scOrbit.Origin.Equals(centralBody) {
+		return nil, fmt.Errorf("the orbit is about %s, not %s", scOrbit.Origin, centralBody)
+	}
+	// The missions propagate the orbits in the default frame of their origin (e.g. for ToXCentric).
+	scOrbit.ToFrame(defaultFrame(centralBody), startDT)
+	perts, err := PerturbationsFromConfig(v)
+	if err != nil {
+		return nil, err
+	}
+	for burnNo := 0; v.IsSet(fmt.Sprintf("burns.%d", burnNo)); burnNo++ {
+		burnDT, err := EpochFromConfig(v, fmt.Sprintf("burns.%d.date", burnNo))
+		if err != nil {
+			return nil, err
+		}
+		R := v.GetFloat64(fmt.Sprintf("burns.%d.R", burnNo))
+		N := v.GetFloat64(fmt.Sprintf("burns.%d.N", burnNo))
+		C := v.GetFloat64(fmt.Sprintf("burns.%d.C", burnNo))
+		sc.Maneuvers[burnDT] = NewManeuver(R, N, C)
+	}
+	mission := NewPreciseMission(sc, scOrbit, startDT, startDT.Add(-1), perts, v.GetDuration("mission.step"), computeSTM, ExportConfig{})
+	if name := v.GetString("mission.formulation"); name != "" {
+		if mission.Formulation, err = StateFormulationFromString(name); err != nil {
+			return nil, err
+		}
+	}
+	return mission, nil
+}
+
+// PerturbationsFromConfig returns the perturbations of the `perturbations` section of the provided configuration, i.e.
+// the J2, J3 and J4 flags, the perturbing bodies (only the Sun is supported) and the SRP with the Cr of the spacecraft.
+func PerturbationsFromConfig(v *viper.Viper) (perts Perturbations, err error) {
+	if v.GetBool("perturbations.J4") {
+		perts.Jn = 4
+	} else if v.GetBool("perturbations.J3") {
+		perts.Jn = 3
+	} else if v.GetBool("perturbations.J2") {
+		perts.Jn = 2
+	}
+	for _, body := range v.GetStringSlice("perturbations.bodies") {
+		celObj, err := CelestialObjectFromString(body)
+		if err != nil {
+			return perts, fmt.Errorf("could not understand body `%s`: %s", body, err)
+		}
+		if !celObj.Equals(Sun) {
+			return perts, fmt.Errorf("perturbing body `%s` not yet supported (only the Sun is)", body)
+		}
+		perts.PerturbingBody = &celObj
+	}
+	// The solar radiation pressure uses the Cr of the spacecraft, which is then part of the STM.
+	perts.Drag = v.GetBool("perturbations.SRP")
+	return perts, nil
+}
+
+// oemStateAt returns the orbit of the last state of the OEM file at or before the provided epoch, and its epoch.
+func oemStateAt(filename string, dt time.Time) (*Orbit, time.Time, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, dt, err
+	}
+	defer f.Close()
+	oem, err := ParseOEM(f)
+	if err != nil {
+		return nil, dt, fmt.Errorf("could not parse OEM `%s`: %s", filename, err)
+	}
+	var orbit *Orbit
+	var epoch time.Time
+	for _, segment := range oem.Segments {
+		orbits, err := segment.Orbits()
+		if err != nil {
+			return nil, dt, fmt.Errorf("OEM `%s`: %s", filename, err)
+		}
+		for i, state := range segment.States {
+			if !state.Epoch.After(dt) && (orbit == nil || state.Epoch.After(epoch)) {
+				orbit, epoch = orbits[i], state.Epoch
+			}
+		}
+	}
+	if orbit == nil {
+		return nil, dt, fmt.Errorf("no state of OEM `%s` at or before %s", filename, dt)
+	}
+	return orbit, epoch, nil
+}
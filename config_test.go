@@ -25,14 +25,15 @@ func TestSpice(t *testing.T) {
 	scV := []float64{-0.5114606889356655, -0.6914491357021403, -0.34254913653144525}
 	scOrbit := NewOrbitFromRV(scR, scV, Earth)
 	scOrbit.ToXCentric(Sun, time.Date(2016, 3, 24, 20, 41, 48, 0, time.UTC))
-	// I should get the following, but it's actually very slightly off (maybe a second or two) and I don't have time to debug precisely why.
-	// [-148030923.95108017, -12123548.951590259, 302492.17670564854, 2.6590243298160754, -29.849194304414752, -0.35374685933315347]
-	expR = []float64{-1.4803092395107993e+08, -1.2123548951590609e+07, 302492.1767054541}
-	expV = []float64{2.6590244425141782, -29.84919454394858, -0.3537466068661061}
-	if !floats.Equal(scOrbit.rVec, expR) {
+	// The geocentric state is in the ICRF, and the heliocentric one in the EclipJ2000 frame. The expected state is the
+	// SPICE state of the Earth (which was the origin of the former IAU_EARTH expectation of chgframe.py), plus the
+	// spacecraft vectors rotated by the J2000 obliquity of 84381.448 arcseconds.
+	expR = []float64{-1.4973571565565923e+08, -1.151480056796311e+07, 39787.05519355689}
+	expV = []float64{1.2934699252747226, -30.571494378491238, -0.039122512301925705}
+	if scOrbit.Frame != EclipJ2000 || !floats.EqualApprox(scOrbit.rVec, expR, 1e-6) {
 		t.Fatal("incorrect R")
 	}
-	if !floats.Equal(scOrbit.vVec, expV) {
+	if !floats.EqualApprox(scOrbit.vVec, expV, 1e-9) {
 		t.Fatal("incorrect V")
 	}
 }
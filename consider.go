@@ -0,0 +1,119 @@
+package smd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+/* Consider covariance analysis, cf. Tapley, Schutz and Born, "Statistical Orbit Determination", 2004, section 6.3.
+The considered parameters are not estimated, but the sensitivity S of the estimation error to their errors is
+propagated along with the filter, and their a priori covariance Pcc inflates the covariance of the estimate to the
+consider covariance P + S*Pcc*S^T. With the augmented STM [[Φ, θ], [0, I]] and H = [Hx, Hc], the sensitivity of the
+sequential filters is predicted as Φ*S - θ and updated as (I - K*Hx)*S + K*Hc, where K is the gain of the filter. The
+sensitivity of the batch at the initial epoch is P*Σ(Hx^T*W*Hc), and it is mapped as Φ(t, t0)*S - θ(t, t0). */
+
+// considerAPriori returns the a priori covariance of the considered parameters.
+func (p *odParameters) considerAPriori() *mat64.SymDense {
+	Pcc := mat64.NewSymDense(p.consider, nil)
+	for i, param := range p.list {
+		for k := 0; k < param.Type.Size() && param.Consider; k++ {
+			col := p.columns[i] - 6 - p.size + k
+			Pcc.SetSym(col, col, param.Sigma*param.Sigma)
+		}
+	}
+	return Pcc
+}
+
+// considerCovariance returns the consider covariance of the provided covariance and sensitivity.
+func (p *odParameters) considerCovariance(P mat64.Symmetric, S *mat64.Dense) *mat64.SymDense {
+	var SPcc, SPccSt mat64.Dense
+	SPcc.Mul(S, p.considerAPriori())
+	SPccSt.Mul(&SPcc, S.T())
+	n, _ := P.Dims()
+	Pc := mat64.NewSymDense(n, nil)
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			Pc.SetSym(i, j, P.At(i, j)+(SPccSt.At(i, j)+SPccSt.At(j, i))/2)
+		}
+	}
+	return Pc
+}
+
+// considerMapping returns Φ*S - θ from the provided augmented STM and sensitivity of the estimated state.
+func considerMapping(Φ, S *mat64.Dense) *mat64.Dense {
+	n, q := S.Dims()
+	var mapped mat64.Dense
+	mapped.Mul(Φ.View(0, 0, n, n), S)
+	mapped.Sub(&mapped, Φ.View(0, n, n, q))
+	return &mapped
+}
+
+// considerUpdate returns the sensitivity after a measurement update of the sequential filters, whose gain is computed
+// from the predicted covariance, the augmented H and the measurement noise.
+func considerUpdate(S *mat64.Dense, Pbar mat64.Symmetric, H *mat64.Dense, noiseR mat64.Symmetric) (*mat64.Dense, error) {
+	n, q := S.Dims()
+	rows, _ := H.Dims()
+	Hx := H.View(0, 0, rows, n)
+	// K = Pbar*Hx^T*(Hx*Pbar*Hx^T + R)^-1
+	var PHt, HPHt, innovCovar, invInnovCovar, K mat64.Dense
+	PHt.Mul(Pbar, Hx.T())
+	HPHt.Mul(Hx, &PHt)
+	innovCovar.Add(&HPHt, noiseR)
+	if err := invInnovCovar.Inverse(&innovCovar); err != nil {
+		return nil, fmt.Errorf("consider gain: %s", err)
+	}
+	K.Mul(&PHt, &invInnovCovar)
+	var KH, IKH, updated, KHc mat64.Dense
+	KH.Mul(&K, Hx)
+	IKH.Sub(DenseIdentity(n), &KH)
+	updated.Mul(&IKH, S)
+	KHc.Mul(&K, H.View(0, n, rows, q))
+	updated.Add(&updated, &KHc)
+	return &updated, nil
+}
+
+// ExportConsiderCSV writes the 1σ of each estimated component with and without the considered parameters, followed by
+// the sensitivity matrix (row by row), to the provided CSV file with the elapsed time since start.
+func (r ODResult) ExportConsiderCSV(filename string, start time.Time) error {
+	if len(r.ConsiderNames) == 0 {
+		return fmt.Errorf("no considered parameter to export in %s", filename)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	headers := []string{"epoch", "seconds"}
+	for _, name := range r.StateNames {
+		headers = append(headers, "σ "+name, "σc "+name)
+	}
+	for _, name := range r.StateNames {
+		for _, consider := range r.ConsiderNames {
+			headers = append(headers, fmt.Sprintf("S(%s;%s)", name, consider))
+		}
+	}
+	if _, err = f.WriteString(strings.Join(headers, ",") + "\n"); err != nil {
+		return err
+	}
+	for _, est := range r.Estimates {
+		values := []string{est.DT.Format("2006-01-02 15:04:05"), fmt.Sprintf("%f", est.DT.Sub(start).Seconds())}
+		P := est.Covariance()
+		for i := range r.StateNames {
+			values = append(values, fmt.Sprintf("%e", math.Sqrt(P.At(i, i))), fmt.Sprintf("%e", math.Sqrt(est.ConsiderCovariance.At(i, i))))
+		}
+		for i := range r.StateNames {
+			for j := range r.ConsiderNames {
+				values = append(values, fmt.Sprintf("%e", est.Sensitivity.At(i, j)))
+			}
+		}
+		if _, err = f.WriteString(strings.Join(values, ",") + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package smd
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestConsiderCovariance(t *testing.T) {
+	stations := []Station{DSS13Goldstone, DSS34Canberra, DSS65Madrid}
+	truth := testGEO()
+	meas := simulateODMeasurements(truth, testStart, testStart.Add(30*time.Minute), stations)
+	// The range of DSS65 has a bias which is only considered, so the estimation error is S*δc.
+	bias := 0.01
+	for _, dt := range meas.Epochs {
+		if measurements, _ := meas.At(dt); measurements[2].Station.Name != "" {
+			measurements[2].Values[0] += bias
+		}
+	}
+	δc := mat64.NewVector(2, []float64{0, bias})
+	end := meas.Epochs[len(meas.Epochs)-1]
+	truthMission := testMission(NewEmptySC("truth", 0), testGEO(), testStart, Perturbations{}, false)
+	truthMission.PropagateUntil(end, true)
+	truthR := truthMission.Orbit.R()
+	for _, filter := range []ODFilterType{FilterCKF, FilterSRIF, FilterBLS} {
+		conf := testODConfig(filter)
+		conf.BLSAPriori = true
+		conf.Parameters = []ODParameter{{Type: ParamGM, Consider: true, Sigma: 1}, {Type: ParamRangeBias, Station: DSS65Madrid.Name, Consider: true, Sigma: bias}}
+		mission := testGEOEstimate(meas.Epochs[0])
+		result, err := NewOrbitDetermination(mission, meas, conf).Run()
+		if err != nil {
+			t.Fatalf("%s: %s", filter, err)
+		}
+		if len(result.StateNames) != 6 || len(result.ConsiderNames) != 2 || result.ConsiderNames[1] != DSS65Madrid.Name+" rangeBias" || len(result.Parameters) != 0 {
+			t.Fatalf("%s: invalid names %v %v", filter, result.StateNames, result.ConsiderNames)
+		}
+		for _, est := range result.Estimates {
+			if r, c := est.Sensitivity.Dims(); r != 6 || c != 2 {
+				t.Fatalf("%s: invalid sensitivity %dx%d", filter, r, c)
+			}
+			for i := 0; i < 6; i++ {
+				if est.ConsiderCovariance.At(i, i) < est.Covariance().At(i, i) {
+					t.Fatalf("%s: consider covariance smaller than the nominal one at %s", filter, est.DT)
+				}
+			}
+		}
+		var last ODEstimate
+		for _, est := range result.Estimates {
+			if est.DT.Equal(end) {
+				last = est
+			}
+		}
+		if last.Estimate == nil {
+			t.Fatalf("%s: no estimate at %s", filter, end)
+		}
+		if math.Sqrt(last.ConsiderCovariance.At(0, 0)) <= math.Sqrt(last.Covariance().At(0, 0))*1.001 {
+			t.Fatalf("%s: the considered parameters do not inflate the covariance", filter)
+		}
+		// The reference of the BLS is corrected at each iteration, so the position error is computed from the truth.
+		expected := mat64.NewVector(6, nil)
+		expected.MulVec(last.Sensitivity, δc)
+		R := last.Orbit.R()
+		Δ := make([]float64, 3)
+		for i := 0; i < 3; i++ {
+			Δ[i] = R[i] + last.State().At(i, 0) - truthR[i] - expected.At(i, 0)
+		}
+		if Norm(Δ) > 1e-2*mat64.Norm(expected.SliceVec(0, 3), 2) {
+			t.Fatalf("%s: position error differs from S*δc=%v by %v", filter, mat64.Formatted(expected.T()), Δ)
+		}
+	}
+	// The ephemeris can only be considered.
+	conf := testODConfig(FilterCKF)
+	conf.Parameters = []ODParameter{{Type: ParamEphemeris, Sigma: 1}}
+	mission := testMission(NewEmptySC("est", 0), testGEO(), meas.Epochs[0], Perturbations{PerturbingBody: &Sun}, true)
+	if _, err := NewOrbitDetermination(mission, meas, conf).Run(); err == nil {
+		t.Fatal("solve-for ephemeris accepted")
+	}
+}
+
+func TestSunPositionPartials(t *testing.T) {
+	RSunToEarth := []float64{-1.0e8, 1.1e8, 4.7e7}
+	REarthToSC := []float64{-7000, 35000, 2000}
+	// Third body acceleration of the Sun (cf. Perturb) when its position relative to the Earth is offset by δ.
+	acceleration := func(δ []float64) []float64 {
+		acc := make([]float64, 3)
+		RSE := make([]float64, 3)
+		RSC := make([]float64, 3)
+		for i := 0; i < 3; i++ {
+			RSE[i] = RSunToEarth[i] - δ[i]
+			RSC[i] = RSE[i] + REarthToSC[i]
+		}
+		for i := 0; i < 3; i++ {
+			acc[i] = Sun.μ * (RSE[i]/math.Pow(Norm(RSE), 3) - RSC[i]/math.Pow(Norm(RSC), 3))
+		}
+		return acc
+	}
+	partials := sunPositionPartials(RSunToEarth, REarthToSC)
+	h := 100.
+	for j := 0; j < 3; j++ {
+		δ := make([]float64, 3)
+		δ[j] = h
+		plus := acceleration(δ)
+		δ[j] = -h
+		minus := acceleration(δ)
+		for i := 0; i < 3; i++ {
+			if expected := (plus[i] - minus[i]) / (2 * h); math.Abs(partials.At(i, j)-expected) > 1e-6*math.Abs(expected)+1e-22 {
+				t.Fatalf("∂a%d/∂δ%d = %e instead of %e", i, j, partials.At(i, j), expected)
+			}
+		}
+	}
+}
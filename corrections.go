@@ -0,0 +1,221 @@
+package smd
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+/* Light time, aberration and media corrections of the measurements. The troposphere models follow Saastamoinen (1972)
+with the standard atmosphere of RTKLIB, and Niell, "Global mapping functions for the atmosphere delay at radio
+wavelengths", 1996. */
+
+// TroposphereModel defines the tropospheric delay model.
+type TroposphereModel uint8
+
+const (
+	// NoTroposphere disables the tropospheric delay.
+	NoTroposphere TroposphereModel = iota
+	// Saastamoinen uses the Saastamoinen zenith delays mapped with the cosecant of the elevation.
+	Saastamoinen
+	// Niell uses the Saastamoinen zenith delays mapped with the Niell hydrostatic and wet mapping functions.
+	Niell
+)
+
+func (m TroposphereModel) String() string {
+	switch m {
+	case NoTroposphere:
+		return "none"
+	case Saastamoinen:
+		return "Saastamoinen"
+	case Niell:
+		return "Niell"
+	default:
+		panic("unknown troposphere model")
+	}
+}
+
+// TroposphereModelFromString returns the troposphere model from its name.
+func TroposphereModelFromString(name string) (TroposphereModel, error) {
+	switch strings.ToLower(name) {
+	case "none", "":
+		return NoTroposphere, nil
+	case "saastamoinen":
+		return Saastamoinen, nil
+	case "niell":
+		return Niell, nil
+	default:
+		return NoTroposphere, fmt.Errorf("unknown troposphere model `%s`", name)
+	}
+}
+
+// Corrections defines the corrections applied to the measurements of a station, which are all disabled by default.
+// The media delays are those of the Earth atmosphere and are added to each leg of the range (and integrated Doppler).
+type Corrections struct {
+	LightTime   bool             // Iterated light time of the down and up legs
+	Aberration  bool             // Stellar aberration of the angles (requires the ephemeris of the planet, cf. HelioOrbit)
+	Troposphere TroposphereModel // Standard atmosphere with a relative humidity of 70%
+	Ionosphere  bool             // First order delay at the link frequency (cf. SetCorrections)
+	VTEC        float64          // Vertical total electron content of the ionosphere in TECU (1e16 electrons/m^2)
+}
+
+// CorrectionsFromConfig returns the corrections defined in the `[<key>]` section of the provided configuration with
+// the following keys: lighttime, aberration, troposphere (none, Saastamoinen or Niell), ionosphere and vtec.
+func CorrectionsFromConfig(v *viper.Viper, key string) (c Corrections, err error) {
+	prefix := key + "."
+	c.LightTime = v.GetBool(prefix + "lighttime")
+	c.Aberration = v.GetBool(prefix + "aberration")
+	if c.Troposphere, err = TroposphereModelFromString(v.GetString(prefix + "troposphere")); err != nil {
+		return
+	}
+	c.Ionosphere = v.GetBool(prefix + "ionosphere")
+	c.VTEC = v.GetFloat64(prefix + "vtec")
+	if c.Ionosphere && c.VTEC <= 0 {
+		err = fmt.Errorf("the ionosphere requires a positive `vtec`")
+	}
+	return
+}
+
+// SetCorrections sets the corrections of the station, and returns an error if the station cannot apply them.
+func (s *Station) SetCorrections(c Corrections) error {
+	if c.Ionosphere && s.Link.Frequency <= 0 {
+		return fmt.Errorf("the ionosphere requires the frequency of the link of %s", s.Name)
+	}
+	s.Corrections = c
+	return nil
+}
+
+// mediaDelay returns the tropospheric and ionospheric delay of the provided leg, in km. The ionosphere advances the
+// phase, so its delay is negative for the phase range.
+func (s Station) mediaDelay(leg linkLeg, phase bool) float64 {
+	if s.Corrections.Troposphere == NoTroposphere && !s.Corrections.Ionosphere {
+		return 0
+	}
+	rECEF, _ := inertial2BodyFixed(leg.rSc, leg.vSc, leg.epoch, leg.station.Planet)
+	_, _, el, _ := leg.station.RangeElAz(rECEF)
+	if el <= 0 {
+		return 0 // The signal goes through the planet anyway.
+	}
+	el *= d2r
+	delay := 0.
+	switch s.Corrections.Troposphere {
+	case Saastamoinen:
+		zhd, zwd := saastamoinenZenith(leg.station.LatΦ, leg.station.Altitude)
+		delay += (zhd + zwd) / math.Sin(el)
+	case Niell:
+		zhd, zwd := saastamoinenZenith(leg.station.LatΦ, leg.station.Altitude)
+		mh, mw := niellMapping(leg.station.LatΦ, leg.station.Altitude, leg.epoch, el)
+		delay += zhd*mh + zwd*mw
+	}
+	if s.Corrections.Ionosphere && s.Link.Frequency > 0 {
+		iono := ionosphereDelay(s.Link.Frequency, s.Corrections.VTEC, el, leg.station.Planet.Radius)
+		if phase {
+			iono = -iono
+		}
+		delay += iono
+	}
+	return delay
+}
+
+// saastamoinenZenith returns the hydrostatic and wet zenith delays in km at the provided geodetic latitude (radians)
+// and altitude (km) in the standard atmosphere.
+func saastamoinenZenith(latΦ, altitude float64) (zhd, zwd float64) {
+	h := math.Max(altitude*1e3, 0)
+	P := 1013.25 * math.Pow(1-2.2557e-5*h, 5.2568)        // hPa
+	T := 15 - 6.5e-3*h + 273.16                           // K
+	e := 6.108 * 0.7 * math.Exp((17.15*T-4684)/(T-38.45)) // hPa
+	zhd = 0.0022768 * P / (1 - 0.00266*math.Cos(2*latΦ) - 0.00028*h/1e3) * 1e-3
+	zwd = 0.002277 * (1255/T + 0.05) * e * 1e-3
+	return
+}
+
+// Niell mapping function coefficients at 15, 30, 45, 60 and 75 degrees of latitude.
+var (
+	niellLatitudes = [5]float64{15, 30, 45, 60, 75}
+	niellHydroAvg  = [3][5]float64{
+		{1.2769934e-3, 1.2683230e-3, 1.2465397e-3, 1.2196049e-3, 1.2045996e-3},
+		{2.9153695e-3, 2.9152299e-3, 2.9288445e-3, 2.9022565e-3, 2.9024912e-3},
+		{62.610505e-3, 62.837393e-3, 63.721774e-3, 63.824265e-3, 64.258455e-3},
+	}
+	niellHydroAmp = [3][5]float64{
+		{0, 1.2709626e-5, 2.6523662e-5, 3.4000452e-5, 4.1202191e-5},
+		{0, 2.1414979e-5, 3.0160779e-5, 7.2562722e-5, 11.723375e-5},
+		{0, 9.0128400e-5, 4.3497037e-5, 84.795348e-5, 170.37206e-5},
+	}
+	niellWet = [3][5]float64{
+		{5.8021897e-4, 5.6794847e-4, 5.8118019e-4, 5.9727542e-4, 6.1641693e-4},
+		{1.4275268e-3, 1.5138625e-3, 1.4572752e-3, 1.5007428e-3, 1.7599082e-3},
+		{4.3472961e-2, 4.6729510e-2, 4.3908931e-2, 4.4626982e-2, 5.4736038e-2},
+	}
+	niellHeight = [3]float64{2.53e-5, 5.49e-3, 1.14e-3}
+)
+
+// marini returns the continued fraction of the mapping functions, normalized to one at zenith.
+func marini(sinEl, a, b, c float64) float64 {
+	return (1 + a/(1+b/(1+c))) / (sinEl + a/(sinEl+b/(sinEl+c)))
+}
+
+// niellInterpolate returns the coefficient linearly interpolated at the provided latitude (in degrees).
+func niellInterpolate(coeffs [5]float64, lat float64) float64 {
+	lat = math.Abs(lat)
+	if lat <= niellLatitudes[0] {
+		return coeffs[0]
+	}
+	for i := 1; i < 5; i++ {
+		if lat <= niellLatitudes[i] {
+			return coeffs[i-1] + (coeffs[i]-coeffs[i-1])*(lat-niellLatitudes[i-1])/(niellLatitudes[i]-niellLatitudes[i-1])
+		}
+	}
+	return coeffs[4]
+}
+
+// niellMapping returns the Niell hydrostatic and wet mapping functions at the provided geodetic latitude (radians),
+// altitude (km), epoch and elevation (radians).
+func niellMapping(latΦ, altitude float64, epoch time.Time, el float64) (mh, mw float64) {
+	lat := latΦ * r2d
+	doy := float64(epoch.YearDay())
+	if lat < 0 {
+		doy += 182.625 // Seasons are reversed in the southern hemisphere.
+	}
+	season := math.Cos(2 * math.Pi * (doy - 28) / 365.25)
+	var h, w [3]float64
+	for i := 0; i < 3; i++ {
+		h[i] = niellInterpolate(niellHydroAvg[i], lat) - niellInterpolate(niellHydroAmp[i], lat)*season
+		w[i] = niellInterpolate(niellWet[i], lat)
+	}
+	sinEl := math.Sin(el)
+	mh = marini(sinEl, h[0], h[1], h[2])
+	// Height correction of the hydrostatic mapping
+	mh += (1/sinEl - marini(sinEl, niellHeight[0], niellHeight[1], niellHeight[2])) * altitude
+	mw = marini(sinEl, w[0], w[1], w[2])
+	return
+}
+
+// ionosphereDelay returns the first order ionospheric group delay in km at the provided frequency (Hz), vertical TEC
+// (TECU) and elevation (radians), mapped with a thin shell at 350 km.
+func ionosphereDelay(frequency, vtec, el, radius float64) float64 {
+	sinZ := radius * math.Cos(el) / (radius + 350)
+	return 40.3 * vtec * 1e16 / (frequency * frequency) / math.Sqrt(1-sinZ*sinZ) * 1e-3
+}
+
+// aberration returns the apparent direction of the provided unit vector for an observer moving at vObs (km/s) with
+// respect to the solar system barycenter, to first order.
+func aberration(u, vObs []float64) []float64 {
+	β := []float64{vObs[0] / SpeedOfLight, vObs[1] / SpeedOfLight, vObs[2] / SpeedOfLight}
+	uβ := Dot(u, β)
+	return Unit([]float64{u[0] + β[0] - uβ*u[0], u[1] + β[1] - uβ*u[1], u[2] + β[2] - uβ*u[2]})
+}
+
+// ssbVelocity returns the inertial velocity of this station with respect to the Sun, i.e. the velocity due to the
+// rotation of its planet and the heliocentric velocity of that planet.
+func (s Station) ssbVelocity(epoch time.Time) []float64 {
+	_, vS := s.inertialState(epoch)
+	if s.Planet.Equals(Sun) {
+		return vS
+	}
+	vPlanet := s.Planet.HelioOrbitIn(ICRF, epoch).V()
+	return []float64{vS[0] + vPlanet[0], vS[1] + vPlanet[1], vS[2] + vPlanet[2]}
+}
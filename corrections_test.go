@@ -0,0 +1,155 @@
+package smd
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+	"github.com/spf13/viper"
+)
+
+func TestTroposphere(t *testing.T) {
+	zhd, zwd := saastamoinenZenith(45*d2r, 0)
+	if !floats.EqualWithinAbs(zhd, 2.307e-3, 1e-6) || zwd < 0.05e-3 || zwd > 0.3e-3 {
+		t.Fatalf("invalid zenith delays: %e %e", zhd, zwd)
+	}
+	if zhdHigh, _ := saastamoinenZenith(45*d2r, 2); zhdHigh > 0.8*zhd {
+		t.Fatalf("invalid zenith delay at altitude: %e", zhdHigh)
+	}
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	if mh, mw := niellMapping(40*d2r, 0, dt, math.Pi/2); !floats.EqualWithinAbs(mh, 1, 1e-12) || !floats.EqualWithinAbs(mw, 1, 1e-12) {
+		t.Fatalf("invalid mapping at zenith: %f %f", mh, mw)
+	}
+	for _, el := range []float64{3, 10, 30} {
+		mh, mw := niellMapping(40*d2r, 0, dt, el*d2r)
+		csc := 1 / math.Sin(el*d2r)
+		if mh > csc || mh < 0.7*csc || mw > csc || mw < 0.7*csc {
+			t.Fatalf("invalid mapping at %f deg: %f %f (csc=%f)", el, mh, mw, csc)
+		}
+	}
+	// The seasons are reversed between hemispheres.
+	north, _ := niellMapping(40*d2r, 0, dt, 5*d2r)
+	south, _ := niellMapping(-40*d2r, 0, dt.AddDate(0, 6, 0), 5*d2r)
+	if !floats.EqualWithinAbs(north, south, 1e-3) {
+		t.Fatalf("invalid seasonal variation: %f != %f", north, south)
+	}
+}
+
+func TestIonosphereAberration(t *testing.T) {
+	// 10 TECU at 2.2 GHz at zenith.
+	if delay := ionosphereDelay(2.2e9, 10, math.Pi/2, Earth.Radius); !floats.EqualWithinAbs(delay, 40.3e17/(2.2e9*2.2e9)*1e-3, 1e-15) {
+		t.Fatalf("invalid ionospheric delay: %e", delay)
+	}
+	if ionosphereDelay(2.2e9, 10, 10*d2r, Earth.Radius) < 2*ionosphereDelay(2.2e9, 10, math.Pi/2, Earth.Radius) {
+		t.Fatal("invalid ionospheric mapping")
+	}
+	assertPanic(t, func() {
+		ionosphereDelay(0, 10, math.Pi/2, Earth.Radius)
+	})
+	// The annual aberration is about 20.5 arcseconds.
+	u := aberration([]float64{1, 0, 0}, []float64{0, 30, 0})
+	if θ := math.Acos(u[0]) * r2d * 3600; !floats.EqualWithinAbs(θ, 30/SpeedOfLight*r2d*3600, 1e-3) || u[1] <= 0 {
+		t.Fatalf("invalid aberration: %f arcsec (%v)", θ, u)
+	}
+	if u = aberration([]float64{0, 1, 0}, []float64{0, 30, 0}); !floats.EqualApprox(u, []float64{0, 1, 0}, 1e-15) {
+		t.Fatalf("aberration along the velocity: %v", u)
+	}
+}
+
+func TestLightTime(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	o := NewOrbitFromOE(400000, 0.1, 40, 10, 20, 30, Earth)
+	R, V := o.RV()
+	st := DSS34Canberra
+	st.Corrections.LightTime = true
+	st.Link.Type = TwoWay
+	legs := st.linkLegs(dt, R, V, Earth.μ)
+	up, down := legs[0], legs[1]
+	if !down.epoch.Equal(dt) || down.lightTimeSec < 1 || !up.epoch.Before(dt.Add(-time.Second)) {
+		t.Fatalf("invalid legs: %+v", legs)
+	}
+	for _, leg := range legs {
+		if ρ := distance(leg.rSc, leg.rS); !floats.EqualWithinAbs(ρ, leg.lightTimeSec*SpeedOfLight, 1e-6) {
+			t.Fatalf("light time not converged: %f != %f", ρ, leg.lightTimeSec*SpeedOfLight)
+		}
+	}
+	// The spacecraft state at the bounce is the two body propagated one.
+	Rb, _ := KeplerPropagate(R, V, Earth.μ, -down.lightTimeSec)
+	if !floats.EqualApprox(down.rSc, Rb, 1e-9) || !floats.EqualApprox(up.rSc, down.rSc, 1e-15) {
+		t.Fatalf("invalid bounce state: %v != %v", down.rSc, Rb)
+	}
+	ρ, _, _ := st.Observe(MeasRange, dt, *o)
+	if !floats.EqualWithinAbs(ρ, SpeedOfLight*(up.lightTimeSec+down.lightTimeSec)/2, 1e-6) {
+		t.Fatalf("invalid two way range: %f", ρ)
+	}
+	st.Corrections.LightTime = false
+	ρGeom, _, _ := st.Observe(MeasRange, dt, *o)
+	if math.Abs(ρ-ρGeom) < 1e-2 || math.Abs(ρ-ρGeom) > 10 {
+		t.Fatalf("invalid light time correction: %f", ρ-ρGeom)
+	}
+	// The partials are evaluated at the light time corrected geometry.
+	st.Corrections.LightTime = true
+	for _, measType := range []MeasurementType{MeasRange, MeasRangeRate, MeasRightAscension, MeasElevation} {
+		_, hR, hV := st.Observe(measType, dt, *o)
+		fdR, fdV := fdPartials(st, measType, dt, *o)
+		scale := math.Max(Norm(fdR), Norm(fdV))
+		for i := 0; i < 3; i++ {
+			if !floats.EqualWithinAbs(hR[i], fdR[i], 1e-3*scale) || !floats.EqualWithinAbs(hV[i], fdV[i], 1e-3*scale) {
+				t.Fatalf("%s: invalid partials with light time\n%v %v\n%v %v", measType, hR, hV, fdR, fdV)
+			}
+		}
+	}
+}
+
+func TestMediaCorrections(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Spacecraft 1000 km above DSS34 (not at zenith).
+	rECEF := DSS34Canberra.Planet.Ellipsoid().ToBodyFixed(1000, DSS34Canberra.LatΦ+5*d2r, DSS34Canberra.Longθ)
+	R, V := bodyFixed2Inertial(rECEF, []float64{0, 0, 0}, dt, Earth)
+	o := *NewOrbitFromRV(R, V, Earth)
+	_, _, el, _ := DSS34Canberra.RangeElAz(rECEF)
+	st := DSS34Canberra
+	st.Link = Link{Type: TwoWay, Frequency: 2.1e9}
+	ρGeom, _, _ := st.Observe(MeasRange, dt, o)
+	st.Corrections.Troposphere = Saastamoinen
+	ρTropo, _, _ := st.Observe(MeasRange, dt, o)
+	zhd, zwd := saastamoinenZenith(st.LatΦ, st.Altitude)
+	if !floats.EqualWithinAbs(ρTropo-ρGeom, (zhd+zwd)/math.Sin(el*d2r), 1e-9) {
+		t.Fatalf("invalid tropospheric delay: %e", ρTropo-ρGeom)
+	}
+	st.Corrections.Troposphere = Niell
+	if ρNiell, _, _ := st.Observe(MeasRange, dt, o); ρNiell-ρGeom > ρTropo-ρGeom || ρNiell-ρGeom < 0.9*(ρTropo-ρGeom) {
+		t.Fatalf("invalid Niell delay: %e (Saastamoinen %e)", ρNiell-ρGeom, ρTropo-ρGeom)
+	}
+	// The ionosphere delays the group and advances the phase.
+	st.Corrections = Corrections{Ionosphere: true, VTEC: 20}
+	group := st.linkRange(dt, R, V, Earth.μ, false).ρ
+	phase := st.linkRange(dt, R, V, Earth.μ, true).ρ
+	if group <= ρGeom || !floats.EqualWithinAbs(group-ρGeom, ρGeom-phase, 1e-12) {
+		t.Fatalf("invalid ionospheric delay: %e %e", group-ρGeom, ρGeom-phase)
+	}
+	// The ionosphere requires the frequency of the link.
+	noFrequency := DSS34Canberra
+	if err := noFrequency.SetCorrections(Corrections{Ionosphere: true, VTEC: 20}); err == nil {
+		t.Fatal("ionosphere accepted without the frequency of the link")
+	}
+	if err := st.SetCorrections(Corrections{Ionosphere: true, VTEC: 20}); err != nil {
+		t.Fatal(err)
+	}
+	// Corrections from the configuration
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(strings.NewReader("[corrections]\nlighttime = true\ntroposphere = \"niell\"\nionosphere = true\nvtec = 15")); err != nil {
+		t.Fatal(err)
+	}
+	c, err := CorrectionsFromConfig(v, "corrections")
+	if err != nil || !c.LightTime || c.Aberration || c.Troposphere != Niell || !c.Ionosphere || c.VTEC != 15 {
+		t.Fatalf("invalid corrections: %+v (%s)", c, err)
+	}
+	v.Set("corrections.troposphere", "hopfield")
+	if _, err := CorrectionsFromConfig(v, "corrections"); err == nil {
+		t.Fatal("unknown troposphere model accepted")
+	}
+}
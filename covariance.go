@@ -0,0 +1,232 @@
+package smd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+/* Linear covariance analysis. The covariance of the state is propagated along the reference trajectory of a mission
+which computes its STM, with P = ΦPΦᵀ + ΓQΓᵀ at each step, where Q is the covariance of a white acceleration noise
+mapped to the state as in the state noise compensation of the filters (cf. ODConfig.SNC). This is the time update of
+the filters without any measurement, so that the knowledge of a trajectory can be analyzed without a filter. The
+covariance is mapped to the RIC frame by rotating the position and velocity blocks (without the rotation rate of the
+frame), and to the B-plane with the partials of B·T and B·R, computed by central differences. */
+
+const (
+	// bPlanePartialStep is the relative step of the central differences of the B-plane partials.
+	bPlanePartialStep = 1e-6
+)
+
+// CovarianceState is the covariance of the state along the reference trajectory at a given time.
+type CovarianceState struct {
+	DT    time.Time
+	Orbit Orbit
+	P     *mat64.SymDense // Covariance in the inertial frame (6x6, or 7x7 with the Cr, cf. Perturbations.STMSize)
+}
+
+// RICCovariance returns the covariance of the position and velocity in the RIC frame (6x6).
+func (s CovarianceState) RICCovariance() *mat64.SymDense {
+	return rotateCovariance(s.P, LocalFrameDCM(s.Orbit, RIC))
+}
+
+// RICSigma returns the 1σ of the position (km) and of the velocity (km/s) in the RIC frame.
+func (s CovarianceState) RICSigma() (σR, σV []float64) {
+	P := s.RICCovariance()
+	σR, σV = make([]float64, 3), make([]float64, 3)
+	for i := 0; i < 3; i++ {
+		σR[i] = math.Sqrt(P.At(i, i))
+		σV[i] = math.Sqrt(P.At(i+3, i+3))
+	}
+	return
+}
+
+// BPlane returns the 1σ error ellipse of the B-plane of the orbit, which must be hyperbolic.
+func (s CovarianceState) BPlane() (BPlaneEllipse, error) {
+	return s.BPlaneAbout(s.Orbit.Origin)
+}
+
+// BPlaneAbout returns the 1σ error ellipse of the B-plane of the orbit about the provided target (cf. ToXCentric),
+// about which the orbit must be hyperbolic.
+func (s CovarianceState) BPlaneAbout(target CelestialObject) (BPlaneEllipse, error) {
+	nominal, partials, err := bPlaneAbout(s.Orbit, target, s.DT)
+	if err != nil {
+		return BPlaneEllipse{}, err
+	}
+	var HP, PB mat64.Dense
+	HP.Mul(partials, mat64.DenseCopyOf(s.P).View(0, 0, 6, 6))
+	PB.Mul(&HP, partials.T())
+	return newBPlaneEllipse(nominal.BR, nominal.BT, symmetrized(&PB)), nil
+}
+
+// bPlaneAbout returns the B-plane of the orbit about the provided target at the provided epoch, and its partials with
+// respect to the position and velocity of the orbit (2x6), i.e. in the frame of the origin of the orbit. The
+// ephemerides of the target do not depend on the state, so only the rotation between both frames is in the partials.
+func bPlaneAbout(o Orbit, target CelestialObject, dt time.Time) (BPlane, *mat64.Dense, error) {
+	from := o.Frame
+	if !o.Origin.Equals(target) {
+		o.ToXCentric(target, dt)
+	}
+	if _, e, _, _, _, _, _, _, _ := o.Elements(); e <= 1 {
+		return BPlane{}, nil, fmt.Errorf("orbit not hyperbolic about %s at %s (e=%f)", target, dt, e)
+	}
+	var partials mat64.Dense
+	partials.Mul(bPlanePartials(o), inertialRotation(from, o.Frame, dt))
+	return NewBPlane(o), &partials, nil
+}
+
+// bPlanePartials returns the partials of B·T (first row) and B·R with respect to the position and velocity of the
+// provided hyperbolic orbit (2x6).
+func bPlanePartials(o Orbit) *mat64.Dense {
+	R, V := o.RV()
+	state := append(append([]float64{}, R...), V...)
+	partials := mat64.NewDense(2, 6, nil)
+	for j := 0; j < 6; j++ {
+		δ := bPlanePartialStep * Norm(R)
+		if j >= 3 {
+			δ = bPlanePartialStep * Norm(V)
+		}
+		var bPlanes [2]BPlane
+		for k, sign := range []float64{1, -1} {
+			varied := append([]float64{}, state...)
+			varied[j] += sign * δ
+			bPlanes[k] = NewBPlane(*NewOrbitFromRV(varied[0:3], varied[3:6], o.Origin))
+		}
+		partials.Set(0, j, (bPlanes[0].BT-bPlanes[1].BT)/(2*δ))
+		partials.Set(1, j, (bPlanes[0].BR-bPlanes[1].BR)/(2*δ))
+	}
+	return partials
+}
+
+// BPlaneEllipse is a 1σ error ellipse of the B-plane, defined by its semi-axes and the angle of its semi-major axis
+// from the T axis (towards R).
+type BPlaneEllipse struct {
+	BR      float64 `json:"BR"` // B·R (km)
+	BT      float64 `json:"BT"` // B·T (km)
+	SemiMaj float64 `json:"semiMajorAxis"`
+	SemiMin float64 `json:"semiMinorAxis"`
+	Angle   float64 `json:"angle"` // Degrees
+}
+
+func (e BPlaneEllipse) String() string {
+	return fmt.Sprintf("BR=%f BT=%f km, 1σ ellipse %f x %f km @ %f deg", e.BR, e.BT, e.SemiMaj, e.SemiMin, e.Angle)
+}
+
+// newBPlaneEllipse returns the error ellipse of the provided B-plane and covariance of B·T and B·R (in that order).
+func newBPlaneEllipse(BR, BT float64, covar mat64.Symmetric) BPlaneEllipse {
+	semiMaj, semiMin, angle := errorEllipse(covar)
+	return BPlaneEllipse{BR, BT, semiMaj, semiMin, angle}
+}
+
+// errorEllipse returns the semi-axes of the 1σ ellipse of the provided 2x2 covariance, and the angle of its semi-major
+// axis from the first axis in degrees.
+func errorEllipse(covar mat64.Symmetric) (semiMaj, semiMin, angle float64) {
+	a, b, c := covar.At(0, 0), covar.At(0, 1), covar.At(1, 1)
+	// Eigenvalues of the covariance.
+	center, radius := (a+c)/2, math.Sqrt((a-c)*(a-c)/4+b*b)
+	semiMaj = math.Sqrt(center + radius)
+	semiMin = math.Sqrt(math.Max(center-radius, 0))
+	angle = Rad2deg(math.Atan2(2*b, a-c) / 2)
+	return
+}
+
+// PropagateCovariance propagates the provided initial covariance (in the inertial frame) along the states of the
+// provided channel, until it is closed. The states must include their STM, i.e. be published by a mission computing
+// it. The acceleration noise Q (3x3, in km²/s⁴) is in the RIC frame if QRIC is set, and none if nil.
+func PropagateCovariance(states <-chan State, P0, Q *mat64.SymDense, QRIC bool) ([]CovarianceState, error) {
+	var covars []CovarianceState
+	var err error
+	P := P0
+	var prevDT time.Time
+	// The channel is always drained, so that the mission is not blocked by an error.
+	for state := range states {
+		if err != nil {
+			continue
+		}
+		if state.Φ == nil {
+			err = fmt.Errorf("no STM at %s: the mission must compute it", state.DT)
+			continue
+		}
+		if r, _ := state.Φ.Dims(); r != P0.Symmetric() {
+			err = fmt.Errorf("%dx%d initial covariance for a %dx%d STM", P0.Symmetric(), P0.Symmetric(), r, r)
+			continue
+		}
+		var ΦP, Pbar mat64.Dense
+		ΦP.Mul(state.Φ, P)
+		Pbar.Mul(&ΦP, state.Φ.T())
+		if Q != nil && !prevDT.IsZero() {
+			Δt := state.DT.Sub(prevDT).Seconds()
+			QECI := mat64.Matrix(Q)
+			if QRIC {
+				QECI = ricCovarianceToInertial(Q, state.Orbit)
+			}
+			n := P0.Symmetric()
+			Γ := mat64.NewDense(n, 3, nil)
+			Γ.View(0, 0, 6, 3).(*mat64.Dense).Stack(ScaledDenseIdentity(3, math.Pow(Δt, 2)/2), ScaledDenseIdentity(3, Δt))
+			var ΓQ, ΓQΓt mat64.Dense
+			ΓQ.Mul(Γ, QECI)
+			ΓQΓt.Mul(&ΓQ, Γ.T())
+			Pbar.Add(&Pbar, &ΓQΓt)
+		}
+		P = symmetrized(&Pbar)
+		prevDT = state.DT
+		covars = append(covars, CovarianceState{state.DT, state.Orbit, P})
+	}
+	if err == nil && len(covars) == 0 {
+		err = errors.New("no state to propagate the covariance")
+	}
+	return covars, err
+}
+
+// ExportCovarianceCSV writes the RIC 1σ of the position (km) and velocity (km/s) of the provided covariances to the
+// provided CSV file.
+func ExportCovarianceCSV(filename string, covars []CovarianceState) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "# Creation date (UTC): %s\n\"epoch UTC\",\"Julian day\",sigmaR,sigmaI,sigmaC,sigmaVR,sigmaVI,sigmaVC\n", time.Now().UTC())
+	for _, covar := range covars {
+		σR, σV := covar.RICSigma()
+		fmt.Fprintf(f, "\"%s\",%f,%.9f,%.9f,%.9f,%.12f,%.12f,%.12f\n", covar.DT.Format("2006-01-02 15:04:05"), timeToJD(covar.DT), σR[0], σR[1], σR[2], σV[0], σV[1], σV[2])
+	}
+	return nil
+}
+
+// rotateCovariance returns the covariance of the position and velocity of the provided covariance rotated with the
+// provided DCM.
+func rotateCovariance(P mat64.Symmetric, dcm *mat64.Dense) *mat64.SymDense {
+	rot := mat64.NewDense(6, 6, nil)
+	rot.View(0, 0, 3, 3).(*mat64.Dense).Copy(dcm)
+	rot.View(3, 3, 3, 3).(*mat64.Dense).Copy(dcm)
+	var rotP, rotProtT mat64.Dense
+	rotP.Mul(rot, mat64.DenseCopyOf(P).View(0, 0, 6, 6))
+	rotProtT.Mul(&rotP, rot.T())
+	return symmetrized(&rotProtT)
+}
+
+// ricCovarianceToInertial returns the provided 3x3 covariance of the RIC frame of the orbit in its inertial frame.
+func ricCovarianceToInertial(Q mat64.Matrix, o Orbit) *mat64.Dense {
+	dcm := LocalFrameDCM(o, RIC)
+	var QdcmT, QECI mat64.Dense
+	QdcmT.Mul(Q, dcm)
+	QECI.Mul(dcm.T(), &QdcmT)
+	return &QECI
+}
+
+// symmetrized returns the symmetric part of the provided square matrix, which removes the numerical asymmetries.
+func symmetrized(M mat64.Matrix) *mat64.SymDense {
+	n, _ := M.Dims()
+	S := mat64.NewSymDense(n, nil)
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			S.SetSym(i, j, (M.At(i, j)+M.At(j, i))/2)
+		}
+	}
+	return S
+}
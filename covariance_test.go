@@ -0,0 +1,187 @@
+package smd
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// propagateCovariance returns the covariances along two hours of the provided orbit, and its final orbit.
+func propagateCovariance(o *Orbit, P0, Q *mat64.SymDense, computeSTM bool) ([]CovarianceState, Orbit, error) {
+	var covars []CovarianceState
+	var err error
+	mission := testMission(NewEmptySC("covar", 0), o, testStart, Perturbations{}, computeSTM)
+	propagateStates(mission, testStart.Add(2*time.Hour), func(states <-chan State) {
+		covars, err = PropagateCovariance(states, P0, Q, true)
+	})
+	return covars, *mission.Orbit, err
+}
+
+// outerProduct returns δδᵀ.
+func outerProduct(δ []float64) *mat64.SymDense {
+	P := mat64.NewSymDense(len(δ), nil)
+	for i := range δ {
+		for j := i; j < len(δ); j++ {
+			P.SetSym(i, j, δ[i]*δ[j])
+		}
+	}
+	return P
+}
+
+func TestPropagateCovariance(t *testing.T) {
+	// The covariance of a single deviation is the one of the deviation propagated by the STM, i.e. of the difference
+	// between the perturbed and nominal trajectories.
+	δ := []float64{0.1, -0.05, 0.02, 1e-5, 2e-5, 0}
+	covars, nominal, err := propagateCovariance(NewOrbitFromOE(7000, 0.01, 30, 80, 40, 0, Earth), outerProduct(δ), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	R, V := NewOrbitFromOE(7000, 0.01, 30, 80, 40, 0, Earth).RV()
+	_, perturbed, err := propagateCovariance(NewOrbitFromRV([]float64{R[0] + δ[0], R[1] + δ[1], R[2] + δ[2]}, []float64{V[0] + δ[3], V[1] + δ[4], V[2] + δ[5]}, Earth), outerProduct(δ), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := covars[len(covars)-1]
+	nomR, nomV := nominal.RV()
+	pertR, pertV := perturbed.RV()
+	for i := 0; i < 3; i++ {
+		if σ, Δ := math.Sqrt(last.P.At(i, i)), math.Abs(pertR[i]-nomR[i]); math.Abs(σ-Δ) > 1e-2*Δ+1e-4 {
+			t.Fatalf("position σ #%d of %f km instead of %f km", i, σ, Δ)
+		}
+		if σ, Δ := math.Sqrt(last.P.At(i+3, i+3)), math.Abs(pertV[i]-nomV[i]); math.Abs(σ-Δ) > 1e-2*Δ+1e-7 {
+			t.Fatalf("velocity σ #%d of %f km/s instead of %f km/s", i, σ, Δ)
+		}
+	}
+
+	// The process noise increases the covariance.
+	P0 := mat64.NewSymDense(6, nil)
+	for i := 0; i < 3; i++ {
+		P0.SetSym(i, i, 1e-2)
+		P0.SetSym(i+3, i+3, 1e-8)
+	}
+	noiseless, _, err := propagateCovariance(NewOrbitFromOE(7000, 0.01, 30, 80, 40, 0, Earth), P0, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noisy, _, err := propagateCovariance(NewOrbitFromOE(7000, 0.01, 30, 80, 40, 0, Earth), P0, mat64.NewSymDense(3, []float64{1e-12, 0, 0, 0, 1e-12, 0, 0, 0, 1e-12}), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	σR, _ := noiseless[len(noiseless)-1].RICSigma()
+	σRNoisy, _ := noisy[len(noisy)-1].RICSigma()
+	for i := 0; i < 3; i++ {
+		if σRNoisy[i] <= σR[i] {
+			t.Fatalf("noisy σ %v not above %v", σRNoisy, σR)
+		}
+	}
+
+	// The mission must compute the STM (and is not blocked otherwise).
+	if _, _, err = propagateCovariance(NewOrbitFromOE(7000, 0.01, 30, 80, 40, 0, Earth), P0, nil, false); err == nil {
+		t.Fatal("propagation without STM accepted")
+	}
+
+	f, err := ioutil.TempFile("", "covar*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err = ExportCovarianceCSV(f.Name(), noisy); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(f.Name())
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2+len(noisy) {
+		t.Fatalf("%d lines exported for %d covariances", len(lines), len(noisy))
+	}
+}
+
+func TestCovarianceStateRIC(t *testing.T) {
+	// At ν=90° of an equatorial circular orbit, the radial is Y and the in-track is -X.
+	P := mat64.NewSymDense(6, []float64{
+		4, 0, 0, 0, 0, 0,
+		0, 1, 0, 0, 0, 0,
+		0, 0, 9, 0, 0, 0,
+		0, 0, 0, 1e-6, 0, 0,
+		0, 0, 0, 0, 4e-6, 0,
+		0, 0, 0, 0, 0, 9e-6})
+	state := CovarianceState{Orbit: *NewOrbitFromOE(7000, 0, 0, 0, 0, 90, Earth), P: P}
+	σR, σV := state.RICSigma()
+	for i, exp := range []float64{1, 2, 3} {
+		if math.Abs(σR[i]-exp) > 1e-9 || math.Abs(σV[i]-[]float64{2e-3, 1e-3, 3e-3}[i]) > 1e-9 {
+			t.Fatalf("invalid RIC σ %v %v", σR, σV)
+		}
+	}
+	// The same RIC noise is rotated alike by the covariance analysis and by the filters.
+	Q := mat64.NewSymDense(3, []float64{1, 0, 0, 0, 4, 0, 0, 0, 9})
+	QECI := ricCovarianceToInertial(Q, state.Orbit)
+	if !mat64.EqualApprox(QECI, mat64.NewDense(3, 3, []float64{4, 0, 0, 0, 1, 0, 0, 0, 9}), 1e-12) {
+		t.Fatalf("invalid inertial noise\n%v", mat64.Formatted(QECI))
+	}
+	ΓsqrtQ := sncNoiseRoot(Q, true, state.Orbit, 1)
+	var QECIRoot mat64.Dense
+	QECIRoot.Mul(ΓsqrtQ.View(3, 0, 3, 3), ΓsqrtQ.View(3, 0, 3, 3).T())
+	if !mat64.EqualApprox(&QECIRoot, QECI, 1e-12) {
+		t.Fatalf("invalid inertial noise of the UKF\n%v", mat64.Formatted(&QECIRoot))
+	}
+}
+
+func TestCovarianceStateBPlane(t *testing.T) {
+	o := NewOrbitFromRV([]float64{-500000, 200000, 10000}, []float64{3, -0.5, 0.2}, Earth)
+	if _, err := (CovarianceState{Orbit: *NewOrbitFromOE(7000, 0, 0, 0, 0, 90, Earth), P: outerProduct(make([]float64, 6))}).BPlane(); err == nil {
+		t.Fatal("B-plane of an elliptical orbit")
+	}
+	// A single velocity deviation leads to a degenerate ellipse along the deviation of the B-plane.
+	δ := []float64{0, 0, 0, 0, 1e-6, 1e-6}
+	ellipse, err := CovarianceState{Orbit: *o, P: outerProduct(δ)}.BPlane()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nominal := NewBPlane(*o)
+	R, V := o.RV()
+	varied := NewBPlane(*NewOrbitFromRV(R, []float64{V[0], V[1] + δ[4], V[2] + δ[5]}, Earth))
+	ΔBT, ΔBR := varied.BT-nominal.BT, varied.BR-nominal.BR
+	if ellipse.BT != nominal.BT || ellipse.BR != nominal.BR {
+		t.Fatalf("invalid nominal B-plane %s", ellipse)
+	}
+	if Δ := math.Sqrt(ΔBT*ΔBT + ΔBR*ΔBR); math.Abs(ellipse.SemiMaj-Δ) > 1e-3*Δ || ellipse.SemiMin > 1e-6*Δ {
+		t.Fatalf("invalid ellipse %s instead of %f km", ellipse, Δ)
+	}
+	angle := Rad2deg(math.Atan(ΔBR / ΔBT))
+	if math.Abs(ellipse.Angle-angle) > 0.1 {
+		t.Fatalf("ellipse angle %f instead of %f", ellipse.Angle, angle)
+	}
+}
+
+func TestCovarianceStateBPlaneAbout(t *testing.T) {
+	// A heliocentric approach of Mars is mapped to the B-plane of Mars, with the rotation of the ecliptic deviations.
+	dt := time.Date(2016, 3, 24, 20, 41, 48, 0, time.UTC)
+	R, V := Mars.HelioOrbit(dt).RV()
+	helio := func(δ []float64) *Orbit {
+		return NewOrbitFromRV([]float64{R[0] - 500000, R[1] + 200000, R[2] + 10000}, []float64{V[0] + 3 + δ[3], V[1] - 0.5 + δ[4], V[2] + 0.2 + δ[5]}, Sun)
+	}
+	δ := []float64{0, 0, 0, 0, 1e-6, 1e-6}
+	state := CovarianceState{DT: dt, Orbit: *helio(make([]float64, 6)), P: outerProduct(δ)}
+	if _, err := state.BPlane(); err == nil {
+		t.Fatal("B-plane of a heliocentric elliptical orbit")
+	}
+	ellipse, err := state.BPlaneAbout(Mars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nominal, varied := helio(make([]float64, 6)), helio(δ)
+	nominal.ToXCentric(Mars, dt)
+	varied.ToXCentric(Mars, dt)
+	nominalB, variedB := NewBPlane(*nominal), NewBPlane(*varied)
+	if math.Abs(ellipse.BT-nominalB.BT) > 1e-6 || math.Abs(ellipse.BR-nominalB.BR) > 1e-6 {
+		t.Fatalf("invalid nominal B-plane %s", ellipse)
+	}
+	ΔBT, ΔBR := variedB.BT-nominalB.BT, variedB.BR-nominalB.BR
+	if Δ := math.Sqrt(ΔBT*ΔBT + ΔBR*ΔBR); math.Abs(ellipse.SemiMaj-Δ) > 1e-3*Δ || ellipse.SemiMin > 1e-6*Δ {
+		t.Fatalf("invalid ellipse %s instead of %f km", ellipse, Δ)
+	}
+}
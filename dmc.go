@@ -0,0 +1,79 @@
+package smd
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+/* Dynamic model compensation (DMC), cf. Tapley, Schutz and Born, "Statistical Orbit Determination", 2004, section
+4.9.2. The unmodeled accelerations w are first order Gauss-Markov processes, i.e. dw/dt = -w/τ + u where u is a white
+noise, whose time constants τ are estimated along with w. The process noise of the position, velocity and w of each
+axis is computed with the method of Van Loan ("Computing integrals involving the matrix exponential", 1978), such that
+the steady state standard deviation of w is the DMC sigma, or with their closed form if the step exceeds τ. */
+
+// GaussMarkov is the reference of the unmodeled accelerations of the DMC (cf. Perturbations.DMC), which decay from
+// their value at the epoch with their time constants.
+type GaussMarkov struct {
+	Epoch        time.Time
+	Acceleration []float64 // Inertial acceleration at the epoch (km/s^2)
+	TimeConstant []float64 // Time constant of each component (s)
+}
+
+// At returns the acceleration at the provided time.
+func (g GaussMarkov) At(dt time.Time) []float64 {
+	Δt := dt.Sub(g.Epoch).Seconds()
+	acc := make([]float64, 3)
+	for i := 0; i < 3; i++ {
+		acc[i] = g.Acceleration[i] * math.Exp(-Δt/g.TimeConstant[i])
+	}
+	return acc
+}
+
+// Reset sets the acceleration and time constants at the provided epoch.
+func (g *GaussMarkov) Reset(dt time.Time, acceleration, timeConstant []float64) {
+	g.Epoch = dt
+	g.Acceleration = acceleration
+	g.TimeConstant = timeConstant
+}
+
+func (g GaussMarkov) String() string {
+	return fmt.Sprintf("w=%v km/s^2 τ=%v s @ %s", g.Acceleration, g.TimeConstant, g.Epoch)
+}
+
+// gaussMarkovNoise returns the process noise of the position, velocity and acceleration of an axis over Δt, for a
+// unit spectral density of the white noise of the acceleration whose time constant is τ.
+func gaussMarkovNoise(τ, Δt float64) *mat64.Dense {
+	if Δt > τ {
+		// The exponentials of Van Loan overflow, but the closed form of the integrals does not cancel out.
+		β := 1 / τ
+		βΔt := β * Δt
+		e1 := math.Exp(-βΔt)
+		e1m := -math.Expm1(-βΔt)
+		e2m := -math.Expm1(-2 * βΔt)
+		Qrr := math.Pow(Δt, 3)/(3*β*β) - Δt*Δt/math.Pow(β, 3) + Δt/math.Pow(β, 4) + (e2m/2-2*βΔt*e1)/math.Pow(β, 5)
+		Qrv := Δt*Δt/(2*β*β) - Δt/math.Pow(β, 3) + (e1m+βΔt*e1-e2m/2)/math.Pow(β, 4)
+		Qrw := (e2m/2 - βΔt*e1) / math.Pow(β, 3)
+		Qvv := (Δt - 2*e1m/β + e2m/(2*β)) / (β * β)
+		Qvw := (e1m - e2m/2) / (β * β)
+		Qww := e2m / (2 * β)
+		return mat64.NewDense(3, 3, []float64{Qrr, Qrv, Qrw, Qrv, Qvv, Qvw, Qrw, Qvw, Qww})
+	}
+	// Van Loan: exp([[-F, G*G^T], [0, F^T]]*Δt) = [[., Φ^-1*Q], [0, Φ^T]]
+	F := mat64.NewDense(3, 3, []float64{0, 1, 0, 0, 0, 1, 0, 0, -1 / τ})
+	M := mat64.NewDense(6, 6, nil)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			M.Set(i, j, -F.At(i, j)*Δt)
+			M.Set(i+3, j+3, F.At(j, i)*Δt)
+		}
+	}
+	M.Set(2, 5, Δt)
+	var expM mat64.Dense
+	expM.Exp(M)
+	var Q mat64.Dense
+	Q.Mul(expM.View(3, 3, 3, 3).T(), expM.View(0, 3, 3, 3))
+	return &Q
+}
@@ -0,0 +1,136 @@
+package smd
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestGaussMarkovNoise(t *testing.T) {
+	for _, τ := range []float64{1, 60, 900, 1e5} {
+		for _, Δt := range []float64{1, 10, 60} {
+			// Response of the position, velocity and acceleration to an impulse of the noise, ξ seconds earlier.
+			response := func(ξ float64) []float64 {
+				β := 1 / τ
+				return []float64{(β*ξ + math.Expm1(-β*ξ)) / (β * β), -math.Expm1(-β*ξ) / β, math.Exp(-β * ξ)}
+			}
+			// Simpson's rule of the integral of the products of the responses.
+			steps := 2000
+			h := Δt / float64(steps)
+			Q := gaussMarkovNoise(τ, Δt)
+			for i := 0; i < 3; i++ {
+				for j := 0; j < 3; j++ {
+					sum := 0.
+					for k := 0; k <= steps; k++ {
+						weight := 2.
+						if k == 0 || k == steps {
+							weight = 1
+						} else if k%2 == 1 {
+							weight = 4
+						}
+						g := response(float64(k) * h)
+						sum += weight * g[i] * g[j]
+					}
+					if expected := sum * h / 3; math.Abs(Q.At(i, j)-expected) > 1e-6*math.Abs(expected) {
+						t.Fatalf("τ=%g Δt=%g: Q[%d,%d]=%e instead of %e", τ, Δt, i, j, Q.At(i, j), expected)
+					}
+				}
+			}
+		}
+	}
+	g := GaussMarkov{testStart, []float64{1e-9, 0, -2e-9}, []float64{100, 100, 200}}
+	if acc := g.At(g.Epoch.Add(200 * time.Second)); !vectorsEqual(acc, []float64{1e-9 * math.Exp(-2), 0, -2e-9 * math.Exp(-1)}) {
+		t.Fatalf("invalid acceleration %v", acc)
+	}
+}
+
+func TestDMC(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(strings.NewReader(`[filter]
+type = "EKF"
+[DMC]
+enabled = true
+timeConstant = 3600
+sigma = 1e-8
+timeConstantSigma = 100`)); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := ODConfigFromConfig(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conf.DMC || conf.DMCTau != 3600 || conf.DMCSigma != 1e-8 || conf.DMCTauSigma != 100 {
+		t.Fatalf("invalid DMC configuration: %+v", conf)
+	}
+	v.Set("SNC.enabled", true)
+	if _, err = ODConfigFromConfig(v); err == nil {
+		t.Fatal("SNC and DMC accepted")
+	}
+	v.Set("SNC.enabled", false)
+	v.Set("DMC.sigma", 0)
+	if _, err = ODConfigFromConfig(v); err == nil {
+		t.Fatal("DMC without sigma accepted")
+	}
+
+	// The truth has an unmodeled constant acceleration, which the DMC absorbs.
+	end := testStart.Add(2 * time.Hour)
+	stations := []Station{DSS13Goldstone, DSS34Canberra, DSS65Madrid}
+	unmodeled := []float64{0, 0, 0, 2e-9, -1e-9, 0, 0}
+	truth := testMission(NewEmptySC("truth", 0), testGEO(), testStart, Perturbations{Arbitrary: func(o Orbit) []float64 { return unmodeled }}, false)
+	meas := simulateMissionMeasurements(truth, end, stations)
+	truthR := truth.Orbit.R()
+	errors := make(map[bool]float64)
+	for _, dmc := range []bool{false, true} {
+		conf := testODConfig(FilterEKF)
+		for i := 0; i < 3; i++ {
+			conf.P0.SetSym(i, i, 1e-6)
+			conf.P0.SetSym(i+3, i+3, 1e-12)
+		}
+		conf.DMC, conf.DMCTau, conf.DMCSigma, conf.DMCTauSigma = dmc, 7200, 1e-8, 100
+		mission := testGEOEstimate(meas.Epochs[0])
+		od := NewOrbitDetermination(mission, meas, conf)
+		od.End = end
+		result, err := od.Run()
+		if err != nil {
+			t.Fatalf("DMC=%v: %s", dmc, err)
+		}
+		var last ODEstimate
+		for _, est := range result.Estimates {
+			if est.DT.Equal(end) {
+				last = est
+			}
+		}
+		R := last.Orbit.R()
+		Δ := make([]float64, 3)
+		for i := 0; i < 3; i++ {
+			Δ[i] = R[i] + last.State().At(i, 0) - truthR[i]
+		}
+		errors[dmc] = Norm(Δ)
+		if !dmc {
+			continue
+		}
+		if len(result.StateNames) != 12 || len(result.Parameters) != 6 || result.StateNames[6] != "DMC wx" {
+			t.Fatalf("invalid DMC state %v: %v", result.StateNames, result.Parameters)
+		}
+		for i := 0; i < 3; i++ {
+			σ := math.Sqrt(last.Covariance().At(6+i, 6+i))
+			if w := result.Parameters[i]; σ >= conf.DMCSigma || math.Abs(w-unmodeled[3+i]) > 3*σ {
+				t.Fatalf("invalid DMC acceleration %v (σ=%e) instead of %v", result.Parameters[:3], σ, unmodeled[3:6])
+			}
+		}
+	}
+	if errors[true] >= errors[false]/2 {
+		t.Fatalf("the DMC does not absorb the unmodeled acceleration: %f km (DMC) vs. %f km", errors[true], errors[false])
+	}
+	// Only the CKF and EKF support the DMC.
+	conf = testODConfig(FilterSRIF)
+	conf.DMC, conf.DMCTau, conf.DMCSigma, conf.DMCTauSigma = true, 7200, 1e-8, 100
+	mission := testGEOEstimate(meas.Epochs[0])
+	if _, err = NewOrbitDetermination(mission, meas, conf).Run(); err == nil {
+		t.Fatal("DMC accepted by the SRIF")
+	}
+}
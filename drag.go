@@ -0,0 +1,84 @@
+package smd
+
+import (
+	"math"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+/* Atmospheric drag with the exponential atmosphere of the Earth, cf. Vallado, "Fundamentals of Astrodynamics and
+Applications", 4th ed., table 8-4. The atmosphere rotates with the Earth. */
+
+// areaToMass is the area to mass ratio of all spacecraft (in km^2/kg) for the SRP and the drag.
+const areaToMass = 0.01e-6 // TODO: per spacecraft
+
+// atmosphereLayer is a layer of the exponential atmosphere from its base altitude (km), with the density at that
+// altitude (kg/m^3) and its scale height (km).
+type atmosphereLayer struct {
+	h0, ρ0, H float64
+}
+
+var exponentialAtmosphere = []atmosphereLayer{
+	{0, 1.225, 7.249}, {25, 3.899e-2, 6.349}, {30, 1.774e-2, 6.682}, {40, 3.972e-3, 7.554}, {50, 1.057e-3, 8.382},
+	{60, 3.206e-4, 7.714}, {70, 8.770e-5, 6.549}, {80, 1.905e-5, 5.799}, {90, 3.396e-6, 5.382}, {100, 5.297e-7, 5.877},
+	{110, 9.661e-8, 7.263}, {120, 2.438e-8, 9.473}, {130, 8.484e-9, 12.636}, {140, 3.845e-9, 16.149},
+	{150, 2.070e-9, 22.523}, {180, 5.464e-10, 29.740}, {200, 2.789e-10, 37.105}, {250, 7.248e-11, 45.546},
+	{300, 2.418e-11, 53.628}, {350, 9.518e-12, 53.298}, {400, 3.725e-12, 58.515}, {450, 1.585e-12, 60.828},
+	{500, 6.967e-13, 63.822}, {600, 1.454e-13, 71.835}, {700, 3.614e-14, 88.667}, {800, 1.170e-14, 124.64},
+	{900, 5.245e-15, 181.05}, {1000, 3.019e-15, 268.00},
+}
+
+// atmosphericDensity returns the density (in kg/km^3) of the exponential atmosphere at the provided altitude (km), and
+// the scale height of its layer.
+func atmosphericDensity(altitude float64) (ρ, H float64) {
+	layer := exponentialAtmosphere[0]
+	for _, l := range exponentialAtmosphere {
+		if altitude < l.h0 {
+			break
+		}
+		layer = l
+	}
+	return layer.ρ0 * 1e9 * math.Exp(-(altitude-layer.h0)/layer.H), layer.H
+}
+
+// dragRelativeVelocity returns the velocity of the spacecraft with respect to the rotating atmosphere.
+func dragRelativeVelocity(R, V []float64) []float64 {
+	ωCrossR := Cross([]float64{0, 0, EarthRotationRate}, R)
+	return []float64{V[0] - ωCrossR[0], V[1] - ωCrossR[1], V[2] - ωCrossR[2]}
+}
+
+// dragAcceleration returns the drag acceleration about the Earth of a spacecraft with the provided drag coefficient.
+func dragAcceleration(R, V []float64, Cd float64) []float64 {
+	ρ, _ := atmosphericDensity(Norm(R) - Earth.Radius)
+	vRel := dragRelativeVelocity(R, V)
+	k := -0.5 * ρ * Cd * areaToMass * Norm(vRel)
+	return []float64{k * vRel[0], k * vRel[1], k * vRel[2]}
+}
+
+// dragPartials returns the partials of dragAcceleration with respect to the position and velocity.
+func dragPartials(R, V []float64, Cd float64) (dAdR, dAdV *mat64.Dense) {
+	ρ, H := atmosphericDensity(Norm(R) - Earth.Radius)
+	vRel := dragRelativeVelocity(R, V)
+	vRelNorm := Norm(vRel)
+	B := 0.5 * Cd * areaToMass
+	rUnit := Unit(R)
+	dAdV = mat64.NewDense(3, 3, nil)
+	dAdR = mat64.NewDense(3, 3, nil)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			δij := 0.
+			if i == j {
+				δij = 1
+			}
+			dAdV.Set(i, j, -B*ρ*(vRelNorm*δij+vRel[i]*vRel[j]/vRelNorm))
+			// The density decreases with the altitude.
+			dAdR.Set(i, j, B*ρ/H*vRelNorm*vRel[i]*rUnit[j])
+		}
+	}
+	// The relative velocity depends on the position through the rotation of the atmosphere: ∂vRel/∂R = -[ω×].
+	ωCross := mat64.NewDense(3, 3, []float64{0, -EarthRotationRate, 0, EarthRotationRate, 0, 0, 0, 0, 0})
+	var rotation mat64.Dense
+	rotation.Mul(dAdV, ωCross)
+	dAdR.Sub(dAdR, &rotation)
+	return
+}
@@ -0,0 +1,52 @@
+package smd
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestAtmosphericDrag(t *testing.T) {
+	// Vallado, table 8-4
+	if ρ, H := atmosphericDensity(400); math.Abs(ρ-3.725e-3) > 1e-12 || H != 58.515 {
+		t.Fatalf("invalid density at 400 km: ρ=%e kg/km^3, H=%f km", ρ, H)
+	}
+	if ρ, _ := atmosphericDensity(420); ρ >= 3.725e-3 || ρ <= 1.585e-3 {
+		t.Fatalf("density at 420 km not within its layer: ρ=%e kg/km^3", ρ)
+	}
+	R, V := NewOrbitFromOE(6778, 0.001, 51.6, 30, 40, 50, Earth).RV()
+	Cd := 2.2
+	acc := dragAcceleration(R, V, Cd)
+	if Dot(acc, dragRelativeVelocity(R, V)) >= 0 {
+		t.Fatal("the drag is not opposed to the relative velocity")
+	}
+	// Compare the partials with central differences.
+	dAdR, dAdV := dragPartials(R, V, Cd)
+	scale := math.Max(mat64.Norm(dAdR, math.Inf(1)), mat64.Norm(dAdV, math.Inf(1)))
+	for j := 0; j < 3; j++ {
+		for _, partial := range []struct {
+			h   float64
+			vel bool
+		}{{1e-3, false}, {1e-6, true}} {
+			Rp, Rm := []float64{R[0], R[1], R[2]}, []float64{R[0], R[1], R[2]}
+			Vp, Vm := []float64{V[0], V[1], V[2]}, []float64{V[0], V[1], V[2]}
+			expected := dAdR
+			if partial.vel {
+				Vp[j] += partial.h
+				Vm[j] -= partial.h
+				expected = dAdV
+			} else {
+				Rp[j] += partial.h
+				Rm[j] -= partial.h
+			}
+			plus, minus := dragAcceleration(Rp, Vp, Cd), dragAcceleration(Rm, Vm, Cd)
+			for i := 0; i < 3; i++ {
+				fd := (plus[i] - minus[i]) / (2 * partial.h)
+				if math.Abs(fd-expected.At(i, j)) > 1e-6*scale {
+					t.Fatalf("invalid partial [%d,%d] (velocity=%t): %e instead of %e", i, j, partial.vel, expected.At(i, j), fd)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package smd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+/* Precise Earth fixed frames: IAU-76/FK5 reduction (IAU-1976 precession, IAU-1980 nutation), sidereal time and polar
+motion from the IERS Earth Orientation Parameters. Algorithms from Vallado, 4th edition, section 3.7. */
+
+const (
+	// EarthRotationRateIERS is the nominal Earth rotation rate in radians per second used for the IAU-76/FK5 reduction.
+	EarthRotationRateIERS = 7.292115146706979e-5
+	arcsec2rad            = math.Pi / (180 * 3600)
+	mjdOffset             = 2400000.5 // JD - MJD
+)
+
+var j2000 = time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// EOP stores the IERS Earth Orientation Parameters of a given day.
+type EOP struct {
+	MJD        float64 // Modified Julian date (UTC)
+	Xp, Yp     float64 // Polar motion, in arcseconds
+	UT1UTC     float64 // UT1-UTC, in seconds
+	LOD        float64 // Excess length of day, in seconds
+	DPsi, DEps float64 // Corrections to the IAU-1980 nutation, in arcseconds
+}
+
+var (
+	loadedEOP    []EOP
+	loadedEOPMtx sync.RWMutex
+)
+
+// ParseEOP returns the EOP from the provided reader in the CelesTrak format, e.g. EOP-All.txt, whose data lines are:
+// YYYY MM DD MJD x y UT1-UTC LOD dPsi dEps dX dY DAT
+// All other lines (header, BEGIN/END lines, etc.) are skipped.
+func ParseEOP(r io.Reader) ([]EOP, error) {
+	scanner := bufio.NewScanner(r)
+	eops := make([]EOP, 0)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || len(fields[0]) != 4 {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[0]); err != nil {
+			continue
+		}
+		values := make([]float64, 7)
+		for i := range values {
+			v, err := strconv.ParseFloat(fields[i+3], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid EOP value `%s`", lineNo, fields[i+3])
+			}
+			values[i] = v
+		}
+		eops = append(eops, EOP{values[0], values[1], values[2], values[3], values[4], values[5], values[6]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(eops) == 0 {
+		return nil, fmt.Errorf("no EOP data found")
+	}
+	sort.Slice(eops, func(i, j int) bool { return eops[i].MJD < eops[j].MJD })
+	return eops, nil
+}
+
+// LoadEOP loads the EOP file (CelesTrak format) which will be used for all the Earth frame transformations.
+func LoadEOP(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	eops, err := ParseEOP(f)
+	if err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	SetEOP(eops)
+	return nil
+}
+
+// SetEOP sets the EOP used for all the Earth frame transformations. Set to nil to ignore polar motion and UT1-UTC.
+func SetEOP(eops []EOP) {
+	loadedEOPMtx.Lock()
+	loadedEOP = eops
+	loadedEOPMtx.Unlock()
+}
+
+// EOPAt returns the EOP at the provided UTC time, linearly interpolated between the daily values.
+// WARNING: If no EOP was loaded or if the time is outside of the loaded data, the EOP are all zero.
+func EOPAt(dt time.Time) EOP {
+	loadedEOPMtx.RLock()
+	defer loadedEOPMtx.RUnlock()
+	mjd := timeToJD(dt) - mjdOffset
+	n := len(loadedEOP)
+	if n == 0 || mjd < loadedEOP[0].MJD || mjd > loadedEOP[n-1].MJD {
+		return EOP{MJD: mjd}
+	}
+	i := sort.Search(n, func(i int) bool { return loadedEOP[i].MJD > mjd })
+	if i == n {
+		return loadedEOP[n-1]
+	}
+	e0, e1 := loadedEOP[i-1], loadedEOP[i]
+	f := (mjd - e0.MJD) / (e1.MJD - e0.MJD)
+	interp := func(v0, v1 float64) float64 { return v0 + f*(v1-v0) }
+	return EOP{mjd, interp(e0.Xp, e1.Xp), interp(e0.Yp, e1.Yp), interp(e0.UT1UTC, e1.UT1UTC), interp(e0.LOD, e1.LOD), interp(e0.DPsi, e1.DPsi), interp(e0.DEps, e1.DEps)}
+}
+
+// julianCenturiesTT returns the number of Julian centuries of TT since J2000 at the provided UTC time.
+func julianCenturiesTT(dt time.Time) float64 {
+	return NewEpoch(dt, UTC).In(TT).Sub(j2000).Seconds() / (86400 * 36525)
+}
+
+// jdUT1 returns the UT1 Julian date at the provided UTC time.
+func jdUT1(dt time.Time, eop EOP) float64 {
+	return 2451545 + dt.Add(time.Duration(eop.UT1UTC*1e9)).Sub(j2000).Seconds()/86400
+}
+
+// nutation80Terms are the terms of the IAU-1980 nutation larger than 0.0003 arcseconds, from Meeus, table 22.A.
+// The coefficients of D, M, M', F and Ω are followed by those of Δψ and Δε in 0.0001 arcseconds.
+var nutation80Terms = []struct {
+	d, m, mp, f, ω float64
+	ψ, ψT, ε, εT   float64
+}{
+	{0, 0, 0, 0, 1, -171996, -174.2, 92025, 8.9},
+	{-2, 0, 0, 2, 2, -13187, -1.6, 5736, -3.1},
+	{0, 0, 0, 2, 2, -2274, -0.2, 977, -0.5},
+	{0, 0, 0, 0, 2, 2062, 0.2, -895, 0.5},
+	{0, 1, 0, 0, 0, 1426, -3.4, 54, -0.1},
+	{0, 0, 1, 0, 0, 712, 0.1, -7, 0},
+	{-2, 1, 0, 2, 2, -517, 1.2, 224, -0.6},
+	{0, 0, 0, 2, 1, -386, -0.4, 200, 0},
+	{0, 0, 1, 2, 2, -301, 0, 129, -0.1},
+	{-2, -1, 0, 2, 2, 217, -0.5, -95, 0.3},
+	{-2, 0, 1, 0, 0, -158, 0, 0, 0},
+	{-2, 0, 0, 2, 1, 129, 0.1, -70, 0},
+	{0, 0, -1, 2, 2, 123, 0, -53, 0},
+	{2, 0, 0, 0, 0, 63, 0, 0, 0},
+	{0, 0, 1, 0, 1, 63, 0.1, -33, 0},
+	{2, 0, -1, 2, 2, -59, 0, 26, 0},
+	{0, 0, -1, 0, 1, -58, -0.1, 32, 0},
+	{0, 0, 1, 2, 1, -51, 0, 27, 0},
+	{-2, 0, 2, 0, 0, 48, 0, 0, 0},
+	{0, 0, -2, 2, 1, 46, 0, -24, 0},
+	{2, 0, 0, 2, 2, -38, 0, 16, 0},
+	{0, 0, 2, 2, 2, -31, 0, 13, 0},
+	{0, 0, 2, 0, 0, 29, 0, 0, 0},
+	{-2, 0, 1, 2, 2, 29, 0, -12, 0},
+	{0, 0, 0, 2, 0, 26, 0, 0, 0},
+	{-2, 0, 0, 2, 0, -22, 0, 0, 0},
+	{0, 0, -1, 2, 1, 21, 0, -10, 0},
+	{0, 2, 0, 0, 0, 17, -0.1, 0, 0},
+	{2, 0, -1, 0, 1, 16, 0, -8, 0},
+	{-2, 2, 0, 2, 2, -16, 0.1, 7, 0},
+	{0, 1, 0, 0, 1, -15, 0, 9, 0},
+	{-2, 0, 1, 0, 1, -13, 0, 7, 0},
+	{0, -1, 0, 0, 1, -12, 0, 6, 0},
+	{0, 0, 2, -2, 0, 11, 0, 0, 0},
+	{2, 0, -1, 2, 1, -10, 0, 5, 0},
+	{2, 0, 1, 2, 2, -8, 0, 3, 0},
+	{0, 1, 0, 2, 2, 7, 0, -3, 0},
+	{-2, 1, 1, 0, 0, -7, 0, 0, 0},
+	{0, -1, 0, 2, 2, -7, 0, 3, 0},
+	{2, 0, 0, 2, 1, -7, 0, 3, 0},
+	{2, 0, 1, 0, 0, 6, 0, 0, 0},
+	{-2, 0, 2, 2, 2, 6, 0, -3, 0},
+	{-2, 0, 1, 2, 1, 6, 0, -3, 0},
+	{2, 0, -2, 0, 1, -6, 0, 3, 0},
+	{2, 0, 0, 0, 1, -6, 0, 3, 0},
+	{0, -1, 1, 0, 0, 5, 0, 0, 0},
+	{-2, -1, 0, 2, 1, -5, 0, 3, 0},
+	{-2, 0, 0, 0, 1, -5, 0, 3, 0},
+	{0, 0, 2, 2, 1, -5, 0, 3, 0},
+	{-2, 0, 2, 0, 1, 4, 0, 0, 0},
+	{-2, 1, 0, 2, 1, 4, 0, 0, 0},
+	{0, 0, 1, -2, 0, 4, 0, 0, 0},
+	{-1, 0, 1, 0, 0, -4, 0, 0, 0},
+	{-2, 1, 0, 0, 0, -4, 0, 0, 0},
+	{1, 0, 0, 0, 0, -4, 0, 0, 0},
+	{0, 0, 1, 2, 0, 3, 0, 0, 0},
+	{0, 0, -2, 2, 2, -3, 0, 0, 0},
+	{-1, -1, 1, 0, 0, -3, 0, 0, 0},
+	{0, 1, 1, 0, 0, -3, 0, 0, 0},
+	{0, -1, 1, 2, 2, -3, 0, 0, 0},
+	{2, -1, -1, 2, 2, -3, 0, 0, 0},
+	{0, 0, 3, 2, 2, -3, 0, 0, 0},
+	{2, -1, 0, 2, 2, -3, 0, 0, 0},
+}
+
+// nutation80 returns the IAU-1980 nutation in longitude and in obliquity, the mean obliquity of the ecliptic and the
+// longitude of the ascending node of the Moon, all in radians, for the provided Julian centuries of TT.
+// Algorithm from Meeus, Astronomical Algorithms, 2nd edition, chapter 22.
+func nutation80(T float64) (Δψ, Δε, εBar, Ω float64) {
+	T2 := T * T
+	T3 := T2 * T
+	D := deg2rad * (297.85036 + 445267.111480*T - 0.0019142*T2 + T3/189474)
+	M := deg2rad * (357.52772 + 35999.050340*T - 0.0001603*T2 - T3/300000)
+	Mp := deg2rad * (134.96298 + 477198.867398*T + 0.0086972*T2 + T3/56250)
+	F := deg2rad * (93.27191 + 483202.017538*T - 0.0036825*T2 + T3/327270)
+	Ω = deg2rad * (125.04452 - 1934.136261*T + 0.0020708*T2 + T3/450000)
+	for _, term := range nutation80Terms {
+		arg := term.d*D + term.m*M + term.mp*Mp + term.f*F + term.ω*Ω
+		sArg, cArg := math.Sincos(arg)
+		Δψ += (term.ψ + term.ψT*T) * sArg
+		Δε += (term.ε + term.εT*T) * cArg
+	}
+	Δψ *= 1e-4 * arcsec2rad
+	Δε *= 1e-4 * arcsec2rad
+	εBar = (84381.448 - 46.8150*T - 0.00059*T2 + 0.001813*T3) * arcsec2rad
+	return
+}
+
+// precession76 returns the IAU-1976 precession angles ζ, θ and z in radians for the provided Julian centuries of TT.
+func precession76(T float64) (ζ, θ, z float64) {
+	T2 := T * T
+	T3 := T2 * T
+	ζ = (2306.2181*T + 0.30188*T2 + 0.017998*T3) * arcsec2rad
+	θ = (2004.3109*T - 0.42665*T2 - 0.041833*T3) * arcsec2rad
+	z = (2306.2181*T + 1.09468*T2 + 0.018203*T3) * arcsec2rad
+	return
+}
+
+// earthOrientation stores the IAU-76/FK5 reduction between the ITRF and the GCRF at a given epoch, such that
+// r_GCRF = PN * R3(-GAST) * W * r_ITRF.
+type earthOrientation struct {
+	PN   *mat64.Dense // from the TOD to the GCRF
+	GAST float64      // Greenwich apparent sidereal time in radians
+	W    *mat64.Dense // Polar motion, from the ITRF to the PEF
+	ω    float64      // Earth rotation rate in rad/s
+}
+
+func newEarthOrientation(dt time.Time) earthOrientation {
+	eop := EOPAt(dt)
+	T := julianCenturiesTT(dt)
+	Δψ, Δε, εBar, Ω := nutation80(T)
+	Δψ += eop.DPsi * arcsec2rad
+	Δε += eop.DEps * arcsec2rad
+	var W mat64.Dense
+	W.Mul(R1(eop.Yp*arcsec2rad), R2(eop.Xp*arcsec2rad))
+	// Equation of the equinoxes, including the terms used after 1997.
+	eqe := Δψ*math.Cos(εBar) + (0.00264*math.Sin(Ω)+0.000063*math.Sin(2*Ω))*arcsec2rad
+	gast := math.Mod(gstime(jdUT1(dt, eop))+eqe, twoπ)
+	return earthOrientation{precessionNutation(T, Δψ, Δε, εBar), gast, &W, EarthRotationRateIERS * (1 - eop.LOD/86400)}
+}
+
+// precessionNutation returns the IAU-76/FK5 rotation from the TOD to the GCRF for the provided Julian centuries of TT
+// and nutation angles.
+func precessionNutation(T, Δψ, Δε, εBar float64) *mat64.Dense {
+	ζ, θ, z := precession76(T)
+	var P, N, PN mat64.Dense
+	P.Mul(R3(ζ), R2(-θ))
+	P.Mul(&P, R3(z))
+	N.Mul(R1(-εBar), R3(Δψ))
+	N.Mul(&N, R1(εBar+Δε))
+	PN.Mul(&P, &N)
+	return &PN
+}
+
+// TEME2GCRF converts the provided state in the True Equator Mean Equinox frame of SGP4 to the GCRF at the provided
+// time, i.e. r_GCRF = PN * R3(-Δψ cos ε̄) * r_TEME. As recommended by Vallado et al. (2006, "Revisiting Spacetrack
+// Report #3"), the EOP corrections of the nutation and the terms of the equation of the equinoxes after 1997 are ignored.
+func TEME2GCRF(R, V []float64, dt time.Time) ([]float64, []float64) {
+	T := julianCenturiesTT(dt.UTC())
+	Δψ, Δε, εBar, _ := nutation80(T)
+	var rot mat64.Dense
+	rot.Mul(precessionNutation(T, Δψ, Δε, εBar), R3(-Δψ*math.Cos(εBar)))
+	return MxV33(&rot, R), MxV33(&rot, V)
+}
+
+// toGCRF converts the provided ITRF state to the GCRF.
+func (e earthOrientation) toGCRF(R, V []float64) ([]float64, []float64) {
+	rPEF := MxV33(e.W, R)
+	vPEF := MxV33(e.W, V)
+	ωxr := Cross([]float64{0, 0, e.ω}, rPEF)
+	for i := 0; i < 3; i++ {
+		vPEF[i] += ωxr[i]
+	}
+	var PNR mat64.Dense
+	PNR.Mul(e.PN, R3(-e.GAST))
+	return MxV33(&PNR, rPEF), MxV33(&PNR, vPEF)
+}
+
+// toITRF converts the provided GCRF state to the ITRF.
+func (e earthOrientation) toITRF(R, V []float64) ([]float64, []float64) {
+	var RPN mat64.Dense
+	RPN.Mul(R3(e.GAST), e.PN.T())
+	rPEF := MxV33(&RPN, R)
+	vPEF := MxV33(&RPN, V)
+	ωxr := Cross([]float64{0, 0, e.ω}, rPEF)
+	for i := 0; i < 3; i++ {
+		vPEF[i] -= ωxr[i]
+	}
+	return MxV33(e.W.T(), rPEF), MxV33(e.W.T(), vPEF)
+}
+
+// GMST returns the Greenwich mean sidereal time in radians at the provided time, using UT1-UTC from the loaded EOP.
+func GMST(dt time.Time) float64 {
+	return gstime(jdUT1(dt.UTC(), EOPAt(dt.UTC())))
+}
+
+// GAST returns the Greenwich apparent sidereal time in radians at the provided time (IAU-1982 GMST and IAU-1980 nutation).
+func GAST(dt time.Time) float64 {
+	return newEarthOrientation(dt.UTC()).GAST
+}
+
+// ECI2ECEFState converts the provided ECI (GCRF) state to ECEF (ITRF) at the provided time.
+// This accounts for precession, nutation, sidereal time and polar motion (IAU-76/FK5), and for the Earth rotation
+// in the velocity. Polar motion and UT1-UTC are read from the loaded EOP (cf. LoadEOP).
+func ECI2ECEFState(R, V []float64, dt time.Time) ([]float64, []float64) {
+	return newEarthOrientation(dt.UTC()).toITRF(R, V)
+}
+
+// ECEF2ECIState converts the provided ECEF (ITRF) state to ECI (GCRF) at the provided time.
+// This is the inverse of ECI2ECEFState.
+func ECEF2ECIState(R, V []float64, dt time.Time) ([]float64, []float64) {
+	return newEarthOrientation(dt.UTC()).toGCRF(R, V)
+}
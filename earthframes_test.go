@@ -0,0 +1,110 @@
+package smd
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+)
+
+func TestEarthFramesVallado(t *testing.T) {
+	// Example 3-14 from Vallado, 4th edition.
+	dt := time.Date(2004, 4, 6, 7, 51, 28, 386009000, time.UTC)
+	// The sidereal times of the example do not include the nutation corrections.
+	eop := EOP{53101, -0.140682, 0.333309, -0.4399619, 0.0015563, 0, 0}
+	eopNextDay := eop
+	eopNextDay.MJD++
+	SetEOP([]EOP{eop, eopNextDay})
+	defer SetEOP(nil)
+	if gmst := GMST(dt) * r2d; !floats.EqualWithinAbs(gmst, 312.8098943, 1e-6) {
+		t.Fatalf("GMST = %.7f deg", gmst)
+	}
+	if gast := GAST(dt) * r2d; !floats.EqualWithinAbs(gast, 312.8067654, 1e-6) {
+		t.Fatalf("GAST = %.7f deg", gast)
+	}
+	eop.DPsi, eop.DEps = -0.052195, -0.003875
+	eopNextDay.DPsi, eopNextDay.DEps = eop.DPsi, eop.DEps
+	SetEOP([]EOP{eop, eopNextDay})
+	rITRF := []float64{-1033.4793830, 7901.2952754, 6380.3565958}
+	vITRF := []float64{-3.225636520, -2.872451450, 5.531924446}
+	rGCRF, vGCRF := ECEF2ECIState(rITRF, vITRF, dt)
+	if !floats.EqualApprox(rGCRF, []float64{5102.508958, 6123.011401, 6378.136928}, 1e-7) {
+		t.Fatalf("invalid GCRF position: %+v", rGCRF)
+	}
+	if !floats.EqualApprox(vGCRF, []float64{-4.743220156, 0.790536497, 5.533755276}, 1e-6) {
+		t.Fatalf("invalid GCRF velocity: %+v", vGCRF)
+	}
+	// And back
+	rBack, vBack := ECI2ECEFState(rGCRF, vGCRF, dt)
+	if !floats.EqualApprox(rBack, rITRF, 1e-12) || !floats.EqualApprox(vBack, vITRF, 1e-12) {
+		t.Fatalf("ECEF round trip failed: %+v %+v", rBack, vBack)
+	}
+	// Without any EOP, only polar motion, UT1-UTC and the nutation corrections are ignored.
+	SetEOP(nil)
+	rGCRF2, _ := ECEF2ECIState(rITRF, vITRF, dt)
+	diff := make([]float64, 3)
+	floats.SubTo(diff, rGCRF, rGCRF2)
+	if n := Norm(diff); n < 0.1 || n > 1 {
+		t.Fatalf("unexpected difference without EOP: %f km", n)
+	}
+}
+
+func TestTEME2GCRF(t *testing.T) {
+	// Example of Vallado et al. (2006), "Revisiting Spacetrack Report #3", at the epoch of example 3-14.
+	dt := time.Date(2004, 4, 6, 7, 51, 28, 386009000, time.UTC)
+	R, V := TEME2GCRF([]float64{5094.18016210, 6127.64465950, 6380.34453270}, []float64{-4.746131487, 0.785818041, 5.531931288}, dt)
+	if !floats.EqualApprox(R, []float64{5102.5096, 6123.01152, 6378.1363}, 2e-7) {
+		t.Fatalf("invalid GCRF position: %+v", R)
+	}
+	if !floats.EqualApprox(V, []float64{-4.7432196, 0.7905366, 5.5337561}, 1e-6) {
+		t.Fatalf("invalid GCRF velocity: %+v", V)
+	}
+}
+
+func TestParseEOP(t *testing.T) {
+	data := `# Example in the CelesTrak format
+NUM_OBSERVED_POINTS = 2
+BEGIN OBSERVED
+2004 04 06 53101 -0.140682  0.333309 -0.4399619  0.0015563 -0.052195 -0.003875  0.000000  0.000000  32
+2004 04 07 53102 -0.138990  0.332915 -0.4415203  0.0016076 -0.052441 -0.003950  0.000000  0.000000  32
+END OBSERVED
+`
+	eops, err := ParseEOP(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eops) != 2 || eops[1].MJD != 53102 || eops[0].UT1UTC != -0.4399619 || eops[1].DEps != -0.00395 {
+		t.Fatalf("invalid EOP: %+v", eops)
+	}
+	SetEOP(eops)
+	defer SetEOP(nil)
+	mid := EOPAt(time.Date(2004, 4, 6, 12, 0, 0, 0, time.UTC))
+	if !floats.EqualWithinAbs(mid.Xp, (-0.140682-0.138990)/2, 1e-9) || !floats.EqualWithinAbs(mid.UT1UTC, (-0.4399619-0.4415203)/2, 1e-9) {
+		t.Fatalf("invalid interpolation: %+v", mid)
+	}
+	if out := EOPAt(time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)); out.Xp != 0 || out.UT1UTC != 0 {
+		t.Fatalf("EOP outside of the data should be zero: %+v", out)
+	}
+	if _, err := ParseEOP(strings.NewReader("2004 04 06 53101 -0.140682 x -0.4399619 0.0015563 -0.052195 -0.003875 0 0 32")); err == nil {
+		t.Fatal("invalid EOP accepted")
+	}
+}
+
+func TestStationPerformMeasurement(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	st := NewStation("test", 0, 10, 30, 40, σρ, σρDot)
+	// Place the spacecraft 1000 km above the station (but not at zenith), moving away from the station in the ECEF frame.
+	rECEF := GEO2ECEF(1000, st.LatΦ+d2r, st.Longθ)
+	ρECEF := make([]float64, 3)
+	floats.SubTo(ρECEF, rECEF, st.R)
+	R, V := ECEF2ECIState(rECEF, Unit(ρECEF), dt)
+	m := st.PerformMeasurement(dt, State{DT: dt, Orbit: *NewOrbitFromRV(R, V, Earth)})
+	if !m.Visible || !floats.EqualWithinAbs(m.TrueRange, Norm(ρECEF), 1e-6) || !floats.EqualWithinAbs(m.TrueRangeRate, 1, 1e-9) {
+		t.Fatalf("invalid measurement: %+v", m)
+	}
+	if !m.Epoch.Equal(dt) || math.Abs(m.Range-m.TrueRange) > 1 {
+		t.Fatalf("invalid measurement: %+v", m)
+	}
+}
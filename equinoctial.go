@@ -0,0 +1,83 @@
+package smd
+
+import "math"
+
+/* Non-singular element sets. */
+
+// Equinoctial returns the equinoctial elements of this orbit as defined by Broucke and Cefola (1972):
+// a, h=e*sin(ω+Ω), k=e*cos(ω+Ω), p=tan(i/2)*sin(Ω), q=tan(i/2)*cos(Ω) and the mean longitude λ=M+ω+Ω in radians.
+// These are only singular for retrograde equatorial orbits (i=180 deg).
+func (o Orbit) Equinoctial() (a, h, k, p, q, λ float64) {
+	mp, f, g, mh, mk, L := o.ModifiedEquinoctial()
+	e2 := f*f + g*g
+	a = mp / (1 - e2)
+	ϖ := math.Atan2(g, f) // longitude of periapsis
+	M := True2MeanAnomaly(L-ϖ, math.Sqrt(e2))
+	λ = math.Mod(ϖ+M+2*math.Pi, 2*math.Pi)
+	return a, g, f, mk, mh, λ
+}
+
+// NewOrbitFromEquinoctial returns an orbit from the equinoctial elements (cf. Orbit.Equinoctial).
+// WARNING: The mean longitude λ is in radians.
+func NewOrbitFromEquinoctial(a, h, k, p, q, λ float64, c CelestialObject) *Orbit {
+	e := math.Sqrt(h*h + k*k)
+	ϖ := math.Atan2(h, k)
+	L := ϖ + Mean2TrueAnomaly(math.Mod(λ-ϖ+2*math.Pi, 2*math.Pi), e)
+	return NewOrbitFromMEE(a*(1-e*e), k, h, q, p, L, c)
+}
+
+// ModifiedEquinoctial returns the modified equinoctial elements of this orbit as defined by Walker et al. (1985):
+// the semi-parameter p, f=e*cos(ω+Ω), g=e*sin(ω+Ω), h=tan(i/2)*cos(Ω), k=tan(i/2)*sin(Ω) and the true
+// longitude L=Ω+ω+ν in radians. These are computed from the position and velocity, so they remain valid
+// for circular and equatorial orbits, and for parabolic and hyperbolic orbits.
+func (o Orbit) ModifiedEquinoctial() (p, f, g, h, k, L float64) {
+	R, V := o.RV()
+	hVec := Cross(R, V)
+	p = Dot(hVec, hVec) / o.Origin.μ
+	hHat := Unit(hVec)
+	h = -hHat[1] / (1 + hHat[2])
+	k = hHat[0] / (1 + hHat[2])
+	fHat, gHat := equinoctialFrame(h, k)
+	// Eccentricity vector
+	rHat := Unit(R)
+	vxh := Cross(V, hVec)
+	eVec := make([]float64, 3)
+	for i := 0; i < 3; i++ {
+		eVec[i] = vxh[i]/o.Origin.μ - rHat[i]
+	}
+	f = Dot(eVec, fHat)
+	g = Dot(eVec, gHat)
+	L = math.Atan2(Dot(rHat, gHat), Dot(rHat, fHat))
+	L = math.Mod(L+2*math.Pi, 2*math.Pi)
+	return
+}
+
+// NewOrbitFromMEE returns an orbit from the modified equinoctial elements (cf. Orbit.ModifiedEquinoctial).
+// WARNING: The true longitude L is in radians.
+func NewOrbitFromMEE(p, f, g, h, k, L float64, c CelestialObject) *Orbit {
+	R, V := mee2rv(p, f, g, h, k, L, c.μ)
+	return NewOrbitFromRV(R, V, c)
+}
+
+// mee2rv returns the position and velocity vectors from the modified equinoctial elements.
+func mee2rv(p, f, g, h, k, L, μ float64) (R, V []float64) {
+	fHat, gHat := equinoctialFrame(h, k)
+	sinL, cosL := math.Sincos(L)
+	r := p / (1 + f*cosL + g*sinL)
+	sqrtμp := math.Sqrt(μ / p)
+	R = make([]float64, 3)
+	V = make([]float64, 3)
+	for i := 0; i < 3; i++ {
+		R[i] = r * (cosL*fHat[i] + sinL*gHat[i])
+		V[i] = sqrtμp * (-(g+sinL)*fHat[i] + (f+cosL)*gHat[i])
+	}
+	return
+}
+
+// equinoctialFrame returns the first two unit vectors of the (prograde) equinoctial frame.
+func equinoctialFrame(h, k float64) (fHat, gHat []float64) {
+	s2 := 1 + h*h + k*k
+	fHat = []float64{(1 - k*k + h*h) / s2, 2 * h * k / s2, -2 * k / s2}
+	gHat = []float64{2 * h * k / s2, (1 + k*k - h*h) / s2, 2 * h / s2}
+	return
+}
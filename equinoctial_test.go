@@ -0,0 +1,52 @@
+package smd
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/floats"
+)
+
+func TestOrbitModifiedEquinoctial(t *testing.T) {
+	for _, obj := range []CelestialObject{Earth, Sun, Mars} {
+		for _, oe := range [][]float64{{1.5 * obj.Radius, 0.2, 35, 20, 40, 60}, {1.5 * obj.Radius, 0, 0, 0, 0, 20.5}, {1.5 * obj.Radius, 1e-7, 1e-7, 87, 52, 20.5}, nil} {
+			var oI *Orbit
+			if oe == nil {
+				// Hyperbolic orbits must be initialized from R, V.
+				vEsc := math.Sqrt(2 * obj.μ / (1.5 * obj.Radius))
+				oI = NewOrbitFromRV([]float64{1.5 * obj.Radius, 0.2 * obj.Radius, 0}, []float64{0.1 * vEsc, 1.1 * vEsc, 0.4 * vEsc}, obj)
+			} else {
+				oI = NewOrbitFromOE(oe[0], oe[1], oe[2], oe[3], oe[4], oe[5], obj)
+			}
+			p, f, g, h, k, L := oI.ModifiedEquinoctial()
+			oV := NewOrbitFromMEE(p, f, g, h, k, L, obj)
+			if !rvEqual(oI, oV) {
+				t.Fatalf("MEE round trip failed for %s %+v:\noI: %+v %+v\noV: %+v %+v", obj, oe, oI.R(), oI.V(), oV.R(), oV.V())
+			}
+			if oI.Energyξ() < 0 {
+				a, h, k, p, q, λ := oI.Equinoctial()
+				oV = NewOrbitFromEquinoctial(a, h, k, p, q, λ, obj)
+				if !rvEqual(oI, oV) {
+					t.Fatalf("equinoctial round trip failed for %s %+v:\noI: %+v %+v\noV: %+v %+v", obj, oe, oI.R(), oI.V(), oV.R(), oV.V())
+				}
+			}
+		}
+	}
+	// Check the definitions on an inclined elliptical orbit.
+	o := NewOrbitFromOE(8000, 0.1, 30, 40, 50, 60, Earth)
+	p, f, g, h, k, L := o.ModifiedEquinoctial()
+	exp := []float64{8000 * (1 - 0.01), 0.1 * math.Cos(90*deg2rad), 0.1 * math.Sin(90*deg2rad), math.Tan(15*deg2rad) * math.Cos(40*deg2rad), math.Tan(15*deg2rad) * math.Sin(40*deg2rad), 150 * deg2rad}
+	if !floats.EqualApprox(exp, []float64{p, f, g, h, k, L}, 1e-12) {
+		t.Fatalf("invalid MEE: %+v instead of %+v", []float64{p, f, g, h, k, L}, exp)
+	}
+}
+
+// rvEqual returns whether both orbits have the same position and velocity vectors (to the mm and mm/s).
+func rvEqual(o1, o2 *Orbit) bool {
+	for i := 0; i < 3; i++ {
+		if !floats.EqualWithinAbsOrRel(o1.R()[i], o2.R()[i], 1e-6, 1e-12) || !floats.EqualWithinAbsOrRel(o1.V()[i], o2.V()[i], 1e-6, 1e-12) {
+			return false
+		}
+	}
+	return true
+}
@@ -51,7 +51,9 @@ func (e *OrbitEstimate) GetState() []float64 {
 func (e *OrbitEstimate) SetState(t float64, s []float64) {
 	R := []float64{s[0], s[1], s[2]}
 	V := []float64{s[3], s[4], s[5]}
+	frame := e.Orbit.Frame
 	e.Orbit = *NewOrbitFromRV(R, V, e.Orbit.Origin)
+	e.Orbit.Frame = frame
 	// Extract the components of Φ
 	sIdx := 6
 	rΦ, cΦ := e.Φ.Dims()
@@ -162,72 +164,12 @@ func (e *OrbitEstimate) Func(t float64, f []float64) (fDot []float64) {
 
 	// Jn perturbations:
 	if e.Perts.Jn > 1 {
-		// Ai0 = \frac{\partial a}{\partial x}
-		// Ai1 = \frac{\partial a}{\partial y}
-		// Ai2 = \frac{\partial a}{\partial z}
-		A30 := A.At(3, 0)
-		A40 := A.At(4, 0)
-		A50 := A.At(5, 0)
-		A31 := A.At(3, 1)
-		A41 := A.At(4, 1)
-		A51 := A.At(5, 1)
-		A32 := A.At(3, 2)
-		A42 := A.At(4, 2)
-		A52 := A.At(5, 2)
-
-		// Notation simplification
-		z3 := math.Pow(R[2], 3)
-		z4 := math.Pow(R[2], 4)
-		// Adding those fractions to avoid forgetting the trailing period which makes them floats.
-		f32 := 3 / 2.
-		f152 := 15 / 2.
-		r272 := math.Pow(r2, 7/2.)
-		r292 := math.Pow(r2, 9/2.)
-		// J2
-		j2fact := e.Orbit.Origin.J(2) * math.Pow(e.Orbit.Origin.Radius, 2) * e.Orbit.Origin.μ
-		A30 += -f32 * j2fact * (35*x2*z2/r292 - 5*x2/r272 - 5*z2/r272 + 1/r252) //dAxDx
-		A40 += -f152 * j2fact * (7*x*y*z2/r292 - x*y/r272)                      //dAyDx
-		A50 += -f152 * j2fact * (7*x*z3/r292 - 3*x*z/r272)                      //dAzDx
-
-		A31 += -f152 * j2fact * (7*x*y*z2/r292 - x*y/r272)                      //dAxDy
-		A41 += -f32 * j2fact * (35*y2*z2/r292 - 5*y2/r272 - 5*z2/r272 + 1/r252) // dAyDy
-		A51 += -f152 * j2fact * (7*y*z3/r292 - 3*y*z/r272)                      // dAzDy
-
-		A32 += -f152 * j2fact * (7*x*z3/r292 - 3*x*z/r272)        //dAxDz
-		A42 += -f152 * j2fact * (7*y*z3/r292 - 3*y*z/r272)        //dAyDz
-		A52 += -f32 * j2fact * (35*z4/r292 - 30*z2/r272 + 3/r252) // dAzDz
-
-		// J3
-		if e.Perts.Jn > 2 {
-			z5 := math.Pow(R[2], 5)
-			r2112 := math.Pow(r2, 11/2.)
-			f52 := 5 / 2.
-			f1052 := 105 / 2.
-			j3fact := e.Orbit.Origin.J(3) * math.Pow(e.Orbit.Origin.Radius, 3) * e.Orbit.Origin.μ
-			A30 += -f52 * j3fact * (63*x2*z3/r2112 - 21*x2*z/r292 - 7*z3/r292 + 3*z/r272) //dAxDx
-			A40 += -f1052 * j3fact * (3*x*y*z3/r2112 - x*y*z/r292)                        //dAyDx
-			A50 += -f152 * j3fact * (21*x*z4/r2112 - 14*x*z2/r292 + x/r272)               //dAzDx
-
-			A31 += -f1052 * j3fact * (3*x*y*z3/r2112 - x*y*z/r292)                        //dAxDy
-			A41 += -f52 * j3fact * (63*y2*z3/r2112 - 21*y2*z/r292 - 7*z3/r292 + 3*z/r272) // dAyDy
-			A51 += -f152 * j3fact * (21*y*z4/r2112 - 14*y*z2/r292 + y/r272)               // dAzDy
-
-			A32 += -f152 * j3fact * (21*x*z4/r2112 - 14*x*z2/r292 + x/r272) //dAxDz
-			A42 += -f152 * j3fact * (21*y*z4/r2112 - 14*y*z2/r292 + y/r272) //dAyDz
-			A52 += -f52 * j3fact * (63*z5/r2112 - 70*z3/r292 + 15*z/r272)   // dAzDz
+		AJn := zonalPartials(R, e.Orbit.Origin, e.Perts.Jn, e.dt)
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				A.Set(i+3, j, A.At(i+3, j)+AJn.At(i, j))
+			}
 		}
-		// \frac{\partial a}{\partial x}
-		A.Set(3, 0, A30)
-		A.Set(4, 0, A40)
-		A.Set(5, 0, A50)
-		// \partial a/\partial y
-		A.Set(3, 1, A31)
-		A.Set(4, 1, A41)
-		A.Set(5, 1, A51)
-		// \partial a/\partial z
-		A.Set(3, 2, A32)
-		A.Set(4, 2, A42)
-		A.Set(5, 2, A52)
 	}
 	ΦDot.Mul(A, Φ)
 
@@ -63,7 +63,7 @@ func loadMeasurementFile(version string, initDT time.Time) (map[time.Time]smd.Me
 			}
 			ranges[i] = tRg
 			rates[i] = tRgR
-			measurements[stateDT] = smd.Measurement{Visible: true, Range: tRg, RangeRate: tRgR, TrueRange: tRg, TrueRangeRate: tRgR, Timeθgst: epoch * smd.EarthRotationRate2, State: smd.State{DT: stateDT}, Station: station}
+			measurements[stateDT] = smd.Measurement{Visible: true, Range: tRg, RangeRate: tRgR, TrueRange: tRg, TrueRangeRate: tRgR, Epoch: stateDT, State: smd.State{DT: stateDT}, Station: station}
 		}
 		if cnt == 1 {
 			startDT = stateDT
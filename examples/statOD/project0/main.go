@@ -227,7 +227,7 @@ func main() {
 		}
 
 		// Compute "real" measurement
-		computedObservation := measurement.Station.PerformMeasurement(measurement.Timeθgst, state)
+		computedObservation := measurement.Station.PerformMeasurement(measurement.Epoch, state)
 		if !computedObservation.Visible {
 			fmt.Printf("[WARN] station %s should see the SC but does not\n", measurement.Station.Name)
 			visibilityErrors++
@@ -208,12 +208,33 @@ time,a,e,i,Omega,omega,nu,fuel,timeInHours,timeInDays,`, time.Now(), stateDT.UTC
 	return f
 }
 
+// writeOEMFile writes the OEM of the simulation.
+func writeOEMFile(oem *OEM, conf ExportConfig) {
+	filename := fmt.Sprintf("%s/%s.oem", smdConfig().outputDir, conf.Filename)
+	if conf.Timestamp {
+		t := time.Now()
+		filename = fmt.Sprintf("%s/%s-%d-%02d-%02dT%02d.%02d.%02d.oem", smdConfig().outputDir, conf.Filename, t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if err := oem.WriteKVN(f); err != nil {
+		panic(err)
+	}
+}
+
 // StreamStates streams the output of the channel to the provided file.
 func StreamStates(conf ExportConfig, stateChan <-chan (State)) {
 	// Read from channel
 	var prevStatePtr, firstStatePtr *State
 	var fileNo uint8
 	var f, fAsCSV *os.File
+	var oem *OEM
+	if conf.OEM {
+		oem = NewOEM()
+	}
 	fileNo = 0
 	cgItems := []*CgItems{}
 	var curCgItem *CgItems
@@ -251,10 +272,13 @@ func StreamStates(conf ExportConfig, stateChan <-chan (State)) {
 					label := CgLabel{Color: color, FadeSize: 1000000, ShowText: true}
 					plot := CgTrajectoryPlot{Color: color, LineWidth: 1, Duration: "", Lead: "0 d", Fade: 0, SampleCount: 10}
 					curCgItem = &CgItems{Class: "spacecraft", Name: fmt.Sprintf("%s-%d", state.SC.Name, fileNo), StartTime: fmt.Sprintf("%s", state.DT.UTC()), EndTime: "", Center: state.Orbit.Origin.Name, Trajectory: &traj, Bodyframe: nil, Geometry: nil, Label: &label, TrajectoryPlot: &plot}
-					if state.Orbit.Origin.Equals(Sun) {
-						curCgItem.TrajectoryFrame = "EclipticJ2000"
-					} else {
+					switch state.Orbit.Frame {
+					case ICRF:
 						curCgItem.TrajectoryFrame = "ICRF"
+					case EclipJ2000:
+						curCgItem.TrajectoryFrame = "EclipticJ2000"
+					default:
+						panic(fmt.Errorf("cannot export %s states to Cosmographia", state.Orbit.Frame))
 					}
 				}
 				if conf.AsCSV {
@@ -319,6 +343,12 @@ func StreamStates(conf ExportConfig, stateChan <-chan (State)) {
 							panic(err)
 						}
 					}
+					if conf.OEM {
+						// The change of origin starts a new segment.
+						if err := oem.AddState(state.SC.Name, state.DT, state.Orbit, nil); err != nil {
+							panic(err)
+						}
+					}
 					continue
 				}
 			}
@@ -345,6 +375,11 @@ func StreamStates(conf ExportConfig, stateChan <-chan (State)) {
 					panic(err)
 				}
 			}
+			if conf.OEM {
+				if err := oem.AddState(state.SC.Name, state.DT, state.Orbit, nil); err != nil {
+					panic(err)
+				}
+			}
 		} else {
 			// The channel is closed, hence the simulation is over.
 			if conf.Cosmo {
@@ -355,6 +390,9 @@ func StreamStates(conf ExportConfig, stateChan <-chan (State)) {
 				fAsCSV.WriteString(fmt.Sprintf("\n# Simulation time end (UTC): %s\n", prevStatePtr.DT.UTC()))
 				fAsCSV.Close()
 			}
+			if conf.OEM {
+				writeOEMFile(oem, conf)
+			}
 			longerEnd := prevStatePtr.DT.Add(time.Duration(24) * time.Hour)
 			if conf.Cosmo {
 				curCgItem.EndTime = fmt.Sprintf("%s", longerEnd.UTC())
@@ -371,14 +409,26 @@ type ExportConfig struct {
 	Filename     string
 	Cosmo        bool
 	AsCSV        bool
+	OEM          bool // Export as a CCSDS Orbit Ephemeris Message
 	Timestamp    bool
 	CSVAppend    func(st State) string // Custom export (do not include leading comma)
 	CSVAppendHdr func() string         // Header for the custom export
 }
 
+// GroundTrackCSVAppend exports the sub-satellite point of the state, for use as the CSVAppend of an ExportConfig.
+func GroundTrackCSVAppend(st State) string {
+	latΦ, longθ, altitude := st.SubSatellitePoint()
+	return fmt.Sprintf("%.6f,%.6f,%.3f", latΦ, longθ, altitude)
+}
+
+// GroundTrackCSVAppendHdr is the header of GroundTrackCSVAppend.
+func GroundTrackCSVAppendHdr() string {
+	return "latitude,longitude,altitude"
+}
+
 // IsUseless returns whether this config doesn't actually do anything.
 func (c ExportConfig) IsUseless() bool {
-	return !c.Cosmo && !c.AsCSV
+	return !c.Cosmo && !c.AsCSV && !c.OEM
 }
 
 // ThurstAngleExport configures the exporting of the simulation. Exports in CSV
@@ -0,0 +1,197 @@
+package smd
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// enckeRectification is the ratio of the deviation to the reference position which triggers a rectification.
+	enckeRectification = 1e-2
+	keplerε            = 1e-12
+)
+
+// StateFormulation defines how the state of the orbit is integrated by the Mission.
+type StateFormulation uint8
+
+const (
+	// Cowell integrates the Cartesian position and velocity with all the accelerations.
+	Cowell StateFormulation = iota
+	// MEEVOP integrates the modified equinoctial elements with the Gaussian variation of parameters.
+	MEEVOP
+	// Encke integrates the deviation from a two body reference orbit, which is rectified as needed.
+	Encke
+)
+
+func (f StateFormulation) String() string {
+	switch f {
+	case Cowell:
+		return "Cowell"
+	case MEEVOP:
+		return "MEE VOP"
+	case Encke:
+		return "Encke"
+	default:
+		panic("unknown state formulation")
+	}
+}
+
+// StateFormulationFromString returns the state formulation from its name.
+func StateFormulationFromString(name string) (StateFormulation, error) {
+	switch strings.ToLower(strings.Replace(name, " ", "", -1)) {
+	case "cowell", "":
+		return Cowell, nil
+	case "mee", "meevop", "vop":
+		return MEEVOP, nil
+	case "encke":
+		return Encke, nil
+	default:
+		return Cowell, fmt.Errorf("unknown state formulation `%s`", name)
+	}
+}
+
+// meeVOP returns the time derivatives of the modified equinoctial elements given the perturbing acceleration
+// in the radial, transverse and normal directions.
+// Equations from Walker et al., "A set of modified equinoctial orbit elements", 1985.
+func meeVOP(p, f, g, h, k, L, μ, accR, accT, accN float64) []float64 {
+	sinL, cosL := math.Sincos(L)
+	w := 1 + f*cosL + g*sinL
+	s2 := 1 + h*h + k*k
+	sqrtpμ := math.Sqrt(p / μ)
+	hk := h*sinL - k*cosL
+	return []float64{
+		2 * p / w * sqrtpμ * accT,
+		sqrtpμ * (accR*sinL + ((w+1)*cosL+f)*accT/w - hk*g*accN/w),
+		sqrtpμ * (-accR*cosL + ((w+1)*sinL+g)*accT/w + hk*f*accN/w),
+		sqrtpμ * s2 * accN * cosL / (2 * w),
+		sqrtpμ * s2 * accN * sinL / (2 * w),
+		math.Sqrt(μ*p)*math.Pow(w/p, 2) + sqrtpμ*hk*accN/w,
+	}
+}
+
+// RTN2ECI returns the provided vector from the radial, transverse, normal frame of the R, V state
+// into the inertial frame.
+func RTN2ECI(R, V, vRTN []float64) []float64 {
+	rHat, tHat, nHat := rtnFrame(R, V)
+	vECI := make([]float64, 3)
+	for i := 0; i < 3; i++ {
+		vECI[i] = vRTN[0]*rHat[i] + vRTN[1]*tHat[i] + vRTN[2]*nHat[i]
+	}
+	return vECI
+}
+
+// ECI2RTN returns the provided inertial vector in the radial, transverse, normal frame of the R, V state.
+func ECI2RTN(R, V, vECI []float64) []float64 {
+	rHat, tHat, nHat := rtnFrame(R, V)
+	return []float64{Dot(vECI, rHat), Dot(vECI, tHat), Dot(vECI, nHat)}
+}
+
+func rtnFrame(R, V []float64) (rHat, tHat, nHat []float64) {
+	rHat = Unit(R)
+	nHat = Unit(Cross(R, V))
+	tHat = Cross(nHat, rHat)
+	return
+}
+
+// enckeReference stores the two body reference orbit of the Encke formulation.
+type enckeReference struct {
+	R, V   []float64 // reference position and velocity at DT
+	DT     time.Time
+	Origin CelestialObject
+	t0     float64 // integrator time at the start of the current step
+	inStep bool    // set once the integrator has started the current step
+}
+
+// enckeAcceleration returns the two body acceleration of the deviation δ from the reference position ρ.
+// It uses Battin's f(q) to avoid the loss of precision of the difference between two close accelerations.
+func enckeAcceleration(ρ, δ []float64, μ float64) []float64 {
+	r := make([]float64, 3)
+	for i := 0; i < 3; i++ {
+		r[i] = ρ[i] + δ[i]
+	}
+	tmp := make([]float64, 3)
+	for i := 0; i < 3; i++ {
+		tmp[i] = δ[i] - 2*r[i]
+	}
+	q := Dot(δ, tmp) / Dot(r, r)
+	fq := q * (3 + 3*q + q*q) / (1 + math.Pow(1+q, 1.5))
+	ρ3 := math.Pow(Norm(ρ), 3)
+	acc := make([]float64, 3)
+	for i := 0; i < 3; i++ {
+		acc[i] = -μ / ρ3 * (δ[i] + fq*r[i])
+	}
+	return acc
+}
+
+// KeplerPropagate returns the position and velocity after Δt seconds of two body motion.
+// This works for all conic sections and for negative Δt.
+// Algorithm from Vallado, 4th edition, page 93 (algorithm 8, universal variables).
+func KeplerPropagate(R0, V0 []float64, μ, Δt float64) (R, V []float64) {
+	R = make([]float64, 3)
+	V = make([]float64, 3)
+	if Δt == 0 {
+		copy(R, R0)
+		copy(V, V0)
+		return
+	}
+	sqrtμ := math.Sqrt(μ)
+	r0 := Norm(R0)
+	v0 := Norm(V0)
+	rv0 := Dot(R0, V0) / sqrtμ
+	α := -v0*v0/μ + 2/r0
+	var χ float64
+	if α > 1e-9 {
+		// Ellipse
+		χ = sqrtμ * Δt * α
+	} else if α < -1e-9 {
+		// Hyperbola
+		a := 1 / α
+		sgn := math.Copysign(1, Δt)
+		χ = sgn * math.Sqrt(-a) * math.Log(-2*μ*α*Δt/(Dot(R0, V0)+sgn*math.Sqrt(-μ*a)*(1-r0*α)))
+	} else {
+		// Parabola: use the near circular guess which the iterations will correct.
+		χ = sqrtμ * Δt / r0
+	}
+	var ψ, c2, c3, r float64
+	for iter := 0; iter < 100; iter++ {
+		ψ = χ * χ * α
+		c2, c3 = stumpff(ψ)
+		r = χ*χ*c2 + rv0*χ*(1-ψ*c3) + r0*(1-ψ*c2)
+		δχ := (sqrtμ*Δt - χ*χ*χ*c3 - rv0*χ*χ*c2 - r0*χ*(1-ψ*c3)) / r
+		χ += δχ
+		if math.Abs(δχ) < keplerε {
+			break
+		}
+	}
+	ψ = χ * χ * α
+	c2, c3 = stumpff(ψ)
+	r = χ*χ*c2 + rv0*χ*(1-ψ*c3) + r0*(1-ψ*c2)
+	f := 1 - χ*χ/r0*c2
+	g := Δt - χ*χ*χ/sqrtμ*c3
+	gDot := 1 - χ*χ/r*c2
+	fDot := sqrtμ / (r * r0) * χ * (ψ*c3 - 1)
+	for i := 0; i < 3; i++ {
+		R[i] = f*R0[i] + g*V0[i]
+		V[i] = fDot*R0[i] + gDot*V0[i]
+	}
+	return
+}
+
+// stumpff returns the c2 and c3 Stumpff functions of ψ.
+func stumpff(ψ float64) (c2, c3 float64) {
+	if ψ > 1e-6 {
+		sqrtψ := math.Sqrt(ψ)
+		c2 = (1 - math.Cos(sqrtψ)) / ψ
+		c3 = (sqrtψ - math.Sin(sqrtψ)) / (ψ * sqrtψ)
+	} else if ψ < -1e-6 {
+		sqrtψ := math.Sqrt(-ψ)
+		c2 = (1 - math.Cosh(sqrtψ)) / ψ
+		c3 = (math.Sinh(sqrtψ) - sqrtψ) / (-ψ * sqrtψ)
+	} else {
+		c2 = 1/2. - ψ/24
+		c3 = 1/6. - ψ/120
+	}
+	return
+}
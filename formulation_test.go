@@ -0,0 +1,79 @@
+package smd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+)
+
+func TestKeplerPropagate(t *testing.T) {
+	for _, o := range []*Orbit{NewOrbitFromOE(7000, 0.01, 30, 20, 10, 5, Earth), NewOrbitFromRV([]float64{7000, 1000, 0}, []float64{1, 12, 3}, Earth)} {
+		R0, V0 := o.RV()
+		Δt := 3600.0
+		if o.Energyξ() < 0 {
+			// A full period must lead to the same state.
+			Δt = o.Period().Seconds()
+		}
+		R, V := KeplerPropagate(R0, V0, Earth.μ, Δt)
+		Rb, Vb := KeplerPropagate(R, V, Earth.μ, -Δt)
+		if !floats.EqualApprox(R0, Rb, 1e-6) || !floats.EqualApprox(V0, Vb, 1e-9) {
+			t.Fatalf("forward then backward propagation changed the state:\n%+v %+v\n%+v %+v", R0, V0, Rb, Vb)
+		}
+		if o.Energyξ() < 0 && (!floats.EqualApprox(R0, R, 1e-6) || !floats.EqualApprox(V0, V, 1e-9)) {
+			t.Fatalf("propagation over one period changed the state:\n%+v %+v\n%+v %+v", R0, V0, R, V)
+		}
+		// The energy must be conserved.
+		if oF := NewOrbitFromRV(R, V, Earth); !floats.EqualWithinAbs(oF.Energyξ(), o.Energyξ(), 1e-10) {
+			t.Fatalf("energy not conserved: %f != %f", oF.Energyξ(), o.Energyξ())
+		}
+	}
+}
+
+func TestMissionFormulations(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(6 * time.Hour)
+	for _, oe := range [][]float64{{7000, 0.01, 50, 20, 30, 0}, {8000, 0, 0, 0, 0, 0}} {
+		cowell := NewOrbitFromOE(oe[0], oe[1], oe[2], oe[3], oe[4], oe[5], Earth)
+		NewPreciseMission(NewEmptySC("cowell", 0), cowell, start, end, Perturbations{Jn: 2}, 30*time.Second, false, ExportConfig{}).Propagate()
+		for _, form := range []StateFormulation{MEEVOP, Encke} {
+			o := NewOrbitFromOE(oe[0], oe[1], oe[2], oe[3], oe[4], oe[5], Earth)
+			m := NewPreciseMission(NewEmptySC(form.String(), 0), o, start, end, Perturbations{Jn: 2}, 30*time.Second, false, ExportConfig{})
+			m.Formulation = form
+			m.Propagate()
+			if !floats.EqualApprox(cowell.R(), o.R(), 1e-2) || !floats.EqualApprox(cowell.V(), o.V(), 1e-5) {
+				t.Fatalf("%s differs from Cowell for %+v:\n%+v %+v\n%+v %+v", form, oe, cowell.R(), cowell.V(), o.R(), o.V())
+			}
+		}
+	}
+}
+
+func TestEnckeRectification(t *testing.T) {
+	// The burn moves the orbit away from the reference, which must be rectified.
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(6 * time.Hour)
+	var orbits []*Orbit
+	for _, form := range []StateFormulation{Cowell, Encke} {
+		sc := NewSpacecraft(form.String(), 500, 100, NewUnlimitedEPS(), []EPThruster{}, true, []*Cargo{}, []Waypoint{})
+		sc.Maneuvers[start.Add(time.Hour)] = NewManeuver(0.1, 0.5, 0.1)
+		o := NewOrbitFromOE(7000, 0.01, 50, 20, 30, 0, Earth)
+		m := NewPreciseMission(sc, o, start, end, Perturbations{Jn: 2}, 30*time.Second, false, ExportConfig{})
+		m.Formulation = form
+		m.Propagate()
+		orbits = append(orbits, o)
+	}
+	if !floats.EqualApprox(orbits[0].R(), orbits[1].R(), 1e-2) || !floats.EqualApprox(orbits[0].V(), orbits[1].V(), 1e-5) {
+		t.Fatalf("Encke differs from Cowell after a burn:\n%+v %+v\n%+v %+v", orbits[0].R(), orbits[0].V(), orbits[1].R(), orbits[1].V())
+	}
+}
+
+func TestStateFormulationFromString(t *testing.T) {
+	for name, exp := range map[string]StateFormulation{"cowell": Cowell, "MEE VOP": MEEVOP, "Encke": Encke, "": Cowell} {
+		if form, err := StateFormulationFromString(name); err != nil || form != exp {
+			t.Fatalf("`%s` returned %s (%v)", name, form, err)
+		}
+	}
+	if _, err := StateFormulationFromString("Gauss"); err == nil {
+		t.Fatal("unknown formulation did not return an error")
+	}
+}
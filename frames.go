@@ -0,0 +1,281 @@
+package smd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+/* Reference frames and the transformation graph between them. */
+
+// Frame is the reference frame in which the state of an orbit is expressed.
+type Frame uint8
+
+const (
+	// ICRF is the equatorial inertial frame (EME2000 and GCRF are considered identical), used for planetocentric orbits.
+	ICRF Frame = iota
+	// EclipJ2000 is the ecliptic and mean equinox of J2000 inertial frame, used for heliocentric orbits.
+	EclipJ2000
+	// BodyFixed is the IAU body fixed frame of the origin (ITRF for the Earth).
+	BodyFixed
+	// RIC is the radial, in-track and cross-track local orbital frame.
+	RIC
+	// VNC is the velocity, normal and co-normal local orbital frame.
+	VNC
+	// LVLH is the local vertical local horizontal frame (Z to nadir and Y opposite of the angular momentum).
+	LVLH
+)
+
+// ε2000 is the obliquity of the ecliptic at J2000 (IAU-1976) in radians.
+const ε2000 = 84381.448 * arcsec2rad
+
+func (f Frame) String() string {
+	switch f {
+	case ICRF:
+		return "ICRF"
+	case EclipJ2000:
+		return "EclipJ2000"
+	case BodyFixed:
+		return "BodyFixed"
+	case RIC:
+		return "RIC"
+	case VNC:
+		return "VNC"
+	case LVLH:
+		return "LVLH"
+	default:
+		panic(fmt.Errorf("unknown frame %d", f))
+	}
+}
+
+// IsLocal returns whether this frame is a local orbital frame, i.e. defined by a reference orbit.
+func (f Frame) IsLocal() bool {
+	return f == RIC || f == VNC || f == LVLH
+}
+
+// FrameFromString returns the frame from its name, case insensitive (EME2000 and GCRF are aliases of ICRF).
+func FrameFromString(name string) (Frame, error) {
+	switch strings.ToUpper(name) {
+	case "ICRF", "EME2000", "GCRF", "J2000":
+		return ICRF, nil
+	case "ECLIPJ2000", "ECLIPTICJ2000":
+		return EclipJ2000, nil
+	case "BODYFIXED":
+		return BodyFixed, nil
+	case "RIC", "RSW", "RTN":
+		return RIC, nil
+	case "VNC":
+		return VNC, nil
+	case "LVLH":
+		return LVLH, nil
+	default:
+		return ICRF, fmt.Errorf("unknown frame `%s`", name)
+	}
+}
+
+// defaultFrame returns the frame used by default for orbits about the provided celestial object.
+func defaultFrame(c CelestialObject) Frame {
+	if c.Equals(Sun) {
+		return EclipJ2000
+	}
+	return ICRF
+}
+
+// frameConversion converts a state between two adjacent frames of the graph. The reference orbit is the ICRF
+// state which defines the local orbital frames, and its origin is the origin of the state.
+type frameConversion func(R, V []float64, dt time.Time, ref Orbit) ([]float64, []float64)
+
+// frameGraph stores the conversions between adjacent frames. All other conversions are found by traversing the graph.
+var frameGraph = map[Frame]map[Frame]frameConversion{
+	ICRF: {
+		EclipJ2000: func(R, V []float64, dt time.Time, ref Orbit) ([]float64, []float64) {
+			return MxV33(R1(ε2000), R), MxV33(R1(ε2000), V)
+		},
+		BodyFixed: func(R, V []float64, dt time.Time, ref Orbit) ([]float64, []float64) {
+			return inertial2BodyFixed(R, V, dt, ref.Origin)
+		},
+		RIC: func(R, V []float64, dt time.Time, ref Orbit) ([]float64, []float64) {
+			return inertial2Local(R, V, RIC, ref)
+		},
+		VNC: func(R, V []float64, dt time.Time, ref Orbit) ([]float64, []float64) {
+			return inertial2Local(R, V, VNC, ref)
+		},
+		LVLH: func(R, V []float64, dt time.Time, ref Orbit) ([]float64, []float64) {
+			return inertial2Local(R, V, LVLH, ref)
+		},
+	},
+	EclipJ2000: {
+		ICRF: func(R, V []float64, dt time.Time, ref Orbit) ([]float64, []float64) {
+			return MxV33(R1(-ε2000), R), MxV33(R1(-ε2000), V)
+		},
+	},
+	BodyFixed: {
+		ICRF: func(R, V []float64, dt time.Time, ref Orbit) ([]float64, []float64) {
+			return bodyFixed2Inertial(R, V, dt, ref.Origin)
+		},
+	},
+	RIC: {
+		ICRF: func(R, V []float64, dt time.Time, ref Orbit) ([]float64, []float64) {
+			return local2Inertial(R, V, RIC, ref)
+		},
+	},
+	VNC: {
+		ICRF: func(R, V []float64, dt time.Time, ref Orbit) ([]float64, []float64) {
+			return local2Inertial(R, V, VNC, ref)
+		},
+	},
+	LVLH: {
+		ICRF: func(R, V []float64, dt time.Time, ref Orbit) ([]float64, []float64) {
+			return local2Inertial(R, V, LVLH, ref)
+		},
+	},
+}
+
+// framePath returns the shortest list of frames from one frame to the other (both included).
+func framePath(from, to Frame) ([]Frame, error) {
+	previous := map[Frame]Frame{from: from}
+	queue := []Frame{from}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if cur == to {
+			path := []Frame{to}
+			for f := to; f != from; f = previous[f] {
+				path = append([]Frame{previous[f]}, path...)
+			}
+			return path, nil
+		}
+		for next := range frameGraph[cur] {
+			if _, visited := previous[next]; !visited {
+				previous[next] = cur
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil, fmt.Errorf("no transformation from %s to %s", from, to)
+}
+
+// FrameTransform returns the state converted from one frame to another at the provided epoch.
+// The reference orbit provides the origin of the state, and defines the local orbital frames (RIC, VNC and LVLH):
+// a state in a local frame is relative to the reference orbit, and its velocity is relative to the rotating frame.
+// An error is returned if the reference orbit is itself in a local frame.
+func FrameTransform(R, V []float64, from, to Frame, dt time.Time, ref Orbit) ([]float64, []float64, error) {
+	if ref.Frame.IsLocal() {
+		return nil, nil, fmt.Errorf("reference orbit in local frame %s", ref.Frame)
+	}
+	path, err := framePath(from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	if from.IsLocal() || to.IsLocal() {
+		// The local frames are defined from the ICRF state of the reference.
+		ref.ToFrame(ICRF, dt)
+	}
+	R = []float64{R[0], R[1], R[2]}
+	V = []float64{V[0], V[1], V[2]}
+	for i := 1; i < len(path); i++ {
+		R, V = frameGraph[path[i-1]][path[i]](R, V, dt, ref)
+	}
+	return R, V, nil
+}
+
+// ToFrame converts this orbit to the provided frame at the provided epoch.
+// Panics if either frame is a local orbital frame (cf. FrameTransform).
+func (o *Orbit) ToFrame(f Frame, dt time.Time) {
+	if o.Frame == f {
+		return
+	}
+	if o.Frame.IsLocal() || f.IsLocal() {
+		panic(fmt.Errorf("cannot convert an orbit from %s to %s, use FrameTransform", o.Frame, f))
+	}
+	R, V, err := FrameTransform(o.rVec, o.vVec, o.Frame, f, dt, *o)
+	if err != nil {
+		panic(err)
+	}
+	*o = *NewOrbitFromRV(R, V, o.Origin)
+	o.Frame = f
+}
+
+// inertialRotation returns the rotation of the states (6x6) from one inertial frame to another at the provided epoch.
+func inertialRotation(from, to Frame, dt time.Time) *mat64.Dense {
+	rot := mat64.NewDense(6, 6, nil)
+	for j := 0; j < 3; j++ {
+		e := make([]float64, 3)
+		e[j] = 1
+		R, V, err := FrameTransform(e, e, from, to, dt, Orbit{})
+		if err != nil {
+			panic(err)
+		}
+		for i := 0; i < 3; i++ {
+			rot.Set(i, j, R[i])
+			rot.Set(i+3, j+3, V[i])
+		}
+	}
+	return rot
+}
+
+// LocalFrameDCM returns the direction cosine matrix from the inertial frame of the provided orbit to the provided
+// local orbital frame (the rows are the axes of the local frame).
+// Panics if the frame is not a local orbital frame.
+func LocalFrameDCM(o Orbit, f Frame) *mat64.Dense {
+	R, V := o.RV()
+	h := Unit(Cross(R, V))
+	var x, y, z []float64
+	switch f {
+	case RIC:
+		x = Unit(R)
+		z = h
+		y = Cross(z, x)
+	case VNC:
+		x = Unit(V)
+		y = h
+		z = Cross(x, y)
+	case LVLH:
+		z = Unit(R)
+		y = h
+		for i := 0; i < 3; i++ {
+			z[i] *= -1
+			y[i] *= -1
+		}
+		x = Cross(y, z)
+	default:
+		panic(fmt.Errorf("%s is not a local orbital frame", f))
+	}
+	return mat64.NewDense(3, 3, []float64{x[0], x[1], x[2], y[0], y[1], y[2], z[0], z[1], z[2]})
+}
+
+// localFrameRate returns the angular velocity of the local orbital frames of the orbit, i.e. h/r² (in its frame).
+func localFrameRate(o Orbit) []float64 {
+	h := o.H()
+	r2 := Dot(o.rVec, o.rVec)
+	return []float64{h[0] / r2, h[1] / r2, h[2] / r2}
+}
+
+func inertial2Local(R, V []float64, f Frame, ref Orbit) ([]float64, []float64) {
+	ρ := make([]float64, 3)
+	ρDot := make([]float64, 3)
+	for i := 0; i < 3; i++ {
+		ρ[i] = R[i] - ref.rVec[i]
+		ρDot[i] = V[i] - ref.vVec[i]
+	}
+	ωxρ := Cross(localFrameRate(ref), ρ)
+	for i := 0; i < 3; i++ {
+		ρDot[i] -= ωxρ[i]
+	}
+	dcm := LocalFrameDCM(ref, f)
+	return MxV33(dcm, ρ), MxV33(dcm, ρDot)
+}
+
+func local2Inertial(R, V []float64, f Frame, ref Orbit) ([]float64, []float64) {
+	dcmT := LocalFrameDCM(ref, f).T()
+	ρ := MxV33(dcmT, R)
+	ρDot := MxV33(dcmT, V)
+	ωxρ := Cross(localFrameRate(ref), ρ)
+	for i := 0; i < 3; i++ {
+		ρ[i] += ref.rVec[i]
+		ρDot[i] += ref.vVec[i] + ωxρ[i]
+	}
+	return ρ, ρDot
+}
@@ -0,0 +1,150 @@
+package smd
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+)
+
+func TestFrameGraph(t *testing.T) {
+	for _, exp := range []struct {
+		from, to Frame
+		path     []Frame
+	}{
+		{ICRF, ICRF, []Frame{ICRF}},
+		{EclipJ2000, BodyFixed, []Frame{EclipJ2000, ICRF, BodyFixed}},
+		{BodyFixed, RIC, []Frame{BodyFixed, ICRF, RIC}},
+		{VNC, LVLH, []Frame{VNC, ICRF, LVLH}},
+	} {
+		path, err := framePath(exp.from, exp.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(path) != len(exp.path) {
+			t.Fatalf("%s -> %s: %v", exp.from, exp.to, path)
+		}
+		for i := range path {
+			if path[i] != exp.path[i] {
+				t.Fatalf("%s -> %s: %v", exp.from, exp.to, path)
+			}
+		}
+	}
+}
+
+func TestFrameTransform(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	o := NewOrbitFromOE(7000, 0.01, 28.5, 10, 20, 30, Earth)
+	if o.Frame != ICRF || NewOrbitFromOE(1.5*AU, 0.1, 1, 10, 20, 30, Sun).Frame != EclipJ2000 {
+		t.Fatal("invalid default frames")
+	}
+	// Ecliptic: the equatorial Y axis is tilted by the obliquity.
+	R, _, err := FrameTransform([]float64{0, 1, 0}, []float64{0, 0, 0}, ICRF, EclipJ2000, dt, *o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !floats.EqualApprox(R, []float64{0, math.Cos(ε2000), -math.Sin(ε2000)}, 1e-12) {
+		t.Fatalf("invalid ecliptic vector: %v", R)
+	}
+	// Body fixed is the precise Earth frame.
+	rECEF, vECEF := ECI2ECEFState(o.R(), o.V(), dt)
+	oFixed := *o
+	oFixed.ToFrame(BodyFixed, dt)
+	if oFixed.Frame != BodyFixed || !floats.EqualApprox(oFixed.R(), rECEF, 1e-9) || !floats.EqualApprox(oFixed.V(), vECEF, 1e-12) {
+		t.Fatalf("invalid body fixed orbit: %s", oFixed)
+	}
+	if ok, _ := oFixed.Equals(*o); ok {
+		t.Fatal("orbits in different frames are equal")
+	}
+	// And back through the graph.
+	oFixed.ToFrame(EclipJ2000, dt)
+	oFixed.ToFrame(ICRF, dt)
+	if !floats.EqualApprox(oFixed.R(), o.R(), 1e-9) || !floats.EqualApprox(oFixed.V(), o.V(), 1e-12) {
+		t.Fatalf("frame round trip failed:\n%s\n%s", oFixed, o)
+	}
+	assertPanic(t, func() {
+		oFixed.ToFrame(RIC, dt)
+	})
+	assertPanic(t, func() {
+		oFixed.Frame = BodyFixed
+		oFixed.ToXCentric(Sun, dt)
+	})
+}
+
+func TestLocalFrames(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	chief := NewOrbitFromOE(7000, 0, 45, 10, 0, 30, Earth)
+	R, V := chief.RV()
+	n := math.Sqrt(Earth.μ / math.Pow(7000, 3))
+	// A deputy 1 km above the chief with the same inertial velocity drifts backwards in the rotating frame.
+	rUp := Unit(R)
+	rDeputy := []float64{R[0] + rUp[0], R[1] + rUp[1], R[2] + rUp[2]}
+	ρ, ρDot, err := FrameTransform(rDeputy, V, ICRF, RIC, dt, *chief)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !floats.EqualApprox(ρ, []float64{1, 0, 0}, 1e-9) || !floats.EqualApprox(ρDot, []float64{0, -n, 0}, 1e-9) {
+		t.Fatalf("invalid RIC state: %v %v", ρ, ρDot)
+	}
+	// In LVLH, the same deputy is along -Z.
+	ρ, _, _ = FrameTransform(rDeputy, V, ICRF, LVLH, dt, *chief)
+	if !floats.EqualApprox(ρ, []float64{0, 0, -1}, 1e-9) {
+		t.Fatalf("invalid LVLH state: %v", ρ)
+	}
+	// A velocity increment along the velocity is along V in VNC (and along I in RIC for a circular orbit).
+	vHat := Unit(V)
+	vDeputy := []float64{V[0] + 1e-3*vHat[0], V[1] + 1e-3*vHat[1], V[2] + 1e-3*vHat[2]}
+	ρ, ρDot, _ = FrameTransform(R, vDeputy, ICRF, VNC, dt, *chief)
+	if !floats.EqualApprox(ρ, []float64{0, 0, 0}, 1e-9) || !floats.EqualApprox(ρDot, []float64{1e-3, 0, 0}, 1e-12) {
+		t.Fatalf("invalid VNC state: %v %v", ρ, ρDot)
+	}
+	ρ, ρDot, _ = FrameTransform(ρ, ρDot, VNC, RIC, dt, *chief)
+	if !floats.EqualApprox(ρ, []float64{0, 0, 0}, 1e-9) || !floats.EqualApprox(ρDot, []float64{0, 1e-3, 0}, 1e-12) {
+		t.Fatalf("invalid RIC state from VNC: %v %v", ρ, ρDot)
+	}
+	// Round trip from the body fixed frame.
+	rFixed, vFixed, _ := FrameTransform(rDeputy, vDeputy, ICRF, BodyFixed, dt, *chief)
+	ρ, ρDot, _ = FrameTransform(rFixed, vFixed, BodyFixed, LVLH, dt, *chief)
+	rBack, vBack, _ := FrameTransform(ρ, ρDot, LVLH, ICRF, dt, *chief)
+	if !floats.EqualApprox(rBack, rDeputy, 1e-9) || !floats.EqualApprox(vBack, vDeputy, 1e-12) {
+		t.Fatalf("local frame round trip failed: %v %v", rBack, vBack)
+	}
+	chief.Frame = RIC
+	if _, _, err := FrameTransform(R, V, ICRF, RIC, dt, *chief); err == nil {
+		t.Fatal("local reference accepted")
+	}
+}
+
+func TestCCSDSFrames(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	o := NewOrbitFromOE(5000, 0.01, 28.5, 10, 20, 30, Mars)
+	o.ToFrame(BodyFixed, dt)
+	opm, err := NewOPM("lander", *o, dt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opm.Metadata.RefFrame != "IAU_MARS" {
+		t.Fatalf("invalid frame: %s", opm.Metadata.RefFrame)
+	}
+	var buf bytes.Buffer
+	if err := opm.WriteKVN(&buf); err != nil {
+		t.Fatal(err)
+	}
+	opmIn, err := ParseOPM(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oIn, err := opmIn.Orbit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oIn.Frame != BodyFixed || !floats.EqualApprox(oIn.R(), o.R(), 1e-9) {
+		t.Fatalf("invalid orbit: %s (%s)", oIn, oIn.Frame)
+	}
+	opmIn.Metadata.RefFrame = "IAU_EARTH"
+	if _, err := opmIn.Orbit(); err == nil {
+		t.Fatal("Earth body fixed frame accepted for Mars")
+	}
+}
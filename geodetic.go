@@ -0,0 +1,84 @@
+package smd
+
+import (
+	"math"
+)
+
+/* Geodetic coordinates on a reference ellipsoid. Algorithms from Vallado, 4th edition, section 3.2. */
+
+// Ellipsoid is a reference ellipsoid defined by its equatorial radius (in km) and its flattening.
+type Ellipsoid struct {
+	Radius, Flattening float64
+}
+
+// WGS84 is the reference ellipsoid of the Earth.
+var WGS84 = Ellipsoid{6378.137, 1 / 298.257223563}
+
+// Ellipsoid returns the reference ellipsoid of this object (WGS84 for the Earth).
+func (c CelestialObject) Ellipsoid() Ellipsoid {
+	if c.Equals(Earth) {
+		return WGS84
+	}
+	return Ellipsoid{c.Radius, c.Flattening}
+}
+
+// e2 returns the square of the eccentricity of the ellipsoid.
+func (e Ellipsoid) e2() float64 {
+	return e.Flattening * (2 - e.Flattening)
+}
+
+// ToBodyFixed returns the body fixed vector of the provided geodetic coordinates (in km and radians).
+// Note that the first parameter is the altitude above the ellipsoid (cf. GEO2ECEF).
+func (e Ellipsoid) ToBodyFixed(altitude, latitude, longitude float64) []float64 {
+	sLong, cLong := math.Sincos(longitude)
+	sLat, cLat := math.Sincos(latitude)
+	C := e.Radius / math.Sqrt(1-e.e2()*sLat*sLat)
+	S := C * (1 - e.e2())
+	return []float64{(C + altitude) * cLat * cLong, (C + altitude) * cLat * sLong, (S + altitude) * sLat}
+}
+
+// FromBodyFixed returns the geodetic altitude, latitude and longitude (in km and radians) of the provided body fixed
+// vector. This is the inverse of ToBodyFixed (Vallado algorithm 12).
+func (e Ellipsoid) FromBodyFixed(R []float64) (altitude, latitude, longitude float64) {
+	e2 := e.e2()
+	rδ := math.Sqrt(R[0]*R[0] + R[1]*R[1])
+	longitude = math.Atan2(R[1], R[0])
+	latitude = math.Atan2(R[2], rδ)
+	var C float64
+	for i := 0; i < 10; i++ {
+		sLat := math.Sin(latitude)
+		C = e.Radius / math.Sqrt(1-e2*sLat*sLat)
+		prevLat := latitude
+		latitude = math.Atan2(R[2]+C*e2*sLat, rδ)
+		if math.Abs(latitude-prevLat) < 1e-14 {
+			break
+		}
+	}
+	sLat, cLat := math.Sincos(latitude)
+	if math.Abs(cLat) > 1e-3 {
+		altitude = rδ/cLat - C
+	} else {
+		// Close to the poles
+		altitude = R[2]/sLat - C*(1-e2)
+	}
+	return
+}
+
+// GEOD2ECEF converts the provided WGS84 geodetic coordinates (in km and radians) to the ECEF vector.
+// Note that the first parameter is the altitude, not the radius from the center of the body!
+func GEOD2ECEF(altitude, latitude, longitude float64) []float64 {
+	return WGS84.ToBodyFixed(altitude, latitude, longitude)
+}
+
+// ECEF2GEOD converts the provided ECEF vector to WGS84 geodetic altitude, latitude and longitude (in km and radians).
+func ECEF2GEOD(R []float64) (altitude, latitude, longitude float64) {
+	return WGS84.FromBodyFixed(R)
+}
+
+// SubSatellitePoint returns the geodetic latitude, longitude (in degrees) and altitude (in km) of this state above
+// the reference ellipsoid of the origin, e.g. for ground tracks.
+func (s State) SubSatellitePoint() (latΦ, longθ, altitude float64) {
+	rFixed, _ := inertial2BodyFixed(s.Orbit.R(), s.Orbit.V(), s.DT, s.Orbit.Origin)
+	altitude, latΦ, longθ = s.Orbit.Origin.Ellipsoid().FromBodyFixed(rFixed)
+	return latΦ * r2d, longθ * r2d, altitude
+}
@@ -0,0 +1,57 @@
+package smd
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+)
+
+func TestGeodetic(t *testing.T) {
+	// Example 3-3 from Vallado, 4th edition.
+	altitude, latΦ, longθ := ECEF2GEOD([]float64{6524.834, 6862.875, 6448.296})
+	if !floats.EqualWithinAbs(latΦ*r2d, 34.352496, 1e-6) || !floats.EqualWithinAbs(longθ*r2d, 46.4464, 1e-4) || !floats.EqualWithinAbs(altitude, 5085.22, 1e-2) {
+		t.Fatalf("invalid geodetic coordinates: %f km %f deg %f deg", altitude, latΦ*r2d, longθ*r2d)
+	}
+	// Round trips, including at the poles and below the surface.
+	for _, e := range []Ellipsoid{WGS84, Mars.Ellipsoid(), Venus.Ellipsoid()} {
+		for _, geo := range [][]float64{{0, 0, 0}, {1.2, 40, -4}, {500, -89.9999, 120}, {35786, 90, 0}, {-10, -45, 179}} {
+			R := e.ToBodyFixed(geo[0], geo[1]*d2r, geo[2]*d2r)
+			alt, lat, long := e.FromBodyFixed(R)
+			if !floats.EqualWithinAbs(alt, geo[0], 1e-8) || !floats.EqualWithinAbs(lat*r2d, geo[1], 1e-9) {
+				t.Fatalf("%+v round trip failed for %v: %f %f", e, geo, alt, lat*r2d)
+			}
+			if math.Abs(geo[1]) < 90 && !floats.EqualWithinAbs(long*r2d, geo[2], 1e-9) {
+				t.Fatalf("%+v round trip failed for %v: %f", e, geo, long*r2d)
+			}
+		}
+	}
+	// The DSN stations are within 50 m of their published positions (JPL DSN 810-005).
+	for _, exp := range []struct {
+		st Station
+		R  []float64
+	}{
+		{DSS13Goldstone, []float64{-2351.112659, -4655.530636, 3660.912728}},
+		{DSS34Canberra, []float64{-4461.147093, 2682.439239, -3674.393133}},
+		{DSS65Madrid, []float64{4849.339645, -360.427656, 4114.750743}},
+	} {
+		Δ := make([]float64, 3)
+		floats.SubTo(Δ, exp.st.R, exp.R)
+		if Norm(Δ) > 0.05 {
+			t.Fatalf("%s is %f km away from its published position", exp.st.Name, Norm(Δ))
+		}
+	}
+}
+
+func TestSubSatellitePoint(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, body := range []CelestialObject{Earth, Mars} {
+		rFixed := body.Ellipsoid().ToBodyFixed(400, 51.6*d2r, -75*d2r)
+		R, V := bodyFixed2Inertial(rFixed, []float64{0, 0, 0}, dt, body)
+		latΦ, longθ, altitude := State{DT: dt, Orbit: *NewOrbitFromRV(R, V, body)}.SubSatellitePoint()
+		if !floats.EqualWithinAbs(latΦ, 51.6, 1e-9) || !floats.EqualWithinAbs(longθ, -75, 1e-9) || !floats.EqualWithinAbs(altitude, 400, 1e-6) {
+			t.Fatalf("invalid sub-satellite point on %s: %f %f %f", body.Name, latΦ, longθ, altitude)
+		}
+	}
+}
@@ -3,7 +3,9 @@ package smd
 import (
 	"fmt"
 	"math"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gonum/floats"
 )
@@ -37,3 +39,62 @@ func anglesEqual(a, b float64) (bool, error) {
 	}
 	return false, fmt.Errorf("difference of %3.10f degrees", math.Abs(Rad2deg(diff)))
 }
+
+// testStart is the start of the missions of the orbit determination, covariance, Monte Carlo and TCM tests.
+var testStart = time.Date(2015, 2, 3, 0, 0, 0, 0, time.UTC)
+
+// testGEO returns the GEO orbit of the orbit determination tests.
+func testGEO() *Orbit {
+	return NewOrbitFromOE(36469, 0, 0, 0, 0, 90, Earth)
+}
+
+// testMission returns the mission of the spacecraft and orbit from the provided start, until it is propagated.
+func testMission(sc *Spacecraft, o *Orbit, start time.Time, perts Perturbations, computeSTM bool) *Mission {
+	return NewPreciseMission(sc, o, start, start.Add(-1), perts, StepSize, computeSTM, ExportConfig{})
+}
+
+// testGEOEstimate returns the two body mission of the estimate of the GEO orbit from the first measurement.
+func testGEOEstimate(first time.Time) *Mission {
+	return testMission(NewEmptySC("est", 0), testGEO(), first, Perturbations{}, true)
+}
+
+// propagateStates propagates the mission until the provided end while its states are consumed, and returns once
+// both are done. The states left by the consumer are drained so that the mission is not blocked.
+func propagateStates(mission *Mission, end time.Time, consume func(states <-chan State)) {
+	stateChan := make(chan (State), 1)
+	mission.RegisterStateChan(stateChan)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		consume(stateChan)
+		for range stateChan {
+		}
+	}()
+	mission.PropagateUntil(end, true)
+	wg.Wait()
+}
+
+// simulateODMeasurements returns the noiseless measurements of the provided stations along the two body trajectory.
+func simulateODMeasurements(o *Orbit, start, end time.Time, stations []Station) *ODMeasurements {
+	return simulateMissionMeasurements(testMission(NewEmptySC("truth", 0), o, start, Perturbations{}, false), end, stations)
+}
+
+// simulateMissionMeasurements returns the noiseless measurements of the provided stations along the trajectory of
+// the mission.
+func simulateMissionMeasurements(mission *Mission, end time.Time, stations []Station) *ODMeasurements {
+	meas := NewODMeasurements(stations)
+	propagateStates(mission, end, func(states <-chan State) {
+		for state := range states {
+			for _, st := range stations {
+				if m := st.PerformMeasurement(state.DT, state); m.Visible {
+					// Use the noiseless values as the observations.
+					m.Range, m.RangeRate = m.TrueRange, m.TrueRangeRate
+					m.Values = m.TrueValues
+					meas.Add(m)
+				}
+			}
+		}
+	})
+	return meas
+}
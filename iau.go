@@ -0,0 +1,116 @@
+package smd
+
+import (
+	"math"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+/* IAU rotation models of the celestial objects, from the IAU WGCCRE 2009 report (Archinal et al., 2011). */
+
+// IAURotation defines the orientation of the pole and of the prime meridian of a celestial object
+// with respect to the ICRF, as linear functions of the TDB time since J2000.
+// WARNING: The periodic terms (e.g. of Neptune and Jupiter) are ignored.
+type IAURotation struct {
+	RA0, RA1   float64 // Right ascension of the pole: RA0 + RA1*T in degrees (T in Julian centuries)
+	Dec0, Dec1 float64 // Declination of the pole: Dec0 + Dec1*T in degrees (T in Julian centuries)
+	W0, W1     float64 // Prime meridian: W0 + W1*d in degrees (d in days)
+}
+
+// iauDays returns the number of days since J2000 in TDB.
+func iauDays(dt time.Time) float64 {
+	return NewEpoch(dt, UTC).In(TDB).Sub(j2000).Hours() / 24
+}
+
+// Pole returns the right ascension and declination of the pole in radians at the provided time.
+func (r IAURotation) Pole(dt time.Time) (α, δ float64) {
+	T := iauDays(dt) / 36525
+	return (r.RA0 + r.RA1*T) * deg2rad, (r.Dec0 + r.Dec1*T) * deg2rad
+}
+
+// PrimeMeridian returns the angle W of the prime meridian in radians at the provided time.
+func (r IAURotation) PrimeMeridian(dt time.Time) float64 {
+	return math.Mod(r.W0+r.W1*iauDays(dt), 360) * deg2rad
+}
+
+// Rate returns the rotation rate about the pole in radians per second.
+func (r IAURotation) Rate() float64 {
+	return r.W1 * deg2rad / 86400
+}
+
+// equatorDCM returns the DCM from the ICRF to the mean equator of the pole at the provided time.
+func (r IAURotation) equatorDCM(dt time.Time) *mat64.Dense {
+	α, δ := r.Pole(dt)
+	var dcm mat64.Dense
+	dcm.Mul(R1(math.Pi/2-δ), R3(math.Pi/2+α))
+	return &dcm
+}
+
+// rotationRate returns the rotation rate of this object about its pole in radians per second.
+func (c CelestialObject) rotationRate() float64 {
+	if c.Rotation == nil {
+		return c.RotRate
+	}
+	return c.Rotation.Rate()
+}
+
+// surfaceRotationRate returns the rotation rate of the stations on the surface of the object, i.e. the one of the
+// Earth or of the rotation model of the other objects.
+func (c CelestialObject) surfaceRotationRate() float64 {
+	if c.Equals(Earth) {
+		return EarthRotationRate
+	}
+	return c.rotationRate()
+}
+
+// bodyFixedDCM returns the DCM from the ICRF to the body fixed frame at the provided time, and the rotation rate
+// of that frame about its Z axis in radians per second.
+// Objects without a rotation model rotate about the ICRF Z axis at RotRate from their J2000 orientation.
+func (c CelestialObject) bodyFixedDCM(dt time.Time) (*mat64.Dense, float64) {
+	if c.Rotation == nil {
+		return R3(c.RotRate * dt.Sub(j2000).Seconds()), c.RotRate
+	}
+	var dcm mat64.Dense
+	dcm.Mul(R3(c.Rotation.PrimeMeridian(dt)), c.Rotation.equatorDCM(dt))
+	return &dcm, c.rotationRate()
+}
+
+// poleDCM returns the DCM from the ICRF to the frame whose Z axis is the pole of this object, which is how the Jn
+// zonal harmonics are defined. The returned bool is false if both frames are considered identical, which is the case
+// for the Earth (the ICRF Z axis is its mean pole at J2000) and for objects without a rotation model.
+func (c CelestialObject) poleDCM(dt time.Time) (*mat64.Dense, bool) {
+	if c.Rotation == nil || c.Equals(Earth) {
+		return nil, false
+	}
+	return c.Rotation.equatorDCM(dt), true
+}
+
+// inertial2BodyFixed converts the provided inertial state to the body fixed frame of the provided celestial object.
+// The Earth uses the precise ITRF (cf. ECI2ECEFState), and other objects use their IAU rotation model.
+func inertial2BodyFixed(R, V []float64, dt time.Time, body CelestialObject) ([]float64, []float64) {
+	if body.Equals(Earth) {
+		return ECI2ECEFState(R, V, dt)
+	}
+	dcm, ω := body.bodyFixedDCM(dt)
+	rFixed := MxV33(dcm, R)
+	vFixed := MxV33(dcm, V)
+	ωxr := Cross([]float64{0, 0, ω}, rFixed)
+	for i := 0; i < 3; i++ {
+		vFixed[i] -= ωxr[i]
+	}
+	return rFixed, vFixed
+}
+
+// bodyFixed2Inertial is the inverse of inertial2BodyFixed.
+func bodyFixed2Inertial(R, V []float64, dt time.Time, body CelestialObject) ([]float64, []float64) {
+	if body.Equals(Earth) {
+		return ECEF2ECIState(R, V, dt)
+	}
+	dcm, ω := body.bodyFixedDCM(dt)
+	vRot := Cross([]float64{0, 0, ω}, R)
+	for i := 0; i < 3; i++ {
+		vRot[i] += V[i]
+	}
+	return MxV33(dcm.T(), R), MxV33(dcm.T(), vRot)
+}
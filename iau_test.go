@@ -0,0 +1,103 @@
+package smd
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+)
+
+func TestIAURotation(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Sidereal rotation period of Mars: 24h 37m 22.66s.
+	if rate := Mars.Rotation.Rate(); !floats.EqualWithinAbs(rate, 2*math.Pi/88642.66, 1e-10) {
+		t.Fatalf("invalid Mars rotation rate: %e", rate)
+	}
+	// The pole of Mars is the Z axis of its body fixed frame.
+	α, δ := Mars.Rotation.Pole(dt)
+	if !floats.EqualWithinAbs(α*r2d, 317.68143-0.1061*0.17, 1e-3) || !floats.EqualWithinAbs(δ*r2d, 52.88650-0.0609*0.17, 1e-3) {
+		t.Fatalf("invalid Mars pole: %f %f", α*r2d, δ*r2d)
+	}
+	pole := []float64{4000 * math.Cos(δ) * math.Cos(α), 4000 * math.Cos(δ) * math.Sin(α), 4000 * math.Sin(δ)}
+	rFixed, vFixed := inertial2BodyFixed(pole, []float64{0, 0, 0}, dt, Mars)
+	if !floats.EqualApprox(rFixed, []float64{0, 0, 4000}, 1e-9) || Norm(vFixed) > 1e-12 {
+		t.Fatalf("invalid pole in Mars body fixed frame: %v %v", rFixed, vFixed)
+	}
+	// A point on the equator of the body fixed frame moves at ωr in inertial space, and back.
+	R, V := bodyFixed2Inertial([]float64{Mars.Radius, 0, 0}, []float64{0, 0, 0}, dt, Mars)
+	if !floats.EqualWithinAbs(Norm(V), Mars.Rotation.Rate()*Mars.Radius, 1e-12) || !floats.EqualWithinAbs(Dot(Unit(V), Unit(pole)), 0, 1e-12) {
+		t.Fatalf("invalid inertial velocity: %v", V)
+	}
+	rFixed, vFixed = inertial2BodyFixed(R, V, dt, Mars)
+	if !floats.EqualApprox(rFixed, []float64{Mars.Radius, 0, 0}, 1e-9) || Norm(vFixed) > 1e-12 {
+		t.Fatalf("body fixed round trip failed: %v %v", rFixed, vFixed)
+	}
+	// After one sidereal day, the body fixed point is at the same inertial position (ignoring the slow pole motion).
+	R2, _ := bodyFixed2Inertial([]float64{Mars.Radius, 0, 0}, []float64{0, 0, 0}, dt.Add(time.Duration(2*math.Pi/Mars.Rotation.Rate()*1e9)), Mars)
+	if !floats.EqualApprox(R, R2, 1e-3) {
+		t.Fatalf("invalid sidereal rotation: %v != %v", R, R2)
+	}
+}
+
+func TestMarsStation(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	st := NewPlanetStation(Mars, "lander", 0, 10, 4.5, 137.4, σρ, σρDot)
+	if !floats.EqualApprox(st.R, Mars.Ellipsoid().ToBodyFixed(0, 4.5*d2r, 137.4*d2r), 1e-12) || !st.Planet.Equals(Mars) {
+		t.Fatalf("invalid station: %s", st)
+	}
+	// Place the spacecraft 500 km above the station (but not at zenith), moving away from the station in the body fixed frame.
+	rFixed := []float64{0, 0, 0}
+	sLong, cLong := math.Sincos(st.Longθ)
+	sLat, cLat := math.Sincos(st.LatΦ + d2r)
+	for i, v := range []float64{cLat * cLong, cLat * sLong, sLat} {
+		rFixed[i] = (Mars.Radius + 500) * v
+	}
+	ρFixed := make([]float64, 3)
+	floats.SubTo(ρFixed, rFixed, st.R)
+	R, V := bodyFixed2Inertial(rFixed, Unit(ρFixed), dt, Mars)
+	m := st.PerformMeasurement(dt, State{DT: dt, Orbit: *NewOrbitFromRV(R, V, Mars)})
+	if !m.Visible || !floats.EqualWithinAbs(m.TrueRange, Norm(ρFixed), 1e-6) || !floats.EqualWithinAbs(m.TrueRangeRate, 1, 1e-9) {
+		t.Fatalf("invalid measurement: %+v", m)
+	}
+	// The spacecraft is not visible from the other side of Mars.
+	rFixed[0], rFixed[1], rFixed[2] = -rFixed[0], -rFixed[1], -rFixed[2]
+	R, V = bodyFixed2Inertial(rFixed, []float64{0, 0, 0}, dt, Mars)
+	if m = st.PerformMeasurement(dt, State{DT: dt, Orbit: *NewOrbitFromRV(R, V, Mars)}); m.Visible {
+		t.Fatalf("spacecraft visible through Mars: %+v", m)
+	}
+}
+
+func TestZonalHarmonicsPole(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Above the pole, the zonal harmonics are along the pole.
+	α, δ := Mars.Rotation.Pole(dt)
+	pole := []float64{math.Cos(δ) * math.Cos(α), math.Cos(δ) * math.Sin(α), math.Sin(δ)}
+	R := []float64{5000 * pole[0], 5000 * pole[1], 5000 * pole[2]}
+	acc := zonalAcceleration(R, Mars, 3, dt)
+	if Norm(acc) == 0 || !floats.EqualWithinAbs(math.Abs(Dot(Unit(acc), pole)), 1, 1e-12) {
+		t.Fatalf("zonal acceleration not along the pole: %v", acc)
+	}
+	// The Earth harmonics are about the ICRF Z axis.
+	acc = zonalAcceleration([]float64{0, 0, 7000}, Earth, 3, dt)
+	if acc[0] != 0 || acc[1] != 0 {
+		t.Fatalf("Earth zonal acceleration not along Z: %v", acc)
+	}
+	// Check the partials with finite differences.
+	R = []float64{3000, -2500, 1800}
+	A := zonalPartials(R, Mars, 3, dt)
+	h := 1e-3
+	for j := 0; j < 3; j++ {
+		Rp := []float64{R[0], R[1], R[2]}
+		Rm := []float64{R[0], R[1], R[2]}
+		Rp[j] += h
+		Rm[j] -= h
+		accP := zonalAcceleration(Rp, Mars, 3, dt)
+		accM := zonalAcceleration(Rm, Mars, 3, dt)
+		for i := 0; i < 3; i++ {
+			if fd := (accP[i] - accM[i]) / (2 * h); !floats.EqualWithinAbs(A.At(i, j), fd, 1e-12) {
+				t.Fatalf("invalid partial (%d,%d): %e != %e", i, j, A.At(i, j), fd)
+			}
+		}
+	}
+}
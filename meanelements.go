@@ -0,0 +1,180 @@
+package smd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// meanElementsIterations is the number of fixed point iterations used to invert the Brouwer-Lyddane mapping.
+	meanElementsIterations = 10
+)
+
+// MeanElements returns the Brouwer-Lyddane mean elements (to first order in J2) of this osculating orbit.
+// The returned elements are a, e, i, Ω, ω and the mean anomaly M, all angles in radians.
+// If the origin has no J2, the osculating elements are returned (with the mean anomaly instead of the true anomaly).
+// WARNING: As with any Brouwer theory, this is singular at the critical inclination (63.4 deg) and ill-defined
+// for equatorial orbits.
+func (o Orbit) MeanElements() (a, e, i, Ω, ω, M float64) {
+	oa, oe, oi, oΩ, oω, oν, _, _, _ := o.Elements()
+	oM := True2MeanAnomaly(oν, oe)
+	if o.Origin.J2 == 0 {
+		return oa, oe, oi, oΩ, oω, oM
+	}
+	osc := []float64{oa, oe, oi, oΩ, oω, oM}
+	// First order guess: apply the inverse mapping once.
+	a, e, i, Ω, ω, M = brouwerLyddane(oa, oe, oi, oΩ, oω, oM, o.Origin, -1)
+	// Iterate on the forward mapping to remove the second order error of the first guess.
+	for iter := 0; iter < meanElementsIterations; iter++ {
+		ca, ce, ci, cΩ, cω, cM := brouwerLyddane(a, e, i, Ω, ω, M, o.Origin, 1)
+		computed := []float64{ca, ce, ci, cΩ, cω, cM}
+		mean := []float64{a, e, i, Ω, ω, M}
+		maxErr := 0.0
+		for k := 0; k < 6; k++ {
+			δ := osc[k] - computed[k]
+			if k > 1 {
+				// Angles: take the shortest difference.
+				δ = math.Remainder(δ, 2*math.Pi)
+			}
+			mean[k] += δ
+			if err := math.Abs(δ); k > 0 && err > maxErr {
+				maxErr = err
+			}
+		}
+		a, e, i, Ω, ω, M = mean[0], mean[1], mean[2], mean[3], mean[4], mean[5]
+		if maxErr < 1e-12 {
+			break
+		}
+	}
+	Ω = math.Mod(Ω+2*math.Pi, 2*math.Pi)
+	ω = math.Mod(ω+2*math.Pi, 2*math.Pi)
+	M = math.Mod(M+2*math.Pi, 2*math.Pi)
+	return
+}
+
+// MeanEqualsWithin returns whether two orbits have the same mean elements within the provided bounds.
+// This is the same as EqualsWithin but ignores the J2 short period oscillations.
+func (o Orbit) MeanEqualsWithin(o1 Orbit, distanceε, eccentricityε, angleε float64) (bool, error) {
+	if !o.Origin.Equals(o1.Origin) {
+		return false, errors.New("different origin")
+	}
+	if o.Frame != o1.Frame {
+		return false, fmt.Errorf("different frames (%s and %s)", o.Frame, o1.Frame)
+	}
+	a, e, i, Ω, ω, M := o.MeanElements()
+	a1, e1, i1, Ω1, ω1, M1 := o1.MeanElements()
+	λ := math.Mod(Ω+ω+M, 2*math.Pi)
+	λ1 := math.Mod(Ω1+ω1+M1, 2*math.Pi)
+	u := math.Mod(ω+M, 2*math.Pi)
+	u1 := math.Mod(ω1+M1, 2*math.Pi)
+	return elementsEqualWithin(a, e, i, Ω, ω, λ, u, a1, e1, i1, Ω1, ω1, λ1, u1, distanceε, eccentricityε, angleε)
+}
+
+// NewOrbitFromMeanOE creates an orbit from the Brouwer-Lyddane mean orbital elements.
+// WARNING: Angles must be in degrees not radians, and the last parameter is the *mean* anomaly.
+func NewOrbitFromMeanOE(a, e, i, Ω, ω, M float64, c CelestialObject) *Orbit {
+	if c.J2 == 0 {
+		return NewOrbitFromOE(a, e, i, Ω, ω, Mean2TrueAnomaly(M*deg2rad, e)*rad2deg, c)
+	}
+	oa, oe, oi, oΩ, oω, oM := brouwerLyddane(a, e, i*deg2rad, Ω*deg2rad, ω*deg2rad, M*deg2rad, c, 1)
+	return NewOrbitFromOE(oa, oe, oi*rad2deg, oΩ*rad2deg, oω*rad2deg, Mean2TrueAnomaly(oM, oe)*rad2deg, c)
+}
+
+// brouwerLyddane maps mean elements to osculating elements if sgn is +1, and osculating to mean (to first order)
+// if sgn is -1. All angles are in radians and the anomaly is the mean anomaly.
+// Algorithm from Schaub and Junkins, Analytical Mechanics of Space Systems, appendix F.
+func brouwerLyddane(a, e, i, Ω, ω, M float64, c CelestialObject, sgn float64) (ap, ep, ip, Ωp, ωp, Mp float64) {
+	γ2 := sgn * c.J2 / 2 * math.Pow(c.Radius/a, 2)
+	η := math.Sqrt(1 - e*e)
+	η2 := η * η
+	η3 := η2 * η
+	η6 := η3 * η3
+	γ2p := γ2 / (η2 * η2)
+	ν := Mean2TrueAnomaly(M, e)
+	sinν, cosν := math.Sincos(ν)
+	cosi := math.Cos(i)
+	cos2i := cosi * cosi
+	cos4i := cos2i * cos2i
+	crit := 1 - 5*cos2i // Critical inclination term
+	aOr := (1 + e*cosν) / η2
+	aOr3 := aOr * aOr * aOr
+	cos2ω2ν := math.Cos(2*ω + 2*ν)
+	sin2ω2ν := math.Sin(2*ω + 2*ν)
+	cos2ων, sin2ων := math.Cos(2*ω+ν), math.Sin(2*ω+ν)
+	cos2ω3ν, sin2ω3ν := math.Cos(2*ω+3*ν), math.Sin(2*ω+3*ν)
+	eqCenter := ν - M + e*sinν
+
+	ap = a + a*γ2*((3*cos2i-1)*(aOr3-1/η3)+3*(1-cos2i)*aOr3*cos2ω2ν)
+
+	δe1 := γ2p / 8 * e * η2 * (1 - 11*cos2i - 40*cos4i/crit) * math.Cos(2*ω)
+	cosTerms := 3*cosν + 3*e*cosν*cosν + e*e*cosν*cosν*cosν
+	δe := δe1 + η2/2*(γ2*((3*cos2i-1)/η6*(e*η+e/(1+η)+cosTerms)+3*(1-cos2i)/η6*(e+cosTerms)*cos2ω2ν)-
+		γ2p*(1-cos2i)*(3*cos2ων+cos2ω3ν))
+
+	tani := math.Tan(i)
+	if math.Abs(tani) < angleε {
+		// The node is undefined for equatorial orbits.
+		tani = math.Copysign(angleε, tani)
+	}
+	δi := -e*δe1/(η2*tani) + γ2p/2*cosi*math.Sqrt(1-cos2i)*(3*cos2ω2ν+3*e*cos2ων+e*cos2ω3ν)
+
+	shortPeriod := 3*sin2ω2ν + 3*e*sin2ων + e*sin2ω3ν
+	δΩ := -γ2p/8*e*e*cosi*(11+80*cos2i/crit+200*cos4i/(crit*crit)) -
+		γ2p/2*cosi*(6*eqCenter-shortPeriod)
+
+	MωΩ := M + ω + Ω + γ2p/8*η3*(1-11*cos2i-40*cos4i/crit) -
+		γ2p/16*(2+e*e-11*(2+3*e*e)*cos2i-40*(2+5*e*e)*cos4i/crit-400*e*e*cos4i*cos2i/(crit*crit)) +
+		γ2p/4*(-6*crit*eqCenter+(3-5*cos2i)*shortPeriod) + δΩ
+
+	aOrη2 := aOr * aOr * η2
+	eδM := γ2p/8*e*η3*(1-11*cos2i-40*cos4i/crit) -
+		γ2p/4*η3*(2*(3*cos2i-1)*(aOrη2+aOr+1)*sinν+
+			3*(1-cos2i)*((-aOrη2-aOr+1)*sin2ων+(aOrη2+aOr+1/3.)*sin2ω3ν))
+
+	sinM, cosM := math.Sincos(M)
+	d1 := (e+δe)*sinM + eδM*cosM
+	d2 := (e+δe)*cosM - eδM*sinM
+	Mp = math.Atan2(d1, d2)
+	ep = math.Sqrt(d1*d1 + d2*d2)
+
+	sini2, cosi2 := math.Sincos(i / 2)
+	sinΩ, cosΩ := math.Sincos(Ω)
+	d3 := (sini2+cosi2*δi/2)*sinΩ + sini2*δΩ/2*cosΩ
+	d4 := (sini2+cosi2*δi/2)*cosΩ - sini2*δΩ/2*sinΩ
+	Ωp = math.Atan2(d3, d4)
+	ip = 2 * math.Asin(math.Min(1, math.Sqrt(d3*d3+d4*d4)))
+	ωp = MωΩ - Mp - Ωp
+
+	Mp = math.Mod(Mp+2*math.Pi, 2*math.Pi)
+	Ωp = math.Mod(Ωp+2*math.Pi, 2*math.Pi)
+	ωp = math.Mod(math.Mod(ωp, 2*math.Pi)+2*math.Pi, 2*math.Pi)
+	return
+}
+
+// Mean2TrueAnomaly returns the true anomaly from the mean anomaly of an elliptical orbit (angles in radians).
+func Mean2TrueAnomaly(M, e float64) float64 {
+	// Solve Kepler's equation with Newton-Raphson (Vallado, 4th edition, algorithm 2).
+	E := M
+	if e > 0.8 {
+		E = math.Pi
+	}
+	for iter := 0; iter < 50; iter++ {
+		δE := (E - e*math.Sin(E) - M) / (1 - e*math.Cos(E))
+		E -= δE
+		if math.Abs(δE) < 1e-14 {
+			break
+		}
+	}
+	sinE, cosE := math.Sincos(E)
+	ν := math.Atan2(math.Sqrt(1-e*e)*sinE, cosE-e)
+	return math.Mod(ν+2*math.Pi, 2*math.Pi)
+}
+
+// True2MeanAnomaly returns the mean anomaly from the true anomaly of an elliptical orbit (angles in radians).
+func True2MeanAnomaly(ν, e float64) float64 {
+	sinν, cosν := math.Sincos(ν)
+	E := math.Atan2(math.Sqrt(1-e*e)*sinν, e+cosν)
+	M := E - e*math.Sin(E)
+	return math.Mod(M+2*math.Pi, 2*math.Pi)
+}
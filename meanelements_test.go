@@ -0,0 +1,73 @@
+package smd
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+)
+
+func TestAnomalyConversions(t *testing.T) {
+	for _, e := range []float64{0.0001, 0.1, 0.5, 0.9} {
+		for ν := 0.0; ν < 2*math.Pi; ν += 0.1 {
+			M := True2MeanAnomaly(ν, e)
+			if ν1 := Mean2TrueAnomaly(M, e); !floats.EqualWithinAbs(ν, ν1, 1e-10) {
+				t.Fatalf("e=%f ν=%f M=%f ν1=%f", e, ν, M, ν1)
+			}
+		}
+	}
+}
+
+func TestMeanElementsRoundTrip(t *testing.T) {
+	o := NewOrbitFromMeanOE(7000, 0.01, 45, 20, 30, 40, Earth)
+	a, e, i, Ω, ω, M := o.MeanElements()
+	if !floats.EqualWithinAbs(a, 7000, 1e-6) {
+		t.Fatalf("a=%f", a)
+	}
+	if !floats.EqualWithinAbs(e, 0.01, 1e-9) {
+		t.Fatalf("e=%f", e)
+	}
+	for k, pair := range [][]float64{{i, 45}, {Ω, 20}, {ω, 30}, {M, 40}} {
+		if ok, err := anglesEqual(pair[0], pair[1]*deg2rad); !ok {
+			t.Fatalf("angle #%d: %s", k, err)
+		}
+	}
+	// The osculating elements must differ from the mean ones.
+	oa, _, _, _, _, _, _, _, _ := o.Elements()
+	if floats.EqualWithinAbs(oa, 7000, 1) {
+		t.Fatalf("osculating a=%f too close to mean a", oa)
+	}
+	// Without J2, mean and osculating elements are the same.
+	noJ2 := Earth
+	noJ2.J2 = 0
+	o = NewOrbitFromMeanOE(7000, 0.01, 45, 20, 30, 40, noJ2)
+	if a, _, _, _, _, _ = o.MeanElements(); !floats.EqualWithinAbs(a, 7000, 1e-6) {
+		t.Fatalf("a=%f", a)
+	}
+}
+
+func TestMeanElementsJ2Propagation(t *testing.T) {
+	o := NewOrbitFromOE(7000, 0.001, 50, 20, 30, 0, Earth)
+	meanA0, meanE0, meanI0, _, _, _ := o.MeanElements()
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(o.Period() / 4)
+	astro := NewPreciseMission(NewEmptySC("mean", 0), o, start, end, Perturbations{Jn: 2}, time.Second, false, ExportConfig{})
+	astro.Propagate()
+	oscA0, _, _, _, _, _, _, _, _ := NewOrbitFromOE(7000, 0.001, 50, 20, 30, 0, Earth).Elements()
+	oscA1, _, _, _, _, _, _, _, _ := o.Elements()
+	meanA1, meanE1, meanI1, _, _, _ := o.MeanElements()
+	t.Logf("osculating Δa=%f km\tmean Δa=%f km", oscA1-oscA0, meanA1-meanA0)
+	if math.Abs(oscA1-oscA0) < 1 {
+		t.Fatal("osculating semi-major axis should vary with J2")
+	}
+	if !floats.EqualWithinAbs(meanA0, meanA1, 0.05) {
+		t.Fatalf("mean semi-major axis varies: %f -> %f", meanA0, meanA1)
+	}
+	if !floats.EqualWithinAbs(meanE0, meanE1, 1e-5) {
+		t.Fatalf("mean eccentricity varies: %f -> %f", meanE0, meanE1)
+	}
+	if ok, err := anglesEqual(meanI0, meanI1); !ok {
+		t.Fatalf("mean inclination varies: %s", err)
+	}
+}
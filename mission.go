@@ -21,9 +21,10 @@ var wg sync.WaitGroup
 
 // Mission defines a mission and does the propagation.
 type Mission struct {
-	Vehicle                    *Spacecraft  // As pointer because SC may be altered during propagation.
-	Orbit                      *Orbit       // As pointer because the orbit changes during propagation.
-	Φ                          *mat64.Dense // STM
+	Vehicle                    *Spacecraft      // As pointer because SC may be altered during propagation.
+	Orbit                      *Orbit           // As pointer because the orbit changes during propagation.
+	Φ                          *mat64.Dense     // STM
+	Formulation                StateFormulation // How the orbit is integrated (defaults to Cowell)
 	StartDT, StopDT, CurrentDT time.Time
 	perts                      Perturbations
 	step                       time.Duration // time step
@@ -32,6 +33,7 @@ type Mission struct {
 	computeSTM, done, collided bool
 	autoChanClosing            bool // Set to False to not automatically close the channels upon end propgation time reached.
 	propuntilCalled            bool // Avoids too many messages if repeated calls to PropagateUntil()
+	encke                      enckeReference
 }
 
 // NewMission is the same as NewPreciseMission with the default step size.
@@ -49,7 +51,7 @@ func NewPreciseMission(s *Spacecraft, o *Orbit, start, end time.Time, perts Pert
 		end = end.UTC()
 	}
 	rSTM, _ := perts.STMSize()
-	a := &Mission{s, o, DenseIdentity(rSTM), start, end, start, perts, step, make(chan (bool), 1), nil, computeSTM, false, false, true, false}
+	a := &Mission{s, o, DenseIdentity(rSTM), Cowell, start, end, start, perts, step, make(chan (bool), 1), nil, computeSTM, false, false, true, false, enckeReference{}}
 	// Create a main history channel if there is any exporting
 	if !conf.IsUseless() {
 		a.histChans = []chan (State){make(chan (State), 10)}
@@ -181,7 +183,7 @@ func (a *Mission) Stop(t float64) bool {
 	return stop
 }
 
-// GetState returns the state for the integrator for the Gaussian VOP.
+// GetState returns the state for the integrator in the formulation of this mission.
 func (a *Mission) GetState() (s []float64) {
 	stateSize := 7
 	if a.computeSTM {
@@ -192,12 +194,10 @@ func (a *Mission) GetState() (s []float64) {
 		}
 	}
 	s = make([]float64, stateSize)
-	R, V := a.Orbit.RV()
-	// R, V in the state
-	for i := 0; i < 3; i++ {
-		s[i] = R[i]
-		s[i+3] = V[i]
-	}
+	// The impulsive burns are applied at the start of the integration step.
+	a.applyManeuver()
+	// R, V (or the elements) in the state
+	copy(s, a.formulationState())
 	s[6] = a.Vehicle.FuelMass
 	if a.computeSTM {
 		if a.Vehicle.Drag > 0 {
@@ -216,12 +216,46 @@ func (a *Mission) GetState() (s []float64) {
 	return
 }
 
+// applyManeuver applies the impulsive burn scheduled at the current time step to the velocity, if any.
+func (a *Mission) applyManeuver() {
+	dt := a.CurrentDT.Truncate(a.step)
+	maneuver, exists := a.Vehicle.Maneuvers[dt]
+	if !exists || maneuver.done {
+		return
+	}
+	a.Vehicle.logger.Log("level", "info", "subsys", "astro", "date", a.CurrentDT, "thrust", "impulse", "v(km/s)", maneuver.Δv())
+	// The components are in the RTN frame, which is computed from the vectors because the angles of circular or
+	// equatorial orbits are not defined.
+	R, V := a.Orbit.RV()
+	Δv := RTN2ECI(R, V, []float64{maneuver.R, maneuver.N, maneuver.C})
+	// The vectors of the orbit are shared with the states already published.
+	Vpost := make([]float64, 3)
+	for j := 0; j < 3; j++ {
+		Vpost[j] = V[j] + Δv[j]
+	}
+	frame := a.Orbit.Frame
+	*a.Orbit = *NewOrbitFromRV([]float64{R[0], R[1], R[2]}, Vpost, a.Orbit.Origin)
+	a.Orbit.Frame = frame
+	maneuver.done = true
+	a.Vehicle.Maneuvers[dt] = maneuver
+}
+
 // SetState sets the updated state.
 func (a *Mission) SetState(t float64, s []float64) {
+	*a.Orbit = *a.orbitFromState(t, s) // Deref is important (cf. TestMissionSpiral)
+	// The reference is evaluated at the same time as the state, i.e. before the current time is advanced.
+	rectify := false
+	if a.Formulation == Encke && a.encke.inStep {
+		ρ, _ := a.enckeReferenceAt(t)
+		rectify = Norm(s[0:3]) > enckeRectification*Norm(ρ)
+	}
 	a.CurrentDT = a.CurrentDT.Add(a.step)
-	R := []float64{s[0], s[1], s[2]}
-	V := []float64{s[3], s[4], s[5]}
-	*a.Orbit = *NewOrbitFromRV(R, V, a.Orbit.Origin) // Deref is important (cf. TestMissionSpiral)
+	if rectify {
+		// Rectify the reference orbit since the deviation is too large.
+		a.encke.R, a.encke.V = a.Orbit.RV()
+		a.encke.DT = a.CurrentDT
+		a.encke.inStep = false
+	}
 
 	// Orbit sanity checks and warnings.
 	if !a.collided && a.Orbit.RNorm() < a.Orbit.Origin.Radius {
@@ -241,14 +275,15 @@ func (a *Mission) SetState(t float64, s []float64) {
 	a.Vehicle.FuelMass = s[6]
 
 	var latestVector *mat64.Vector
+	R, V := a.Orbit.RV()
+	st := []float64{R[0], R[1], R[2], V[0], V[1], V[2]}
 	if a.Vehicle.Drag > 0 && a.computeSTM {
-		st := s[0:6]
 		st = append(st, a.Vehicle.Drag)
 		// Update Cr
 		a.Vehicle.Drag = s[7]
 		latestVector = mat64.NewVector(7, st)
 	} else {
-		latestVector = mat64.NewVector(6, s[0:6])
+		latestVector = mat64.NewVector(6, st)
 	}
 	latestState := State{a.CurrentDT, *a.Vehicle, *a.Orbit, nil, latestVector}
 
@@ -287,7 +322,8 @@ func (a *Mission) SetState(t float64, s []float64) {
 
 }
 
-// Func is the integration function using Gaussian VOP as per Ruggiero et al. 2011.
+// Func is the integration function of the state in the formulation of this mission.
+// The thrust direction is computed as per Ruggiero et al. 2011.
 func (a *Mission) Func(t float64, f []float64) (fDot []float64) {
 	stateSize := 7
 	if a.computeSTM {
@@ -301,39 +337,45 @@ func (a *Mission) Func(t float64, f []float64) (fDot []float64) {
 	// Let's add the thrust to increase the magnitude of the velocity.
 	// XXX: Should this Accelerate call be with tmpOrbit?!
 	Δv, usedFuel := a.Vehicle.Accelerate(a.CurrentDT, a.Orbit)
-	var tmpOrbit *Orbit
-
-	R := []float64{f[0], f[1], f[2]}
-	V := []float64{f[3], f[4], f[5]}
-	tmpOrbit = NewOrbitFromRV(R, V, a.Orbit.Origin)
+	if a.Formulation == Encke && !a.encke.inStep {
+		// First call of this integration step.
+		a.encke.t0 = t
+		a.encke.inStep = true
+	}
+	tmpOrbit := a.orbitFromState(t, f)
+	R, V := tmpOrbit.RV()
 	bodyAcc := -tmpOrbit.Origin.μ / math.Pow(Norm(R), 3)
 	_, _, i, Ω, _, _, _, _, u := tmpOrbit.Elements()
-	// Check if any impulse burn, and execute them if needed.
-	if maneuver, exists := a.Vehicle.Maneuvers[a.CurrentDT.Truncate(a.step)]; exists {
-		if !maneuver.done {
-			a.Vehicle.logger.Log("level", "info", "subsys", "astro", "date", a.CurrentDT, "thrust", "impulse", "v(km/s)", maneuver.Δv())
-			Δv[0] += maneuver.R
-			Δv[1] += maneuver.N
-			Δv[2] += maneuver.C
-			maneuver.done = true
-			a.Vehicle.Maneuvers[a.CurrentDT.Truncate(a.step)] = maneuver
-		}
-	}
 	Δv = Rot313Vec(-u, -i, -Ω, Δv)
-	// d\vec{R}/dt
-	fDot[0] = f[3]
-	fDot[1] = f[4]
-	fDot[2] = f[5]
-	// d\vec{V}/dt
-	fDot[3] = bodyAcc*f[0] + Δv[0]
-	fDot[4] = bodyAcc*f[1] + Δv[1]
-	fDot[5] = bodyAcc*f[2] + Δv[2]
-	// d(fuel)/dt
-	fDot[6] = -usedFuel
 
-	// Compute and add the perturbations (which are method dependent).
+	// Compute the perturbations (which are method dependent).
 	pert := a.perts.Perturb(*tmpOrbit, a.CurrentDT, *a.Vehicle)
 
+	switch a.Formulation {
+	case MEEVOP:
+		// All the accelerations other than the central body are perturbations of the elements.
+		// WARNING: Perturbations of the position itself (e.g. noise) are ignored.
+		acc := ECI2RTN(R, V, []float64{Δv[0] + pert[3], Δv[1] + pert[4], Δv[2] + pert[5]})
+		copy(fDot, meeVOP(f[0], f[1], f[2], f[3], f[4], f[5], tmpOrbit.Origin.μ, acc[0], acc[1], acc[2]))
+	case Encke:
+		// The two body motion of the reference is analytical, so only the deviation is integrated.
+		ρ, _ := a.enckeReferenceAt(t)
+		δAcc := enckeAcceleration(ρ, f[0:3], tmpOrbit.Origin.μ)
+		for j := 0; j < 3; j++ {
+			fDot[j] = f[j+3] + pert[j]
+			fDot[j+3] = δAcc[j] + Δv[j] + pert[j+3]
+		}
+	default:
+		for j := 0; j < 3; j++ {
+			// d\vec{R}/dt
+			fDot[j] = V[j] + pert[j]
+			// d\vec{V}/dt
+			fDot[j+3] = bodyAcc*R[j] + Δv[j] + pert[j+3]
+		}
+	}
+	// d(fuel)/dt
+	fDot[6] = -usedFuel + pert[6]
+
 	// Compute STM if needed.
 	if a.computeSTM {
 		// Extract the components of Φ
@@ -391,79 +433,30 @@ func (a *Mission) Func(t float64, f []float64) (fDot []float64) {
 
 		// Jn perturbations:
 		if a.perts.Jn > 1 {
-			// Ai0 = \frac{\partial a}{\partial x}
-			// Ai1 = \frac{\partial a}{\partial y}
-			// Ai2 = \frac{\partial a}{\partial z}
-			A30 := A.At(3, 0)
-			A40 := A.At(4, 0)
-			A50 := A.At(5, 0)
-			A31 := A.At(3, 1)
-			A41 := A.At(4, 1)
-			A51 := A.At(5, 1)
-			A32 := A.At(3, 2)
-			A42 := A.At(4, 2)
-			A52 := A.At(5, 2)
+			AJn := zonalPartials(R, a.Orbit.Origin, a.perts.Jn, a.CurrentDT)
+			for i := 0; i < 3; i++ {
+				for j := 0; j < 3; j++ {
+					A.Set(i+3, j, A.At(i+3, j)+AJn.At(i, j))
+				}
+			}
+		}
 
-			// Notation simplification
-			z3 := math.Pow(R[2], 3)
-			z4 := math.Pow(R[2], 4)
-			// Adding those fractions to avoid forgetting the trailing period which makes them floats.
-			f32 := 3 / 2.
-			f152 := 15 / 2.
-			r272 := math.Pow(r2, 7/2.)
-			r292 := math.Pow(r2, 9/2.)
-			// J2
-			j2fact := a.Orbit.Origin.J(2) * math.Pow(a.Orbit.Origin.Radius, 2) * a.Orbit.Origin.μ
-			A30 += -f32 * j2fact * (35*x2*z2/r292 - 5*x2/r272 - 5*z2/r272 + 1/r252) //dAxDx
-			A40 += -f152 * j2fact * (7*x*y*z2/r292 - x*y/r272)                      //dAyDx
-			A50 += -f152 * j2fact * (7*x*z3/r292 - 3*x*z/r272)                      //dAzDx
-
-			A31 += -f152 * j2fact * (7*x*y*z2/r292 - x*y/r272)                      //dAxDy
-			A41 += -f32 * j2fact * (35*y2*z2/r292 - 5*y2/r272 - 5*z2/r272 + 1/r252) // dAyDy
-			A51 += -f152 * j2fact * (7*y*z3/r292 - 3*y*z/r272)                      // dAzDy
-
-			A32 += -f152 * j2fact * (7*x*z3/r292 - 3*x*z/r272)        //dAxDz
-			A42 += -f152 * j2fact * (7*y*z3/r292 - 3*y*z/r272)        //dAyDz
-			A52 += -f32 * j2fact * (35*z4/r292 - 30*z2/r272 + 3/r252) // dAzDz
-
-			// J3
-			if a.perts.Jn > 2 {
-				z5 := math.Pow(R[2], 5)
-				r2112 := math.Pow(r2, 11/2.)
-				f52 := 5 / 2.
-				f1052 := 105 / 2.
-				j3fact := a.Orbit.Origin.J(3) * math.Pow(a.Orbit.Origin.Radius, 3) * a.Orbit.Origin.μ
-				A30 += -f52 * j3fact * (63*x2*z3/r2112 - 21*x2*z/r292 - 7*z3/r292 + 3*z/r272) //dAxDx
-				A40 += -f1052 * j3fact * (3*x*y*z3/r2112 - x*y*z/r292)                        //dAyDx
-				A50 += -f152 * j3fact * (21*x*z4/r2112 - 14*x*z2/r292 + x/r272)               //dAzDx
-
-				A31 += -f1052 * j3fact * (3*x*y*z3/r2112 - x*y*z/r292)                        //dAxDy
-				A41 += -f52 * j3fact * (63*y2*z3/r2112 - 21*y2*z/r292 - 7*z3/r292 + 3*z/r272) // dAyDy
-				A51 += -f152 * j3fact * (21*y*z4/r2112 - 14*y*z2/r292 + y/r272)               // dAzDy
-
-				A32 += -f152 * j3fact * (21*x*z4/r2112 - 14*x*z2/r292 + x/r272) //dAxDz
-				A42 += -f152 * j3fact * (21*y*z4/r2112 - 14*y*z2/r292 + y/r272) //dAyDz
-				A52 += -f52 * j3fact * (63*z5/r2112 - 70*z3/r292 + 15*z/r272)   // dAzDz
+		// Atmospheric drag
+		if a.perts.AtmosphericDrag && a.Orbit.Origin.Name == Earth.Name {
+			dAdR, dAdV := dragPartials(R, V, a.Vehicle.Cd)
+			for i := 0; i < 3; i++ {
+				for j := 0; j < 3; j++ {
+					A.Set(i+3, j, A.At(i+3, j)+dAdR.At(i, j))
+					A.Set(i+3, j+3, A.At(i+3, j+3)+dAdV.At(i, j))
+				}
 			}
-			// \frac{\partial a}{\partial x}
-			A.Set(3, 0, A30)
-			A.Set(4, 0, A40)
-			A.Set(5, 0, A50)
-			// \partial a/\partial y
-			A.Set(3, 1, A31)
-			A.Set(4, 1, A41)
-			A.Set(5, 1, A51)
-			// \partial a/\partial z
-			A.Set(3, 2, A32)
-			A.Set(4, 2, A42)
-			A.Set(5, 2, A52)
 		}
 
 		var RSunToEarth, RSunToSC, REarthToSC []float64
 
 		if a.perts.Drag || a.perts.PerturbingBody != nil {
 			REarthToSC = a.Orbit.R()
-			RSunToEarth = MxV33(R1(Deg2rad(-Earth.tilt)), a.Orbit.Origin.HelioOrbit(a.CurrentDT).R())
+			RSunToEarth = a.Orbit.Origin.HelioOrbitIn(a.Orbit.Frame, a.CurrentDT).R()
 			RSunToSC = make([]float64, 3)
 			for i := 0; i < 3; i++ {
 				RSunToSC[i] = RSunToEarth[i] + REarthToSC[i]
@@ -472,7 +465,7 @@ func (a *Mission) Func(t float64, f []float64) (fDot []float64) {
 
 		if a.perts.Drag || a.perts.PerturbingBody != nil {
 			Cr := a.Vehicle.Drag
-			S := 0.01e-6 // TODO: Idem for the Area to mass ratio
+			S := areaToMass
 			Phi := 1357.
 			// Build the vectors.
 			celerity := 2.997925e+05
@@ -547,9 +540,6 @@ func (a *Mission) Func(t float64, f []float64) (fDot []float64) {
 
 	// Sanity check
 	for i := 0; i < stateSize; i++ {
-		if i < 7 {
-			fDot[i] += pert[i]
-		}
 		if math.IsNaN(fDot[i]) {
 			r, v := a.Orbit.RV()
 			panic(fmt.Errorf("fDot[%d]=NaN @ dt=%s\ncur:%s\tΔv=%+v\nR=%+v\tV=%+v", i, a.CurrentDT, a.Orbit, Δv, r, v))
@@ -558,6 +548,59 @@ func (a *Mission) Func(t float64, f []float64) (fDot []float64) {
 	return
 }
 
+// formulationState returns the orbital part of the integration state in the formulation of this mission.
+func (a *Mission) formulationState() []float64 {
+	switch a.Formulation {
+	case MEEVOP:
+		p, f, g, h, k, L := a.Orbit.ModifiedEquinoctial()
+		return []float64{p, f, g, h, k, L}
+	case Encke:
+		if a.encke.R == nil || !a.encke.Origin.Equals(a.Orbit.Origin) {
+			// Initialize the reference, or reset it after a change of origin.
+			a.encke.R, a.encke.V = a.Orbit.RV()
+			a.encke.DT = a.CurrentDT
+			a.encke.Origin = a.Orbit.Origin
+		}
+		a.encke.inStep = false
+		ρ, ρDot := a.enckeReferenceAt(0)
+		R, V := a.Orbit.RV()
+		return []float64{R[0] - ρ[0], R[1] - ρ[1], R[2] - ρ[2], V[0] - ρDot[0], V[1] - ρDot[1], V[2] - ρDot[2]}
+	default:
+		R, V := a.Orbit.RV()
+		return []float64{R[0], R[1], R[2], V[0], V[1], V[2]}
+	}
+}
+
+// orbitFromState returns the orbit from the orbital part of the integration state at integrator time t.
+func (a *Mission) orbitFromState(t float64, s []float64) (o *Orbit) {
+	switch a.Formulation {
+	case MEEVOP:
+		o = NewOrbitFromMEE(s[0], s[1], s[2], s[3], s[4], s[5], a.Orbit.Origin)
+	case Encke:
+		ρ, ρDot := a.enckeReferenceAt(t)
+		R := make([]float64, 3)
+		V := make([]float64, 3)
+		for i := 0; i < 3; i++ {
+			R[i] = ρ[i] + s[i]
+			V[i] = ρDot[i] + s[i+3]
+		}
+		o = NewOrbitFromRV(R, V, a.Orbit.Origin)
+	default:
+		o = NewOrbitFromRV([]float64{s[0], s[1], s[2]}, []float64{s[3], s[4], s[5]}, a.Orbit.Origin)
+	}
+	o.Frame = a.Orbit.Frame
+	return
+}
+
+// enckeReferenceAt returns the position and velocity of the Encke reference orbit at integrator time t.
+func (a *Mission) enckeReferenceAt(t float64) (ρ, ρDot []float64) {
+	Δt := a.CurrentDT.Sub(a.encke.DT).Seconds()
+	if a.encke.inStep {
+		Δt += t - a.encke.t0
+	}
+	return KeplerPropagate(a.encke.R, a.encke.V, a.encke.Origin.μ, Δt)
+}
+
 // State stores propagated state.
 type State struct {
 	DT      time.Time
@@ -91,7 +91,7 @@ func TestMissionGEO(t *testing.T) {
 }
 
 func TestMission1DayNoJ2(t *testing.T) {
-	virtObj := CelestialObject{"virtObj", 6378.145, 149598023, 398600.4, 23.4, 0.00005, 924645.0, 0.00108248, -2.5324e-6, -1.6204e-6, 0, nil}
+	virtObj := CelestialObject{"virtObj", 6378.145, 149598023, 398600.4, 23.4, 0.00005, 924645.0, 0.00108248, -2.5324e-6, -1.6204e-6, 0, nil, nil, 0}
 	orbit := NewOrbitFromRV([]float64{-2436.45, -2436.45, 6891.037}, []float64{5.088611, -5.088611, 0}, virtObj)
 	startDT := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
 	endDT := startDT.Add(24 * time.Hour).Add(time.Second)
@@ -107,7 +107,7 @@ func TestMission1DayNoJ2(t *testing.T) {
 }
 
 func TestMission1DayWithJ2(t *testing.T) {
-	virtObj := CelestialObject{"virtObj", 6378.145, 149598023, 398600.4, 23.4, 0.00005, 924645.0, 0.00108248, -2.5324e-6, -1.6204e-6, 0, nil}
+	virtObj := CelestialObject{"virtObj", 6378.145, 149598023, 398600.4, 23.4, 0.00005, 924645.0, 0.00108248, -2.5324e-6, -1.6204e-6, 0, nil, nil, 0}
 	orbit := NewOrbitFromRV([]float64{-2436.45, -2436.45, 6891.037}, []float64{5.088611, -5.088611, 0}, virtObj)
 	startDT := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
 	endDT := startDT.Add(24 * time.Hour).Add(time.Second)
@@ -799,3 +799,32 @@ func TestMissionSTM(t *testing.T) {
 		}
 	}
 }
+
+func TestMissionManeuver(t *testing.T) {
+	// The impulsive maneuvers are executed entirely at the start of their step.
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	burnDT := start.Add(time.Minute)
+	end := burnDT.Add(StepSize)
+	sc := NewEmptySC("burn", 0)
+	sc.Maneuvers[burnDT] = NewManeuver(0, 0.01, 0)
+	burn := NewOrbitFromOE(7000, 0.001, 30, 80, 40, 0, Earth)
+	NewPreciseMission(sc, burn, start, start.Add(-1), Perturbations{}, StepSize, false, ExportConfig{}).PropagateUntil(end, true)
+	coast := NewOrbitFromOE(7000, 0.001, 30, 80, 40, 0, Earth)
+	NewPreciseMission(NewEmptySC("coast", 0), coast, start, start.Add(-1), Perturbations{}, StepSize, false, ExportConfig{}).PropagateUntil(end, true)
+	if !sc.Maneuvers[burnDT].done {
+		t.Fatal("maneuver not executed")
+	}
+	Vb, Vc := burn.V(), coast.V()
+	if Δv := Norm([]float64{Vb[0] - Vc[0], Vb[1] - Vc[1], Vb[2] - Vc[2]}); math.Abs(Δv-0.01) > 1e-4 {
+		t.Fatalf("invalid Δv: %f km/s instead of 0.01 km/s", Δv)
+	}
+	// The maneuver keeps the frame of the orbit.
+	sc = NewEmptySC("eclip", 0)
+	sc.Maneuvers[burnDT] = NewManeuver(0, 0.01, 0)
+	eclip := NewOrbitFromOE(7000, 0.001, 30, 80, 40, 0, Earth)
+	eclip.ToFrame(EclipJ2000, start)
+	NewPreciseMission(sc, eclip, start, start.Add(-1), Perturbations{}, StepSize, false, ExportConfig{}).PropagateUntil(end, true)
+	if !sc.Maneuvers[burnDT].done || eclip.Frame != EclipJ2000 {
+		t.Fatalf("maneuver changed the frame to %s", eclip.Frame)
+	}
+}
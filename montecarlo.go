@@ -0,0 +1,463 @@
+package smd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+	"github.com/gonum/stat/distmv"
+	"github.com/spf13/viper"
+)
+
+/* Monte Carlo dispersion analysis. Each run propagates its own mission from a new nominal spacecraft and orbit, whose
+initial state, masses, coefficient of reflectivity, thrusters and impulsive maneuvers are dispersed as per the 1σ of
+the Dispersions. The runs are spread over parallel workers, and each run draws from its own stream of the seed (cf.
+StreamSeed) in a fixed order, so that the results do not depend on the number of workers. The statistics of the final
+orbital elements, Δv, fuel use and B-plane (of the runs ending on a hyperbolic orbit) are computed over the successful
+runs. */
+
+// Dispersions defines the 1σ dispersions of a Monte Carlo analysis, where a zero σ disables the dispersion.
+type Dispersions struct {
+	P0            *mat64.SymDense // Covariance of the initial position and velocity in the inertial frame (6x6), none if nil
+	BurnMagnitude float64         // Relative σ of the magnitude of the impulsive maneuvers (e.g. 0.01 for 1%)
+	BurnFixed     float64         // σ of the magnitude of the impulsive maneuvers independent of the magnitude (km/s)
+	BurnPointing  float64         // σ of the pointing of the impulsive maneuvers (degrees)
+	Thrust        float64         // Relative σ of the thrust of each EP thruster
+	Isp           float64         // Relative σ of the Isp of each EP thruster
+	Cr            float64         // σ of the coefficient of reflectivity (cf. Spacecraft.Drag)
+	DryMass       float64         // σ of the dry mass (kg)
+	FuelMass      float64         // σ of the fuel mass (kg)
+}
+
+// DispersionsFromConfig returns the dispersions from the provided section of the configuration, with the keys
+// position (km) and velocity (km/s) for the σ of each component of the initial state, burn_magnitude (relative),
+// burn_fixed (km/s), burn_pointing (degrees), thrust and isp (relative), Cr, dry_mass and fuel_mass (kg). Unlike the
+// covariance of the orbit determination, all the values are standard deviations.
+func DispersionsFromConfig(v *viper.Viper, key string) (disp Dispersions, err error) {
+	prefix := key + "."
+	for _, σ := range []struct {
+		key   string
+		value *float64
+	}{{"burn_magnitude", &disp.BurnMagnitude}, {"burn_fixed", &disp.BurnFixed}, {"burn_pointing", &disp.BurnPointing},
+		{"thrust", &disp.Thrust}, {"isp", &disp.Isp}, {"Cr", &disp.Cr}, {"dry_mass", &disp.DryMass}, {"fuel_mass", &disp.FuelMass}} {
+		if *σ.value = v.GetFloat64(prefix + σ.key); *σ.value < 0 {
+			return disp, fmt.Errorf("negative `%s%s`", prefix, σ.key)
+		}
+	}
+	σPos, σVel := v.GetFloat64(prefix+"position"), v.GetFloat64(prefix+"velocity")
+	if σPos < 0 || σVel < 0 {
+		return disp, fmt.Errorf("negative `%sposition` or `%svelocity`", prefix, prefix)
+	}
+	if σPos > 0 || σVel > 0 {
+		disp.P0 = mat64.NewSymDense(6, nil)
+		for i := 0; i < 3; i++ {
+			disp.P0.SetSym(i, i, σPos*σPos)
+			disp.P0.SetSym(i+3, i+3, σVel*σVel)
+		}
+	}
+	return
+}
+
+// dispersedThruster is a thruster whose thrust and Isp are scaled.
+type dispersedThruster struct {
+	EPThruster
+	thrust, isp float64
+}
+
+// Thrust implements the EPThruster interface.
+func (t dispersedThruster) Thrust(voltage, power uint) (thrust, isp float64) {
+	thrust, isp = t.EPThruster.Thrust(voltage, power)
+	return thrust * t.thrust, isp * t.isp
+}
+
+// disperse disperses the provided spacecraft and orbit with the provided source, and returns the executed Δv of the
+// impulsive maneuvers (km/s).
+func (d Dispersions) disperse(sc *Spacecraft, o *Orbit, rng *rand.Rand) (Δv float64, err error) {
+	if d.P0 != nil {
+		R, V := o.RV()
+		dist, ok := distmv.NewNormal(append(append([]float64{}, R...), V...), d.P0, rng)
+		if !ok {
+			return 0, errors.New("the covariance of the initial state is not positive definite")
+		}
+		state := dist.Rand(nil)
+		*o = *NewOrbitFromRV(state[0:3], state[3:6], o.Origin)
+	}
+	sc.DryMass = math.Max(sc.DryMass+d.DryMass*rng.NormFloat64(), 0)
+	sc.FuelMass = math.Max(sc.FuelMass+d.FuelMass*rng.NormFloat64(), 0)
+	sc.Drag = math.Max(sc.Drag+d.Cr*rng.NormFloat64(), 0)
+	for i, thruster := range sc.EPThrusters {
+		sc.EPThrusters[i] = dispersedThruster{thruster, 1 + d.Thrust*rng.NormFloat64(), 1 + d.Isp*rng.NormFloat64()}
+	}
+	// The maneuvers are dispersed in chronological order, so that the draws do not depend on the order of the map.
+	epochs := make([]time.Time, 0, len(sc.Maneuvers))
+	for dt := range sc.Maneuvers {
+		epochs = append(epochs, dt)
+	}
+	sort.Slice(epochs, func(i, j int) bool { return epochs[i].Before(epochs[j]) })
+	for _, dt := range epochs {
+		burn := sc.Maneuvers[dt]
+		rtn := d.burnError([]float64{burn.R, burn.N, burn.C}, rng)
+		sc.Maneuvers[dt] = NewManeuver(rtn[0], rtn[1], rtn[2])
+		Δv += Norm(rtn)
+	}
+	return
+}
+
+// burnError returns the provided Δv with the magnitude and pointing errors of the dispersions.
+func (d Dispersions) burnError(Δv []float64, rng *rand.Rand) []float64 {
+	magnitude := Norm(Δv)
+	if magnitude == 0 {
+		return Δv
+	}
+	u := Unit(Δv)
+	// The pointing error is a rotation about a random axis perpendicular to the Δv.
+	axis := []float64{1, 0, 0}
+	if math.Abs(u[0]) > 0.9 {
+		axis = []float64{0, 1, 0}
+	}
+	e1 := Unit(Cross(u, axis))
+	e2 := Cross(u, e1)
+	θ := Deg2rad(d.BurnPointing * rng.NormFloat64())
+	φ := 2 * math.Pi * rng.Float64()
+	sinθ, cosθ := math.Sincos(θ)
+	sinφ, cosφ := math.Sincos(φ)
+	magnitude *= 1 + d.BurnMagnitude*rng.NormFloat64()
+	magnitude += d.BurnFixed * rng.NormFloat64()
+	rtn := make([]float64, 3)
+	for i := 0; i < 3; i++ {
+		rtn[i] = magnitude * (cosθ*u[i] + sinθ*(cosφ*e1[i]+sinφ*e2[i]))
+	}
+	return rtn
+}
+
+// MonteCarlo defines a Monte Carlo dispersion analysis.
+type MonteCarlo struct {
+	Nominal       func() (*Spacecraft, *Orbit) // Returns a new nominal spacecraft and initial orbit for each run
+	Start, End    time.Time
+	Step          time.Duration // Defaults to StepSize
+	Perturbations Perturbations
+	Formulation   StateFormulation
+	Dispersions   Dispersions
+	Runs          int
+	Workers       int // Number of parallel runs, defaults to the number of CPUs
+	Seed          int64
+}
+
+// MonteCarloRun is the outcome of a run.
+type MonteCarloRun struct {
+	Run         int       `json:"run"`
+	Seed        int64     `json:"seed"` // Seed of the run, i.e. StreamSeed of the analysis seed and the run number
+	Error       string    `json:"error,omitempty"`
+	DryMass     float64   `json:"dryMass"`
+	FuelMass    float64   `json:"fuelMass"` // Initial fuel mass
+	Cr          float64   `json:"Cr"`
+	Δv          float64   `json:"deltaV"` // Executed Δv of the impulsive maneuvers (km/s)
+	Fuel        float64   `json:"fuel"`   // Fuel used (kg)
+	R           []float64 `json:"R"`      // Final position (km)
+	V           []float64 `json:"V"`      // Final velocity (km/s)
+	SMA         float64   `json:"sma"`
+	Ecc         float64   `json:"ecc"`
+	Inc         float64   `json:"inc"` // Final angles in degrees
+	RAAN        float64   `json:"RAAN"`
+	ArgPeri     float64   `json:"argPeri"`
+	TrueAnomaly float64   `json:"tAnomaly"`
+	Hyperbolic  bool      `json:"hyperbolic"` // Whether the B-plane is set
+	BR          float64   `json:"BR"`
+	BT          float64   `json:"BT"`
+	LTOF        float64   `json:"LTOF"`
+}
+
+// MonteCarloStats are the statistics of a quantity over the successful runs.
+type MonteCarloStats struct {
+	Name string  `json:"name"`
+	Mean float64 `json:"mean"`
+	Std  float64 `json:"std"`
+	Min  float64 `json:"min"`
+	P1   float64 `json:"p1"`
+	P5   float64 `json:"p5"`
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// BPlaneDispersion is the dispersion of the B-plane of the runs ending on a hyperbolic orbit.
+type BPlaneDispersion struct {
+	Runs int `json:"runs"`
+	BPlaneEllipse
+	LTOFMean float64 `json:"LTOF"`
+	LTOFStd  float64 `json:"LTOFStd"`
+}
+
+// MonteCarloResult is the outcome of a Monte Carlo analysis.
+type MonteCarloResult struct {
+	Seed   int64             `json:"seed"`
+	Failed int               `json:"failed"`
+	Stats  []MonteCarloStats `json:"stats"`
+	BPlane *BPlaneDispersion `json:"bplane,omitempty"` // Only if any run ends on a hyperbolic orbit
+	Runs   []MonteCarloRun   `json:"runs"`
+}
+
+// Run runs the analysis and returns its result, or an error if the analysis is invalid or all the runs failed.
+func (mc MonteCarlo) Run() (*MonteCarloResult, error) {
+	if mc.Nominal == nil {
+		return nil, errors.New("no nominal spacecraft and orbit")
+	}
+	if mc.Runs <= 0 {
+		return nil, errors.New("the number of runs must be positive")
+	}
+	if mc.End.Before(mc.Start) {
+		return nil, errors.New("the analysis ends before it starts")
+	}
+	workers := mc.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	result := &MonteCarloResult{Seed: mc.Seed, Runs: make([]MonteCarloRun, mc.Runs)}
+	runs := make(chan int)
+	var runWG sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		runWG.Add(1)
+		go func() {
+			defer runWG.Done()
+			for run := range runs {
+				result.Runs[run] = mc.run(run)
+			}
+		}()
+	}
+	for run := 0; run < mc.Runs; run++ {
+		runs <- run
+	}
+	close(runs)
+	runWG.Wait()
+	for _, run := range result.Runs {
+		if run.Error != "" {
+			result.Failed++
+		}
+	}
+	if result.Failed == mc.Runs {
+		return result, fmt.Errorf("all runs failed, e.g. %s", result.Runs[0].Error)
+	}
+	result.statistics()
+	return result, nil
+}
+
+// run propagates the provided run, whose panics are returned as its error.
+func (mc MonteCarlo) run(run int) (rtn MonteCarloRun) {
+	rtn = MonteCarloRun{Run: run, Seed: StreamSeed(mc.Seed, run)}
+	defer func() {
+		if r := recover(); r != nil {
+			rtn.Error = fmt.Sprintf("%v", r)
+		}
+	}()
+	rng := NewRand(rtn.Seed)
+	sc, o := mc.Nominal()
+	Δv, err := mc.Dispersions.disperse(sc, o, rng)
+	if err != nil {
+		rtn.Error = err.Error()
+		return
+	}
+	rtn.DryMass, rtn.FuelMass, rtn.Cr, rtn.Δv = sc.DryMass, sc.FuelMass, sc.Drag, Δv
+	// The orbit noise is drawn from the stream of the run, since its source cannot be shared between the runs.
+	perts := mc.Perturbations
+	perts.Noise = perts.Noise.withRand(NewRand(StreamSeed(rtn.Seed, 0)))
+	step := mc.Step
+	if step == 0 {
+		step = StepSize
+	}
+	mission := NewPreciseMission(sc, o, mc.Start, mc.End, perts, step, false, ExportConfig{})
+	mission.Formulation = mc.Formulation
+	mission.Propagate()
+	rtn.Fuel = rtn.FuelMass - sc.FuelMass
+	rtn.R, rtn.V = mission.Orbit.RV()
+	a, e, i, Ω, ω, ν, _, _, _ := mission.Orbit.Elements()
+	rtn.SMA, rtn.Ecc, rtn.Inc, rtn.RAAN, rtn.ArgPeri, rtn.TrueAnomaly = a, e, Rad2deg(i), Rad2deg(Ω), Rad2deg(ω), Rad2deg(ν)
+	if e > 1 {
+		bPlane := NewBPlane(*mission.Orbit)
+		rtn.Hyperbolic, rtn.BR, rtn.BT, rtn.LTOF = true, bPlane.BR, bPlane.BT, bPlane.LTOF
+		if math.IsNaN(rtn.LTOF) {
+			// The LTOF is not defined past the periapsis (and NaN cannot be exported as JSON).
+			rtn.LTOF = 0
+		}
+	}
+	return
+}
+
+// statistics sets the statistics of the successful runs. The angles are unwrapped about their circular mean (cf.
+// unwrapAngles), so their statistics may be beyond [0, 360).
+func (r *MonteCarloResult) statistics() {
+	quantities := []struct {
+		name  string
+		angle bool
+		value func(MonteCarloRun) float64
+	}{
+		{"sma", false, func(run MonteCarloRun) float64 { return run.SMA }},
+		{"ecc", false, func(run MonteCarloRun) float64 { return run.Ecc }},
+		{"inc", false, func(run MonteCarloRun) float64 { return run.Inc }},
+		{"RAAN", true, func(run MonteCarloRun) float64 { return run.RAAN }},
+		{"argPeri", true, func(run MonteCarloRun) float64 { return run.ArgPeri }},
+		{"tAnomaly", true, func(run MonteCarloRun) float64 { return run.TrueAnomaly }},
+		{"deltaV", false, func(run MonteCarloRun) float64 { return run.Δv }},
+		{"fuel", false, func(run MonteCarloRun) float64 { return run.Fuel }},
+	}
+	r.Stats = nil
+	for _, quantity := range quantities {
+		var values []float64
+		for _, run := range r.Runs {
+			if run.Error == "" {
+				values = append(values, quantity.value(run))
+			}
+		}
+		if quantity.angle {
+			values = unwrapAngles(values)
+		}
+		r.Stats = append(r.Stats, newMonteCarloStats(quantity.name, values))
+	}
+	var BR, BT, LTOF []float64
+	for _, run := range r.Runs {
+		if run.Error == "" && run.Hyperbolic {
+			BR, BT, LTOF = append(BR, run.BR), append(BT, run.BT), append(LTOF, run.LTOF)
+		}
+	}
+	r.BPlane = nil
+	if len(BR) == 0 {
+		return
+	}
+	r.Stats = append(r.Stats, newMonteCarloStats("BR", BR), newMonteCarloStats("BT", BT), newMonteCarloStats("LTOF", LTOF))
+	ltofStats := newMonteCarloStats("LTOF", LTOF)
+	r.BPlane = &BPlaneDispersion{len(BR), sampleBPlaneEllipse(BR, BT), ltofStats.Mean, ltofStats.Std}
+}
+
+// sampleBPlaneEllipse returns the mean B-plane of the provided samples and the ellipse of their sample covariance.
+func sampleBPlaneEllipse(BR, BT []float64) BPlaneEllipse {
+	meanBR, meanBT := mean(BR), mean(BT)
+	covar := mat64.NewSymDense(2, nil)
+	for k := range BR {
+		dT, dR := BT[k]-meanBT, BR[k]-meanBR
+		covar.SetSym(0, 0, covar.At(0, 0)+dT*dT)
+		covar.SetSym(0, 1, covar.At(0, 1)+dT*dR)
+		covar.SetSym(1, 1, covar.At(1, 1)+dR*dR)
+	}
+	if n := float64(len(BR) - 1); n > 0 {
+		covar.ScaleSym(1/n, covar)
+	}
+	return newBPlaneEllipse(meanBR, meanBT, covar)
+}
+
+// newMonteCarloStats returns the statistics of the provided values.
+func newMonteCarloStats(name string, values []float64) MonteCarloStats {
+	stats := MonteCarloStats{Name: name}
+	if len(values) == 0 {
+		return stats
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	stats.Mean = mean(sorted)
+	for _, value := range sorted {
+		stats.Std += (value - stats.Mean) * (value - stats.Mean)
+	}
+	if len(sorted) > 1 {
+		stats.Std = math.Sqrt(stats.Std / float64(len(sorted)-1))
+	}
+	stats.Min, stats.Max = sorted[0], sorted[len(sorted)-1]
+	stats.P1, stats.P5, stats.P50 = percentile(sorted, 1), percentile(sorted, 5), percentile(sorted, 50)
+	stats.P95, stats.P99 = percentile(sorted, 95), percentile(sorted, 99)
+	return stats
+}
+
+// unwrapAngles returns the provided angles (in degrees) within 180 degrees of their circular mean, which is within
+// [0, 360), so that the runs wrapping through 0 or 360 degrees are not spread over the whole circle.
+func unwrapAngles(angles []float64) []float64 {
+	var sinSum, cosSum float64
+	for _, angle := range angles {
+		s, c := math.Sincos(Deg2rad(angle))
+		sinSum += s
+		cosSum += c
+	}
+	circMean := math.Mod(math.Atan2(sinSum, cosSum)/deg2rad+360, 360)
+	unwrapped := make([]float64, len(angles))
+	for i, angle := range angles {
+		unwrapped[i] = circMean + math.Remainder(angle-circMean, 360)
+	}
+	return unwrapped
+}
+
+// mean returns the mean of the provided values.
+func mean(values []float64) (m float64) {
+	for _, value := range values {
+		m += value
+	}
+	return m / float64(len(values))
+}
+
+// percentile returns the provided percentile of the sorted values, linearly interpolated between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// ExportJSON writes the result to the provided JSON file.
+func (r MonteCarloResult) ExportJSON(filename string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// ExportCSV writes the runs to the provided CSV file, and the statistics to the same file with a `-stats` suffix.
+func (r MonteCarloResult) ExportCSV(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "# Creation date (UTC): %s\n# Seed: %d\n", time.Now().UTC(), r.Seed)
+	fmt.Fprint(f, "run,seed,error,dryMass,fuelMass,Cr,deltaV,fuel,x,y,z,vx,vy,vz,sma,ecc,inc,RAAN,argPeri,tAnomaly,BR,BT,LTOF\n")
+	for _, run := range r.Runs {
+		if run.Error != "" {
+			fmt.Fprintf(f, "%d,%d,%q%s\n", run.Run, run.Seed, run.Error, strings.Repeat(",", 20))
+			continue
+		}
+		fmt.Fprintf(f, "%d,%d,,%f,%f,%f,%.9f,%f,%f,%f,%f,%.9f,%.9f,%.9f,%f,%.9f,%f,%f,%f,%f,", run.Run, run.Seed, run.DryMass,
+			run.FuelMass, run.Cr, run.Δv, run.Fuel, run.R[0], run.R[1], run.R[2], run.V[0], run.V[1], run.V[2], run.SMA,
+			run.Ecc, run.Inc, run.RAAN, run.ArgPeri, run.TrueAnomaly)
+		if run.Hyperbolic {
+			fmt.Fprintf(f, "%f,%f,%f\n", run.BR, run.BT, run.LTOF)
+		} else {
+			fmt.Fprint(f, ",,\n")
+		}
+	}
+	stats, err := os.Create(csvSuffix(filename, "-stats"))
+	if err != nil {
+		return err
+	}
+	defer stats.Close()
+	fmt.Fprint(stats, "name,mean,std,min,p1,p5,p50,p95,p99,max\n")
+	for _, s := range r.Stats {
+		fmt.Fprintf(stats, "%s,%.9f,%.9f,%.9f,%.9f,%.9f,%.9f,%.9f,%.9f,%.9f\n", s.Name, s.Mean, s.Std, s.Min, s.P1, s.P5, s.P50, s.P95, s.P99, s.Max)
+	}
+	return nil
+}
+
+// csvSuffix returns the provided file name with the provided suffix before its .csv extension (if any).
+func csvSuffix(filename, suffix string) string {
+	if len(filename) > 4 && filename[len(filename)-4:] == ".csv" {
+		return filename[:len(filename)-4] + suffix + ".csv"
+	}
+	return filename + suffix
+}
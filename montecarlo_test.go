@@ -0,0 +1,203 @@
+package smd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+	"github.com/gonum/matrix/mat64"
+	"github.com/spf13/viper"
+)
+
+// testMonteCarlo returns an analysis of a maneuver of a GEO spacecraft, or of a hyperbolic escape about the Earth.
+func testMonteCarlo(hyperbolic bool, disp Dispersions, workers int) MonteCarlo {
+	return MonteCarlo{
+		Nominal: func() (*Spacecraft, *Orbit) {
+			sc := NewSpacecraft("mc", 500, 100, NewUnlimitedEPS(), []EPThruster{}, true, []*Cargo{}, []Waypoint{})
+			if hyperbolic {
+				return sc, NewOrbitFromRV([]float64{7000, 0, 0}, []float64{0, 12, 0.5}, Earth)
+			}
+			sc.Maneuvers[testStart.Add(10*time.Minute)] = NewManeuver(0, 0.1, 0)
+			return sc, testGEO()
+		},
+		Start:       testStart,
+		End:         testStart.Add(time.Hour),
+		Step:        time.Minute,
+		Dispersions: disp,
+		Runs:        12,
+		Workers:     workers,
+		Seed:        42,
+	}
+}
+
+func TestMonteCarlo(t *testing.T) {
+	if _, err := (MonteCarlo{Runs: 1}).Run(); err == nil {
+		t.Fatal("analysis without nominal accepted")
+	}
+	// Without dispersion, all the runs are the nominal one.
+	nominal, err := testMonteCarlo(false, Dispersions{}, 0).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stats := range nominal.Stats {
+		if stats.Std > 1e-9 || stats.Min != stats.Max {
+			t.Fatalf("dispersed %s without dispersion: %+v", stats.Name, stats)
+		}
+	}
+	if nominal.BPlane != nil || nominal.Stats[6].Name != "deltaV" || math.Abs(nominal.Stats[6].Mean-0.1) > 1e-12 {
+		t.Fatalf("invalid nominal result %+v", nominal.Stats)
+	}
+
+	P0 := mat64.NewSymDense(6, nil)
+	for i := 0; i < 3; i++ {
+		P0.SetSym(i, i, 1)
+		P0.SetSym(i+3, i+3, 1e-8)
+	}
+	disp := Dispersions{P0: P0, BurnMagnitude: 0.05, BurnPointing: 1, DryMass: 5, FuelMass: 1, Cr: 0.1}
+	serial, err := testMonteCarlo(false, disp, 1).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parallel, err := testMonteCarlo(false, disp, 4).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The runs do not depend on the number of workers.
+	for i, run := range serial.Runs {
+		if run.Error != "" || run.Seed != StreamSeed(42, i) {
+			t.Fatalf("invalid run %+v", run)
+		}
+		if !floats.Equal(run.R, parallel.Runs[i].R) || run.Δv != parallel.Runs[i].Δv || run.DryMass != parallel.Runs[i].DryMass {
+			t.Fatalf("run #%d differs with parallel workers", i)
+		}
+	}
+	for _, stats := range serial.Stats {
+		if stats.Name == "fuel" {
+			continue // Impulsive maneuvers only
+		}
+		if stats.Std == 0 || stats.Min > stats.P5 || stats.P5 > stats.P50 || stats.P50 > stats.P95 || stats.P95 > stats.P99 || stats.P99 > stats.Max {
+			t.Fatalf("invalid statistics %+v", stats)
+		}
+	}
+
+	hyperbolic, err := testMonteCarlo(true, disp, 0).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hyperbolic.BPlane == nil || hyperbolic.BPlane.Runs != 12 || hyperbolic.BPlane.SemiMaj < hyperbolic.BPlane.SemiMin || hyperbolic.BPlane.SemiMin <= 0 {
+		t.Fatalf("invalid B-plane dispersion %+v", hyperbolic.BPlane)
+	}
+
+	// Exports
+	f, err := ioutil.TempFile("", "mc*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	defer os.Remove(csvSuffix(f.Name(), "-stats"))
+	if err = hyperbolic.ExportCSV(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(f.Name())
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 3+12 || strings.Count(lines[3], ",") != 22 {
+		t.Fatalf("invalid CSV export\n%s", data)
+	}
+	data, _ = ioutil.ReadFile(csvSuffix(f.Name(), "-stats"))
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1+len(hyperbolic.Stats) {
+		t.Fatalf("invalid CSV statistics\n%s", data)
+	}
+	if err = hyperbolic.ExportJSON(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadFile(f.Name())
+	var imported MonteCarloResult
+	if err = json.Unmarshal(data, &imported); err != nil || len(imported.Runs) != 12 || imported.BPlane.BT != hyperbolic.BPlane.BT {
+		t.Fatalf("invalid JSON export (%v)", err)
+	}
+}
+
+func TestBurnError(t *testing.T) {
+	Δv := []float64{0.1, -0.2, 0.05}
+	if rtn := (Dispersions{}).burnError(Δv, NewRand(42)); !floats.EqualApprox(rtn, Δv, 1e-15) {
+		t.Fatalf("burn error %v without dispersion", rtn)
+	}
+	rng := NewRand(42)
+	pointing := Dispersions{BurnPointing: 1}
+	magnitude := Dispersions{BurnMagnitude: 0.01}
+	var sumθ2, sumε2 float64
+	for i := 0; i < 1000; i++ {
+		rtn := pointing.burnError(Δv, rng)
+		if math.Abs(Norm(rtn)-Norm(Δv)) > 1e-12 {
+			t.Fatal("pointing error changed the magnitude")
+		}
+		θ := Rad2deg(math.Acos(math.Min(Dot(Unit(rtn), Unit(Δv)), 1)))
+		sumθ2 += θ * θ
+		rtn = magnitude.burnError(Δv, rng)
+		if !floats.EqualWithinAbs(Dot(Unit(rtn), Unit(Δv)), 1, 1e-12) {
+			t.Fatal("magnitude error changed the direction")
+		}
+		ε := Norm(rtn)/Norm(Δv) - 1
+		sumε2 += ε * ε
+	}
+	if σθ := math.Sqrt(sumθ2 / 1000); math.Abs(σθ-1) > 0.1 {
+		t.Fatalf("pointing σ of %f degrees instead of 1", σθ)
+	}
+	if σε := math.Sqrt(sumε2 / 1000); math.Abs(σε-0.01) > 1e-3 {
+		t.Fatalf("relative magnitude σ of %f instead of 0.01", σε)
+	}
+}
+
+func TestMonteCarloStats(t *testing.T) {
+	stats := newMonteCarloStats("test", []float64{5, 1, 4, 2, 3})
+	if stats.Mean != 3 || stats.Min != 1 || stats.Max != 5 || stats.P50 != 3 || math.Abs(stats.P5-1.2) > 1e-12 || math.Abs(stats.Std-math.Sqrt(2.5)) > 1e-12 {
+		t.Fatalf("invalid statistics %+v", stats)
+	}
+	// The angles wrapping through 0 degrees are not spread over the whole circle.
+	stats = newMonteCarloStats("angle", unwrapAngles([]float64{358, 2, 356, 4, 0}))
+	if math.Abs(stats.Max-stats.Min-8) > 1e-9 || math.Abs(stats.Std-math.Sqrt(10)) > 1e-9 || math.Abs(Rad2deg180(Deg2rad(stats.Mean))) > 1e-9 {
+		t.Fatalf("invalid angle statistics %+v", stats)
+	}
+	// The angles about 200 degrees are kept as is, as in the CSV export of each run.
+	clustered := []float64{195, 205, 200, 190, 210}
+	if unwrapped := unwrapAngles(clustered); !floats.EqualApprox(unwrapped, clustered, 1e-9) {
+		t.Fatalf("invalid unwrapped angles %v", unwrapped)
+	}
+	stats = newMonteCarloStats("angle", unwrapAngles([]float64{185, 175, 180, 170, 190}))
+	if math.Abs(stats.Mean-180) > 1e-9 || math.Abs(stats.Min-170) > 1e-9 || math.Abs(stats.Max-190) > 1e-9 {
+		t.Fatalf("invalid angle statistics %+v", stats)
+	}
+	semiMaj, semiMin, angle := errorEllipse(mat64.NewSymDense(2, []float64{4, 0, 0, 1}))
+	if semiMaj != 2 || semiMin != 1 || angle != 0 {
+		t.Fatalf("invalid ellipse %f x %f @ %f", semiMaj, semiMin, angle)
+	}
+	semiMaj, semiMin, angle = errorEllipse(mat64.NewSymDense(2, []float64{2, 1, 1, 2}))
+	if math.Abs(semiMaj-math.Sqrt(3)) > 1e-12 || math.Abs(semiMin-1) > 1e-12 || math.Abs(angle-45) > 1e-12 {
+		t.Fatalf("invalid ellipse %f x %f @ %f", semiMaj, semiMin, angle)
+	}
+}
+
+func TestDispersionsFromConfig(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(strings.NewReader(`[dispersions]
+position = 2.0
+velocity = 1e-3
+burn_pointing = 0.5
+Cr = 0.1`)); err != nil {
+		t.Fatal(err)
+	}
+	disp, err := DispersionsFromConfig(v, "dispersions")
+	if err != nil || disp.P0 == nil || disp.P0.At(0, 0) != 4 || math.Abs(disp.P0.At(5, 5)-1e-6) > 1e-18 || disp.BurnPointing != 0.5 || disp.Cr != 0.1 || disp.Thrust != 0 {
+		t.Fatalf("invalid dispersions %+v (%v)", disp, err)
+	}
+	v.Set("dispersions.dry_mass", -1)
+	if _, err = DispersionsFromConfig(v, "dispersions"); err == nil {
+		t.Fatal("negative σ accepted")
+	}
+}
@@ -0,0 +1,285 @@
+package smd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+/* Ground station networks, terrain masks and availability windows. */
+
+// MaskPoint is the minimum elevation at a given azimuth, both in degrees.
+type MaskPoint struct {
+	Azimuth, Elevation float64
+}
+
+// ElevationMask is a terrain mask defined by points sorted by azimuth, which are linearly interpolated
+// (and wrap around North).
+type ElevationMask []MaskPoint
+
+// NewElevationMask returns a new elevation mask from the provided points, in any order.
+func NewElevationMask(points ...MaskPoint) ElevationMask {
+	mask := make(ElevationMask, len(points))
+	for i, pt := range points {
+		mask[i] = MaskPoint{math.Mod(math.Mod(pt.Azimuth, 360)+360, 360), pt.Elevation}
+	}
+	sort.Slice(mask, func(i, j int) bool { return mask[i].Azimuth < mask[j].Azimuth })
+	return mask
+}
+
+// At returns the minimum elevation at the provided azimuth (in degrees).
+func (m ElevationMask) At(az float64) float64 {
+	if len(m) == 0 {
+		return -90
+	}
+	az = math.Mod(math.Mod(az, 360)+360, 360)
+	// Find the points around this azimuth, wrapping around North.
+	next := sort.Search(len(m), func(i int) bool { return m[i].Azimuth >= az })
+	prev := next - 1
+	prevAz := 0.
+	nextAz := 0.
+	if next == len(m) {
+		next = 0
+		nextAz = 360
+	}
+	if prev < 0 {
+		prev = len(m) - 1
+		prevAz = -360
+	}
+	prevAz += m[prev].Azimuth
+	nextAz += m[next].Azimuth
+	if nextAz == prevAz {
+		return m[next].Elevation
+	}
+	return m[prev].Elevation + (m[next].Elevation-m[prev].Elevation)*(az-prevAz)/(nextAz-prevAz)
+}
+
+// TimeWindow is a time span, e.g. a tracking pass.
+type TimeWindow struct {
+	Start, End time.Time
+}
+
+// Contains returns whether the provided time is within this window (inclusive).
+func (w TimeWindow) Contains(dt time.Time) bool {
+	return !dt.Before(w.Start) && !dt.After(w.End)
+}
+
+func (w TimeWindow) String() string {
+	return fmt.Sprintf("%s -> %s", w.Start.UTC(), w.End.UTC())
+}
+
+// ParseStationNetwork parses a TOML station network where each station is defined in its own `[station.<key>]`
+// section with the following keys: name (defaults to the key), planet (defaults to Earth), latitude, longitude
+// (geodetic, in degrees), altitude (km), elevation (minimum elevation in degrees), range_sigma, rate_sigma,
+// mask (list of [azimuth, elevation] pairs in degrees), min_range and max_range (km), and windows (list of
+// [start, end] epochs, cf. ParseEpoch). The measurement types are set with `types` (e.g. ["ra", "dec"], cf.
+// MeasurementTypeFromString) and their variances with `<type>_sigma`, and the link with `link` (one-way, two-way or
+// three-way), `transmitter` (key of the transmitting station of three-way links), `frequency` (uplink, in Hz),
+// `range_modulus` (in RU) and `count_time` (e.g. "60s"). The noise of a station is drawn from its own `seed` if set.
+// The stations are returned by their key (in lower case).
+func ParseStationNetwork(r io.Reader) (map[string]Station, error) {
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(r); err != nil {
+		return nil, fmt.Errorf("invalid station network: %s", err)
+	}
+	stations := make(map[string]Station)
+	for key := range v.GetStringMap("station") {
+		st, err := StationFromConfig(v, key)
+		if err != nil {
+			return nil, fmt.Errorf("station `%s`: %s", key, err)
+		}
+		stations[strings.ToLower(key)] = st
+	}
+	if len(stations) == 0 {
+		return nil, fmt.Errorf("no station defined in the network")
+	}
+	for key, st := range stations {
+		if st.Link.Type != ThreeWay {
+			continue
+		}
+		txKey := strings.ToLower(v.GetString("station." + key + ".transmitter"))
+		tx, found := stations[txKey]
+		if !found || txKey == key {
+			return nil, fmt.Errorf("station `%s`: invalid transmitter `%s`", key, txKey)
+		}
+		st.Link.Transmitter = &tx
+		stations[key] = st
+	}
+	return stations, nil
+}
+
+// LoadStationNetwork loads a station network from the provided file, cf. ParseStationNetwork.
+func LoadStationNetwork(filename string) (map[string]Station, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseStationNetwork(f)
+}
+
+// StationFromConfig returns the station defined in the `[station.<key>]` section of the provided configuration, cf.
+// ParseStationNetwork. The transmitter of three-way links is not set.
+func StationFromConfig(v *viper.Viper, key string) (Station, error) {
+	prefix := "station." + key + "."
+	name := v.GetString(prefix + "name")
+	if name == "" {
+		name = key
+	}
+	planet := Earth
+	if planetName := v.GetString(prefix + "planet"); planetName != "" {
+		var err error
+		if planet, err = CelestialObjectFromString(planetName); err != nil {
+			return Station{}, err
+		}
+	}
+	for _, required := range []string{"latitude", "longitude", "range_sigma", "rate_sigma"} {
+		if !v.IsSet(prefix + required) {
+			return Station{}, fmt.Errorf("missing `%s`", required)
+		}
+	}
+	st := NewPlanetStation(planet, name, v.GetFloat64(prefix+"altitude"), v.GetFloat64(prefix+"elevation"),
+		v.GetFloat64(prefix+"latitude"), v.GetFloat64(prefix+"longitude"), v.GetFloat64(prefix+"range_sigma"),
+		v.GetFloat64(prefix+"rate_sigma"))
+	st.MinRange = v.GetFloat64(prefix + "min_range")
+	st.MaxRange = v.GetFloat64(prefix + "max_range")
+	if st.MaxRange > 0 && st.MaxRange < st.MinRange {
+		return Station{}, fmt.Errorf("max_range smaller than min_range")
+	}
+	if v.IsSet(prefix + "mask") {
+		pairs, err := configPairs(v.Get(prefix + "mask"))
+		if err != nil {
+			return Station{}, fmt.Errorf("invalid mask: %s", err)
+		}
+		points := make([]MaskPoint, len(pairs))
+		for i, pair := range pairs {
+			az, errAz := configFloat(pair[0])
+			el, errEl := configFloat(pair[1])
+			if errAz != nil || errEl != nil {
+				return Station{}, fmt.Errorf("invalid mask point %v", pair)
+			}
+			points[i] = MaskPoint{az, el}
+		}
+		st.Mask = NewElevationMask(points...)
+	}
+	if v.IsSet(prefix + "windows") {
+		pairs, err := configPairs(v.Get(prefix + "windows"))
+		if err != nil {
+			return Station{}, fmt.Errorf("invalid windows: %s", err)
+		}
+		for _, pair := range pairs {
+			start, errStart := configEpoch(pair[0])
+			end, errEnd := configEpoch(pair[1])
+			if errStart != nil || errEnd != nil {
+				return Station{}, fmt.Errorf("invalid window %v", pair)
+			}
+			if end.Before(start) {
+				return Station{}, fmt.Errorf("window %v ends before it starts", pair)
+			}
+			st.Windows = append(st.Windows, TimeWindow{start, end})
+		}
+	}
+	if err := linkFromConfig(v, prefix, &st); err != nil {
+		return Station{}, err
+	}
+	if v.IsSet(prefix + "seed") {
+		st.Rand = NewRand(v.GetInt64(prefix + "seed"))
+	}
+	return st, nil
+}
+
+// linkFromConfig sets the measurement types, their variances and the link of the station (except the transmitter).
+func linkFromConfig(v *viper.Viper, prefix string, st *Station) (err error) {
+	seen := make(map[MeasurementType]bool)
+	for _, name := range v.GetStringSlice(prefix + "types") {
+		t, err := MeasurementTypeFromString(name)
+		if err != nil {
+			return err
+		}
+		if seen[t] {
+			return fmt.Errorf("duplicate measurement type `%s`", t)
+		}
+		seen[t] = true
+		st.Types = append(st.Types, t)
+	}
+	// Each station has a single range unit and angle type, cf. TDMSegment.
+	if seen[MeasRange] && seen[MeasDSNRange] {
+		return fmt.Errorf("cannot measure both the range and the DSN range")
+	}
+	if (seen[MeasRightAscension] || seen[MeasDeclination]) && (seen[MeasAzimuth] || seen[MeasElevation]) {
+		return fmt.Errorf("cannot measure both RA/Dec and az/el angles")
+	}
+	for _, t := range st.Types {
+		if t == MeasRange || t == MeasRangeRate {
+			continue // Already set from range_sigma and rate_sigma.
+		}
+		if key := prefix + t.String() + "_sigma"; v.IsSet(key) {
+			if st.Variances == nil {
+				st.Variances = make(map[MeasurementType]float64)
+			}
+			st.Variances[t] = v.GetFloat64(key)
+		}
+	}
+	if st.Link.Type, err = LinkTypeFromString(v.GetString(prefix + "link")); err != nil {
+		return err
+	}
+	if st.Link.Type == ThreeWay && v.GetString(prefix+"transmitter") == "" {
+		return fmt.Errorf("missing `transmitter` of three-way link")
+	}
+	st.Link.Frequency = v.GetFloat64(prefix + "frequency")
+	st.Link.RangeModulus = v.GetFloat64(prefix + "range_modulus")
+	st.Link.CountTime = v.GetDuration(prefix + "count_time")
+	if seen[MeasDSNRange] && st.Link.Frequency <= 0 {
+		return fmt.Errorf("missing uplink `frequency` for the DSN range")
+	}
+	return nil
+}
+
+// configPairs returns the list of pairs from a TOML array of two-element arrays.
+func configPairs(value interface{}) ([][2]interface{}, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list, got %v", value)
+	}
+	pairs := make([][2]interface{}, len(list))
+	for i, item := range list {
+		pair, ok := item.([]interface{})
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("expected a pair, got %v", item)
+		}
+		pairs[i] = [2]interface{}{pair[0], pair[1]}
+	}
+	return pairs, nil
+}
+
+func configFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	default:
+		return 0, fmt.Errorf("expected a number, got %v", value)
+	}
+}
+
+func configEpoch(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC(), nil
+	case string:
+		e, err := ParseEpoch(v)
+		return e.UTC(), err
+	default:
+		return time.Time{}, fmt.Errorf("expected an epoch, got %v", value)
+	}
+}
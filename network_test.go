@@ -0,0 +1,88 @@
+package smd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+)
+
+func TestElevationMask(t *testing.T) {
+	mask := NewElevationMask(MaskPoint{270, 20}, MaskPoint{90, 10}, MaskPoint{-10, 0})
+	for _, exp := range []struct{ az, el float64 }{
+		{90, 10}, {180, 15}, {270, 20}, {310, 10}, {350, 0}, {0, 1}, {20, 3}, {450, 10}, {-90, 20},
+	} {
+		if el := mask.At(exp.az); !floats.EqualWithinAbs(el, exp.el, 1e-12) {
+			t.Fatalf("mask at %f: %f != %f", exp.az, el, exp.el)
+		}
+	}
+	if el := NewElevationMask(MaskPoint{45, 5}).At(200); el != 5 {
+		t.Fatalf("single point mask: %f", el)
+	}
+}
+
+func TestStationNetwork(t *testing.T) {
+	network := `
+[station.DSS34]
+name = "DSS34Canberra"
+latitude = -35.398333
+longitude = 148.981944
+altitude = 0.691750
+elevation = 6
+range_sigma = 0.1
+rate_sigma = 0.1
+mask = [[0, 10], [180, 30]]
+max_range = 40000
+windows = [["2017-01-01 00:00:00", "2017-01-01 08:00:00"], [2017-01-02T00:00:00Z, "2017-01-02 01:00:00 TAI"]]
+
+[station.lander]
+planet = "mars"
+latitude = 4.5
+longitude = 137.4
+range_sigma = 0.1
+rate_sigma = 0.1
+`
+	stations, err := ParseStationNetwork(strings.NewReader(network))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dss34, lander := stations["dss34"], stations["lander"]
+	if len(stations) != 2 || dss34.Name != "DSS34Canberra" || lander.Name != "lander" || !lander.Planet.Equals(Mars) {
+		t.Fatalf("invalid network: %+v", stations)
+	}
+	if !floats.EqualApprox(dss34.R, DSS34Canberra.R, 1e-12) || len(dss34.Mask) != 2 || dss34.MaxRange != 40000 || len(dss34.Windows) != 2 {
+		t.Fatalf("invalid station: %+v", dss34)
+	}
+	if end := dss34.Windows[1].End; !end.Equal(time.Date(2017, 1, 2, 0, 59, 23, 0, time.UTC)) {
+		t.Fatalf("invalid window end: %s", end)
+	}
+	inWindow := time.Date(2017, 1, 1, 4, 0, 0, 0, time.UTC)
+	for _, exp := range []struct {
+		dt        time.Time
+		ρ, el, az float64
+		visible   bool
+	}{
+		{inWindow, 1000, 20, 0, true},
+		{inWindow, 1000, 8, 0, false},    // Below the mask
+		{inWindow, 1000, 20, 180, false}, // Below the mask
+		{inWindow, 1000, 5, 350, false},  // Below the elevation
+		{inWindow, 50000, 20, 0, false},  // Too far
+		{time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC), 1000, 20, 0, false},
+	} {
+		if visible := dss34.IsVisible(exp.dt, exp.ρ, exp.el, exp.az); visible != exp.visible {
+			t.Fatalf("visibility at %s (ρ=%f el=%f az=%f): %v", exp.dt, exp.ρ, exp.el, exp.az, visible)
+		}
+	}
+	for _, invalid := range []string{
+		"",
+		"[station.x]\nlatitude = 0\nlongitude = 0\nrange_sigma = 0.1",
+		"[station.x]\nlatitude = 0\nlongitude = 0\nrange_sigma = 0.1\nrate_sigma = 0.1\nplanet = \"Vulcan\"",
+		"[station.x]\nlatitude = 0\nlongitude = 0\nrange_sigma = 0.1\nrate_sigma = 0.1\nmask = [[0]]",
+		"[station.x]\nlatitude = 0\nlongitude = 0\nrange_sigma = 0.1\nrate_sigma = 0.1\nwindows = [[\"2017-01-02\", \"2017-01-01\"]]",
+	} {
+		if _, err := ParseStationNetwork(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("invalid network accepted:\n%s", invalid)
+		}
+	}
+}
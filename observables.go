@@ -0,0 +1,434 @@
+package smd
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+	"github.com/gonum/stat/distmv"
+)
+
+/* Measurement types and tracking links. Algorithms from Moyer, "Formulation for observed and computed values of Deep
+Space Network data types for navigation", 2003, and Vallado, 4th edition, section 4.4. */
+
+// SpeedOfLight is the speed of light in vacuum, in km/s.
+const SpeedOfLight = 299792.458
+
+// MeasurementType defines the observable of a measurement.
+type MeasurementType uint8
+
+const (
+	// MeasRange is the range of the link in km.
+	MeasRange MeasurementType = iota
+	// MeasRangeRate is the instantaneous range rate of the link in km/s.
+	MeasRangeRate
+	// MeasRightAscension is the topocentric inertial right ascension in degrees.
+	MeasRightAscension
+	// MeasDeclination is the topocentric inertial declination in degrees.
+	MeasDeclination
+	// MeasAzimuth is the azimuth (from North, positive East) in degrees.
+	MeasAzimuth
+	// MeasElevation is the elevation above the local horizon in degrees.
+	MeasElevation
+	// MeasIntegratedDoppler is the range rate averaged over the count time of the link (in km/s), i.e. the range
+	// difference over that time.
+	MeasIntegratedDoppler
+	// MeasDSNRange is the DSN sequential range in range units (RU), modulo the range modulus of the link.
+	MeasDSNRange
+)
+
+func (t MeasurementType) String() string {
+	switch t {
+	case MeasRange:
+		return "range"
+	case MeasRangeRate:
+		return "rate"
+	case MeasRightAscension:
+		return "ra"
+	case MeasDeclination:
+		return "dec"
+	case MeasAzimuth:
+		return "az"
+	case MeasElevation:
+		return "el"
+	case MeasIntegratedDoppler:
+		return "doppler"
+	case MeasDSNRange:
+		return "dsnrange"
+	default:
+		panic("unknown measurement type")
+	}
+}
+
+// MeasurementTypeFromString returns the measurement type from its name (cf. String).
+func MeasurementTypeFromString(name string) (MeasurementType, error) {
+	switch strings.ToLower(strings.Replace(name, "_", "", -1)) {
+	case "range", "rho":
+		return MeasRange, nil
+	case "rate", "rangerate", "rhodot", "instantaneousdoppler":
+		return MeasRangeRate, nil
+	case "ra", "rightascension":
+		return MeasRightAscension, nil
+	case "dec", "declination":
+		return MeasDeclination, nil
+	case "az", "azimuth":
+		return MeasAzimuth, nil
+	case "el", "elevation":
+		return MeasElevation, nil
+	case "doppler", "integrateddoppler":
+		return MeasIntegratedDoppler, nil
+	case "dsnrange", "sequentialrange":
+		return MeasDSNRange, nil
+	default:
+		return MeasRange, fmt.Errorf("unknown measurement type `%s`", name)
+	}
+}
+
+// Difference returns the observed minus computed value of this type, where the angles which wrap around (right
+// ascension and azimuth) are returned between -180 and 180 degrees.
+func (t MeasurementType) Difference(observed, computed float64) float64 {
+	δ := observed - computed
+	if t == MeasRightAscension || t == MeasAzimuth {
+		δ = math.Mod(δ, 360)
+		if δ > 180 {
+			δ -= 360
+		} else if δ < -180 {
+			δ += 360
+		}
+	}
+	return δ
+}
+
+// LinkType defines the participants of a tracking link.
+type LinkType uint8
+
+const (
+	// OneWay links are received by the station from the spacecraft.
+	OneWay LinkType = iota
+	// TwoWay links are transmitted by the station to the spacecraft, which transponds it back to the same station.
+	TwoWay
+	// ThreeWay links are transmitted by another station and received by this one.
+	ThreeWay
+)
+
+func (l LinkType) String() string {
+	switch l {
+	case OneWay:
+		return "one-way"
+	case TwoWay:
+		return "two-way"
+	case ThreeWay:
+		return "three-way"
+	default:
+		panic("unknown link type")
+	}
+}
+
+// LinkTypeFromString returns the link type from its name, e.g. "two-way" or "2".
+func LinkTypeFromString(name string) (LinkType, error) {
+	switch strings.ToLower(strings.Replace(strings.Replace(name, "-", "", -1), " ", "", -1)) {
+	case "oneway", "1", "":
+		return OneWay, nil
+	case "twoway", "2":
+		return TwoWay, nil
+	case "threeway", "3":
+		return ThreeWay, nil
+	default:
+		return OneWay, fmt.Errorf("unknown link type `%s`", name)
+	}
+}
+
+// Link defines how a station tracks the spacecraft.
+// The range and range rate of two and three-way links are the average of the up and down legs.
+type Link struct {
+	Type         LinkType
+	Transmitter  *Station      // Transmitting station of three-way links
+	Frequency    float64       // Uplink frequency in Hz (needed for the DSN range)
+	RangeModulus float64       // Modulus of the DSN range in RU (none if zero)
+	CountTime    time.Duration // Count time of the integrated Doppler (defaults to 60 seconds)
+}
+
+// countTime returns the count time of the integrated Doppler in seconds.
+func (l Link) countTime() float64 {
+	if l.CountTime == 0 {
+		return 60
+	}
+	return l.CountTime.Seconds()
+}
+
+// rangeUnitFactor returns the number of range units per cycle of the uplink frequency, which depends on its band
+// (S, X or Ka), cf. DSN 810-005 module 214.
+func (l Link) rangeUnitFactor() float64 {
+	switch {
+	case l.Frequency == 0:
+		panic("DSN range requires the uplink frequency of the link")
+	case l.Frequency < 7e9:
+		return 0.5
+	case l.Frequency < 2e10:
+		return 221. / (749 * 2)
+	default:
+		return 221. / (3599 * 2)
+	}
+}
+
+// MeasurementTypes returns the types of the measurements of this station (range and range rate by default).
+func (s Station) MeasurementTypes() []MeasurementType {
+	if len(s.Types) == 0 {
+		return []MeasurementType{MeasRange, MeasRangeRate}
+	}
+	return s.Types
+}
+
+// legs returns the stations of each leg of the link: the transmitter of three-way links, and this station.
+func (s Station) legs() []Station {
+	if s.Link.Type == ThreeWay {
+		if s.Link.Transmitter == nil {
+			panic(fmt.Errorf("three-way link of %s without a transmitter", s.Name))
+		}
+		return []Station{*s.Link.Transmitter, s}
+	}
+	return []Station{s}
+}
+
+// inertialState returns the inertial position and velocity of this station at the provided epoch.
+func (s Station) inertialState(epoch time.Time) ([]float64, []float64) {
+	return bodyFixed2Inertial(s.R, []float64{0, 0, 0}, epoch, s.Planet)
+}
+
+// linkLeg is a leg of a link: the signal between a station at a given epoch and the spacecraft.
+type linkLeg struct {
+	station      Station
+	epoch        time.Time
+	rS, vS       []float64 // Inertial state of the station
+	rSc, vSc     []float64 // Inertial state of the spacecraft
+	lightTimeSec float64
+}
+
+// linkLegs returns the down leg (received by this station at the provided epoch) and, for two and three-way links,
+// the up leg of the link with a spacecraft at the provided inertial state. The epochs and states account for the
+// light time if this correction is enabled.
+func (s Station) linkLegs(epoch time.Time, R, V []float64, μ float64) []linkLeg {
+	rS, vS := s.inertialState(epoch)
+	down := linkLeg{s, epoch, rS, vS, R, V, 0}
+	if s.Corrections.LightTime {
+		// The spacecraft state when the signal was transmitted.
+		for i := 0; i < 10; i++ {
+			τ := distance(down.rSc, rS) / SpeedOfLight
+			if math.Abs(τ-down.lightTimeSec) < 1e-12 {
+				break
+			}
+			down.lightTimeSec = τ
+			down.rSc, down.vSc = KeplerPropagate(R, V, μ, -τ)
+		}
+	}
+	if s.Link.Type == OneWay {
+		return []linkLeg{down}
+	}
+	tx := s.legs()[0]
+	bounce := epoch.Add(-time.Duration(down.lightTimeSec * 1e9))
+	rT, vT := tx.inertialState(bounce)
+	up := linkLeg{tx, bounce, rT, vT, down.rSc, down.vSc, 0}
+	if s.Corrections.LightTime {
+		// The transmitting station state when the signal was transmitted.
+		for i := 0; i < 10; i++ {
+			τ := distance(up.rSc, up.rS) / SpeedOfLight
+			if math.Abs(τ-up.lightTimeSec) < 1e-12 {
+				break
+			}
+			up.lightTimeSec = τ
+			up.epoch = bounce.Add(-time.Duration(τ * 1e9))
+			up.rS, up.vS = tx.inertialState(up.epoch)
+		}
+	}
+	return []linkLeg{up, down}
+}
+
+// linkObservables stores the range and range rate of a link, and their partials with respect to the position and
+// velocity of the spacecraft.
+type linkObservables struct {
+	ρ, ρDot      float64
+	ρR, ρV       []float64
+	ρDotR, ρDotV []float64
+}
+
+// linkRange returns the range and range rate of the link with a spacecraft at the provided inertial state, and their
+// partials. The range of two and three-way links is the average of both legs. The media delays are added to the
+// range, or subtracted for the ionosphere if the phase range is requested (e.g. for the integrated Doppler).
+// With the light time, the spacecraft state at the bounce is approximated as r - τv for the partials.
+func (s Station) linkRange(epoch time.Time, R, V []float64, μ float64, phase bool) (l linkObservables) {
+	l.ρR = make([]float64, 3)
+	l.ρV = make([]float64, 3)
+	l.ρDotR = make([]float64, 3)
+	l.ρDotV = make([]float64, 3)
+	legs := s.linkLegs(epoch, R, V, μ)
+	τ := legs[len(legs)-1].lightTimeSec
+	w := 1 / float64(len(legs))
+	for _, leg := range legs {
+		ρVec := make([]float64, 3)
+		vRel := make([]float64, 3)
+		for i := 0; i < 3; i++ {
+			ρVec[i] = leg.rSc[i] - leg.rS[i]
+			vRel[i] = leg.vSc[i] - leg.vS[i]
+		}
+		ρLeg := Norm(ρVec)
+		ρDotLeg := Dot(ρVec, vRel) / ρLeg
+		l.ρ += w * (ρLeg + s.mediaDelay(leg, phase))
+		l.ρDot += w * ρDotLeg
+		for i := 0; i < 3; i++ {
+			ρR := ρVec[i] / ρLeg
+			ρDotR := vRel[i]/ρLeg - ρDotLeg*ρVec[i]/(ρLeg*ρLeg)
+			l.ρR[i] += w * ρR
+			l.ρV[i] -= w * τ * ρR
+			l.ρDotR[i] += w * ρDotR
+			l.ρDotV[i] += w * (ρR - τ*ρDotR)
+		}
+	}
+	return
+}
+
+// distance returns the norm of a-b.
+func distance(a, b []float64) float64 {
+	return Norm([]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]})
+}
+
+// bodyFixedMatrix returns the rotation from the inertial frame to the body fixed frame of the station planet.
+func (s Station) bodyFixedMatrix(epoch time.Time) *mat64.Dense {
+	M := mat64.NewDense(3, 3, nil)
+	for j := 0; j < 3; j++ {
+		e := []float64{0, 0, 0}
+		e[j] = 1
+		col, _ := inertial2BodyFixed(e, []float64{0, 0, 0}, epoch, s.Planet)
+		for i := 0; i < 3; i++ {
+			M.Set(i, j, col[i])
+		}
+	}
+	return M
+}
+
+// Observe returns the true value of the provided measurement type of a spacecraft in the provided orbit, and its
+// partials with respect to the inertial position and velocity of the spacecraft, with the corrections of the station.
+// The partials ignore the corrections, except that they are evaluated at the light time corrected geometry.
+func (s Station) Observe(t MeasurementType, epoch time.Time, o Orbit) (value float64, hR, hV []float64) {
+	R, V := o.RV()
+	μ := o.Origin.μ
+	hV = make([]float64, 3)
+	switch t {
+	case MeasRange:
+		l := s.linkRange(epoch, R, V, μ, false)
+		value, hR, hV = l.ρ, l.ρR, l.ρV
+	case MeasRangeRate:
+		l := s.linkRange(epoch, R, V, μ, false)
+		value, hR, hV = l.ρDot, l.ρDotR, l.ρDotV
+	case MeasDSNRange:
+		l := s.linkRange(epoch, R, V, μ, false)
+		// The round trip light time times the uplink frequency.
+		k := 2 * s.Link.rangeUnitFactor() * s.Link.Frequency / SpeedOfLight
+		value = k * l.ρ
+		if s.Link.RangeModulus > 0 {
+			value = math.Mod(value, s.Link.RangeModulus)
+		}
+		hR = []float64{k * l.ρR[0], k * l.ρR[1], k * l.ρR[2]}
+		hV = []float64{k * l.ρV[0], k * l.ρV[1], k * l.ρV[2]}
+	case MeasIntegratedDoppler:
+		// The range at the start of the count is computed with a two body propagation, whose STM is approximated
+		// as [[I, -Tc I], [0, I]] for the partials.
+		Tc := s.Link.countTime()
+		l := s.linkRange(epoch, R, V, μ, true)
+		R0, V0 := KeplerPropagate(R, V, μ, -Tc)
+		l0 := s.linkRange(epoch.Add(-time.Duration(Tc*1e9)), R0, V0, μ, true)
+		value = (l.ρ - l0.ρ) / Tc
+		hR = make([]float64, 3)
+		for i := 0; i < 3; i++ {
+			hR[i] = (l.ρR[i] - l0.ρR[i]) / Tc
+			hV[i] = l0.ρR[i] + (l.ρV[i]-l0.ρV[i])/Tc
+		}
+	case MeasRightAscension, MeasDeclination:
+		ρVec, τ := s.lineOfSight(epoch, R, V, μ)
+		value, hR = raDecPartials(t == MeasRightAscension, ρVec)
+		hV = []float64{-τ * hR[0], -τ * hR[1], -τ * hR[2]}
+	case MeasAzimuth, MeasElevation:
+		var SEZ, D mat64.Dense
+		SEZ.Mul(R2(math.Pi/2-s.LatΦ), R3(s.Longθ))
+		D.Mul(&SEZ, s.bodyFixedMatrix(epoch))
+		ρVec, τ := s.lineOfSight(epoch, R, V, μ)
+		ρSEZ := MxV33(&D, ρVec)
+		var hSEZ []float64
+		if t == MeasAzimuth {
+			ρ2 := ρSEZ[0]*ρSEZ[0] + ρSEZ[1]*ρSEZ[1]
+			value = math.Mod(math.Atan2(ρSEZ[1], -ρSEZ[0])*r2d+360, 360)
+			hSEZ = []float64{r2d * ρSEZ[1] / ρ2, -r2d * ρSEZ[0] / ρ2, 0}
+		} else {
+			// The elevation is the declination in the SEZ frame.
+			value, hSEZ = raDecPartials(false, ρSEZ)
+		}
+		hR = MxV33(D.T(), hSEZ)
+		hV = []float64{-τ * hR[0], -τ * hR[1], -τ * hR[2]}
+	default:
+		panic(fmt.Errorf("unsupported measurement type %s", t))
+	}
+	return
+}
+
+// lineOfSight returns the inertial vector from this station to the spacecraft, from its light time corrected position
+// and with the aberration if these corrections are enabled, and the light time in seconds.
+func (s Station) lineOfSight(epoch time.Time, R, V []float64, μ float64) ([]float64, float64) {
+	down := s.linkLegs(epoch, R, V, μ)
+	leg := down[len(down)-1]
+	ρVec := []float64{leg.rSc[0] - leg.rS[0], leg.rSc[1] - leg.rS[1], leg.rSc[2] - leg.rS[2]}
+	if s.Corrections.Aberration {
+		ρ := Norm(ρVec)
+		u := aberration(Unit(ρVec), s.ssbVelocity(epoch))
+		for i := 0; i < 3; i++ {
+			ρVec[i] = ρ * u[i]
+		}
+	}
+	return ρVec, leg.lightTimeSec
+}
+
+// raDecPartials returns the right ascension (or declination) of the provided vector and its partials, in degrees.
+func raDecPartials(ra bool, ρ []float64) (float64, []float64) {
+	ρxy2 := ρ[0]*ρ[0] + ρ[1]*ρ[1]
+	if ra {
+		return math.Mod(math.Atan2(ρ[1], ρ[0])*r2d+360, 360), []float64{-r2d * ρ[1] / ρxy2, r2d * ρ[0] / ρxy2, 0}
+	}
+	ρ2 := ρxy2 + ρ[2]*ρ[2]
+	ρxy := math.Sqrt(ρxy2)
+	return math.Asin(ρ[2]/math.Sqrt(ρ2)) * r2d, []float64{-r2d * ρ[0] * ρ[2] / (ρ2 * ρxy), -r2d * ρ[1] * ρ[2] / (ρ2 * ρxy), r2d * ρxy / ρ2}
+}
+
+// noise returns a random noise for the provided measurement type from its variance (cf. variance), drawn from the
+// source of the station if set and from the global source otherwise. The types without variance, or with a zero
+// variance, are noise free and do not draw from the source.
+func (s Station) noise(t MeasurementType) float64 {
+	σ2, err := s.variance(t)
+	if err != nil || σ2 == 0 {
+		return 0
+	}
+	if s.Rand != nil {
+		return s.Rand.NormFloat64() * math.Sqrt(σ2)
+	}
+	return rand.NormFloat64() * math.Sqrt(σ2)
+}
+
+// variance returns the variance of the noise of the provided measurement type of the station (cf. noise).
+func (s Station) variance(t MeasurementType) (float64, error) {
+	if σ2, set := s.Variances[t]; set {
+		return σ2, nil
+	}
+	var normal *distmv.Normal
+	switch t {
+	case MeasRange:
+		normal = s.RangeNoise
+	case MeasRangeRate, MeasIntegratedDoppler:
+		normal = s.RangeRateNoise
+	default:
+		return 0, nil
+	}
+	if normal == nil {
+		return 0, fmt.Errorf("no noise for %s", t)
+	}
+	return normal.CovarianceMatrix(nil).At(0, 0), nil
+}
@@ -0,0 +1,265 @@
+package smd
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+)
+
+// fdPartials returns the partials of the measurement type with respect to the position and velocity by central finite
+// differences.
+func fdPartials(st Station, t MeasurementType, dt time.Time, o Orbit) (hR, hV []float64) {
+	hR = make([]float64, 3)
+	hV = make([]float64, 3)
+	for j := 0; j < 6; j++ {
+		h := 1e-3
+		if j >= 3 {
+			h = 1e-6
+		}
+		var values [2]float64
+		for k, sign := range []float64{1, -1} {
+			R := []float64{o.R()[0], o.R()[1], o.R()[2]}
+			V := []float64{o.V()[0], o.V()[1], o.V()[2]}
+			if j < 3 {
+				R[j] += sign * h
+			} else {
+				V[j-3] += sign * h
+			}
+			values[k], _, _ = st.Observe(t, dt, *NewOrbitFromRV(R, V, o.Origin))
+		}
+		if j < 3 {
+			hR[j] = t.Difference(values[0], values[1]) / (2 * h)
+		} else {
+			hV[j-3] = t.Difference(values[0], values[1]) / (2 * h)
+		}
+	}
+	return
+}
+
+func TestObservablePartials(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	o := NewOrbitFromOE(12000, 0.1, 40, 10, 20, 30, Earth)
+	twoWay := DSS34Canberra
+	twoWay.Link = Link{Type: TwoWay, Frequency: 7.2e9}
+	threeWay := DSS34Canberra
+	threeWay.Link = Link{Type: ThreeWay, Transmitter: &DSS65Madrid, Frequency: 2.1e9, CountTime: 10 * time.Second}
+	lander := NewPlanetStation(Mars, "lander", 0, 10, 4.5, 137.4, σρ, σρDot)
+	lander.Link.Type = TwoWay
+	for _, st := range []Station{DSS34Canberra, twoWay, threeWay, lander} {
+		orbit := *o
+		if st.Planet.Equals(Mars) {
+			orbit = *NewOrbitFromOE(5000, 0.1, 40, 10, 20, 30, Mars)
+		}
+		for _, measType := range []MeasurementType{MeasRange, MeasRangeRate, MeasRightAscension, MeasDeclination, MeasAzimuth, MeasElevation, MeasIntegratedDoppler, MeasDSNRange} {
+			if measType == MeasDSNRange && st.Link.Frequency == 0 {
+				assertPanic(t, func() {
+					st.Observe(measType, dt, orbit)
+				})
+				continue
+			}
+			_, hR, hV := st.Observe(measType, dt, orbit)
+			fdR, fdV := fdPartials(st, measType, dt, orbit)
+			// The partials of the integrated Doppler use a linear approximation of the STM over the count time.
+			relTol := 1e-5
+			if measType == MeasIntegratedDoppler {
+				relTol = 1e-2
+			}
+			scale := math.Max(Norm(fdR), Norm(fdV))
+			for i := 0; i < 3; i++ {
+				if !floats.EqualWithinAbs(hR[i], fdR[i], relTol*scale) || !floats.EqualWithinAbs(hV[i], fdV[i], relTol*scale) {
+					t.Fatalf("%s %s (%s): invalid partials\n%v %v\n%v %v", st.Name, measType, st.Link.Type, hR, hV, fdR, fdV)
+				}
+			}
+		}
+	}
+}
+
+func TestObservableValues(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	o := NewOrbitFromOE(12000, 0.1, 40, 10, 20, 30, Earth)
+	R, V := o.RV()
+	l := DSS34Canberra.linkRange(dt, R, V, Earth.μ, false)
+	ρ, ρDot := l.ρ, l.ρDot
+	// Without light time, the two way range is the one way range.
+	twoWay := DSS34Canberra
+	twoWay.Link = Link{Type: TwoWay, Frequency: 7.2e9, RangeModulus: 1 << 20}
+	if value, _, _ := twoWay.Observe(MeasRange, dt, *o); !floats.EqualWithinAbs(value, ρ, 1e-9) {
+		t.Fatalf("invalid two way range: %f != %f", value, ρ)
+	}
+	ru, _, _ := twoWay.Observe(MeasDSNRange, dt, *o)
+	if exp := math.Mod(221./1498*7.2e9*2*ρ/SpeedOfLight, 1<<20); !floats.EqualWithinAbs(ru, exp, 1e-6) {
+		t.Fatalf("invalid DSN range: %f != %f", ru, exp)
+	}
+	// The three way range is the average of both legs.
+	threeWay := DSS34Canberra
+	threeWay.Link = Link{Type: ThreeWay, Transmitter: &DSS65Madrid}
+	l65 := DSS65Madrid.linkRange(dt, R, V, Earth.μ, false)
+	ρ65, ρDot65 := l65.ρ, l65.ρDot
+	if value, _, _ := threeWay.Observe(MeasRange, dt, *o); !floats.EqualWithinAbs(value, (ρ+ρ65)/2, 1e-9) {
+		t.Fatalf("invalid three way range: %f != %f", value, (ρ+ρ65)/2)
+	}
+	if value, _, _ := threeWay.Observe(MeasRangeRate, dt, *o); !floats.EqualWithinAbs(value, (ρDot+ρDot65)/2, 1e-12) {
+		t.Fatalf("invalid three way range rate: %f != %f", value, (ρDot+ρDot65)/2)
+	}
+	// The integrated Doppler is close to the range rate in the middle of the count.
+	doppler, _, _ := DSS34Canberra.Observe(MeasIntegratedDoppler, dt, *o)
+	Rmid, Vmid := KeplerPropagate(R, V, Earth.μ, -30)
+	ρDotMid := DSS34Canberra.linkRange(dt.Add(-30*time.Second), Rmid, Vmid, Earth.μ, false).ρDot
+	if !floats.EqualWithinAbs(doppler, ρDotMid, 1e-5) {
+		t.Fatalf("invalid integrated Doppler: %f != %f", doppler, ρDotMid)
+	}
+	// Elevation and azimuth as computed for the visibility.
+	rECEF, _ := inertial2BodyFixed(R, V, dt, Earth)
+	_, _, el, az := DSS34Canberra.RangeElAz(rECEF)
+	if value, _, _ := DSS34Canberra.Observe(MeasElevation, dt, *o); !floats.EqualWithinAbs(value, el, 1e-9) {
+		t.Fatalf("invalid elevation: %f != %f", value, el)
+	}
+	if value, _, _ := DSS34Canberra.Observe(MeasAzimuth, dt, *o); !floats.EqualWithinAbs(MeasAzimuth.Difference(value, az), 0, 1e-9) {
+		t.Fatalf("invalid azimuth: %f != %f", value, az)
+	}
+	// Topocentric right ascension and declination.
+	rS, _ := DSS34Canberra.inertialState(dt)
+	above := *NewOrbitFromRV([]float64{rS[0] - 1000, rS[1], rS[2] + 1000}, V, Earth)
+	ra, _, _ := DSS34Canberra.Observe(MeasRightAscension, dt, above)
+	dec, _, _ := DSS34Canberra.Observe(MeasDeclination, dt, above)
+	if !floats.EqualWithinAbs(ra, 180, 1e-9) || !floats.EqualWithinAbs(dec, 45, 1e-9) {
+		t.Fatalf("invalid RA/Dec: %f %f", ra, dec)
+	}
+	if δ := MeasRightAscension.Difference(1, 359); δ != 2 {
+		t.Fatalf("invalid RA difference: %f", δ)
+	}
+	if δ := MeasDeclination.Difference(1, 359); δ != -358 {
+		t.Fatalf("invalid declination difference: %f", δ)
+	}
+}
+
+func TestStationMeasurementTypes(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Spacecraft 1000 km above DSS34 (not at zenith).
+	rECEF := DSS34Canberra.Planet.Ellipsoid().ToBodyFixed(1000, DSS34Canberra.LatΦ+d2r, DSS34Canberra.Longθ)
+	R, V := bodyFixed2Inertial(rECEF, []float64{0, 0, 0}, dt, Earth)
+	state := State{DT: dt, Orbit: *NewOrbitFromRV(R, V, Earth)}
+	st := DSS34Canberra
+	st.Types = []MeasurementType{MeasAzimuth, MeasElevation, MeasRange}
+	st.Variances = map[MeasurementType]float64{MeasAzimuth: 1e-4}
+	m := st.PerformMeasurement(dt, state)
+	if !m.Visible || len(m.Values) != 3 || m.Values[2] != m.Range || m.TrueValues[2] != m.TrueRange || m.Values[1] != m.TrueValues[1] || m.Values[0] == m.TrueValues[0] {
+		t.Fatalf("invalid measurement: %+v", m)
+	}
+	if H := m.HTilde(); H.RawMatrix().Rows != 3 || H.At(2, 0) == 0 || H.At(1, 3) != 0 {
+		t.Fatalf("invalid H: %v", H)
+	}
+	if m.StateVector().Len() != 3 || m.TrueStateVector().At(1, 0) != m.TrueValues[1] {
+		t.Fatal("invalid state vectors")
+	}
+	// Madrid cannot transmit to a spacecraft above Canberra.
+	st.Link = Link{Type: ThreeWay, Transmitter: &DSS65Madrid}
+	if m = st.PerformMeasurement(dt, state); m.Visible {
+		t.Fatal("three way link visible without the transmitter")
+	}
+}
+
+func TestTDMMeasurementTypes(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	st := DSS34Canberra
+	st.Types = []MeasurementType{MeasDSNRange, MeasIntegratedDoppler, MeasRightAscension, MeasDeclination}
+	st.Link = Link{Type: ThreeWay, Transmitter: &DSS65Madrid, Frequency: 7.2e9, RangeModulus: 1 << 20, CountTime: 10 * time.Second}
+	noTransmitter := st
+	noTransmitter.Link.Transmitter = nil
+	if _, err := NewStationTDMSegment(noTransmitter, "sc"); err == nil {
+		t.Fatal("three-way segment accepted without a transmitter")
+	}
+	seg, err := NewStationTDMSegment(st, "sc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg.AddMeasurement(Measurement{Visible: true, State: State{DT: dt}, Station: st, Types: st.Types, Values: []float64{123, -1, 359, -10}})
+	tdm := NewTDM()
+	tdm.Segments = append(tdm.Segments, seg)
+	for _, asXML := range []bool{false, true} {
+		var buf bytes.Buffer
+		var err error
+		if asXML {
+			err = tdm.WriteXML(&buf)
+		} else {
+			err = tdm.WriteKVN(&buf)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		tdmIn, err := ParseTDM(&buf)
+		if err != nil {
+			t.Fatalf("could not parse written TDM (XML=%v): %s\n%s", asXML, err, buf.String())
+		}
+		segIn := tdmIn.Segments[0]
+		if segIn.LinkType() != ThreeWay || segIn.Station() != st.Name || segIn.Transmitter() != DSS65Madrid.Name || segIn.RangeModulus != 1<<20 || segIn.IntegrationInterval != 10 || segIn.AngleType != "RADEC" {
+			t.Fatalf("invalid segment (XML=%v): %+v", asXML, segIn)
+		}
+		for i, obs := range segIn.Observations {
+			measType, err := segIn.MeasurementType(obs.Keyword)
+			if err != nil || measType != st.Types[i] || obs.Value != seg.Observations[i].Value {
+				t.Fatalf("invalid observation #%d (XML=%v): %+v (%s)", i, asXML, obs, err)
+			}
+		}
+	}
+	if seg := NewTDMSegment("st", "sc"); seg.LinkType() != OneWay || seg.Transmitter() != "st" {
+		t.Fatalf("invalid one way segment: %+v", seg)
+	}
+	if _, err := NewTDMSegment("st", "sc").MeasurementType(TDMAngle1); err == nil {
+		t.Fatal("angle without angle type accepted")
+	}
+}
+
+func TestStationNetworkLinks(t *testing.T) {
+	network := `
+[station.DSS34]
+latitude = -35.398333
+longitude = 148.981944
+range_sigma = 0.1
+rate_sigma = 0.1
+types = ["dsnrange", "doppler"]
+doppler_sigma = 1e-10
+link = "two-way"
+frequency = 7.2e9
+count_time = "10s"
+
+[station.DSS65]
+latitude = 40.427222
+longitude = -4.250556
+range_sigma = 0.1
+rate_sigma = 0.1
+types = ["Azimuth", "el"]
+link = "three-way"
+transmitter = "dss34"
+`
+	stations, err := ParseStationNetwork(strings.NewReader(network))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dss34, dss65 := stations["dss34"], stations["dss65"]
+	if len(dss34.Types) != 2 || dss34.Types[1] != MeasIntegratedDoppler || dss34.Variances[MeasIntegratedDoppler] != 1e-10 || dss34.Link.Type != TwoWay || dss34.Link.Frequency != 7.2e9 || dss34.Link.CountTime != 10*time.Second {
+		t.Fatalf("invalid station: %+v", dss34)
+	}
+	if dss65.Types[0] != MeasAzimuth || dss65.Link.Type != ThreeWay || dss65.Link.Transmitter == nil || dss65.Link.Transmitter.Name != "dss34" {
+		t.Fatalf("invalid three way station: %+v", dss65)
+	}
+	base := "[station.x]\nlatitude = 0\nlongitude = 0\nrange_sigma = 0.1\nrate_sigma = 0.1\n"
+	for _, invalid := range []string{
+		base + "types = [\"range\", \"dsnrange\"]\nfrequency = 7.2e9",
+		base + "types = [\"ra\", \"el\"]",
+		base + "types = [\"range\", \"range\"]",
+		base + "types = [\"parallax\"]",
+		base + "types = [\"dsnrange\"]",
+		base + "link = \"four-way\"",
+		base + "link = \"three-way\"",
+		base + "link = \"three-way\"\ntransmitter = \"y\"",
+	} {
+		if _, err := ParseStationNetwork(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("invalid network accepted:\n%s", invalid)
+		}
+	}
+}
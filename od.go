@@ -0,0 +1,730 @@
+package smd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChristopherRabotin/gokalman"
+	"github.com/gonum/matrix/mat64"
+	"github.com/spf13/viper"
+)
+
+/* Orbit determination of a spacecraft from the measurements of its tracking stations. The reference trajectory is
+propagated by a Mission (with the STM), and each state is either a time update of the filter, or a measurement update
+if any station has a measurement at that epoch. */
+
+// ODFilterType defines the filter of the orbit determination.
+type ODFilterType uint8
+
+const (
+	// FilterCKF is the classical (linearized) Kalman filter.
+	FilterCKF ODFilterType = iota + 1
+	// FilterEKF is the extended Kalman filter, which starts as a CKF until it is triggered.
+	FilterEKF
+	// FilterSRIF is the square root information filter.
+	FilterSRIF
+	// FilterUKF is the unscented Kalman filter, whose sigma points are propagated without the STM.
+	FilterUKF
+	// FilterBLS is the iterated batch least squares, which estimates the deviation at the start of the mission.
+	FilterBLS
+)
+
+func (f ODFilterType) String() string {
+	switch f {
+	case FilterCKF:
+		return "CKF"
+	case FilterEKF:
+		return "EKF"
+	case FilterSRIF:
+		return "SRIF"
+	case FilterUKF:
+		return "UKF"
+	case FilterBLS:
+		return "BLS"
+	default:
+		panic("unknown filter")
+	}
+}
+
+// ODFilterTypeFromString returns the filter type from its name.
+func ODFilterTypeFromString(name string) (ODFilterType, error) {
+	switch strings.ToUpper(name) {
+	case "CKF":
+		return FilterCKF, nil
+	case "EKF":
+		return FilterEKF, nil
+	case "SRIF":
+		return FilterSRIF, nil
+	case "UKF":
+		return FilterUKF, nil
+	case "BLS":
+		return FilterBLS, nil
+	default:
+		return 0, fmt.Errorf("unknown filter `%s`", name)
+	}
+}
+
+// ODConfig defines the filter of the orbit determination.
+type ODConfig struct {
+	Filter         ODFilterType
+	Smooth         bool                        // Smooth the CKF or SRIF estimates once all the measurements are processed
+	EKFTrigger     int                         // Number of measurements prior to switching to EKF mode
+	EKFDisableTime float64                     // Seconds between measurements to switch back to CKF, ignored if negative
+	SNC            bool                        // Enables the state noise compensation
+	SNCRIC         bool                        // Q is defined in the RIC frame instead of the inertial frame
+	SNCDisableTime float64                     // Seconds between measurements above which the SNC is not used
+	Q              *mat64.SymDense             // SNC noise (3x3)
+	DMC            bool                        // Enables the dynamic model compensation (cf. GaussMarkov)
+	DMCTau         float64                     // A priori time constant of the DMC accelerations (s)
+	DMCSigma       float64                     // Steady state and a priori σ of the DMC accelerations (km/s^2)
+	DMCTauSigma    float64                     // A priori σ of the estimated time constants (s)
+	P0             *mat64.SymDense             // Initial covariance of the state deviation (6x6)
+	EditSigma      float64                     // Pre-fit residuals beyond EditSigma σ are rejected, no editing if zero
+	Noise          map[MeasurementType]float64 // Variance of each measurement type
+	UKFAlpha       float64                     // Spread of the sigma points, defaults to 1 (with β=2 and κ=0)
+	UKFBeta        float64                     // Prior knowledge of the distribution, 2 is optimal for Gaussians
+	UKFKappa       float64                     // Secondary scaling of the sigma points
+	UKFSquareRoot  bool                        // Use the square root UKF
+	BLSIterations  int                         // Maximum number of iterations of the BLS, defaults to 10
+	BLSCriterion   BLSCriterion                // Convergence criterion of the BLS, defaults to BLSResidualsRMS
+	BLSTolerance   float64                     // Tolerance of the convergence criterion of the BLS
+	BLSAPriori     bool                        // Use P0 as the a priori information of the BLS
+	Parameters     []ODParameter               // Estimated or considered in addition to the position and velocity
+}
+
+// ODConfigFromConfig returns the configuration of the filter from the provided configuration, i.e. the `filter`,
+// `noise`, `covariance`, `SNC`, `DMC`, `residuals`, filter specific (e.g. `EKF`) and `parameters.<number>` sections.
+func ODConfigFromConfig(v *viper.Viper) (conf ODConfig, err error) {
+	if conf.Filter, err = ODFilterTypeFromString(v.GetString("filter.type")); err != nil {
+		return
+	}
+	switch conf.Filter {
+	case FilterEKF:
+		conf.EKFTrigger = v.GetInt("EKF.trigger")
+		conf.EKFDisableTime = v.GetFloat64("EKF.disableTime")
+	case FilterCKF, FilterSRIF:
+		conf.Smooth = v.GetBool(conf.Filter.String() + ".smooth")
+	case FilterUKF:
+		conf.UKFAlpha = v.GetFloat64("UKF.alpha")
+		conf.UKFBeta = v.GetFloat64("UKF.beta")
+		conf.UKFKappa = v.GetFloat64("UKF.kappa")
+		conf.UKFSquareRoot = v.GetBool("UKF.squareRoot")
+	case FilterBLS:
+		conf.BLSIterations = v.GetInt("BLS.iterations")
+		conf.BLSTolerance = v.GetFloat64("BLS.tolerance")
+		conf.BLSAPriori = v.GetBool("BLS.apriori")
+		if v.IsSet("BLS.criterion") {
+			if conf.BLSCriterion, err = BLSCriterionFromString(v.GetString("BLS.criterion")); err != nil {
+				return
+			}
+		}
+	}
+	conf.SNC = v.GetBool("SNC.enabled")
+	conf.SNCRIC = v.GetBool("SNC.RICframe")
+	conf.SNCDisableTime = v.GetFloat64("SNC.disableTime")
+	σQx := v.GetFloat64("noise.Q")
+	if !v.IsSet("noise.Q") {
+		σQx = v.GetFloat64("variance.Q") // Former key of the SNC noise
+	}
+	var σQy, σQz float64
+	if !conf.SNCRIC {
+		σQy = σQx
+		σQz = σQx
+	}
+	conf.Q = mat64.NewSymDense(3, []float64{σQx, 0, 0, 0, σQy, 0, 0, 0, σQz})
+	if conf.DMC = v.GetBool("DMC.enabled"); conf.DMC {
+		if conf.SNC {
+			return conf, errors.New("the SNC and DMC cannot be both enabled")
+		}
+		conf.DMCTau = v.GetFloat64("DMC.timeConstant")
+		conf.DMCSigma = v.GetFloat64("DMC.sigma")
+		conf.DMCTauSigma = v.GetFloat64("DMC.timeConstantSigma")
+		if conf.DMCTau <= 0 || conf.DMCSigma <= 0 || conf.DMCTauSigma <= 0 {
+			return conf, errors.New("the DMC `timeConstant`, `sigma` and `timeConstantSigma` must be positive")
+		}
+	}
+	if conf.EditSigma = v.GetFloat64("residuals.editSigma"); conf.EditSigma < 0 {
+		return conf, errors.New("the residual `editSigma` must be positive (or zero to disable the editing)")
+	}
+	conf.P0 = mat64.NewSymDense(6, nil)
+	for i := 0; i < 3; i++ {
+		conf.P0.SetSym(i, i, v.GetFloat64("covariance.position"))
+		conf.P0.SetSym(i+3, i+3, v.GetFloat64("covariance.velocity"))
+	}
+	// Each station has its own measurement types, whose noise is read from `noise.<type>` (e.g. noise.range).
+	conf.Noise = make(map[MeasurementType]float64)
+	for measType := MeasRange; measType <= MeasDSNRange; measType++ {
+		if key := "noise." + measType.String(); v.IsSet(key) {
+			conf.Noise[measType] = v.GetFloat64(key)
+		}
+	}
+	conf.Parameters, err = odParametersFromConfig(v)
+	return
+}
+
+// ODEstimate is the estimate of the state deviation from the reference orbit at a given epoch.
+type ODEstimate struct {
+	gokalman.Estimate
+	DT       time.Time
+	Orbit    Orbit         // Reference orbit
+	Residual *mat64.Vector // Post-fit residuals, nil if there is no measurement at this epoch
+	// Pre-fit residuals (i.e. innovations) of each row and their σ (cf. odediting.go), the status of each row and the
+	// NIS of the accepted rows (zero for the BLS), all nil or zero if there is no measurement at this epoch.
+	Prefit      *mat64.Vector
+	PrefitSigma *mat64.Vector
+	Rows        []ODRowStatus
+	NIS         float64
+	// Sensitivity of the estimated state to the considered parameters and its consider covariance (cf. consider.go),
+	// nil without any considered parameter.
+	Sensitivity        *mat64.Dense
+	ConsiderCovariance *mat64.SymDense
+}
+
+// ODResult stores the estimates of the orbit determination at each state of the reference trajectory.
+type ODResult struct {
+	Filter           ODFilterType
+	Estimates        []ODEstimate
+	StateNames       []string          // Name of each component of the estimated state
+	Parameters       []float64         // Estimated value of each solve-for parameter component (cf. StateNames)
+	ConsiderNames    []string          // Name of each considered parameter component (columns of the sensitivity)
+	RowNames         []string          // Station and measurement type of each row of the residuals
+	RowStations      []string          // Station of each row of the residuals
+	RowTypes         []MeasurementType // Measurement type of each row of the residuals
+	RowSigma         []float64         // Measurement noise σ of each row of the residuals
+	Measurements     int               // Number of processed measurement epochs
+	VisibilityErrors int               // Number of measurements from stations which should not see the spacecraft
+	Rejected         int               // Number of rows rejected by the residual editing
+	Iterations       int               // Number of iterations of the BLS (zero for the sequential filters)
+	Converged        bool              // Whether the BLS converged within its maximum number of iterations
+}
+
+// RMS returns the root mean square of the estimated position and velocity deviations.
+func (r ODResult) RMS() (position, velocity float64) {
+	for _, est := range r.Estimates {
+		for i := 0; i < 3; i++ {
+			position += math.Pow(est.State().At(i, 0), 2)
+			velocity += math.Pow(est.State().At(i+3, 0), 2)
+		}
+	}
+	n := float64(len(r.Estimates))
+	return math.Sqrt(position / n), math.Sqrt(velocity / n)
+}
+
+// ExportCSV writes the estimates with their 3σ bounds to the provided CSV file, with the elapsed time since start.
+func (r ODResult) ExportCSV(filename string, start time.Time) error {
+	dir, file := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	headers := []string{"_epoch", "_seconds", "_minutes", "_hours", "_days", "x", "y", "z", "xDot", "yDot", "zDot"}
+	if len(r.StateNames) > 6 {
+		headers = append(headers, r.StateNames[6:]...)
+	}
+	ce, err := gokalman.NewCustomCSVExporter(headers, dir, file, 3)
+	if err != nil {
+		return err
+	}
+	for _, est := range r.Estimates {
+		Δt := est.DT.Sub(start)
+		ce.WriteRaw(fmt.Sprintf("\"%s\",%f,%f,%f,%f,", est.DT.Format("2006-01-02 15:04:05"), Δt.Seconds(), Δt.Minutes(), Δt.Hours(), Δt.Hours()/24))
+		if err = ce.Write(est); err != nil {
+			ce.Close()
+			return err
+		}
+	}
+	return ce.Close()
+}
+
+// ExportResidualsCSV writes the post-fit residuals of each state to the provided CSV file (zeros without measurement).
+func (r ODResult) ExportResidualsCSV(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.WriteString(strings.Join(r.RowNames, ",") + "\n"); err != nil {
+		return err
+	}
+	for _, est := range r.Estimates {
+		values := make([]string, len(r.RowNames))
+		for i := range values {
+			values[i] = "0"
+			if est.Residual != nil {
+				values[i] = fmt.Sprintf("%f", est.Residual.At(i, 0))
+			}
+		}
+		if _, err = f.WriteString(strings.Join(values, ",") + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// odEstimate is the estimate of the filters which are not from gokalman (i.e. the UKF and BLS).
+type odEstimate struct {
+	state, meas, innov *mat64.Vector
+	covar, predCovar   *mat64.SymDense
+}
+
+// IsWithinNσ returns whether the estimation is within the N*σ bounds.
+func (e odEstimate) IsWithinNσ(N float64) bool {
+	for i := 0; i < e.state.Len(); i++ {
+		nσ := N * math.Sqrt(e.covar.At(i, i))
+		if e.state.At(i, 0) < -nσ || e.state.At(i, 0) > nσ {
+			return false
+		}
+	}
+	return true
+}
+
+// State returns the state deviation.
+func (e odEstimate) State() *mat64.Vector { return e.state }
+
+// Measurement returns the observations.
+func (e odEstimate) Measurement() *mat64.Vector { return e.meas }
+
+// Innovation returns the mean pre-fit residuals.
+func (e odEstimate) Innovation() *mat64.Vector { return e.innov }
+
+// Covariance returns the covariance of the state.
+func (e odEstimate) Covariance() mat64.Symmetric { return e.covar }
+
+// PredCovariance returns the predicted covariance of the state.
+func (e odEstimate) PredCovariance() mat64.Symmetric { return e.predCovar }
+
+func (e odEstimate) String() string {
+	return fmt.Sprintf("x=%+v\nP=%+v", mat64.Formatted(e.state, mat64.Prefix("  ")), mat64.Formatted(e.covar, mat64.Prefix("  ")))
+}
+
+// OrbitDetermination estimates the orbit of a spacecraft from the measurements of its stations along the reference
+// trajectory of the provided mission, which must compute the STM (except for the UKF). Use NewOrbitDetermination to
+// initialize.
+type OrbitDetermination struct {
+	Mission      *Mission
+	Measurements *ODMeasurements
+	Config       ODConfig
+	End          time.Time // End of the reference trajectory, defaults to the last measurement
+}
+
+// NewOrbitDetermination returns a new orbit determination of the provided measurements.
+func NewOrbitDetermination(m *Mission, measurements *ODMeasurements, conf ODConfig) *OrbitDetermination {
+	if len(measurements.Epochs) == 0 {
+		panic("no measurements for the orbit determination")
+	}
+	return &OrbitDetermination{m, measurements, conf, measurements.Epochs[len(measurements.Epochs)-1]}
+}
+
+// rows returns the first row of each station in the stacked measurements, the name of each row and the measurement
+// noise matrix.
+func (od *OrbitDetermination) rows() (stationRows []int, rowNames []string, R *mat64.SymDense, err error) {
+	var rowNoise []float64
+	stationRows = make([]int, len(od.Measurements.Stations))
+	for pos, st := range od.Measurements.Stations {
+		stationRows[pos] = len(rowNoise)
+		for _, measType := range st.MeasurementTypes() {
+			σ2, found := od.Config.Noise[measType]
+			if !found {
+				return nil, nil, nil, fmt.Errorf("the noise of %s must be set for the measurements of %s", measType, st.Name)
+			}
+			rowNoise = append(rowNoise, σ2)
+			rowNames = append(rowNames, fmt.Sprintf("%s %s", st.Name, measType))
+		}
+	}
+	R = mat64.NewSymDense(len(rowNoise), nil)
+	for i, σ2 := range rowNoise {
+		R.SetSym(i, i, σ2)
+	}
+	return
+}
+
+// describe sets the filter, and the station, measurement type and noise of each row of the result.
+func (od *OrbitDetermination) describe(result *ODResult, noiseR mat64.Symmetric) {
+	result.Filter = od.Config.Filter
+	result.RowStations, result.RowTypes, result.RowSigma = nil, nil, nil
+	for _, st := range od.Measurements.Stations {
+		for _, measType := range st.MeasurementTypes() {
+			result.RowStations = append(result.RowStations, st.Name)
+			result.RowTypes = append(result.RowTypes, measType)
+			result.RowSigma = append(result.RowSigma, math.Sqrt(noiseR.At(len(result.RowSigma), len(result.RowSigma))))
+		}
+	}
+}
+
+// Run processes all the measurements and returns the estimates, smoothed if requested, with the description of the
+// rows needed by their report (cf. ODResult.Report).
+func (od *OrbitDetermination) Run() (*ODResult, error) {
+	result, err := od.run()
+	if err != nil {
+		return nil, err
+	}
+	_, _, noiseR, _ := od.rows()
+	od.describe(result, noiseR)
+	return result, nil
+}
+
+// run processes all the measurements with the configured filter.
+func (od *OrbitDetermination) run() (*ODResult, error) {
+	conf := od.Config
+	stationRows, rowNames, noiseR, err := od.rows()
+	if err != nil {
+		return nil, err
+	}
+	if conf.DMC && conf.Filter != FilterCKF && conf.Filter != FilterEKF {
+		return nil, errors.New("the DMC is only supported by the CKF and EKF")
+	} else if conf.DMC && conf.SNC {
+		return nil, errors.New("the SNC and DMC cannot be both enabled")
+	}
+	params, err := newODParameters(od)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Filter == FilterUKF {
+		if conf.Smooth {
+			log.Println("[WARNING] smoothing is not supported with a UKF")
+		}
+		if params.size > 0 || params.consider > 0 {
+			return nil, errors.New("the UKF only estimates the position and velocity")
+		}
+		return od.runUKF(stationRows, rowNames, noiseR)
+	}
+	if conf.Filter == FilterBLS {
+		if conf.Smooth || conf.SNC {
+			log.Println("[WARNING] smoothing and SNC are not supported with a BLS")
+		}
+		return od.runBLS(stationRows, rowNames, noiseR, params)
+	}
+	numRows := len(rowNames)
+	n := 6 + params.size
+	noiseQ := conf.Q
+	if noiseQ == nil {
+		noiseQ = mat64.NewSymDense(3, nil)
+	}
+	noiseKF := gokalman.NewNoiseless(noiseQ, noiseR)
+
+	var kf gokalman.NLDKF
+	x0 := mat64.NewVector(n, nil)
+	P0 := params.covariance(conf.P0)
+	switch conf.Filter {
+	case FilterCKF, FilterEKF:
+		kf, _, err = gokalman.NewHybridKF(x0, P0, noiseKF, numRows)
+	case FilterSRIF:
+		// The SRIF whitens the measurements with the Cholesky factor of the noise it is given, so it needs the
+		// information of each row (R is diagonal) to whiten them with the inverse of the square root of R.
+		infoR := mat64.NewSymDense(numRows, nil)
+		for i := 0; i < numRows; i++ {
+			infoR.SetSym(i, i, 1/noiseR.At(i, i))
+		}
+		kf, _, err = gokalman.NewSRIF(x0, P0, numRows, false, gokalman.NewNoiseless(noiseQ, infoR))
+	default:
+		err = fmt.Errorf("unsupported filter %s", conf.Filter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if conf.Smooth && conf.Filter == FilterEKF {
+		log.Println("[WARNING] smoothing has no effect with an EKF")
+		conf.Smooth = false
+	}
+
+	stateEstChan := make(chan (State), 1)
+	od.Mission.RegisterStateChan(stateEstChan)
+	epochs := od.Measurements.Epochs
+	var ekfWG sync.WaitGroup
+	if conf.Filter != FilterEKF {
+		go od.Mission.PropagateUntil(od.End.Add(od.Mission.step), true)
+	} else {
+		// Go step by step because the orbit of the mission is updated after each measurement.
+		go func() {
+			for i, epoch := range epochs {
+				ekfWG.Wait()
+				ekfWG.Add(1)
+				od.Mission.PropagateUntil(epoch, i == len(epochs)-1)
+			}
+		}()
+	}
+
+	result := &ODResult{StateNames: params.stateNames(), ConsiderNames: params.considerNames(), RowNames: rowNames}
+	if params.consider > 0 && conf.Smooth {
+		log.Println("[WARNING] the consider covariance is the one of the filter (not smoothed)")
+	}
+	var S *mat64.Dense // Sensitivity to the considered parameters
+	if params.consider > 0 {
+		S = mat64.NewDense(n, params.consider, nil)
+	}
+	var prevDT time.Time
+	ckfMeasNo := 0
+	// Previous estimate, from which the pre-fit residuals are predicted.
+	var prevX *mat64.Vector = x0
+	var prevP mat64.Symmetric = P0
+	process := func(state State, measurements []Measurement, exists bool) error {
+		augmentedΦ := params.transition(state)
+		Φ := augmentedΦ.View(0, 0, n, n).(*mat64.Dense)
+		if params.consider > 0 {
+			S = considerMapping(augmentedΦ, S)
+		}
+		if !exists {
+			if result.Measurements == 0 {
+				return fmt.Errorf("the filter should start at the first measurement: %s (got) %s (exp)", state.DT, epochs[0])
+			}
+			// There is no measurement here, let's only predict the covariance.
+			kf.Prepare(Φ, nil)
+			if conf.DMC {
+				kf.SetNoise(gokalman.NewNoiseless(params.dmcNoise(), noiseR))
+				kf.PreparePNT(DenseIdentity(n))
+			}
+			est, err := kf.Predict()
+			if err != nil {
+				return fmt.Errorf("prediction #%05d: %s", result.Measurements, err)
+			}
+			if est.State().Len() != n {
+				// The predictions of the EKF only have the deviation of the position and velocity, which is zero.
+				covar := mat64.NewSymDense(n, nil)
+				covar.CopySym(est.Covariance())
+				est = odEstimate{mat64.NewVector(n, nil), est.Measurement(), est.Innovation(), covar, covar}
+			}
+			odEst := ODEstimate{Estimate: est, DT: state.DT, Orbit: state.Orbit}
+			if params.consider > 0 {
+				odEst.Sensitivity, odEst.ConsiderCovariance = S, params.considerCovariance(est.Covariance(), S)
+			}
+			result.Estimates = append(result.Estimates, odEst)
+			prevX, prevP = est.State(), est.Covariance()
+			return nil
+		}
+
+		if result.Measurements == 0 {
+			prevDT = state.DT
+		}
+		ΔtDuration := state.DT.Sub(prevDT)
+		Δt := ΔtDuration.Seconds()
+		if conf.Filter == FilterEKF {
+			if !kf.EKFEnabled() && ckfMeasNo == conf.EKFTrigger {
+				// Switch KF to EKF mode
+				kf.EnableEKF()
+				log.Printf("[info] #%05d EKF now enabled\n", result.Measurements)
+			} else if kf.EKFEnabled() && conf.EKFDisableTime > 0 && Δt > conf.EKFDisableTime {
+				// Switch KF back to CKF mode
+				kf.DisableEKF()
+				ckfMeasNo = 0
+				log.Printf("[info] #%05d EKF now disabled (Δt=%s)\n", result.Measurements, ΔtDuration)
+			}
+		}
+
+		stkdMeasVector, stkdCmpdVector, augmentedH := od.stack(state, measurements, stationRows, numRows, result, params)
+		stkdHtilde := augmentedH.View(0, 0, numRows, n).(*mat64.Dense)
+
+		// Process noise of this update (ΓQΓ^T), if any.
+		var Γ *mat64.Dense
+		var Q mat64.Symmetric
+		if conf.DMC {
+			// The process noise of the DMC is added at each step of the reference trajectory.
+			Q, Γ = params.dmcNoise(), DenseIdentity(n)
+			kf.SetNoise(gokalman.NewNoiseless(Q, noiseR))
+		}
+		if conf.SNC && Δt < conf.SNCDisableTime {
+			// Only enable SNC for small time differences between measurements.
+			Q = noiseQ
+			if conf.SNCRIC {
+				QECI, err := gokalman.AsSymDense(ricCovarianceToInertial(noiseQ, state.Orbit))
+				if err != nil {
+					return fmt.Errorf("SNC noise in the inertial frame: %s", err)
+				}
+				kf.SetNoise(gokalman.NewNoiseless(QECI, noiseR))
+				Q = QECI
+			}
+			Γtop := ScaledDenseIdentity(3, math.Pow(Δt, 2)/2)
+			Γbot := ScaledDenseIdentity(3, Δt)
+			Γ = mat64.NewDense(n, 3, nil)
+			Γ.View(0, 0, 6, 3).(*mat64.Dense).Stack(Γtop, Γbot)
+		}
+
+		// Pre-fit residuals from the predicted deviation (zero in EKF mode) and covariance (the SRIF has no process
+		// noise), and editing of the outliers.
+		xBar := mat64.NewVector(n, nil)
+		if !kf.EKFEnabled() {
+			xBar.MulVec(Φ, prevX)
+		}
+		var ΦP, Pbar mat64.Dense
+		ΦP.Mul(Φ, prevP)
+		Pbar.Mul(&ΦP, Φ.T())
+		if Γ != nil && conf.Filter != FilterSRIF {
+			var ΓQ, ΓQΓt mat64.Dense
+			ΓQ.Mul(Γ, Q)
+			ΓQΓt.Mul(&ΓQ, Γ.T())
+			Pbar.Add(&Pbar, &ΓQΓt)
+		}
+		PbarSym, err := gokalman.AsSymDense(&Pbar)
+		if err != nil {
+			return fmt.Errorf("update #%05d: predicted covariance: %s", result.Measurements, err)
+		}
+		rows := observedRows(measurements, stationRows, numRows)
+		prefit := mat64.NewVector(numRows, nil)
+		prefit.MulVec(stkdHtilde, xBar)
+		prefit.SubVec(stkdMeasVector, prefit)
+		prefit.SubVec(prefit, stkdCmpdVector)
+		innovCovar := innovationCovariance(stkdHtilde, PbarSym, noiseR)
+		prefitσ := editInnovations(prefit, innovCovar, rows, conf.EditSigma)
+		nis, err := normalizedInnovation(prefit, innovCovar, rows)
+		if err != nil {
+			return fmt.Errorf("update #%05d: %s", result.Measurements, err)
+		}
+		// The rejected rows have no partials and no observation deviation, so they do not change the estimate.
+		filteredMeas, filteredH := stkdMeasVector, augmentedH
+		if count := countRows(rows, RowRejected); count > 0 {
+			log.Printf("[WARNING] #%05d %d measurement(s) rejected\n", result.Measurements, count)
+			result.Rejected += count
+			filteredMeas = mat64.NewVector(numRows, nil)
+			filteredMeas.CopyVec(stkdMeasVector)
+			filteredH = mat64.DenseCopyOf(augmentedH)
+			for i, status := range rows {
+				if status == RowRejected {
+					filteredMeas.SetVec(i, stkdCmpdVector.At(i, 0))
+					for j := 0; j < params.augmented(); j++ {
+						filteredH.Set(i, j, 0)
+					}
+				}
+			}
+		}
+
+		kf.Prepare(Φ, filteredH.View(0, 0, numRows, n).(*mat64.Dense))
+		if Γ != nil {
+			kf.PreparePNT(Γ)
+		}
+		est, err := kf.Update(filteredMeas, stkdCmpdVector)
+		if err != nil {
+			return fmt.Errorf("update #%05d: %s", result.Measurements, err)
+		}
+
+		// Compute the post-fit residuals (including those of the rejected rows)
+		// NOTE: the observation deviation of the SRIF is whitened, so it is recomputed here.
+		residual := mat64.NewVector(numRows, nil)
+		residual.MulVec(stkdHtilde, est.State())
+		residual.SubVec(stkdMeasVector, residual)
+		residual.SubVec(residual, stkdCmpdVector)
+		odEst := ODEstimate{Estimate: est, DT: state.DT, Orbit: state.Orbit, Residual: residual, Prefit: prefit, PrefitSigma: prefitσ, Rows: rows, NIS: nis}
+		if params.consider > 0 {
+			if S, err = considerUpdate(S, est.PredCovariance(), filteredH, noiseR); err != nil {
+				return fmt.Errorf("update #%05d: %s", result.Measurements, err)
+			}
+			odEst.Sensitivity, odEst.ConsiderCovariance = S, params.considerCovariance(est.Covariance(), S)
+		}
+		result.Estimates = append(result.Estimates, odEst)
+		prevX, prevP = est.State(), est.Covariance()
+		prevDT = state.DT
+
+		// If in EKF, update the reference trajectory.
+		if kf.EKFEnabled() {
+			R, V := state.Orbit.RV()
+			for i := 0; i < 3; i++ {
+				R[i] += est.State().At(i, 0)
+				V[i] += est.State().At(i+3, 0)
+			}
+			od.Mission.Orbit = NewOrbitFromRV(R, V, od.Mission.Orbit.Origin)
+			params.correct(est.State())
+			params.apply(od.Mission)
+		}
+		ckfMeasNo++
+		result.Measurements++
+		return nil
+	}
+
+	for state := range stateEstChan {
+		measurements, exists := od.Measurements.At(state.DT.Truncate(time.Second))
+		// After an error, the remaining states are only consumed so that the propagation ends.
+		if err == nil {
+			err = process(state, measurements, exists)
+		}
+		if exists && conf.Filter == FilterEKF {
+			ekfWG.Done()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Smooth {
+		if err = smoothEstimates(kf, result.Estimates); err != nil {
+			return nil, err
+		}
+	}
+	if params.size > 0 {
+		// The deviation of the parameters is already applied in EKF mode.
+		var x *mat64.Vector
+		if !kf.EKFEnabled() {
+			x = result.Estimates[len(result.Estimates)-1].State()
+		}
+		result.Parameters = params.estimated(x)
+	}
+	return result, nil
+}
+
+// stack returns the stacked observations, computed observations and Htilde of the augmented state (cf. odParameters)
+// of the provided measurements along the reference state, and counts the visibility errors in the result.
+func (od *OrbitDetermination) stack(state State, measurements []Measurement, stationRows []int, numRows int, result *ODResult, params *odParameters) (observed, computed *mat64.Vector, Htilde *mat64.Dense) {
+	observed = mat64.NewVector(numRows, nil)
+	computed = mat64.NewVector(numRows, nil)
+	Htilde = mat64.NewDense(numRows, params.augmented(), nil)
+	for measPos, measurement := range measurements {
+		if !measurement.Visible {
+			continue
+		}
+		st := params.station(measurement.Station)
+		computedObservation := st.PerformMeasurement(measurement.Epoch, state)
+		if !computedObservation.Visible {
+			log.Printf("[WARNING] #%05d station %s should see the SC but does not\n", result.Measurements, measurement.Station.Name)
+			result.VisibilityErrors++
+		}
+		H := computedObservation.HTilde()
+		obsVec := measurement.StateVector()
+		cmpdVec := computedObservation.TrueStateVector()
+		for i, measType := range computedObservation.MeasurementTypes() {
+			row := stationRows[measPos] + i
+			obs := obsVec.At(i, 0)
+			observed.SetVec(row, obs)
+			// The angles are wrapped so that the difference with the observation is the smallest one.
+			computed.SetVec(row, obs-measType.Difference(obs, cmpdVec.At(i, 0)+params.bias(st, measType)))
+			for j := 0; j < 6; j++ {
+				Htilde.Set(row, j, H.At(i, j))
+			}
+			params.measurementPartials(Htilde, row, measType, st, measurement.Epoch, state.Orbit)
+		}
+	}
+	return
+}
+
+// smoothEstimates smoothes all the estimates of the provided filter, in place.
+func smoothEstimates(kf gokalman.NLDKF, estimates []ODEstimate) error {
+	switch flt := kf.(type) {
+	case *gokalman.SRIF:
+		history := make([]*gokalman.SRIFEstimate, len(estimates))
+		for i, est := range estimates {
+			history[i] = est.Estimate.(*gokalman.SRIFEstimate)
+		}
+		if err := flt.SmoothAll(history); err != nil {
+			return err
+		}
+		for i := range estimates {
+			estimates[i].Estimate = history[i]
+		}
+	case *gokalman.HybridKF:
+		history := make([]*gokalman.HybridKFEstimate, len(estimates))
+		for i, est := range estimates {
+			history[i] = est.Estimate.(*gokalman.HybridKFEstimate)
+		}
+		if err := flt.SmoothAll(history); err != nil {
+			return err
+		}
+		for i := range estimates {
+			estimates[i].Estimate = history[i]
+		}
+	default:
+		return errors.New("smoothing is only supported for the CKF and SRIF")
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package smd
+
+import (
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+	"github.com/spf13/viper"
+)
+
+func testODConfig(filter ODFilterType) ODConfig {
+	P0 := mat64.NewSymDense(6, nil)
+	for i := 0; i < 3; i++ {
+		P0.SetSym(i, i, 10)
+		P0.SetSym(i+3, i+3, 0.01)
+	}
+	return ODConfig{Filter: filter, EKFTrigger: 10, P0: P0, Noise: map[MeasurementType]float64{MeasRange: 1e-3, MeasRangeRate: 1e-6}}
+}
+
+func TestOrbitDetermination(t *testing.T) {
+	end := testStart.Add(30 * time.Minute)
+	stations := []Station{DSS13Goldstone, DSS34Canberra, DSS65Madrid}
+	truth := testGEO()
+	meas := simulateODMeasurements(truth, testStart, end, stations)
+	if meas.Count == 0 {
+		t.Fatal("no measurements simulated")
+	}
+	for i, filter := range []ODFilterType{FilterCKF, FilterEKF, FilterSRIF, FilterUKF, FilterUKF, FilterBLS} {
+		conf := testODConfig(filter)
+		conf.Smooth = filter == FilterSRIF
+		conf.UKFSquareRoot = i == 4
+		// The reference trajectory starts at the first measurement, like the truth one.
+		first := meas.Epochs[0]
+		mEst := testGEOEstimate(first)
+		result, err := NewOrbitDetermination(mEst, meas, conf).Run()
+		if err != nil {
+			t.Fatalf("%s: %s", filter, err)
+		}
+		if result.Measurements != len(meas.Epochs) || result.VisibilityErrors != 0 {
+			t.Fatalf("%s: processed %d of %d measurements (%d visibility errors)", filter, result.Measurements, len(meas.Epochs), result.VisibilityErrors)
+		}
+		if len(result.RowNames) != 6 || !strings.HasPrefix(result.RowNames[0], DSS13Goldstone.Name) {
+			t.Fatalf("%s: invalid rows %v", filter, result.RowNames)
+		}
+		// The reference trajectory is the truth, so the estimated deviation and the residuals are nil, except for the
+		// UKF whose sigma points spread over the initial covariance (and which is then only within its bounds).
+		if pos, vel := result.RMS(); (filter != FilterUKF && (pos > 1e-3 || vel > 1e-6)) || math.IsNaN(pos) {
+			t.Fatalf("%s: invalid RMS %e %e", filter, pos, vel)
+		}
+		for _, est := range result.Estimates {
+			if !est.IsWithinNσ(3) {
+				t.Fatalf("%s: estimate not within 3σ at %s", filter, est.DT)
+			}
+		}
+		for _, est := range result.Estimates {
+			if est.Residual == nil {
+				continue
+			}
+			for i := 0; i < est.Residual.Len(); i++ {
+				// Each station has a range and a range rate row.
+				bound := 1e-3
+				if filter == FilterUKF {
+					bound = 3 * math.Sqrt(conf.Noise[[]MeasurementType{MeasRange, MeasRangeRate}[i%2]])
+				}
+				if math.Abs(est.Residual.At(i, 0)) > bound {
+					t.Fatalf("%s: invalid residuals at %s: %v", filter, est.DT, mat64.Formatted(est.Residual.T()))
+				}
+			}
+		}
+	}
+}
+
+func TestBatchLeastSquares(t *testing.T) {
+	stations := []Station{DSS13Goldstone, DSS34Canberra, DSS65Madrid}
+	meas := simulateODMeasurements(testGEO(), testStart, testStart.Add(30*time.Minute), stations)
+	first := meas.Epochs[0]
+	Rt, Vt := testGEO().RV()
+	for _, criterion := range []BLSCriterion{BLSResidualsRMS, BLSCorrection} {
+		// The initial state is off by 1 km and 1 m/s.
+		R, V := testGEO().RV()
+		R[0]++
+		V[1] += 1e-3
+		mEst := testMission(NewEmptySC("est", 0), NewOrbitFromRV(R, V, Earth), first, Perturbations{}, true)
+		conf := testODConfig(FilterBLS)
+		// The a priori information pulls the estimate towards the initial state, within the formal covariance.
+		conf.BLSAPriori = criterion == BLSResidualsRMS
+		conf.BLSCriterion = criterion
+		conf.BLSTolerance = 1e-6
+		result, err := NewOrbitDetermination(mEst, meas, conf).Run()
+		if err != nil {
+			t.Fatalf("%s: %s", criterion, err)
+		}
+		if !result.Converged || result.Iterations < 2 || result.Measurements != len(meas.Epochs) {
+			t.Fatalf("%s: converged=%t after %d iterations with %d measurements", criterion, result.Converged, result.Iterations, result.Measurements)
+		}
+		// The last reference trajectory and the estimated deviation lead to the truth.
+		est := result.Estimates[0]
+		R, V = est.Orbit.RV()
+		for i := 0; i < 3; i++ {
+			δr := R[i] + est.State().At(i, 0) - Rt[i]
+			δv := V[i] + est.State().At(i+3, 0) - Vt[i]
+			boundR, boundV := 1e-3, 1e-6
+			if conf.BLSAPriori {
+				boundR, boundV = 3*math.Sqrt(est.Covariance().At(i, i)), 3*math.Sqrt(est.Covariance().At(i+3, i+3))
+			}
+			if math.Abs(δr) > boundR || math.Abs(δv) > boundV {
+				t.Fatalf("%s: invalid estimate (δr[%d]=%e, δv[%d]=%e)", criterion, i, δr, i, δv)
+			}
+		}
+		if σ := math.Sqrt(est.Covariance().At(0, 0)); σ == 0 || math.IsNaN(σ) || (conf.BLSAPriori && σ > math.Sqrt(conf.P0.At(0, 0))) {
+			t.Fatalf("%s: invalid formal covariance (σx=%e)", criterion, σ)
+		}
+	}
+}
+
+func TestOrbitDeterminationErrors(t *testing.T) {
+	meas := simulateODMeasurements(testGEO(), testStart, testStart.Add(5*time.Minute), []Station{DSS13Goldstone})
+	conf := testODConfig(FilterCKF)
+	delete(conf.Noise, MeasRangeRate)
+	mEst := NewPreciseMission(NewEmptySC("est", 0), testGEO(), meas.Epochs[0], testStart, Perturbations{}, StepSize, true, ExportConfig{})
+	if _, err := NewOrbitDetermination(mEst, meas, conf).Run(); err == nil {
+		t.Fatal("missing noise of the range rate accepted")
+	}
+	assertPanic(t, func() {
+		NewOrbitDetermination(mEst, NewODMeasurements(nil), conf)
+	})
+	if err := meas.Add(Measurement{Station: DSS65Madrid}); err == nil {
+		t.Fatal("measurement from an unknown station accepted")
+	}
+	meas.Trim(testStart.Add(time.Minute), testStart.Add(2*time.Minute))
+	if len(meas.Epochs) != 7 || meas.Count != 7 {
+		t.Fatalf("invalid trimming: %d epochs, %d measurements", len(meas.Epochs), meas.Count)
+	}
+}
+
+func TestODConfigFromConfig(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(strings.NewReader(`[filter]
+type = "SRIF"
+[SRIF]
+smooth = true
+[SNC]
+enabled = true
+RICframe = true
+disableTime = 1200
+[noise]
+Q = 1e-12
+range = 1e-3
+ra = 1e-8
+[covariance]
+position = 10
+velocity = 0.01`)); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := ODConfigFromConfig(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Filter != FilterSRIF || !conf.Smooth || !conf.SNC || !conf.SNCRIC || conf.SNCDisableTime != 1200 {
+		t.Fatalf("invalid configuration: %+v", conf)
+	}
+	if conf.Q.At(0, 0) != 1e-12 || conf.Q.At(1, 1) != 0 || conf.P0.At(2, 2) != 10 || conf.P0.At(5, 5) != 0.01 {
+		t.Fatal("invalid SNC noise or initial covariance")
+	}
+	if len(conf.Noise) != 2 || conf.Noise[MeasRange] != 1e-3 || conf.Noise[MeasRightAscension] != 1e-8 {
+		t.Fatalf("invalid noise: %v", conf.Noise)
+	}
+	v.Set("filter.type", "UKF")
+	v.Set("UKF.alpha", 1e-3)
+	v.Set("UKF.squareRoot", true)
+	if conf, err = ODConfigFromConfig(v); err != nil || conf.Filter != FilterUKF || conf.UKFAlpha != 1e-3 || !conf.UKFSquareRoot {
+		t.Fatalf("invalid UKF configuration: %+v (%v)", conf, err)
+	}
+	v.Set("filter.type", "BLS")
+	v.Set("BLS.iterations", 5)
+	v.Set("BLS.criterion", "correction")
+	v.Set("BLS.apriori", true)
+	if conf, err = ODConfigFromConfig(v); err != nil || conf.Filter != FilterBLS || conf.BLSIterations != 5 || conf.BLSCriterion != BLSCorrection || !conf.BLSAPriori {
+		t.Fatalf("invalid BLS configuration: %+v (%v)", conf, err)
+	}
+	v.Set("BLS.criterion", "chi2")
+	if _, err = ODConfigFromConfig(v); err == nil {
+		t.Fatal("unknown convergence criterion accepted")
+	}
+	v.Set("filter.type", "LSQ")
+	if _, err := ODConfigFromConfig(v); err == nil {
+		t.Fatal("unknown filter accepted")
+	}
+}
+
+func TestLoadODMeasurements(t *testing.T) {
+	f, err := os.CreateTemp("", "meas*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("station,epoch,jde,range,rate\nDSS34Canberra,2015-02-03 00:00:10,2457056.5,36000.5,0.25\nUnknown,2015-02-03 00:00:10,2457056.5,1,1\nDSS34Canberra,2015-02-03 00:00:00,2457056.5,36000,0.2\n")
+	f.Close()
+	meas, err := LoadODMeasurements(f.Name(), []Station{DSS34Canberra})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meas.Count != 2 || len(meas.Epochs) != 2 || !meas.Epochs[0].Before(meas.Epochs[1]) {
+		t.Fatalf("invalid measurements: %+v", meas.Epochs)
+	}
+	if m, _ := meas.At(meas.Epochs[1]); m[0].Range != 36000.5 || m[0].RangeRate != 0.25 {
+		t.Fatalf("invalid measurement: %+v", m[0])
+	}
+}
@@ -0,0 +1,126 @@
+package smd
+
+import (
+	"errors"
+	"math"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+/* Editing of the measurements of the orbit determination. The pre-fit residuals of the sequential filters are the
+observation deviations minus the measurements of the predicted deviation, i.e. the innovations ν = y - H*xBar, whose
+covariance is S = H*PBar*H^T + R. A row is rejected if its innovation exceeds N times its σ, and the normalized
+innovation squared (NIS) ν^T*S^-1*ν of the accepted rows follows a χ² distribution (with as many degrees of freedom as
+accepted rows) if the filter is consistent. From its second iteration, the batch rejects the rows whose weighted pre-fit
+residual exceeds N times the weighted RMS of the previous iteration (or N if that RMS is below one). */
+
+// ODRowStatus is the status of a row of the stacked measurements at an epoch.
+type ODRowStatus uint8
+
+const (
+	// RowUnobserved is a row without measurement at this epoch.
+	RowUnobserved ODRowStatus = iota
+	// RowAccepted is a measurement processed by the filter.
+	RowAccepted
+	// RowRejected is a measurement rejected by the residual editing.
+	RowRejected
+)
+
+func (s ODRowStatus) String() string {
+	switch s {
+	case RowUnobserved:
+		return "unobserved"
+	case RowAccepted:
+		return "accepted"
+	case RowRejected:
+		return "rejected"
+	default:
+		panic("unknown row status")
+	}
+}
+
+// observedRows returns the status of each row of the stacked measurements, i.e. accepted if its station sees the
+// spacecraft.
+func observedRows(measurements []Measurement, stationRows []int, numRows int) []ODRowStatus {
+	status := make([]ODRowStatus, numRows)
+	for measPos, measurement := range measurements {
+		if !measurement.Visible {
+			continue
+		}
+		for i := range measurement.MeasurementTypes() {
+			status[stationRows[measPos]+i] = RowAccepted
+		}
+	}
+	return status
+}
+
+// innovationCovariance returns H*PBar*H^T + R.
+func innovationCovariance(H mat64.Matrix, Pbar, noiseR mat64.Symmetric) *mat64.SymDense {
+	var PHt, HPHt mat64.Dense
+	PHt.Mul(Pbar, H.T())
+	HPHt.Mul(H, &PHt)
+	rows, _ := HPHt.Dims()
+	S := mat64.NewSymDense(rows, nil)
+	for i := 0; i < rows; i++ {
+		for j := i; j < rows; j++ {
+			S.SetSym(i, j, (HPHt.At(i, j)+HPHt.At(j, i))/2+noiseR.At(i, j))
+		}
+	}
+	return S
+}
+
+// editInnovations rejects the accepted rows whose innovation exceeds N times its σ (no editing if N is not positive),
+// and returns the σ of each innovation.
+func editInnovations(ν *mat64.Vector, S mat64.Symmetric, status []ODRowStatus, N float64) (σ *mat64.Vector) {
+	σ = mat64.NewVector(ν.Len(), nil)
+	for i := 0; i < ν.Len(); i++ {
+		if status[i] == RowUnobserved {
+			continue
+		}
+		σ.SetVec(i, math.Sqrt(S.At(i, i)))
+		if N > 0 && math.Abs(ν.At(i, 0)) > N*σ.At(i, 0) {
+			status[i] = RowRejected
+		}
+	}
+	return
+}
+
+// normalizedInnovation returns the NIS of the accepted rows.
+func normalizedInnovation(ν *mat64.Vector, S mat64.Symmetric, status []ODRowStatus) (float64, error) {
+	var accepted []int
+	for i, s := range status {
+		if s == RowAccepted {
+			accepted = append(accepted, i)
+		}
+	}
+	if len(accepted) == 0 {
+		return 0, nil
+	}
+	Sa := mat64.NewSymDense(len(accepted), nil)
+	νa := mat64.NewVector(len(accepted), nil)
+	for i, row := range accepted {
+		νa.SetVec(i, ν.At(row, 0))
+		for j := i; j < len(accepted); j++ {
+			Sa.SetSym(i, j, S.At(row, accepted[j]))
+		}
+	}
+	var chol mat64.Cholesky
+	if !chol.Factorize(Sa) {
+		return 0, errors.New("the innovation covariance is not positive definite")
+	}
+	var Sν mat64.Vector
+	if err := Sν.SolveCholeskyVec(&chol, νa); err != nil {
+		return 0, err
+	}
+	return mat64.Dot(νa, &Sν), nil
+}
+
+// countRows returns the number of rows with the provided status.
+func countRows(status []ODRowStatus, which ODRowStatus) (count int) {
+	for _, s := range status {
+		if s == which {
+			count++
+		}
+	}
+	return
+}
@@ -0,0 +1,85 @@
+package smd
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestResidualEditing(t *testing.T) {
+	stations := []Station{DSS13Goldstone, DSS34Canberra, DSS65Madrid}
+	meas := simulateODMeasurements(testGEO(), testStart, testStart.Add(30*time.Minute), stations)
+	// Add a 10 km outlier to the range of the first station seeing the spacecraft at the middle epoch.
+	outlierDT := meas.Epochs[len(meas.Epochs)/2]
+	measurements, _ := meas.At(outlierDT)
+	outlierRow := -1
+	for pos, m := range measurements {
+		if m.Visible {
+			measurements[pos].Range += 10
+			outlierRow = 2 * pos
+			break
+		}
+	}
+	if outlierRow < 0 {
+		t.Fatal("no visible measurement at the middle epoch")
+	}
+	for _, filter := range []ODFilterType{FilterCKF, FilterSRIF, FilterUKF, FilterBLS} {
+		for _, editSigma := range []float64{0, 3} {
+			conf := testODConfig(filter)
+			conf.EditSigma = editSigma
+			first := meas.Epochs[0]
+			mEst := testGEOEstimate(first)
+			result, err := NewOrbitDetermination(mEst, meas, conf).Run()
+			if err != nil {
+				t.Fatalf("%s (%.0fσ): %s", filter, editSigma, err)
+			}
+			if expected := int(editSigma / 3); result.Rejected != expected {
+				t.Fatalf("%s (%.0fσ): %d rows rejected instead of %d", filter, editSigma, result.Rejected, expected)
+			}
+			if len(result.RowStations) != 6 || result.RowTypes[1] != MeasRangeRate || result.RowSigma[0] != math.Sqrt(conf.Noise[MeasRange]) {
+				t.Fatalf("%s: invalid description of the rows %v %v %v", filter, result.RowStations, result.RowTypes, result.RowSigma)
+			}
+			for _, est := range result.Estimates {
+				if !est.DT.Equal(outlierDT) {
+					continue
+				}
+				status := est.Rows[outlierRow]
+				if (editSigma == 0 && status != RowAccepted) || (editSigma > 0 && status != RowRejected) {
+					t.Fatalf("%s (%.0fσ): outlier %s", filter, editSigma, status)
+				}
+				if est.Prefit.At(outlierRow, 0) < 5 {
+					t.Fatalf("%s (%.0fσ): invalid pre-fit residual of the outlier %f", filter, editSigma, est.Prefit.At(outlierRow, 0))
+				}
+			}
+			if editSigma == 0 || filter == FilterUKF || filter == FilterBLS {
+				continue
+			}
+			// The rejected outlier does not change the estimate, whose reference trajectory is the truth (the reference of
+			// the BLS is corrected by its first iteration, which processes the outlier).
+			if pos, vel := result.RMS(); pos > 1e-3 || vel > 1e-6 {
+				t.Fatalf("%s: outlier processed (RMS %e %e)", filter, pos, vel)
+			}
+		}
+	}
+}
+
+func TestInnovationConsistency(t *testing.T) {
+	ν := mat64.NewVector(3, []float64{1, -3, 0.5})
+	S := mat64.NewSymDense(3, []float64{1, 0, 0, 0, 1, 0, 0, 0, 4})
+	status := []ODRowStatus{RowAccepted, RowAccepted, RowUnobserved}
+	σ := editInnovations(ν, S, status, 2)
+	if status[0] != RowAccepted || status[1] != RowRejected || status[2] != RowUnobserved {
+		t.Fatalf("invalid editing: %v", status)
+	}
+	if σ.At(0, 0) != 1 || σ.At(2, 0) != 0 {
+		t.Fatalf("invalid σ: %v", mat64.Formatted(σ.T()))
+	}
+	if nis, err := normalizedInnovation(ν, S, status); err != nil || nis != 1 {
+		t.Fatalf("invalid NIS %f (%v)", nis, err)
+	}
+	if countRows(status, RowRejected) != 1 || countRows(status, RowAccepted) != 1 {
+		t.Fatal("invalid count of the rows")
+	}
+}
@@ -0,0 +1,258 @@
+package smd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ODMeasurements stores the measurements of the stations used for the orbit determination, grouped by epoch. Each
+// epoch holds one measurement per station, in the order of the stations, which is nil if that station has no
+// measurement at that epoch.
+type ODMeasurements struct {
+	Stations []Station
+	Epochs   []time.Time // Sorted epochs of the measurements
+	Count    int         // Number of station measurements
+	byEpoch  map[time.Time][]Measurement
+	ordering map[string]int
+}
+
+// NewODMeasurements returns an empty set of measurements of the provided stations.
+func NewODMeasurements(stations []Station) *ODMeasurements {
+	ordering := make(map[string]int)
+	for pos, st := range stations {
+		ordering[st.Name] = pos
+	}
+	return &ODMeasurements{stations, nil, 0, make(map[time.Time][]Measurement), ordering}
+}
+
+// Add adds the provided measurement, which must be from one of the stations. The epochs must be sorted once all the
+// measurements are added if they are not added chronologically (cf. Sort).
+func (m *ODMeasurements) Add(meas Measurement) error {
+	pos, found := m.ordering[meas.Station.Name]
+	if !found {
+		return fmt.Errorf("unknown station `%s`", meas.Station.Name)
+	}
+	if _, exists := m.byEpoch[meas.Epoch]; !exists {
+		m.byEpoch[meas.Epoch] = make([]Measurement, len(m.Stations))
+		m.Epochs = append(m.Epochs, meas.Epoch)
+	}
+	if m.byEpoch[meas.Epoch][pos].Station.Name == "" {
+		m.Count++
+	}
+	m.byEpoch[meas.Epoch][pos] = meas
+	return nil
+}
+
+// Sort sorts the epochs of the measurements chronologically.
+func (m *ODMeasurements) Sort() {
+	sort.Slice(m.Epochs, func(i, j int) bool { return m.Epochs[i].Before(m.Epochs[j]) })
+}
+
+// At returns the measurements of all the stations at the provided epoch, and whether there is any.
+func (m *ODMeasurements) At(epoch time.Time) ([]Measurement, bool) {
+	measurements, exists := m.byEpoch[epoch]
+	return measurements, exists
+}
+
+// Trim removes the measurements before start and after end.
+func (m *ODMeasurements) Trim(start, end time.Time) {
+	trimmed := []time.Time{}
+	for _, dt := range m.Epochs {
+		if dt.Before(start) || dt.After(end) {
+			for _, meas := range m.byEpoch[dt] {
+				if meas.Station.Name != "" {
+					m.Count--
+				}
+			}
+			delete(m.byEpoch, dt)
+			continue
+		}
+		trimmed = append(trimmed, dt)
+	}
+	m.Epochs = trimmed
+}
+
+// LoadODMeasurements loads the measurements of the provided stations from a CCSDS TDM (if the file has a TDM
+// extension) or from the CSV generated by cmd/mission. Measurements from unknown stations are skipped.
+func LoadODMeasurements(filename string, stations []Station) (*ODMeasurements, error) {
+	if isTDMFile(filename) {
+		return loadTDMMeasurements(filename, stations)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	m := NewODMeasurements(stations)
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanLines)
+	cnt := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// Remove double quotes
+		line = strings.Replace(line, "\"", "", -1)
+		if len(line) == 0 || line[0:1] == "#" {
+			continue
+		}
+		if cnt == 0 { // Skip header line
+			cnt++
+			continue
+		}
+		// "DSS34Canberra","2015-02-03 01:56:00 +0000 UTC",2457056.580556,16.715366,0.148457,
+		entries := strings.Split(line, ",")
+		stationName := entries[0]
+		// Check that the station exists, and complain otherwise.
+		pos, stExists := m.ordering[stationName]
+		if !stExists {
+			log.Printf("[WARNING] skipping unknown station `%s` in measurement file\n", stationName)
+			continue
+		}
+		station := stations[pos]
+		if len(station.Types) > 0 {
+			return nil, fmt.Errorf("station `%s` has measurement types %v but CSV files only contain the range and range rate (use a TDM)", stationName, station.Types)
+		}
+		stateDT, perr := time.Parse("2006-01-02 15:04:05", entries[1])
+		if perr != nil {
+			log.Printf("[WARNING] skipping malformatted date `%s` in measurement file: %s\n", entries[1], perr)
+			continue
+		}
+		// Older files have a θgst column before the range, which is now computed from the epoch.
+		rangeCol := 3
+		if len(entries) > 6 || (len(entries) == 6 && entries[5] != "") {
+			rangeCol = 4
+		}
+		if len(entries) < rangeCol+2 {
+			log.Printf("[WARNING] skipping malformatted line `%s` in measurement file\n", line)
+			continue
+		}
+		stRange, ferr0 := strconv.ParseFloat(entries[rangeCol], 64)
+		if ferr0 != nil {
+			log.Printf("[WARNING] skipping malformatted range `%s` in measurement file: %s\n", entries[rangeCol], ferr0)
+			continue
+		}
+		stRate, ferr1 := strconv.ParseFloat(entries[rangeCol+1], 64)
+		if ferr1 != nil {
+			log.Printf("[WARNING] skipping malformatted range rate `%s` in measurement file: %s\n", entries[rangeCol+1], ferr1)
+			continue
+		}
+		m.Add(Measurement{Visible: true, Range: stRange, RangeRate: stRate, Epoch: stateDT, State: State{DT: stateDT}, Station: station})
+		cnt++
+	}
+	m.Sort()
+	return m, scanner.Err()
+}
+
+// loadTDMMeasurements loads the measurements of the provided stations from a CCSDS TDM.
+func loadTDMMeasurements(filename string, stations []Station) (*ODMeasurements, error) {
+	tdm, err := LoadTDM(filename)
+	if err != nil {
+		return nil, err
+	}
+	m := NewODMeasurements(stations)
+	for _, seg := range tdm.Segments {
+		pos, stExists := m.ordering[seg.Station()]
+		if !stExists {
+			log.Printf("[WARNING] skipping unknown station `%s` in TDM\n", seg.Station())
+			continue
+		}
+		station := stations[pos]
+		if seg.LinkType() != station.Link.Type {
+			log.Printf("[WARNING] %s link of %s in TDM but station is configured as %s\n", seg.LinkType(), station.Name, station.Link.Type)
+		}
+		// Group the observations of each epoch by measurement type.
+		types := station.MeasurementTypes()
+		observations := make(map[time.Time]map[MeasurementType]float64)
+		unsupported := make(map[string]bool)
+		for _, obs := range seg.Observations {
+			measType, err := seg.MeasurementType(obs.Keyword)
+			if err != nil {
+				if !unsupported[obs.Keyword] {
+					log.Printf("[WARNING] skipping %s observations of %s in TDM: %s\n", obs.Keyword, station.Name, err)
+					unsupported[obs.Keyword] = true
+				}
+				continue
+			}
+			if _, exists := observations[obs.Epoch]; !exists {
+				observations[obs.Epoch] = make(map[MeasurementType]float64)
+			}
+			observations[obs.Epoch][measType] = obs.Value
+		}
+		for stateDT, values := range observations {
+			measurement := Measurement{Visible: true, Epoch: stateDT, State: State{DT: stateDT}, Station: station, Types: types, Values: make([]float64, len(types))}
+			complete := true
+			for i, measType := range types {
+				if measurement.Values[i], complete = values[measType]; !complete {
+					log.Printf("[WARNING] skipping %s observations at %s without %s in TDM\n", station.Name, stateDT, measType)
+					break
+				}
+			}
+			if complete {
+				m.Add(measurement)
+			}
+		}
+	}
+	m.Sort()
+	return m, nil
+}
+
+// Export writes the measurements of the spacecraft to the provided file, as a CCSDS TDM (KVN, or XML with an .xml
+// extension) if the file has a TDM extension, and as the CSV read by LoadODMeasurements otherwise (which only has the
+// range and range rate).
+func (m *ODMeasurements) Export(filename, spacecraft string) error {
+	if !isTDMFile(filename) {
+		for _, st := range m.Stations {
+			if len(st.Types) > 0 {
+				return fmt.Errorf("station `%s` has measurement types %v which require a TDM file", st.Name, st.Types)
+			}
+		}
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if isTDMFile(filename) {
+		tdm := NewTDM()
+		for _, st := range m.Stations {
+			seg, err := NewStationTDMSegment(st, spacecraft)
+			if err != nil {
+				return err
+			}
+			tdm.Segments = append(tdm.Segments, seg)
+		}
+		for _, dt := range m.Epochs {
+			for pos, meas := range m.byEpoch[dt] {
+				if meas.Station.Name != "" {
+					tdm.Segments[pos].AddMeasurement(meas)
+				}
+			}
+		}
+		if strings.ToLower(filepath.Ext(filename)) == ".xml" {
+			return tdm.WriteXML(f)
+		}
+		return tdm.WriteKVN(f)
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "# Creation date (UTC): %s\n\"station name\",\"epoch UTC\",\"Julian day\",\"range (km)\",\"range rate (km/s)\"\n", time.Now().UTC())
+	for _, dt := range m.Epochs {
+		for _, meas := range m.byEpoch[dt] {
+			if meas.Station.Name != "" {
+				fmt.Fprintf(w, "\"%s\",\"%s\",%f,%s\n", meas.Station.Name, dt.Format("2006-01-02 15:04:05"), timeToJD(dt), meas.ShortCSV())
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// isTDMFile returns whether the measurement file is a CCSDS TDM (KVN or XML) from its extension.
+func isTDMFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".tdm" || ext == ".kvn" || ext == ".xml"
+}
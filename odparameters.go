@@ -0,0 +1,599 @@
+package smd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+	"github.com/spf13/viper"
+)
+
+/* Parameters of the orbit determination in addition to the position and velocity, cf. Tapley, Schutz and Born,
+"Statistical Orbit Determination", 2004, section 4.2. The solve-for parameters are appended to the estimated state,
+whose STM is [[Φ, Ψ], [0, I]] where Ψ maps the parameters to the position and velocity. The Ψ of each integration
+step is computed with the trapezoidal rule from the partials of the acceleration (by central differences), and the
+impulsive maneuvers are mapped at the start of their step. The considered parameters are kept at their nominal
+value, and their partials follow the solve-for ones in the augmented STM and H (cf. consider.go). */
+
+// ODParameterType defines a parameter of the orbit determination.
+type ODParameterType uint8
+
+const (
+	// ParamCr is the coefficient of reflectivity of the SRP (cf. Perturbations.Drag).
+	ParamCr ODParameterType = iota + 1
+	// ParamCd is the drag coefficient (cf. Perturbations.AtmosphericDrag).
+	ParamCd
+	// ParamGM is the gravitational parameter of the central body in km^3/s^2.
+	ParamGM
+	// ParamRangeBias is the bias of the range (km) and DSN range (RU) measurements of a station.
+	ParamRangeBias
+	// ParamDopplerBias is the bias of the range rate and integrated Doppler measurements of a station (km/s).
+	ParamDopplerBias
+	// ParamStationPosition is the offset of the position of a station in its body fixed frame (km).
+	ParamStationPosition
+	// ParamManeuver is the error of the R, N and C components of the Δv of a maneuver (km/s).
+	ParamManeuver
+	// ParamEphemeris is the error of the position of the perturbing body (km), which can only be considered.
+	ParamEphemeris
+)
+
+func (t ODParameterType) String() string {
+	switch t {
+	case ParamCr:
+		return "Cr"
+	case ParamCd:
+		return "Cd"
+	case ParamGM:
+		return "GM"
+	case ParamRangeBias:
+		return "rangeBias"
+	case ParamDopplerBias:
+		return "dopplerBias"
+	case ParamStationPosition:
+		return "stationPosition"
+	case ParamManeuver:
+		return "maneuver"
+	case ParamEphemeris:
+		return "ephemeris"
+	default:
+		panic("unknown parameter type")
+	}
+}
+
+// ODParameterTypeFromString returns the parameter type from its name.
+func ODParameterTypeFromString(name string) (ODParameterType, error) {
+	for t := ParamCr; t <= ParamEphemeris; t++ {
+		if strings.ToLower(name) == strings.ToLower(t.String()) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown parameter `%s`", name)
+}
+
+// Size returns the number of components of the parameter.
+func (t ODParameterType) Size() int {
+	if t == ParamStationPosition || t == ParamManeuver || t == ParamEphemeris {
+		return 3
+	}
+	return 1
+}
+
+// ODParameter is a parameter of the orbit determination, which is either estimated (solve-for) or considered.
+type ODParameter struct {
+	Type     ODParameterType
+	Station  string    // Name of the station of the biases and position offsets (cf. Station.Name)
+	Epoch    time.Time // Epoch of the maneuver
+	Consider bool      // Considered instead of estimated
+	Sigma    float64   // A priori standard deviation of each component
+}
+
+// Names returns the name of each component of the parameter.
+func (p ODParameter) Names() []string {
+	switch p.Type {
+	case ParamRangeBias, ParamDopplerBias:
+		return []string{fmt.Sprintf("%s %s", p.Station, p.Type)}
+	case ParamStationPosition:
+		return []string{p.Station + " dx", p.Station + " dy", p.Station + " dz"}
+	case ParamManeuver:
+		epoch := p.Epoch.Format(time.RFC3339)
+		return []string{"maneuver " + epoch + " R", "maneuver " + epoch + " N", "maneuver " + epoch + " C"}
+	case ParamEphemeris:
+		return []string{"ephemeris dx", "ephemeris dy", "ephemeris dz"}
+	default:
+		return []string{p.Type.String()}
+	}
+}
+
+func (p ODParameter) String() string {
+	kind := "solve-for"
+	if p.Consider {
+		kind = "consider"
+	}
+	return fmt.Sprintf("%s (%s, σ=%g)", strings.Join(p.Names(), ", "), kind, p.Sigma)
+}
+
+// odParametersFromConfig returns the parameters defined in the `[parameters.<number>]` sections of the provided
+// configuration, numbered from zero.
+func odParametersFromConfig(v *viper.Viper) (params []ODParameter, err error) {
+	for no := 0; v.IsSet(fmt.Sprintf("parameters.%d", no)); no++ {
+		prefix := fmt.Sprintf("parameters.%d.", no)
+		var param ODParameter
+		if param.Type, err = ODParameterTypeFromString(v.GetString(prefix + "type")); err != nil {
+			return nil, fmt.Errorf("parameter #%d: %s", no, err)
+		}
+		param.Station = v.GetString(prefix + "station")
+		param.Consider = v.GetBool(prefix + "consider")
+		param.Sigma = v.GetFloat64(prefix + "sigma")
+		switch param.Type {
+		case ParamRangeBias, ParamDopplerBias, ParamStationPosition:
+			if param.Station == "" {
+				return nil, fmt.Errorf("parameter #%d: missing `station` of %s", no, param.Type)
+			}
+		case ParamEphemeris:
+			if !param.Consider {
+				return nil, fmt.Errorf("parameter #%d: the ephemeris can only be considered", no)
+			}
+		case ParamManeuver:
+			if !v.IsSet(prefix + "epoch") {
+				return nil, fmt.Errorf("parameter #%d: missing `epoch` of the maneuver", no)
+			}
+			if param.Epoch, err = configEpoch(v.Get(prefix + "epoch")); err != nil {
+				return nil, fmt.Errorf("parameter #%d: %s", no, err)
+			}
+		}
+		if param.Sigma <= 0 {
+			return nil, fmt.Errorf("parameter #%d: `sigma` must be positive", no)
+		}
+		params = append(params, param)
+	}
+	return
+}
+
+// odParameters stores the values of the parameters of an orbit determination, and computes their partials.
+type odParameters struct {
+	list      []ODParameter
+	values    [][]float64 // Current value of each component of each parameter
+	columns   []int       // First column of each parameter in the augmented state (solve-for, then considered)
+	size      int         // Number of solve-for components
+	consider  int         // Number of considered components
+	perts     Perturbations
+	step      time.Duration
+	prevDT    time.Time // Previous state of the reference trajectory (cf. transition)
+	prevOrbit Orbit
+	prevB     *mat64.Dense
+	Δt        float64      // Seconds of the last transition
+	dmc       *GaussMarkov // Reference of the DMC accelerations, nil without DMC
+	dmcColumn int          // First column of the DMC accelerations, followed by their time constants
+	dmcSigma  float64      // Steady state σ of the DMC accelerations
+	dmcTauσ   float64      // A priori σ of the time constants
+}
+
+// newODParameters returns the parameters of the orbit determination with their nominal values from its mission.
+func newODParameters(od *OrbitDetermination) (*odParameters, error) {
+	perts := od.Mission.perts
+	perts.Noise = OrbitNoise{}
+	p := &odParameters{list: make([]ODParameter, len(od.Config.Parameters)), perts: perts, step: od.Mission.step}
+	copy(p.list, od.Config.Parameters)
+	for i, param := range p.list {
+		nominal := make([]float64, param.Type.Size())
+		switch param.Type {
+		case ParamCr:
+			if !perts.Drag {
+				return nil, errors.New("Cr requires the SRP (cf. Perturbations.Drag)")
+			}
+			nominal[0] = od.Mission.Vehicle.Drag
+		case ParamCd:
+			if !perts.AtmosphericDrag {
+				return nil, errors.New("Cd requires the atmospheric drag")
+			}
+			nominal[0] = od.Mission.Vehicle.Cd
+		case ParamGM:
+			nominal[0] = od.Mission.Orbit.Origin.μ
+		case ParamRangeBias, ParamDopplerBias, ParamStationPosition:
+			found := false
+			for _, st := range od.Measurements.Stations {
+				found = found || strings.ToLower(st.Name) == strings.ToLower(param.Station)
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown station `%s` of %s", param.Station, param.Type)
+			}
+		case ParamManeuver:
+			exists := false
+			for dt, maneuver := range od.Mission.Vehicle.Maneuvers {
+				if dt.Equal(param.Epoch) {
+					// Use the key of the maneuver whose time zone may differ.
+					p.list[i].Epoch = dt
+					nominal = []float64{maneuver.R, maneuver.N, maneuver.C}
+					exists = true
+				}
+			}
+			if !exists {
+				return nil, fmt.Errorf("no maneuver at %s", param.Epoch)
+			}
+		case ParamEphemeris:
+			if !param.Consider {
+				return nil, errors.New("the ephemeris can only be considered")
+			}
+			if perts.PerturbingBody == nil {
+				return nil, errors.New("the ephemeris requires a perturbing body")
+			}
+		}
+		p.values = append(p.values, nominal)
+		if !param.Consider {
+			p.size += param.Type.Size()
+		}
+	}
+	if conf := od.Config; conf.DMC {
+		// The reference of the DMC accelerations is also used by the mission which propagates the reference trajectory.
+		p.dmc = &GaussMarkov{od.Mission.CurrentDT, make([]float64, 3), []float64{conf.DMCTau, conf.DMCTau, conf.DMCTau}}
+		p.dmcColumn, p.dmcSigma, p.dmcTauσ = 6+p.size, conf.DMCSigma, conf.DMCTauSigma
+		p.size += 6
+		p.perts.DMC = p.dmc
+		od.Mission.perts.DMC = p.dmc
+	}
+	solveFor, considered := 6, 6+p.size
+	for _, param := range p.list {
+		if param.Consider {
+			p.columns = append(p.columns, considered)
+			considered += param.Type.Size()
+			continue
+		}
+		p.columns = append(p.columns, solveFor)
+		solveFor += param.Type.Size()
+	}
+	p.consider = considered - 6 - p.size
+	return p, nil
+}
+
+// augmented returns the size of the augmented state, i.e. the estimated state followed by the considered parameters.
+func (p *odParameters) augmented() int {
+	return 6 + p.size + p.consider
+}
+
+// stateNames returns the name of each component of the estimated state.
+func (p *odParameters) stateNames() []string {
+	names := []string{"x", "y", "z", "xDot", "yDot", "zDot"}
+	for _, param := range p.list {
+		if !param.Consider {
+			names = append(names, param.Names()...)
+		}
+	}
+	if p.dmc != nil {
+		names = append(names, "DMC wx", "DMC wy", "DMC wz", "DMC τx", "DMC τy", "DMC τz")
+	}
+	return names
+}
+
+// considerNames returns the name of each considered component.
+func (p *odParameters) considerNames() (names []string) {
+	for _, param := range p.list {
+		if param.Consider {
+			names = append(names, param.Names()...)
+		}
+	}
+	return
+}
+
+// dmcNoise returns the process noise of the estimated state over the last transition (cf. gaussMarkovNoise).
+func (p *odParameters) dmcNoise() *mat64.SymDense {
+	Q := mat64.NewSymDense(6+p.size, nil)
+	if p.Δt == 0 {
+		return Q
+	}
+	for k := 0; k < 3; k++ {
+		// The spectral density of the white noise is 2σ^2/τ for a steady state σ of the acceleration.
+		τ := p.dmc.TimeConstant[k]
+		Qaxis := gaussMarkovNoise(τ, p.Δt)
+		rows := []int{k, k + 3, p.dmcColumn + k}
+		for i := 0; i < 3; i++ {
+			for j := i; j < 3; j++ {
+				Q.SetSym(rows[i], rows[j], 2*p.dmcSigma*p.dmcSigma/τ*(Qaxis.At(i, j)+Qaxis.At(j, i))/2)
+			}
+		}
+	}
+	return Q
+}
+
+// covariance returns the initial covariance of the estimated state from the one of the position and velocity.
+func (p *odParameters) covariance(P0 mat64.Symmetric) *mat64.SymDense {
+	P := mat64.NewSymDense(6+p.size, nil)
+	for i := 0; i < 6; i++ {
+		for j := i; j < 6; j++ {
+			P.SetSym(i, j, P0.At(i, j))
+		}
+	}
+	for i, param := range p.list {
+		for k := 0; k < param.Type.Size() && !param.Consider; k++ {
+			P.SetSym(p.columns[i]+k, p.columns[i]+k, param.Sigma*param.Sigma)
+		}
+	}
+	for k := 0; k < 3 && p.dmc != nil; k++ {
+		P.SetSym(p.dmcColumn+k, p.dmcColumn+k, p.dmcSigma*p.dmcSigma)
+		P.SetSym(p.dmcColumn+3+k, p.dmcColumn+3+k, p.dmcTauσ*p.dmcTauσ)
+	}
+	return P
+}
+
+// estimated returns the estimated value of each solve-for component, from the deviation of the estimated state if
+// it is not nil.
+func (p *odParameters) estimated(x *mat64.Vector) (values []float64) {
+	for i, param := range p.list {
+		for k := 0; k < param.Type.Size() && !param.Consider; k++ {
+			value := p.values[i][k]
+			if x != nil {
+				value += x.At(p.columns[i]+k, 0)
+			}
+			values = append(values, value)
+		}
+	}
+	if p.dmc != nil {
+		// Accelerations at the last state of the reference trajectory, followed by the time constants.
+		values = append(values, p.dmc.At(p.prevDT)...)
+		values = append(values, p.dmc.TimeConstant...)
+		for k := 0; k < 6 && x != nil; k++ {
+			values[len(values)-6+k] += x.At(p.dmcColumn+k, 0)
+		}
+	}
+	return
+}
+
+// correct adds the deviation of the solve-for parameters of the estimated state to their values.
+func (p *odParameters) correct(x *mat64.Vector) {
+	for i, param := range p.list {
+		for k := 0; k < param.Type.Size() && !param.Consider; k++ {
+			p.values[i][k] += x.At(p.columns[i]+k, 0)
+		}
+	}
+	if p.dmc != nil {
+		w := p.dmc.At(p.prevDT)
+		τ := make([]float64, 3)
+		for k := 0; k < 3; k++ {
+			w[k] += x.At(p.dmcColumn+k, 0)
+			// A time constant is only corrected if it remains positive.
+			if τ[k] = p.dmc.TimeConstant[k] + x.At(p.dmcColumn+3+k, 0); τ[k] <= 0 {
+				τ[k] = p.dmc.TimeConstant[k]
+			}
+		}
+		p.dmc.Reset(p.prevDT, w, τ)
+	}
+}
+
+// apply sets the values of the dynamic parameters to the vehicle and orbit of the provided mission.
+func (p *odParameters) apply(m *Mission) {
+	for i, param := range p.list {
+		switch param.Type {
+		case ParamCr:
+			m.Vehicle.Drag = p.values[i][0]
+		case ParamCd:
+			m.Vehicle.Cd = p.values[i][0]
+		case ParamGM:
+			m.Orbit.Origin.μ = p.values[i][0]
+		case ParamManeuver:
+			// The done flag is kept so that an executed maneuver is not executed again.
+			maneuver := m.Vehicle.Maneuvers[param.Epoch]
+			maneuver.R, maneuver.N, maneuver.C = p.values[i][0], p.values[i][1], p.values[i][2]
+			m.Vehicle.Maneuvers[param.Epoch] = maneuver
+		}
+	}
+}
+
+// station returns the provided station with the offset of its position, if any.
+func (p *odParameters) station(st Station) Station {
+	for i, param := range p.list {
+		if param.Type != ParamStationPosition || strings.ToLower(param.Station) != strings.ToLower(st.Name) {
+			continue
+		}
+		R := make([]float64, 3)
+		for j := 0; j < 3; j++ {
+			R[j] = st.R[j] + p.values[i][j]
+		}
+		st.R = R
+		st.V = Cross([]float64{0, 0, st.Planet.surfaceRotationRate()}, R)
+	}
+	return st
+}
+
+// biased returns whether the provided parameter is a bias of the measurement type of the station.
+func (param ODParameter) biased(st Station, t MeasurementType) bool {
+	if strings.ToLower(param.Station) != strings.ToLower(st.Name) {
+		return false
+	}
+	switch param.Type {
+	case ParamRangeBias:
+		return t == MeasRange || t == MeasDSNRange
+	case ParamDopplerBias:
+		return t == MeasRangeRate || t == MeasIntegratedDoppler
+	default:
+		return false
+	}
+}
+
+// bias returns the sum of the biases of the measurement type of the station.
+func (p *odParameters) bias(st Station, t MeasurementType) (bias float64) {
+	for i, param := range p.list {
+		if param.biased(st, t) {
+			bias += p.values[i][0]
+		}
+	}
+	return
+}
+
+// measurementPartials sets the partials of the measurement type of the station (whose position is already offset)
+// with respect to the parameters in the provided row of the augmented H.
+func (p *odParameters) measurementPartials(H *mat64.Dense, row int, t MeasurementType, st Station, epoch time.Time, o Orbit) {
+	for i, param := range p.list {
+		col := p.columns[i]
+		if param.biased(st, t) {
+			H.Set(row, col, 1)
+		}
+		if param.Type != ParamStationPosition || strings.ToLower(param.Station) != strings.ToLower(st.Name) {
+			continue
+		}
+		// Central differences of the observation, which is wrapped for the angles.
+		h := 1e-3
+		for j := 0; j < 3; j++ {
+			var values [2]float64
+			for k, δ := range []float64{h, -h} {
+				offset := st
+				offset.R = []float64{st.R[0], st.R[1], st.R[2]}
+				offset.R[j] += δ
+				offset.V = Cross([]float64{0, 0, st.Planet.surfaceRotationRate()}, offset.R)
+				values[k], _, _ = offset.Observe(t, epoch, o)
+			}
+			H.Set(row, col+j, t.Difference(values[0], values[1])/(2*h))
+		}
+	}
+}
+
+// acceleration returns the acceleration of the spacecraft on the provided orbit, with the provided value of a
+// dynamic parameter.
+func (p *odParameters) acceleration(o Orbit, dt time.Time, sc Spacecraft, t ODParameterType, value float64) []float64 {
+	switch t {
+	case ParamCr:
+		sc.Drag = value
+	case ParamCd:
+		sc.Cd = value
+	case ParamGM:
+		o.Origin.μ = value
+	}
+	R := o.R()
+	k := -o.Origin.μ / math.Pow(Norm(R), 3)
+	pert := p.perts.Perturb(o, dt, sc)
+	return []float64{k*R[0] + pert[3], k*R[1] + pert[4], k*R[2] + pert[5]}
+}
+
+// dynamicPartials returns the partials of the derivative of the position and velocity with respect to the parameters
+// (6 x size+consider) on the provided orbit.
+func (p *odParameters) dynamicPartials(o Orbit, dt time.Time, sc Spacecraft) *mat64.Dense {
+	B := mat64.NewDense(6, p.size+p.consider, nil)
+	for i, param := range p.list {
+		col := p.columns[i] - 6
+		if param.Type == ParamEphemeris {
+			B.View(3, col, 3, 3).(*mat64.Dense).Copy(ephemerisPartials(o, dt))
+			continue
+		}
+		var value float64
+		switch param.Type {
+		case ParamCr:
+			value = sc.Drag
+		case ParamCd:
+			value = sc.Cd
+		case ParamGM:
+			value = o.Origin.μ
+		default:
+			continue
+		}
+		h := 1e-4 * math.Max(math.Abs(value), 1)
+		plus := p.acceleration(o, dt, sc, param.Type, value+h)
+		minus := p.acceleration(o, dt, sc, param.Type, value-h)
+		for j := 0; j < 3; j++ {
+			B.Set(j+3, col, (plus[j]-minus[j])/(2*h))
+		}
+	}
+	// The DMC accelerations are added as is, and their time constants only change the accelerations (cf. transition).
+	for j := 0; j < 3 && p.dmc != nil; j++ {
+		B.Set(j+3, p.dmcColumn-6+j, 1)
+	}
+	return B
+}
+
+// ephemerisPartials returns the partials of the acceleration of the perturbing body (only the Sun, cf. Perturb) with
+// respect to the error of its position relative to the central body of the provided orbit.
+func ephemerisPartials(o Orbit, dt time.Time) *mat64.Dense {
+	return sunPositionPartials(o.Origin.HelioOrbitIn(o.Frame, dt).R(), o.R())
+}
+
+// sunPositionPartials returns the partials of the third body acceleration of the Sun with respect to the error of its
+// position, from the vector between the Sun and the central body and the position of the spacecraft.
+func sunPositionPartials(RSunToEarth, REarthToSC []float64) *mat64.Dense {
+	RSunToSC := make([]float64, 3)
+	for i := 0; i < 3; i++ {
+		RSunToSC[i] = RSunToEarth[i] + REarthToSC[i]
+	}
+	// The partial of r/|r|^3 is (I - 3*r*r^T/|r|^2)/|r|^3, and both vectors from the Sun are shifted by -δ.
+	F := func(r []float64) *mat64.Dense {
+		norm := Norm(r)
+		J := mat64.NewDense(3, 3, nil)
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				J.Set(i, j, -3*r[i]*r[j]/math.Pow(norm, 5))
+			}
+			J.Set(i, i, J.At(i, i)+1/math.Pow(norm, 3))
+		}
+		return J
+	}
+	var partials mat64.Dense
+	partials.Sub(F(RSunToSC), F(RSunToEarth))
+	partials.Scale(Sun.μ, &partials)
+	return &partials
+}
+
+// transition returns the STM of the augmented state from the previous state of the reference trajectory to the
+// provided one, whose STM must be the one of the position and velocity over this step (the first state is the
+// start of the trajectory).
+func (p *odParameters) transition(state State) *mat64.Dense {
+	n := p.augmented()
+	Φ := mat64.NewDense(n, n, nil)
+	Φrv := state.Φ.View(0, 0, 6, 6)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if i < 6 && j < 6 {
+				Φ.Set(i, j, Φrv.At(i, j))
+			} else if i == j {
+				Φ.Set(i, j, 1)
+			}
+		}
+	}
+	if n == 6 {
+		return Φ
+	}
+	B := p.dynamicPartials(state.Orbit, state.DT, state.SC)
+	p.Δt = 0
+	if p.prevB != nil {
+		p.Δt = state.DT.Sub(p.prevDT).Seconds()
+		if p.dmc != nil {
+			// dw/dt = -w/τ, hence w = w0*exp(-Δt/τ) and its partial with respect to τ is w*Δt/τ^2.
+			w0 := p.dmc.At(p.prevDT)
+			for k := 0; k < 3; k++ {
+				decay := math.Exp(-p.Δt / p.dmc.TimeConstant[k])
+				Φ.Set(p.dmcColumn+k, p.dmcColumn+k, decay)
+				Φ.Set(p.dmcColumn+k, p.dmcColumn+3+k, w0[k]*decay*p.Δt/math.Pow(p.dmc.TimeConstant[k], 2))
+			}
+		}
+		// Ψ is the integral of Φ(t, s)*B(s)*Φpp(s, t0), where Φpp is the STM of the parameters.
+		var Ψ, BΦpp mat64.Dense
+		Ψ.Mul(Φrv, p.prevB)
+		BΦpp.Mul(B, Φ.View(6, 6, n-6, n-6))
+		Ψ.Add(&Ψ, &BΦpp)
+		Ψ.Scale(p.Δt/2, &Ψ)
+		// The impulsive maneuvers are executed at the start of their step (cf. Mission.applyManeuver).
+		R, V := p.prevOrbit.RV()
+		for i, param := range p.list {
+			if param.Type != ParamManeuver || !p.prevDT.Truncate(p.step).Equal(param.Epoch) {
+				continue
+			}
+			G := mat64.NewDense(6, 3, nil)
+			for c := 0; c < 3; c++ {
+				unit := make([]float64, 3)
+				unit[c] = 1
+				column := RTN2ECI(R, V, unit)
+				for r := 0; r < 3; r++ {
+					G.Set(r+3, c, column[r])
+				}
+			}
+			var ΦG mat64.Dense
+			ΦG.Mul(Φrv, G)
+			for r := 0; r < 6; r++ {
+				for c := 0; c < 3; c++ {
+					Ψ.Set(r, p.columns[i]-6+c, Ψ.At(r, p.columns[i]-6+c)+ΦG.At(r, c))
+				}
+			}
+		}
+		Φ.View(0, 6, 6, n-6).(*mat64.Dense).Copy(&Ψ)
+	}
+	p.prevDT, p.prevOrbit, p.prevB = state.DT, state.Orbit, B
+	return Φ
+}
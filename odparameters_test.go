@@ -0,0 +1,141 @@
+package smd
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+	"github.com/gonum/matrix/mat64"
+	"github.com/spf13/viper"
+)
+
+func TestODParametersFromConfig(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(strings.NewReader(`[filter]
+type = "CKF"
+[parameters.0]
+type = "rangeBias"
+station = "DSS34Canberra"
+sigma = 0.01
+[parameters.1]
+type = "Maneuver"
+epoch = "2015-02-03 00:10:00"
+consider = true
+sigma = 1e-5`)); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := ODConfigFromConfig(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Parameters) != 2 {
+		t.Fatalf("invalid parameters: %+v", conf.Parameters)
+	}
+	if p := conf.Parameters[0]; p.Type != ParamRangeBias || p.Station != "DSS34Canberra" || p.Sigma != 0.01 || p.Consider {
+		t.Fatalf("invalid range bias: %+v", p)
+	}
+	if p := conf.Parameters[1]; p.Type != ParamManeuver || !p.Epoch.Equal(time.Date(2015, 2, 3, 0, 10, 0, 0, time.UTC)) || !p.Consider || len(p.Names()) != 3 {
+		t.Fatalf("invalid maneuver: %+v", p)
+	}
+	for key, value := range map[string]interface{}{"parameters.0.station": "", "parameters.0.sigma": 0, "parameters.0.type": "albedo"} {
+		prev := v.Get(key)
+		v.Set(key, value)
+		if _, err = ODConfigFromConfig(v); err == nil {
+			t.Fatalf("invalid %s accepted", key)
+		}
+		v.Set(key, prev)
+	}
+}
+
+func TestODParameters(t *testing.T) {
+	stations := []Station{DSS13Goldstone, DSS34Canberra, DSS65Madrid}
+	// The range of DSS65 is biased, and the maneuver is 10% larger than planned.
+	bias := 0.05
+	burnDT := testStart.Add(10 * time.Minute)
+	truthSC := NewEmptySC("truth", 0)
+	truthSC.Maneuvers[burnDT] = NewManeuver(0, 1e-3, 0)
+	truth := testMission(truthSC, testGEO(), testStart, Perturbations{}, false)
+	meas := simulateMissionMeasurements(truth, testStart.Add(30*time.Minute), stations)
+	for _, dt := range meas.Epochs {
+		if measurements, _ := meas.At(dt); measurements[2].Station.Name != "" {
+			measurements[2].Values[0] += bias
+		}
+	}
+	first := meas.Epochs[0]
+	newMission := func() *Mission {
+		sc := NewEmptySC("est", 0)
+		sc.Maneuvers[burnDT] = NewManeuver(0, 0.9e-3, 0)
+		return testMission(sc, testGEO(), first, Perturbations{}, true)
+	}
+	for _, filter := range []ODFilterType{FilterCKF, FilterSRIF, FilterBLS} {
+		conf := testODConfig(filter)
+		conf.BLSAPriori = true
+		// The initial state is well known so that the bias of a single station is observable over this short arc.
+		for i := 0; i < 3; i++ {
+			conf.P0.SetSym(i, i, 1e-6)
+			conf.P0.SetSym(i+3, i+3, 1e-12)
+		}
+		conf.Parameters = []ODParameter{{Type: ParamRangeBias, Station: DSS65Madrid.Name, Sigma: 0.1},
+			{Type: ParamGM, Consider: true, Sigma: 1}, {Type: ParamManeuver, Epoch: burnDT, Sigma: 1e-3}}
+		result, err := NewOrbitDetermination(newMission(), meas, conf).Run()
+		if err != nil {
+			t.Fatalf("%s: %s", filter, err)
+		}
+		if len(result.StateNames) != 10 || result.StateNames[6] != DSS65Madrid.Name+" rangeBias" || len(result.Parameters) != 4 {
+			t.Fatalf("%s: invalid state %v: %v", filter, result.StateNames, result.Parameters)
+		}
+		if math.Abs(result.Parameters[0]-bias) > 1e-3 {
+			t.Fatalf("%s: invalid range bias %f km (%v)", filter, result.Parameters[0], result.Parameters)
+		}
+		for i, expected := range []float64{0, 1e-3, 0} {
+			if math.Abs(result.Parameters[1+i]-expected) > 1e-5 {
+				t.Fatalf("%s: invalid maneuver %v km/s", filter, result.Parameters[1:])
+			}
+		}
+		if σ := math.Sqrt(result.Estimates[len(result.Estimates)-1].Covariance().At(6, 6)); σ == 0 || σ > 0.01 {
+			t.Fatalf("%s: invalid σ of the range bias %f km", filter, σ)
+		}
+	}
+	// Invalid parameters
+	for _, param := range []ODParameter{{Type: ParamCr, Sigma: 0.1}, {Type: ParamDopplerBias, Station: "DSS42", Sigma: 1e-6}, {Type: ParamManeuver, Epoch: testStart, Sigma: 1e-3}} {
+		conf := testODConfig(FilterCKF)
+		conf.Parameters = []ODParameter{param}
+		if _, err := NewOrbitDetermination(newMission(), meas, conf).Run(); err == nil {
+			t.Fatalf("invalid parameter %s accepted", param)
+		}
+	}
+	conf := testODConfig(FilterUKF)
+	conf.Parameters = []ODParameter{{Type: ParamRangeBias, Station: DSS65Madrid.Name, Sigma: 0.1}}
+	if _, err := NewOrbitDetermination(newMission(), meas, conf).Run(); err == nil {
+		t.Fatal("solve-for parameter accepted by the UKF")
+	}
+}
+
+func TestODParametersStationPartials(t *testing.T) {
+	// The partials of the range rate with respect to the station position are those of the offset station, whose
+	// velocity is that of its offset position.
+	epoch := testStart
+	o := *testGEO()
+	p := &odParameters{list: []ODParameter{{Type: ParamStationPosition, Station: DSS13Goldstone.Name, Sigma: 1e-3}}, values: [][]float64{{0, 0, 0}}, columns: []int{6}, size: 3}
+	H := mat64.NewDense(1, 9, nil)
+	p.measurementPartials(H, 0, MeasRangeRate, DSS13Goldstone, epoch, o)
+	h := 1e-3
+	for j := 0; j < 3; j++ {
+		var values [2]float64
+		for k, δ := range []float64{h, -h} {
+			p.values[0][j] = δ
+			offset := p.station(DSS13Goldstone)
+			if ωR := Cross([]float64{0, 0, Earth.surfaceRotationRate()}, offset.R); !floats.Equal(offset.V, ωR) {
+				t.Fatalf("offset station velocity %v instead of %v", offset.V, ωR)
+			}
+			values[k], _, _ = offset.Observe(MeasRangeRate, epoch, o)
+		}
+		p.values[0][j] = 0
+		if expected := (values[0] - values[1]) / (2 * h); math.Abs(H.At(0, 6+j)-expected) > 1e-12 {
+			t.Fatalf("range rate partial #%d is %g instead of %g", j, H.At(0, 6+j), expected)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package smd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+/* End of run report of the orbit determination: the statistics of the pre-fit and post-fit residuals of the accepted
+rows (per row, station, measurement type and pass of each station), and the consistency of the filter. The weighted
+pre-fit residuals are divided by the σ of the innovation (or of the measurement noise for the BLS), and the weighted
+post-fit ones by the σ of the measurement noise. The normalized innovation squared (NIS, sequential filters only) and
+the normalized estimation error squared (NEES, with a truth trajectory) of a consistent filter follow χ² distributions,
+so about 95% of the epochs should be within the two-sided 95% bounds. */
+
+// ODResidualStats are the statistics of the residuals of a set of rows.
+type ODResidualStats struct {
+	Name        string  `json:"name"`
+	Accepted    int     `json:"accepted"`
+	Rejected    int     `json:"rejected"`
+	PrefitMean  float64 `json:"prefitMean"`
+	PrefitRMS   float64 `json:"prefitRMS"`
+	PrefitWRMS  float64 `json:"prefitWeightedRMS"`
+	PostfitMean float64 `json:"postfitMean"`
+	PostfitRMS  float64 `json:"postfitRMS"`
+	PostfitWRMS float64 `json:"postfitWeightedRMS"`
+}
+
+// ODPass is a pass of a station, i.e. consecutive measurement epochs with a measurement of that station, which are
+// not separated by more than the pass gap (cf. Report).
+type ODPass struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Epochs int       `json:"epochs"`
+	ODResidualStats
+}
+
+// ODConsistency summarizes a consistency metric (NIS or NEES) of the filter.
+type ODConsistency struct {
+	Epochs       int     `json:"epochs"`
+	Mean         float64 `json:"mean"`
+	ExpectedMean float64 `json:"expectedMean"` // Mean degrees of freedom
+	WithinBounds float64 `json:"withinBounds"` // Fraction of the epochs within the two-sided 95% χ² bounds
+}
+
+// ODReport summarizes an orbit determination run.
+type ODReport struct {
+	Filter           string            `json:"filter"`
+	Measurements     int               `json:"measurements"`
+	VisibilityErrors int               `json:"visibilityErrors"`
+	Rejected         int               `json:"rejected"`
+	Iterations       int               `json:"iterations,omitempty"`
+	Converged        bool              `json:"converged,omitempty"`
+	Rows             []ODResidualStats `json:"rows"`
+	Stations         []ODResidualStats `json:"stations"`
+	Types            []ODResidualStats `json:"types"`
+	Passes           []ODPass          `json:"passes"`
+	NIS              *ODConsistency    `json:"nis,omitempty"`  // nil for the BLS
+	NEES             *ODConsistency    `json:"nees,omitempty"` // nil without truth
+}
+
+// residualAccumulator accumulates the residuals of a set of rows.
+type residualAccumulator struct {
+	name                         string
+	accepted, rejected           int
+	prefit, prefit2, prefitW2    float64
+	postfit, postfit2, postfitW2 float64
+}
+
+// add adds the residuals of the provided row of the estimate, whose measurement noise is σ.
+func (a *residualAccumulator) add(est ODEstimate, row int, σ float64) {
+	switch est.Rows[row] {
+	case RowRejected:
+		a.rejected++
+	case RowAccepted:
+		a.accepted++
+		pre, post := est.Prefit.At(row, 0), est.Residual.At(row, 0)
+		a.prefit += pre
+		a.prefit2 += pre * pre
+		a.prefitW2 += math.Pow(pre/est.PrefitSigma.At(row, 0), 2)
+		a.postfit += post
+		a.postfit2 += post * post
+		a.postfitW2 += math.Pow(post/σ, 2)
+	}
+}
+
+func (a *residualAccumulator) stats() ODResidualStats {
+	s := ODResidualStats{Name: a.name, Accepted: a.accepted, Rejected: a.rejected}
+	if a.accepted > 0 {
+		n := float64(a.accepted)
+		s.PrefitMean, s.PrefitRMS, s.PrefitWRMS = a.prefit/n, math.Sqrt(a.prefit2/n), math.Sqrt(a.prefitW2/n)
+		s.PostfitMean, s.PostfitRMS, s.PostfitWRMS = a.postfit/n, math.Sqrt(a.postfit2/n), math.Sqrt(a.postfitW2/n)
+	}
+	return s
+}
+
+// consistencyAccumulator accumulates a χ² distributed metric.
+type consistencyAccumulator struct {
+	epochs, within int
+	sum, dof       float64
+}
+
+func (a *consistencyAccumulator) add(value float64, dof int) {
+	a.epochs++
+	a.sum += value
+	a.dof += float64(dof)
+	if value >= chiSquaredQuantile(-z975, dof) && value <= chiSquaredQuantile(z975, dof) {
+		a.within++
+	}
+}
+
+func (a *consistencyAccumulator) consistency() *ODConsistency {
+	if a.epochs == 0 {
+		return nil
+	}
+	n := float64(a.epochs)
+	return &ODConsistency{Epochs: a.epochs, Mean: a.sum / n, ExpectedMean: a.dof / n, WithinBounds: float64(a.within) / n}
+}
+
+// z975 is the 0.975 quantile of the standard normal distribution.
+const z975 = 1.959963984540054
+
+// chiSquaredQuantile returns the quantile of the χ² distribution with k degrees of freedom matching the z quantile of
+// the standard normal distribution, with the Wilson-Hilferty approximation (within a few percents from k=1).
+func chiSquaredQuantile(z float64, k int) float64 {
+	h := 2 / (9 * float64(k))
+	return float64(k) * math.Pow(math.Max(1-h+z*math.Sqrt(h), 0), 3)
+}
+
+// Report returns the residual statistics and consistency metrics of the orbit determination. The NEES is computed at
+// each epoch where the truth function (may be nil) returns the true orbit. A pass of a station ends when the time
+// between two of its measurements is larger than the provided gap, or than its cadence (i.e. the smallest time between
+// two of its measurements) if the gap is zero.
+func (r ODResult) Report(truth func(time.Time) (*Orbit, bool), passGap time.Duration) (ODReport, error) {
+	report := ODReport{Filter: r.Filter.String(), Measurements: r.Measurements, VisibilityErrors: r.VisibilityErrors, Rejected: r.Rejected, Iterations: r.Iterations, Converged: r.Converged}
+	rows := make([]*residualAccumulator, len(r.RowNames))
+	var stations, types []*residualAccumulator
+	stationPos := make(map[string]int)
+	typePos := make(map[MeasurementType]int)
+	for i, name := range r.RowNames {
+		rows[i] = &residualAccumulator{name: name}
+		if _, found := stationPos[r.RowStations[i]]; !found {
+			stationPos[r.RowStations[i]] = len(stations)
+			stations = append(stations, &residualAccumulator{name: r.RowStations[i]})
+		}
+		if _, found := typePos[r.RowTypes[i]]; !found {
+			typePos[r.RowTypes[i]] = len(types)
+			types = append(types, &residualAccumulator{name: r.RowTypes[i].String()})
+		}
+	}
+	gaps := r.stationCadences(stationPos)
+	if passGap > 0 {
+		for st := range gaps {
+			gaps[st] = passGap
+		}
+	}
+	// The pass of each station which is in progress, if any.
+	passes := make([]*ODPass, len(stations))
+	passStats := make([]*residualAccumulator, len(stations))
+	endPass := func(st int) {
+		if passes[st] != nil {
+			passes[st].ODResidualStats = passStats[st].stats()
+			report.Passes = append(report.Passes, *passes[st])
+			passes[st] = nil
+		}
+	}
+	var nis, nees consistencyAccumulator
+	for _, est := range r.Estimates {
+		if truth != nil {
+			if o, found := truth(est.DT); found {
+				value, err := nees6(est, o)
+				if err != nil {
+					return report, fmt.Errorf("NEES at %s: %s", est.DT, err)
+				}
+				nees.add(value, 6)
+			}
+		}
+		if est.Rows == nil {
+			continue
+		}
+		observed := make([]bool, len(stations))
+		for i, status := range est.Rows {
+			if status == RowUnobserved {
+				continue
+			}
+			st := stationPos[r.RowStations[i]]
+			if passes[st] != nil && !observed[st] && est.DT.Sub(passes[st].End) > gaps[st] {
+				endPass(st)
+			}
+			observed[st] = true
+			rows[i].add(est, i, r.RowSigma[i])
+			stations[st].add(est, i, r.RowSigma[i])
+			types[typePos[r.RowTypes[i]]].add(est, i, r.RowSigma[i])
+			if passes[st] == nil {
+				passes[st] = &ODPass{Start: est.DT}
+				passStats[st] = &residualAccumulator{name: r.RowStations[i]}
+			}
+			passStats[st].add(est, i, r.RowSigma[i])
+		}
+		for st, seen := range observed {
+			if !seen {
+				endPass(st)
+				continue
+			}
+			passes[st].End = est.DT
+			passes[st].Epochs++
+		}
+		if r.Filter != FilterBLS {
+			if dof := countRows(est.Rows, RowAccepted); dof > 0 {
+				nis.add(est.NIS, dof)
+			}
+		}
+	}
+	for st := range stations {
+		endPass(st)
+	}
+	for _, acc := range rows {
+		report.Rows = append(report.Rows, acc.stats())
+	}
+	for _, acc := range stations {
+		report.Stations = append(report.Stations, acc.stats())
+	}
+	for _, acc := range types {
+		report.Types = append(report.Types, acc.stats())
+	}
+	report.NIS, report.NEES = nis.consistency(), nees.consistency()
+	return report, nil
+}
+
+// stationCadences returns the smallest time between two measurement epochs of each station.
+func (r ODResult) stationCadences(stationPos map[string]int) []time.Duration {
+	cadences := make([]time.Duration, len(stationPos))
+	last := make([]time.Time, len(stationPos))
+	for _, est := range r.Estimates {
+		observed := make([]bool, len(stationPos))
+		for i, status := range est.Rows {
+			if status != RowUnobserved {
+				observed[stationPos[r.RowStations[i]]] = true
+			}
+		}
+		for st, seen := range observed {
+			if !seen {
+				continue
+			}
+			if !last[st].IsZero() {
+				if Δt := est.DT.Sub(last[st]); cadences[st] == 0 || Δt < cadences[st] {
+					cadences[st] = Δt
+				}
+			}
+			last[st] = est.DT
+		}
+	}
+	return cadences
+}
+
+// nees6 returns the NEES of the estimated position and velocity with respect to the true orbit.
+func nees6(est ODEstimate, truth *Orbit) (float64, error) {
+	R, V := est.Orbit.RV()
+	Rt, Vt := truth.RV()
+	e := mat64.NewVector(6, nil)
+	for i := 0; i < 3; i++ {
+		e.SetVec(i, R[i]+est.State().At(i, 0)-Rt[i])
+		e.SetVec(i+3, V[i]+est.State().At(i+3, 0)-Vt[i])
+	}
+	P := mat64.NewSymDense(6, nil)
+	for i := 0; i < 6; i++ {
+		for j := i; j < 6; j++ {
+			P.SetSym(i, j, est.Covariance().At(i, j))
+		}
+	}
+	var chol mat64.Cholesky
+	if !chol.Factorize(P) {
+		return 0, errors.New("the covariance is not positive definite")
+	}
+	var Pe mat64.Vector
+	if err := Pe.SolveCholeskyVec(&chol, e); err != nil {
+		return 0, err
+	}
+	return mat64.Dot(e, &Pe), nil
+}
+
+// ExportJSON writes the report to the provided JSON file.
+func (r ODReport) ExportJSON(filename string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// ExportMarkdown writes the report to the provided Markdown file.
+func (r ODReport) ExportMarkdown(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.WriteMarkdown(f)
+}
+
+// WriteMarkdown writes the report as Markdown tables.
+func (r ODReport) WriteMarkdown(w io.Writer) error {
+	var md bytes.Buffer
+	fmt.Fprintf(&md, "# Orbit determination report (%s)\n\n", r.Filter)
+	fmt.Fprintf(&md, "- Measurement epochs: %d\n- Rejected measurements: %d\n- Visibility errors: %d\n", r.Measurements, r.Rejected, r.VisibilityErrors)
+	if r.Iterations > 0 {
+		fmt.Fprintf(&md, "- Iterations: %d (converged: %t)\n", r.Iterations, r.Converged)
+	}
+	for _, table := range []struct {
+		title string
+		stats []ODResidualStats
+	}{{"Rows", r.Rows}, {"Stations", r.Stations}, {"Measurement types", r.Types}} {
+		fmt.Fprintf(&md, "\n## %s\n\n", table.title)
+		md.WriteString("| Name | Accepted | Rejected | Pre-fit mean | Pre-fit RMS | Pre-fit WRMS | Post-fit mean | Post-fit RMS | Post-fit WRMS |\n")
+		md.WriteString("|---|---|---|---|---|---|---|---|---|\n")
+		for _, s := range table.stats {
+			fmt.Fprintf(&md, "| %s | %d | %d | %e | %e | %.3f | %e | %e | %.3f |\n", s.Name, s.Accepted, s.Rejected, s.PrefitMean, s.PrefitRMS, s.PrefitWRMS, s.PostfitMean, s.PostfitRMS, s.PostfitWRMS)
+		}
+	}
+	md.WriteString("\n## Passes\n\n")
+	md.WriteString("| Station | Start | End | Epochs | Accepted | Rejected | Pre-fit WRMS | Post-fit WRMS |\n")
+	md.WriteString("|---|---|---|---|---|---|---|---|\n")
+	for _, p := range r.Passes {
+		fmt.Fprintf(&md, "| %s | %s | %s | %d | %d | %d | %.3f | %.3f |\n", p.Name, p.Start.Format(time.RFC3339), p.End.Format(time.RFC3339), p.Epochs, p.Accepted, p.Rejected, p.PrefitWRMS, p.PostfitWRMS)
+	}
+	md.WriteString("\n## Consistency\n\n")
+	md.WriteString("| Metric | Epochs | Mean | Expected mean | Within 95% bounds |\n")
+	md.WriteString("|---|---|---|---|---|\n")
+	for _, metric := range []struct {
+		name  string
+		value *ODConsistency
+	}{{"NIS", r.NIS}, {"NEES", r.NEES}} {
+		if c := metric.value; c != nil {
+			fmt.Fprintf(&md, "| %s | %d | %.3f | %.3f | %.1f%% |\n", metric.name, c.Epochs, c.Mean, c.ExpectedMean, 100*c.WithinBounds)
+		} else {
+			fmt.Fprintf(&md, "| %s | - | - | - | - |\n", metric.name)
+		}
+	}
+	_, err := md.WriteTo(w)
+	return err
+}
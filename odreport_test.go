@@ -0,0 +1,135 @@
+package smd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestODReport(t *testing.T) {
+	stations := []Station{DSS13Goldstone, DSS34Canberra, DSS65Madrid}
+	meas := simulateODMeasurements(testGEO(), testStart, testStart.Add(30*time.Minute), stations)
+	visibleRows := 2 * meas.Count
+	for _, filter := range []ODFilterType{FilterCKF, FilterBLS} {
+		first := meas.Epochs[0]
+		mEst := testGEOEstimate(first)
+		result, err := NewOrbitDetermination(mEst, meas, testODConfig(filter)).Run()
+		if err != nil {
+			t.Fatalf("%s: %s", filter, err)
+		}
+		// The reference trajectory is the truth.
+		report, err := result.Report(func(dt time.Time) (*Orbit, bool) {
+			return testGEO(), dt.Equal(first)
+		}, 0)
+		if err != nil {
+			t.Fatalf("%s: %s", filter, err)
+		}
+		if report.Filter != filter.String() || report.Measurements != len(meas.Epochs) || report.Rejected != 0 {
+			t.Fatalf("%s: invalid report %+v", filter, report)
+		}
+		if len(report.Rows) != 6 || len(report.Stations) != 3 || len(report.Types) != 2 || report.Types[0].Name != MeasRange.String() {
+			t.Fatalf("%s: invalid statistics %+v %+v %+v", filter, report.Rows, report.Stations, report.Types)
+		}
+		accepted := 0
+		for _, stats := range report.Types {
+			accepted += stats.Accepted
+			if math.Abs(stats.PostfitMean) > 1e-3 || stats.PostfitRMS > 1e-3 || math.IsNaN(stats.PrefitWRMS) {
+				t.Fatalf("%s: invalid residuals of %s: %+v", filter, stats.Name, stats)
+			}
+		}
+		passRows := 0
+		for _, pass := range report.Passes {
+			passRows += pass.Accepted
+			if pass.Epochs == 0 || pass.End.Before(pass.Start) {
+				t.Fatalf("%s: invalid pass %+v", filter, pass)
+			}
+		}
+		if accepted != visibleRows || passRows != visibleRows {
+			t.Fatalf("%s: %d rows in the statistics and %d rows in the passes instead of %d", filter, accepted, passRows, visibleRows)
+		}
+		if (filter == FilterBLS) != (report.NIS == nil) {
+			t.Fatalf("%s: invalid NIS %+v", filter, report.NIS)
+		}
+		if report.NEES == nil || report.NEES.Epochs != 1 || report.NEES.ExpectedMean != 6 {
+			t.Fatalf("%s: invalid NEES %+v", filter, report.NEES)
+		}
+
+		var md bytes.Buffer
+		if err = report.WriteMarkdown(&md); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(md.String(), "| NIS |") || !strings.Contains(md.String(), DSS34Canberra.Name) {
+			t.Fatalf("%s: invalid Markdown report\n%s", filter, md.String())
+		}
+		f, err := ioutil.TempFile("", "report*.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		defer os.Remove(f.Name())
+		if err = report.ExportJSON(f.Name()); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadFile(f.Name())
+		var imported ODReport
+		if err = json.Unmarshal(data, &imported); err != nil || len(imported.Passes) != len(report.Passes) || imported.Rows[0] != report.Rows[0] {
+			t.Fatalf("%s: invalid JSON report (%v)", filter, err)
+		}
+	}
+}
+
+func TestODReportPasses(t *testing.T) {
+	// The stations are only available in two windows separated by ten minutes without any measurement.
+	windows := []TimeWindow{{testStart, testStart.Add(10 * time.Minute)}, {testStart.Add(20 * time.Minute), testStart.Add(30 * time.Minute)}}
+	stations := []Station{DSS13Goldstone, DSS34Canberra, DSS65Madrid}
+	for i := range stations {
+		stations[i].Windows = windows
+	}
+	meas := simulateODMeasurements(testGEO(), testStart, testStart.Add(30*time.Minute), stations)
+	first := meas.Epochs[0]
+	mEst := testGEOEstimate(first)
+	result, err := NewOrbitDetermination(mEst, meas, testODConfig(FilterCKF)).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		passGap        time.Duration
+		passPerStation int
+	}{{0, 2}, {time.Hour, 1}} {
+		report, err := result.Report(nil, tc.passGap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		visible := 0
+		for _, stats := range report.Stations {
+			if stats.Accepted > 0 {
+				visible++
+			}
+		}
+		if visible == 0 || len(report.Passes) != tc.passPerStation*visible {
+			t.Fatalf("%d passes of %d stations with a gap of %s: %+v", len(report.Passes), visible, tc.passGap, report.Passes)
+		}
+		for _, pass := range report.Passes {
+			if tc.passPerStation == 2 && pass.End.Sub(pass.Start) > 10*time.Minute {
+				t.Fatalf("pass %+v over both windows", pass)
+			}
+		}
+	}
+}
+
+func TestChiSquaredQuantile(t *testing.T) {
+	for _, tc := range []struct {
+		k            int
+		lower, upper float64
+	}{{1, 0.000982, 5.0239}, {6, 1.2373, 14.4494}, {20, 9.5908, 34.1696}} {
+		lower, upper := chiSquaredQuantile(-z975, tc.k), chiSquaredQuantile(z975, tc.k)
+		if math.Abs(lower-tc.lower) > 0.1*tc.lower+0.01 || math.Abs(upper-tc.upper) > 0.05*tc.upper {
+			t.Fatalf("invalid χ² bounds with k=%d: [%f, %f]", tc.k, lower, upper)
+		}
+	}
+}
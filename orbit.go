@@ -26,6 +26,7 @@ const (
 type Orbit struct {
 	rVec, vVec []float64       // Stars with a lowercase to make private
 	Origin     CelestialObject // Orbit origin
+	Frame      Frame           // Frame of the state vectors, defaults to EclipJ2000 about the Sun and ICRF otherwise
 	// Cache management
 	cacheHash, ccha, cche, cchi, cchΩ, cchω, cchν, cchλ, cchtildeω, cchu float64
 }
@@ -279,8 +280,17 @@ func (o Orbit) EqualsWithin(o1 Orbit, distanceε, eccentricityε, angleε float6
 	if !o.Origin.Equals(o1.Origin) {
 		return false, errors.New("different origin")
 	}
+	if o.Frame != o1.Frame {
+		return false, fmt.Errorf("different frames (%s and %s)", o.Frame, o1.Frame)
+	}
 	a, e, i, Ω, ω, _, λ, _, u := o.Elements()
 	a1, e1, i1, Ω1, ω1, _, λ1, _, u1 := o1.Elements()
+	return elementsEqualWithin(a, e, i, Ω, ω, λ, u, a1, e1, i1, Ω1, ω1, λ1, u1, distanceε, eccentricityε, angleε)
+}
+
+// elementsEqualWithin returns whether two sets of orbital elements are identical within provided bounds.
+// The true longitude λ is only used for circular equatorial orbits, and the argument of latitude u for circular inclined ones.
+func elementsEqualWithin(a, e, i, Ω, ω, λ, u, a1, e1, i1, Ω1, ω1, λ1, u1, distanceε, eccentricityε, angleε float64) (bool, error) {
 	if !floats.EqualWithinAbs(a, a1, distanceε) {
 		return false, errors.New("semi major axis invalid")
 	}
@@ -328,33 +338,36 @@ func (o Orbit) StrictlyEquals(o1 Orbit) (bool, error) {
 	return o.Equals(o1)
 }
 
-// ToXCentric converts this orbit the provided celestial object centric equivalent.
-// Panics if the vehicle is not within the SOI of the object.
-// Panics if already in this frame.
+// ToXCentric converts this orbit the provided celestial object centric equivalent, in the default frame of that object.
+// The state is translated in the ICRF with the heliocentric ephemerides of both objects (cf. HelioOrbit).
+// Panics if already in this frame, or if the orbit is not in the default frame of its origin.
 func (o *Orbit) ToXCentric(b CelestialObject, dt time.Time) {
 	if o.Origin.Name == b.Name {
 		panic(fmt.Errorf("already in orbit around %s", b.Name))
 	}
-
-	// Using SPICE for the conversion.
-	state := make([]float64, 6)
+	if o.Frame != defaultFrame(o.Origin) {
+		panic(fmt.Errorf("orbit in %s instead of %s", o.Frame, defaultFrame(o.Origin)))
+	}
+	o.ToFrame(ICRF, dt)
+	originR, originV := helioStateICRF(o.Origin, dt)
+	targetR, targetV := helioStateICRF(b, dt)
+	R := make([]float64, 3)
+	V := make([]float64, 3)
 	for i := 0; i < 3; i++ {
-		state[i] = o.rVec[i]
-		state[i+3] = o.vVec[i]
+		R[i] = o.rVec[i] + originR[i] - targetR[i]
+		V[i] = o.vVec[i] + originV[i] - targetV[i]
 	}
-	toFrame := "IAU_" + b.Name
+	*o = *NewOrbitFromRV(R, V, b) // Don't forget to switch origin
+	o.Frame = ICRF
+	o.ToFrame(defaultFrame(b), dt)
+}
+
+// helioStateICRF returns the heliocentric position and velocity of the provided object in the ICRF (zero for the Sun).
+func helioStateICRF(b CelestialObject, dt time.Time) (R, V []float64) {
 	if b.Equals(Sun) {
-		toFrame = "ECLIPJ2000"
+		return make([]float64, 3), make([]float64, 3)
 	}
-	fromFrame := "IAU_" + o.Origin.Name
-	if o.Origin.Equals(Sun) {
-		fromFrame = "ECLIPJ2000"
-	}
-	pstate := smdConfig().ChgFrame(toFrame, fromFrame, dt, state)
-	o.rVec = pstate.R
-	o.vVec = pstate.V
-
-	o.Origin = b // Don't forget to switch origin
+	return b.HelioOrbitIn(ICRF, dt).RV()
 }
 
 // NewOrbitFromOE creates an orbit from the orbital elements.
@@ -395,14 +408,14 @@ func NewOrbitFromOE(a, e, i, Ω, ω, ν float64, c CelestialObject) *Orbit {
 	vPQW := []float64{-μOp * sinν, μOp * (e + cosν), 0}
 	rIJK := Rot313Vec(-ω, -i, -Ω, rPQW)
 	vIJK := Rot313Vec(-ω, -i, -Ω, vPQW)
-	orbit := Orbit{rIJK, vIJK, c, a, e, i, Ω, ω, ν, 0, 0, 0, 0.0}
+	orbit := Orbit{rIJK, vIJK, c, defaultFrame(c), a, e, i, Ω, ω, ν, 0, 0, 0, 0.0}
 	orbit.Elements()
 	return &orbit
 }
 
 // NewOrbitFromRV returns orbital elements from the R and V vectors. Needed for prop
 func NewOrbitFromRV(R, V []float64, c CelestialObject) *Orbit {
-	orbit := Orbit{R, V, c, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0.0}
+	orbit := Orbit{R, V, c, defaultFrame(c), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0.0}
 	orbit.Elements() // Compute the OEs and the cache hash
 	return &orbit
 }
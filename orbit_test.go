@@ -179,6 +179,27 @@ func TestOrbitRefChange(t *testing.T) {
 	}
 }
 
+func TestOrbitRefChangeMars(t *testing.T) {
+	// A heliocentric orbit converted to Mars centric is in the ICRF, relative to Mars, and converts back.
+	dt := time.Date(2016, 3, 24, 20, 41, 48, 0, time.UTC)
+	mars := Mars.HelioOrbit(dt)
+	R, V := mars.RV()
+	o := NewOrbitFromRV([]float64{R[0] + 50000, R[1] - 30000, R[2] + 10000}, []float64{V[0] + 3, V[1] - 1, V[2] + 0.5}, Sun)
+	helioR, helioV := o.RV()
+	o.ToXCentric(Mars, dt)
+	if !o.Origin.Equals(Mars) || o.Frame != ICRF {
+		t.Fatalf("invalid Mars centric orbit %s in %s", o.Origin, o.Frame)
+	}
+	marsR, marsV := MxV33(R1(-ε2000), []float64{50000, -30000, 10000}), MxV33(R1(-ε2000), []float64{3, -1, 0.5})
+	if !floats.EqualApprox(o.R(), marsR, 1e-6) || !floats.EqualApprox(o.V(), marsV, 1e-9) {
+		t.Fatalf("invalid Mars centric state %+v %+v instead of %+v %+v", o.R(), o.V(), marsR, marsV)
+	}
+	o.ToXCentric(Sun, dt)
+	if !o.Origin.Equals(Sun) || o.Frame != EclipJ2000 || !floats.EqualApprox(o.R(), helioR, 1e-12) || !floats.EqualApprox(o.V(), helioV, 1e-12) {
+		t.Fatalf("heliocentric round trip failed: %+v %+v instead of %+v %+v", o.R(), o.V(), helioR, helioV)
+	}
+}
+
 func TestOrbitEquality(t *testing.T) {
 	oInit := NewOrbitFromOE(226090298.679, 0.088, 26.195, 3.516, 326.494, 278.358, Sun)
 	oTest := NewOrbitFromOE(226090290.608, 0.088, 26.195, 3.516, 326.494, 278.358, Sun)
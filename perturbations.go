@@ -11,16 +11,18 @@ import (
 
 // Perturbations defines how to handle perturbations during the propagation.
 type Perturbations struct {
-	Jn             uint8            // Factors to be used (only up to 4 supported)
-	PerturbingBody *CelestialObject // The 3rd body which is perturbating the spacecraft.
-	AutoThirdBody  bool             // Automatically determine what is the 3rd body based on distance and mass
-	Drag           bool             // Set to true to use the Spacecraft's Drag for everything including STM computation
-	Noise          OrbitNoise
-	Arbitrary      func(o Orbit) []float64 // Additional arbitrary pertubation.
+	Jn              uint8            // Factors to be used (only up to 4 supported)
+	PerturbingBody  *CelestialObject // The 3rd body which is perturbating the spacecraft.
+	AutoThirdBody   bool             // Automatically determine what is the 3rd body based on distance and mass
+	Drag            bool             // Set to true to use the Spacecraft's Drag for everything including STM computation
+	AtmosphericDrag bool             // Exponential atmospheric drag about the Earth with the Cd of the Spacecraft
+	DMC             *GaussMarkov     // Unmodeled accelerations estimated by the dynamic model compensation
+	Noise           OrbitNoise
+	Arbitrary       func(o Orbit) []float64 // Additional arbitrary pertubation.
 }
 
 func (p Perturbations) isEmpty() bool {
-	return p.Jn <= 1 && p.PerturbingBody == nil && p.AutoThirdBody && p.Arbitrary == nil
+	return p.Jn <= 1 && p.PerturbingBody == nil && p.AutoThirdBody && p.Arbitrary == nil && !p.Drag && !p.AtmosphericDrag && p.DMC == nil
 }
 
 // STMSize returns the size of the STM
@@ -41,28 +43,9 @@ func (p Perturbations) Perturb(o Orbit, dt time.Time, sc Spacecraft) []float64 {
 	}
 	if p.Jn > 1 && !o.Origin.Equals(Sun) {
 		// Ignore any Jn about the Sun
-		R := o.R()
-		x := R[0]
-		y := R[1]
-		z := R[2]
-		z2 := math.Pow(R[2], 2)
-		z3 := math.Pow(R[2], 3)
-		r2 := math.Pow(R[0], 2) + math.Pow(R[1], 2) + z2
-		r252 := math.Pow(r2, 5/2.)
-		r272 := math.Pow(r2, 7/2.)
-		// J2 (computed via SageMath: https://cloud.sagemath.com/projects/1fb6b227-1832-4f82-a05c-7e45614c00a2/files/j2perts.sagews)
-		accJ2 := (3 / 2.) * o.Origin.J(2) * math.Pow(o.Origin.Radius, 2) * o.Origin.μ
-		pert[3] += accJ2 * (5*x*z2/r272 - x/r252)
-		pert[4] += accJ2 * (5*y*z2/r272 - y/r252)
-		pert[5] += accJ2 * (5*z3/r272 - 3*z/r252)
-		if p.Jn >= 3 {
-			// J3 (computed via SageMath: https://cloud.sagemath.com/#projects/1fb6b227-1832-4f82-a05c-7e45614c00a2/files/j3perts.sagews)
-			r292 := math.Pow(r2, 9/2.)
-			z4 := math.Pow(R[2], 4)
-			accJ3 := o.Origin.J(3) * math.Pow(o.Origin.Radius, 3) * o.Origin.μ
-			pert[3] += (5 / 2.) * accJ3 * (7*x*z3/r292 - 3*x*z/r272)
-			pert[4] += (5 / 2.) * accJ3 * (7*y*z3/r292 - 3*y*z/r272)
-			pert[5] += 0.5 * accJ3 * (35*z4/r292 - 30*z2/r272 + 3/r252)
+		accJn := zonalAcceleration(o.R(), o.Origin, p.Jn, dt)
+		for i := 0; i < 3; i++ {
+			pert[i+3] += accJn[i]
 		}
 	}
 
@@ -70,7 +53,7 @@ func (p Perturbations) Perturb(o Orbit, dt time.Time, sc Spacecraft) []float64 {
 
 	if p.Drag || p.PerturbingBody != nil {
 		REarthToSC = o.R()
-		RSunToEarth = MxV33(R1(Deg2rad(-Earth.tilt)), o.Origin.HelioOrbit(dt).R())
+		RSunToEarth = o.Origin.HelioOrbitIn(o.Frame, dt).R()
 		RSunToSC = make([]float64, 3)
 		for i := 0; i < 3; i++ {
 			RSunToSC[i] = RSunToEarth[i] + REarthToSC[i]
@@ -81,7 +64,7 @@ func (p Perturbations) Perturb(o Orbit, dt time.Time, sc Spacecraft) []float64 {
 		// If Drag, SRP is *also* turned on.
 		// TODO: Drag, there is only SRP here.
 		Cr := sc.Drag
-		S := 0.01e-6 // TODO: Idem for the Area to mass ratio
+		S := areaToMass
 		Phi := 1357.
 		// Build the vectors.
 		celerity := 2.997925e+05
@@ -91,6 +74,21 @@ func (p Perturbations) Perturb(o Orbit, dt time.Time, sc Spacecraft) []float64 {
 		}
 	}
 
+	// The origin is compared by name because its GM may be estimated (cf. ParamGM).
+	if p.AtmosphericDrag && o.Origin.Name == Earth.Name {
+		accDrag := dragAcceleration(o.R(), o.V(), sc.Cd)
+		for i := 0; i < 3; i++ {
+			pert[i+3] += accDrag[i]
+		}
+	}
+
+	if p.DMC != nil {
+		accDMC := p.DMC.At(dt)
+		for i := 0; i < 3; i++ {
+			pert[i+3] += accDMC[i]
+		}
+	}
+
 	if p.PerturbingBody != nil && !p.PerturbingBody.Equals(o.Origin) {
 		if !p.PerturbingBody.Equals(Sun) {
 			panic("only the Sun as a perturbing body is currently supported")
@@ -117,17 +115,124 @@ func (p Perturbations) Perturb(o Orbit, dt time.Time, sc Spacecraft) []float64 {
 	return pert
 }
 
+// zonalAcceleration returns the acceleration from the J2 and J3 zonal harmonics of the body (as per Jn) in the
+// inertial frame. The harmonics are computed about the pole of the body (cf. CelestialObject.Rotation).
+func zonalAcceleration(R []float64, body CelestialObject, Jn uint8, dt time.Time) []float64 {
+	dcm, tilted := body.poleDCM(dt)
+	if tilted {
+		R = MxV33(dcm, R)
+	}
+	acc := make([]float64, 3)
+	x := R[0]
+	y := R[1]
+	z := R[2]
+	z2 := math.Pow(R[2], 2)
+	z3 := math.Pow(R[2], 3)
+	r2 := math.Pow(R[0], 2) + math.Pow(R[1], 2) + z2
+	r252 := math.Pow(r2, 5/2.)
+	r272 := math.Pow(r2, 7/2.)
+	// J2 (computed via SageMath: https://cloud.sagemath.com/projects/1fb6b227-1832-4f82-a05c-7e45614c00a2/files/j2perts.sagews)
+	accJ2 := (3 / 2.) * body.J(2) * math.Pow(body.Radius, 2) * body.μ
+	acc[0] += accJ2 * (5*x*z2/r272 - x/r252)
+	acc[1] += accJ2 * (5*y*z2/r272 - y/r252)
+	acc[2] += accJ2 * (5*z3/r272 - 3*z/r252)
+	if Jn >= 3 {
+		// J3 (computed via SageMath: https://cloud.sagemath.com/#projects/1fb6b227-1832-4f82-a05c-7e45614c00a2/files/j3perts.sagews)
+		r292 := math.Pow(r2, 9/2.)
+		z4 := math.Pow(R[2], 4)
+		accJ3 := body.J(3) * math.Pow(body.Radius, 3) * body.μ
+		acc[0] += (5 / 2.) * accJ3 * (7*x*z3/r292 - 3*x*z/r272)
+		acc[1] += (5 / 2.) * accJ3 * (7*y*z3/r292 - 3*y*z/r272)
+		acc[2] += 0.5 * accJ3 * (35*z4/r292 - 30*z2/r272 + 3/r252)
+	}
+	if tilted {
+		acc = MxV33(dcm.T(), acc)
+	}
+	return acc
+}
+
+// zonalPartials returns the partials of zonalAcceleration with respect to the inertial position.
+func zonalPartials(R []float64, body CelestialObject, Jn uint8, dt time.Time) *mat64.Dense {
+	dcm, tilted := body.poleDCM(dt)
+	if tilted {
+		R = MxV33(dcm, R)
+	}
+	x := R[0]
+	y := R[1]
+	z := R[2]
+	x2 := math.Pow(R[0], 2)
+	y2 := math.Pow(R[1], 2)
+	z2 := math.Pow(R[2], 2)
+	r2 := x2 + y2 + z2
+	r252 := math.Pow(r2, 5/2.)
+	// Ai0 = \frac{\partial a}{\partial x}
+	// Ai1 = \frac{\partial a}{\partial y}
+	// Ai2 = \frac{\partial a}{\partial z}
+	var A30, A40, A50, A31, A41, A51, A32, A42, A52 float64
+
+	// Notation simplification
+	z3 := math.Pow(R[2], 3)
+	z4 := math.Pow(R[2], 4)
+	// Adding those fractions to avoid forgetting the trailing period which makes them floats.
+	f32 := 3 / 2.
+	f152 := 15 / 2.
+	r272 := math.Pow(r2, 7/2.)
+	r292 := math.Pow(r2, 9/2.)
+	// J2
+	j2fact := body.J(2) * math.Pow(body.Radius, 2) * body.μ
+	A30 += -f32 * j2fact * (35*x2*z2/r292 - 5*x2/r272 - 5*z2/r272 + 1/r252) //dAxDx
+	A40 += -f152 * j2fact * (7*x*y*z2/r292 - x*y/r272)                      //dAyDx
+	A50 += -f152 * j2fact * (7*x*z3/r292 - 3*x*z/r272)                      //dAzDx
+
+	A31 += -f152 * j2fact * (7*x*y*z2/r292 - x*y/r272)                      //dAxDy
+	A41 += -f32 * j2fact * (35*y2*z2/r292 - 5*y2/r272 - 5*z2/r272 + 1/r252) // dAyDy
+	A51 += -f152 * j2fact * (7*y*z3/r292 - 3*y*z/r272)                      // dAzDy
+
+	A32 += -f152 * j2fact * (7*x*z3/r292 - 3*x*z/r272)        //dAxDz
+	A42 += -f152 * j2fact * (7*y*z3/r292 - 3*y*z/r272)        //dAyDz
+	A52 += -f32 * j2fact * (35*z4/r292 - 30*z2/r272 + 3/r252) // dAzDz
+
+	// J3
+	if Jn > 2 {
+		z5 := math.Pow(R[2], 5)
+		r2112 := math.Pow(r2, 11/2.)
+		f52 := 5 / 2.
+		f1052 := 105 / 2.
+		j3fact := body.J(3) * math.Pow(body.Radius, 3) * body.μ
+		A30 += -f52 * j3fact * (63*x2*z3/r2112 - 21*x2*z/r292 - 7*z3/r292 + 3*z/r272) //dAxDx
+		A40 += -f1052 * j3fact * (3*x*y*z3/r2112 - x*y*z/r292)                        //dAyDx
+		A50 += -f152 * j3fact * (21*x*z4/r2112 - 14*x*z2/r292 + x/r272)               //dAzDx
+
+		A31 += -f1052 * j3fact * (3*x*y*z3/r2112 - x*y*z/r292)                        //dAxDy
+		A41 += -f52 * j3fact * (63*y2*z3/r2112 - 21*y2*z/r292 - 7*z3/r292 + 3*z/r272) // dAyDy
+		A51 += -f152 * j3fact * (21*y*z4/r2112 - 14*y*z2/r292 + y/r272)               // dAzDy
+
+		A32 += -f152 * j3fact * (21*x*z4/r2112 - 14*x*z2/r292 + x/r272) //dAxDz
+		A42 += -f152 * j3fact * (21*y*z4/r2112 - 14*y*z2/r292 + y/r272) //dAyDz
+		A52 += -f52 * j3fact * (63*z5/r2112 - 70*z3/r292 + 15*z/r272)   // dAzDz
+	}
+	A := mat64.NewDense(3, 3, []float64{A30, A31, A32, A40, A41, A42, A50, A51, A52})
+	if tilted {
+		var Ainertial mat64.Dense
+		Ainertial.Product(dcm.T(), A, dcm)
+		return &Ainertial
+	}
+	return A
+}
+
 // OrbitNoise defines a new orbit noise applied as a perturbations.
 // Use case is for generating datasets for filtering.
 type OrbitNoise struct {
 	probability float64
 	position    *distmv.Normal
 	velocity    *distmv.Normal
+	rng         *rand.Rand
 }
 
+// Generate returns a random noise on the position and velocity (zero if not drawn as per the probability).
 func (n OrbitNoise) Generate() (rtn []float64) {
 	rtn = make([]float64, 6)
-	if randFloat := rand.Float64(); n.probability < randFloat {
+	if randFloat := n.rng.Float64(); n.probability < randFloat {
 		return
 	}
 	position := n.position.Rand(nil)
@@ -139,17 +244,33 @@ func (n OrbitNoise) Generate() (rtn []float64) {
 	return
 }
 
+// withRand returns a copy of this noise drawn from the provided source (e.g. for a parallel run).
+func (n OrbitNoise) withRand(rng *rand.Rand) OrbitNoise {
+	if n == (OrbitNoise{}) {
+		return n
+	}
+	position, _ := distmv.NewNormal(make([]float64, 3), n.position.CovarianceMatrix(nil), rng)
+	velocity, _ := distmv.NewNormal(make([]float64, 3), n.velocity.CovarianceMatrix(nil), rng)
+	return OrbitNoise{n.probability, position, velocity, rng}
+}
+
+// NewOrbitNoise returns a new orbit noise from a source seeded with the current time (cf. NewSeededOrbitNoise).
 func NewOrbitNoise(probability, sigmaPosition, sigmaVelocity float64) OrbitNoise {
+	return NewSeededOrbitNoise(probability, sigmaPosition, sigmaVelocity, NewRand(time.Now().UnixNano()))
+}
+
+// NewSeededOrbitNoise returns a new orbit noise drawn from the provided source, applied with the provided probability
+// at each call of the perturbations with the provided variances of the position and velocity.
+func NewSeededOrbitNoise(probability, sigmaPosition, sigmaVelocity float64, rng *rand.Rand) OrbitNoise {
 	posMatrix := mat64.NewSymDense(3, []float64{sigmaPosition, 0, 0, 0, sigmaPosition, 0, 0, 0, sigmaPosition})
 	velMatrix := mat64.NewSymDense(3, []float64{sigmaVelocity, 0, 0, 0, sigmaVelocity, 0, 0, 0, sigmaVelocity})
-	seed := rand.New(rand.NewSource(time.Now().UnixNano()))
-	position, ok := distmv.NewNormal(make([]float64, 3), posMatrix, seed)
+	position, ok := distmv.NewNormal(make([]float64, 3), posMatrix, rng)
 	if !ok {
 		panic("process noise invalid")
 	}
-	velocity, ok := distmv.NewNormal(make([]float64, 3), velMatrix, seed)
+	velocity, ok := distmv.NewNormal(make([]float64, 3), velMatrix, rng)
 	if !ok {
 		panic("measurement noise invalid")
 	}
-	return OrbitNoise{probability, position, velocity}
+	return OrbitNoise{probability, position, velocity, rng}
 }
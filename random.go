@@ -0,0 +1,38 @@
+package smd
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+/* Reproducible randomness. All the random draws of the library (the orbit noise, the noise of the stations and the
+tracking simulator) come from explicit sources, so that a given seed always leads to the same run. The sources are not
+safe for concurrent use, so each of the parallel runs derives its own source from the seed with StreamSeed. */
+
+// NewRand returns a new pseudo-random source from the provided seed.
+func NewRand(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed))
+}
+
+// StreamSeed returns the seed of the provided stream (e.g. the number of a parallel run, or of a station) derived from
+// the provided seed, with the SplitMix64 finalizer so that the streams of nearby seeds are independent.
+func StreamSeed(seed int64, stream int) int64 {
+	z := uint64(seed) + uint64(stream+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
+
+// SeedFromConfig returns the seed of the provided key, or a seed from the current time if it is not set, which is
+// logged so that the run can be reproduced.
+func SeedFromConfig(v *viper.Viper, key string) int64 {
+	if v.IsSet(key) {
+		return v.GetInt64(key)
+	}
+	seed := time.Now().UnixNano()
+	log.Printf("[info] `%s` unset, using the random seed %d", key, seed)
+	return seed
+}
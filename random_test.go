@@ -0,0 +1,102 @@
+package smd
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+)
+
+func TestStreamSeed(t *testing.T) {
+	seeds := make(map[int64]bool)
+	for seed := int64(0); seed < 3; seed++ {
+		for stream := 0; stream < 10; stream++ {
+			s := StreamSeed(seed, stream)
+			if s != StreamSeed(seed, stream) {
+				t.Fatalf("stream %d of seed %d is not deterministic", stream, seed)
+			}
+			if seeds[s] {
+				t.Fatalf("stream %d of seed %d is the seed of another stream", stream, seed)
+			}
+			seeds[s] = true
+		}
+	}
+	if NewRand(42).Float64() != NewRand(42).Float64() {
+		t.Fatal("same seed leads to different draws")
+	}
+}
+
+func TestSeededOrbitNoise(t *testing.T) {
+	noise := NewSeededOrbitNoise(1, 1, 0.1, NewRand(42))
+	again := NewSeededOrbitNoise(1, 1, 0.1, NewRand(42))
+	other := NewSeededOrbitNoise(1, 1, 0.1, NewRand(7))
+	for i := 0; i < 10; i++ {
+		draw, againDraw, otherDraw := noise.Generate(), again.Generate(), other.Generate()
+		if !floats.Equal(draw, againDraw) {
+			t.Fatalf("draw #%d: %v != %v with the same seed", i, draw, againDraw)
+		}
+		if floats.Equal(draw, otherDraw) {
+			t.Fatalf("draw #%d: %v with different seeds", i, draw)
+		}
+	}
+	if draw := NewSeededOrbitNoise(0, 1, 0.1, NewRand(42)).Generate(); !floats.Equal(draw, make([]float64, 6)) {
+		t.Fatalf("noise %v despite a null probability", draw)
+	}
+}
+
+func TestStationRand(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	st := NewStation("test", 0, 10, 30, 40, σρ, σρDot)
+	rECEF := GEO2ECEF(1000, st.LatΦ+d2r, st.Longθ)
+	R, V := ECEF2ECIState(rECEF, []float64{0, 0, 1}, dt)
+	state := State{DT: dt, Orbit: *NewOrbitFromRV(R, V, Earth)}
+	measure := func(seed int64) []float64 {
+		st.Rand = NewRand(seed)
+		var values []float64
+		for i := 0; i < 5; i++ {
+			m := st.PerformMeasurement(dt, state)
+			if !m.Visible || math.Abs(m.Range-m.TrueRange) > 1 {
+				t.Fatalf("invalid measurement: %+v", m)
+			}
+			values = append(values, m.Range, m.RangeRate)
+		}
+		return values
+	}
+	values := measure(42)
+	if !floats.Equal(values, measure(42)) {
+		t.Fatal("different noise with the same seed")
+	}
+	if floats.Equal(values, measure(7)) {
+		t.Fatal("same noise with different seeds")
+	}
+	// Without a source, the noise is drawn from the global source, which is reproducible once seeded.
+	global := func() []float64 {
+		st.Rand = nil
+		rand.Seed(42)
+		m := st.PerformMeasurement(dt, state)
+		return []float64{m.Range, m.RangeRate}
+	}
+	if !floats.Equal(global(), global()) {
+		t.Fatal("different noise with the same global seed")
+	}
+	// A station without any noise definition performs noise free measurements.
+	noiseless := Station{Name: "noiseless", R: st.R, V: st.V, LatΦ: st.LatΦ, Longθ: st.Longθ, Elevation: 10, Planet: Earth, Rand: NewRand(42)}
+	if m := noiseless.PerformMeasurement(dt, state); m.Range != m.TrueRange || m.RangeRate != m.TrueRangeRate {
+		t.Fatalf("noisy measurement without noise: %+v", m)
+	}
+	// Only the types of the station with a non zero variance draw from its source.
+	angles := st
+	angles.Types = []MeasurementType{MeasAzimuth, MeasElevation}
+	angles.Variances = map[MeasurementType]float64{MeasAzimuth: 0, MeasElevation: 0}
+	for _, station := range []Station{angles, noiseless} {
+		station.Rand = NewRand(42)
+		if m := station.PerformMeasurement(dt, state); m.Range != m.TrueRange || m.RangeRate != m.TrueRangeRate {
+			t.Fatalf("noisy range of %s without range type: %+v", station.Name, m)
+		}
+		if station.Rand.NormFloat64() != NewRand(42).NormFloat64() {
+			t.Fatalf("%s drew from its source without noise", station.Name)
+		}
+	}
+}
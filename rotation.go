@@ -65,6 +65,7 @@ func GEO2ECEF(altitude, latitude, longitude float64) []float64 {
 }
 
 // ECI2ECEF converts the provided ECI vector to ECEF for the θgst given in degrees.
+// WARNING: This is a single rotation about Z, cf. ECI2ECEFState for precession, nutation and polar motion.
 func ECI2ECEF(R []float64, θgst float64) []float64 {
 	return MxV33(R3(θgst), R)
 }
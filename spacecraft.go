@@ -23,7 +23,8 @@ type Spacecraft struct {
 	FuncQ       []func()
 	logger      kitlog.Logger
 	prevCL      *ControlLaw // Stores the previous control law to follow what is going on.
-	Drag        float64
+	Drag        float64     // Coefficient of reflectivity of the SRP (cf. Perturbations.Drag)
+	Cd          float64     // Drag coefficient (cf. Perturbations.AtmosphericDrag)
 	handleFuel  bool
 }
 
@@ -183,12 +184,12 @@ func (sc *Spacecraft) ToXCentric(body CelestialObject, dt time.Time, o *Orbit) f
 
 // NewEmptySC returns a spacecraft with no cargo and no EPThrusters.
 func NewEmptySC(name string, mass uint) *Spacecraft {
-	return &Spacecraft{name, float64(mass), 0, NewUnlimitedEPS(), []EPThruster{}, false, []*Cargo{}, []Waypoint{}, make(map[time.Time]Maneuver), []func(){}, SCLogInit(name), nil, 0, false}
+	return &Spacecraft{name, float64(mass), 0, NewUnlimitedEPS(), []EPThruster{}, false, []*Cargo{}, []Waypoint{}, make(map[time.Time]Maneuver), []func(){}, SCLogInit(name), nil, 0, 0, false}
 }
 
 // NewSpacecraft returns a spacecraft with initialized function queue and logger.
 func NewSpacecraft(name string, dryMass, fuelMass float64, eps EPS, prop []EPThruster, impulse bool, payload []*Cargo, wp []Waypoint) *Spacecraft {
-	return &Spacecraft{name, dryMass, fuelMass, eps, prop, impulse, payload, wp, make(map[time.Time]Maneuver), make([]func(), 5), SCLogInit(name), nil, 0, fuelMass > 0}
+	return &Spacecraft{name, dryMass, fuelMass, eps, prop, impulse, payload, wp, make(map[time.Time]Maneuver), make([]func(), 5), SCLogInit(name), nil, 0, 0, fuelMass > 0}
 }
 
 // Cargo defines a piece of cargo with arrival date and destination orbit
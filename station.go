@@ -20,36 +20,91 @@ var (
 	σρ             = math.Pow(5e-3, 2) // m , but all measurements in km.
 	σρDot          = math.Pow(5e-6, 2) // m/s , but all measurements in km/s.
 	DSS34Canberra  = NewSpecialStation("DSS34Canberra", 0.691750, 0, -35.398333, 148.981944, σρ, σρDot, 6)
-	DSS65Madrid    = NewSpecialStation("DSS65Madrid", 0.834939, 0, 40.427222, 4.250556, σρ, σρDot, 6)
+	DSS65Madrid    = NewSpecialStation("DSS65Madrid", 0.834939, 0, 40.427222, -4.250556, σρ, σρDot, 6)
 	DSS13Goldstone = NewSpecialStation("DSS13Goldstone", 1.07114904, 0, 35.247164, 243.205, σρ, σρDot, 6)
 )
 
 // Station defines a ground station.
 type Station struct {
 	Name                       string
-	R, V                       []float64 // position and velocity in ECEF
-	LatΦ, Longθ                float64   // these are stored in radians!
+	R, V                       []float64 // position in ECEF and inertial velocity due to the planet rotation (in ECEF)
+	LatΦ, Longθ                float64   // geodetic, these are stored in radians!
 	Altitude, Elevation        float64
-	RangeNoise, RangeRateNoise *distmv.Normal // Station noise
+	RangeNoise, RangeRateNoise *distmv.Normal // Variance of the range and range rate noises (cf. Variances)
 	Planet                     CelestialObject
-	rowsH                      int // If estimating Cr in addition to position and velocity, this needs to be 7
+	Mask                       ElevationMask               // Terrain mask, in addition to the Elevation
+	MinRange, MaxRange         float64                     // In km, a zero MaxRange is unlimited
+	Windows                    []TimeWindow                // Availability windows (e.g. tracking passes), always available if empty
+	Types                      []MeasurementType           // Measurement types, range and range rate if empty
+	Variances                  map[MeasurementType]float64 // Noise variance per measurement type (cf. noise)
+	Link                       Link
+	Corrections                Corrections
+	Rand                       *rand.Rand // Source of the noise (cf. NewRand), the global source of math/rand if nil
+	rowsH                      int        // Deprecated: columns of HTilde (cf. NewSpecialStation)
 }
 
-// PerformMeasurement returns whether the SC is visible, and if so, the measurement.
-func (s Station) PerformMeasurement(θgst float64, state State) Measurement {
-	// The station vectors are in ECEF, so let's convert the state to ECEF.
-	rECEF := ECI2ECEF(state.Orbit.R(), θgst)
-	vECEF := ECI2ECEF(state.Orbit.V(), θgst)
-	// Compute visibility for each station.
-	ρECEF, ρ, el, _ := s.RangeElAz(rECEF)
-	vDiffECEF := make([]float64, 3)
-	for i := 0; i < 3; i++ {
-		vDiffECEF[i] = (vECEF[i] - s.V[i]) / ρ
+// PerformMeasurement returns whether the SC is visible, and if so, the measurement at the provided epoch.
+// The range and range rate of the measurement are those of the link, and the values of all the measurement types of
+// the station are also computed. The noise is drawn once per type of the station with a non zero variance.
+func (s Station) PerformMeasurement(epoch time.Time, state State) Measurement {
+	m := s.noiselessMeasurement(epoch, state)
+	for i, t := range m.Types {
+		m.Values[i] += s.noise(t)
+		switch t {
+		case MeasRange:
+			m.Range = m.Values[i]
+		case MeasRangeRate:
+			m.RangeRate = m.Values[i]
+		}
+	}
+	return m
+}
+
+// noiselessMeasurement returns the measurement of PerformMeasurement without any noise.
+func (s Station) noiselessMeasurement(epoch time.Time, state State) Measurement {
+	visible := true
+	for _, st := range s.legs() {
+		// The station vectors are in ECEF, so let's convert the state to ECEF (where the station is fixed).
+		rECEF, _ := inertial2BodyFixed(state.Orbit.R(), state.Orbit.V(), epoch, st.Planet)
+		_, ρ, el, az := st.RangeElAz(rECEF)
+		visible = visible && st.IsVisible(epoch, ρ, el, az)
+	}
+	l := s.linkRange(epoch, state.Orbit.R(), state.Orbit.V(), state.Orbit.Origin.μ, false)
+	types := s.MeasurementTypes()
+	values := make([]float64, len(types))
+	trueValues := make([]float64, len(types))
+	for i, t := range types {
+		trueValues[i], _, _ = s.Observe(t, epoch, state.Orbit)
+		switch t {
+		case MeasRange:
+			values[i] = l.ρ
+		case MeasRangeRate:
+			values[i] = l.ρDot
+		default:
+			values[i] = trueValues[i]
+		}
+	}
+	return Measurement{visible, l.ρ, l.ρDot, l.ρ, l.ρDot, epoch, state, s, types, values, trueValues}
+}
+
+// IsVisible returns whether a spacecraft at the provided range (in km), elevation and azimuth (in degrees) can be
+// tracked by this station at the provided epoch, as per its elevation and terrain masks, range limits and windows.
+func (s Station) IsVisible(epoch time.Time, ρ, el, az float64) bool {
+	if el < s.Elevation || (len(s.Mask) > 0 && el < s.Mask.At(az)) {
+		return false
+	}
+	if ρ < s.MinRange || (s.MaxRange > 0 && ρ > s.MaxRange) {
+		return false
 	}
-	ρDot := mat64.Dot(mat64.NewVector(3, ρECEF), mat64.NewVector(3, vDiffECEF))
-	ρNoisy := ρ + s.RangeNoise.Rand(nil)[0]
-	ρDotNoisy := ρDot + s.RangeRateNoise.Rand(nil)[0]
-	return Measurement{el >= s.Elevation, ρNoisy, ρDotNoisy, ρ, ρDot, θgst, state, s}
+	if len(s.Windows) == 0 {
+		return true
+	}
+	for _, window := range s.Windows {
+		if window.Contains(epoch) {
+			return true
+		}
+	}
+	return false
 }
 
 // RangeElAz returns the range (in the SEZ frame), elevation and azimuth (in degrees) of a given R vector in ECEF.
@@ -70,25 +125,35 @@ func (s Station) String() string {
 	return fmt.Sprintf("%s (%f,%f); alt = %f km; el = %f deg", s.Name, s.LatΦ/d2r, s.Longθ/d2r, s.Altitude, s.Elevation)
 }
 
-// NewStation returns a new station. Angles in degrees.
+// NewStation returns a new station from its geodetic coordinates on the WGS84 ellipsoid. Angles in degrees.
 func NewStation(name string, altitude, elevation, latΦ, longθ, σρ, σρDot float64) Station {
 	return NewSpecialStation(name, altitude, elevation, latΦ, longθ, σρ, σρDot, 6)
 }
 
 // NewSpecialStation same as NewStation but can specify the rows of H.
+// Deprecated: the parameters of the orbit determination, such as Cr, are set with ODConfig.Parameters.
 func NewSpecialStation(name string, altitude, elevation, latΦ, longθ, σρ, σρDot float64, rowsH int) Station {
-	R := GEO2ECEF(altitude, latΦ*d2r, longθ*d2r)
-	V := Cross([]float64{0, 0, EarthRotationRate}, R)
-	seed := rand.New(rand.NewSource(time.Now().UnixNano()))
-	ρNoise, ok := distmv.NewNormal([]float64{0}, mat64.NewSymDense(1, []float64{σρ}), seed)
+	return newStation(Earth, name, altitude, elevation, latΦ, longθ, σρ, σρDot, rowsH)
+}
+
+// NewPlanetStation same as NewStation but on the provided planet, whose IAU body fixed frame is used.
+func NewPlanetStation(planet CelestialObject, name string, altitude, elevation, latΦ, longθ, σρ, σρDot float64) Station {
+	return newStation(planet, name, altitude, elevation, latΦ, longθ, σρ, σρDot, 6)
+}
+
+func newStation(planet CelestialObject, name string, altitude, elevation, latΦ, longθ, σρ, σρDot float64, rowsH int) Station {
+	R := planet.Ellipsoid().ToBodyFixed(altitude, latΦ*d2r, longθ*d2r)
+	V := Cross([]float64{0, 0, planet.surfaceRotationRate()}, R)
+	// The distributions only define the variances, the noise is drawn from the source of the station (cf. noise).
+	ρNoise, ok := distmv.NewNormal([]float64{0}, mat64.NewSymDense(1, []float64{σρ}), nil)
 	if !ok {
 		panic("NOK in Gaussian")
 	}
-	ρDotNoise, ok := distmv.NewNormal([]float64{0}, mat64.NewSymDense(1, []float64{σρDot}), seed)
+	ρDotNoise, ok := distmv.NewNormal([]float64{0}, mat64.NewSymDense(1, []float64{σρDot}), nil)
 	if !ok {
 		panic("NOK in Gaussian")
 	}
-	return Station{name, R, V, latΦ * d2r, longθ * d2r, altitude, elevation, ρNoise, ρDotNoise, Earth, rowsH}
+	return Station{name, R, V, latΦ * d2r, longθ * d2r, altitude, elevation, ρNoise, ρDotNoise, planet, nil, 0, 0, nil, nil, nil, Link{}, Corrections{}, nil, rowsH}
 }
 
 // Measurement stores a measurement of a station.
@@ -96,51 +161,54 @@ type Measurement struct {
 	Visible                  bool    // Stores whether or not the attempted measurement was visible from the station.
 	Range, RangeRate         float64 // Store the range and range rate
 	TrueRange, TrueRangeRate float64 // Store the true range and range rate
-	Timeθgst                 float64
+	Epoch                    time.Time
 	State                    State
 	Station                  Station
+	Types                    []MeasurementType // Types of the values, range and range rate if empty
+	Values, TrueValues       []float64         // Store the noisy and true values of each type
 }
 
 // IsNil returns the state vector as a mat64.Vector
 func (m Measurement) IsNil() bool {
-	return m.Range == m.RangeRate && m.RangeRate == 0
+	return m.Range == m.RangeRate && m.RangeRate == 0 && len(m.Values) == 0
+}
+
+// MeasurementTypes returns the types of the values of this measurement.
+func (m Measurement) MeasurementTypes() []MeasurementType {
+	if len(m.Types) == 0 {
+		return []MeasurementType{MeasRange, MeasRangeRate}
+	}
+	return m.Types
 }
 
 // StateVector returns the state vector as a mat64.Vector
 func (m Measurement) StateVector() *mat64.Vector {
-	return mat64.NewVector(2, []float64{m.Range, m.RangeRate})
+	if len(m.Types) == 0 {
+		return mat64.NewVector(2, []float64{m.Range, m.RangeRate})
+	}
+	return mat64.NewVector(len(m.Values), m.Values)
 }
 
-// HTilde returns the H tilde matrix for this given measurement.
+// TrueStateVector returns the noise free state vector as a mat64.Vector
+func (m Measurement) TrueStateVector() *mat64.Vector {
+	if len(m.Types) == 0 {
+		return mat64.NewVector(2, []float64{m.TrueRange, m.TrueRangeRate})
+	}
+	return mat64.NewVector(len(m.TrueValues), m.TrueValues)
+}
+
+// HTilde returns the H tilde matrix for this given measurement, i.e. the partials of each measurement type with respect
+// to the inertial position and velocity of the spacecraft.
 func (m Measurement) HTilde() *mat64.Dense {
-	stationR := ECEF2ECI(m.Station.R, m.Timeθgst)
-	stationV := ECEF2ECI(m.Station.V, m.Timeθgst)
-	xS := stationR[0]
-	yS := stationR[1]
-	zS := stationR[2]
-	xSDot := stationV[0]
-	ySDot := stationV[1]
-	zSDot := stationV[2]
-	R := m.State.Orbit.R()
-	V := m.State.Orbit.V()
-	x := R[0]
-	y := R[1]
-	z := R[2]
-	xDot := V[0]
-	yDot := V[1]
-	zDot := V[2]
-	H := mat64.NewDense(2, m.Station.rowsH, nil)
-	// \partial \rho / \partial {x,y,z}
-	H.Set(0, 0, (x-xS)/m.Range)
-	H.Set(0, 1, (y-yS)/m.Range)
-	H.Set(0, 2, (z-zS)/m.Range)
-	// \partial \dot\rho / \partial {x,y,z}
-	H.Set(1, 0, (xDot-xSDot)/m.Range+(m.RangeRate/math.Pow(m.Range, 2))*(x-xS))
-	H.Set(1, 1, (yDot-ySDot)/m.Range+(m.RangeRate/math.Pow(m.Range, 2))*(y-yS))
-	H.Set(1, 2, (zDot-zSDot)/m.Range+(m.RangeRate/math.Pow(m.Range, 2))*(z-zS))
-	H.Set(1, 3, (x-xS)/m.Range)
-	H.Set(1, 4, (y-yS)/m.Range)
-	H.Set(1, 5, (z-zS)/m.Range)
+	types := m.MeasurementTypes()
+	H := mat64.NewDense(len(types), m.Station.rowsH, nil)
+	for i, t := range types {
+		_, hR, hV := m.Station.Observe(t, m.Epoch, m.State.Orbit)
+		for j := 0; j < 3; j++ {
+			H.Set(i, j, hR[j])
+			H.Set(i, j+3, hV[j])
+		}
+	}
 	return H
 }
 
@@ -0,0 +1,154 @@
+package smd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// testTCMPlan returns a plan of two TCMs on a hyperbolic escape about the Earth.
+func testTCMPlan(o *Orbit, computeSTM bool, knowledge *mat64.SymDense, execution Dispersions) TCMPlan {
+	P0 := mat64.NewSymDense(6, nil)
+	for i := 0; i < 3; i++ {
+		P0.SetSym(i, i, 1)
+		P0.SetSym(i+3, i+3, 1e-8)
+	}
+	return TCMPlan{
+		Mission:   NewPreciseMission(NewEmptySC("tcm", 0), o, testStart, testStart.Add(-1), Perturbations{}, time.Minute, computeSTM, ExportConfig{}),
+		Encounter: testStart.Add(4 * time.Hour),
+		P0:        P0,
+		TCMs:      []TCM{{testStart.Add(20 * time.Minute), knowledge}, {testStart.Add(2 * time.Hour), knowledge}},
+		Execution: execution,
+		Samples:   200,
+		Seed:      42,
+	}
+}
+
+func TestTCMPlan(t *testing.T) {
+	escape := func() *Orbit { return NewOrbitFromRV([]float64{7000, 0, 0}, []float64{0, 12, 0.5}, Earth) }
+	// With a perfect knowledge and execution, the first TCM cancels the dispersion of the B-plane.
+	perfect, err := testTCMPlan(escape(), true, nil, Dispersions{}).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perfect.Nominal.SemiMaj <= 0 || perfect.Delivery.SemiMaj > 1e-6*perfect.Nominal.SemiMaj {
+		t.Fatalf("invalid delivery %s for a nominal %s", perfect.Delivery, perfect.Nominal)
+	}
+	if math.Abs(perfect.Delivery.BR-perfect.Nominal.BR) > 1e-6 || math.Abs(perfect.Delivery.BT-perfect.Nominal.BT) > 1e-6 {
+		t.Fatalf("delivery %s not on the nominal %s", perfect.Delivery, perfect.Nominal)
+	}
+	if perfect.TCMs[0].Mean <= 0 || perfect.TCMs[1].Max > 1e-9 {
+		t.Fatalf("invalid perfect TCMs %+v", perfect.TCMs)
+	}
+
+	// Otherwise, the last TCM corrects the errors of the first one.
+	knowledge := mat64.NewSymDense(6, nil)
+	for i := 0; i < 3; i++ {
+		knowledge.SetSym(i, i, 0.01)
+		knowledge.SetSym(i+3, i+3, 1e-10)
+	}
+	execution := Dispersions{BurnMagnitude: 0.02, BurnFixed: 1e-6, BurnPointing: 1}
+	result, err := testTCMPlan(escape(), true, knowledge, execution).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.TCMs) != 2 || len(result.Samples) != 200 || result.TCMs[1].Mean <= 0 {
+		t.Fatalf("invalid result %+v", result.TCMs)
+	}
+	for _, stats := range append(result.TCMs, TCMStats{MonteCarloStats: result.Total}) {
+		if stats.Std == 0 || stats.Min > stats.P5 || stats.P5 > stats.P50 || stats.P50 > stats.P95 || stats.P95 > stats.P99 || stats.P99 > stats.Max {
+			t.Fatalf("invalid statistics %+v", stats)
+		}
+	}
+	if result.Δv99() != result.Total.P99 || result.Total.P99 < result.TCMs[0].P99 {
+		t.Fatalf("invalid Δv-99 of %f km/s", result.Δv99())
+	}
+	if result.Delivery.SemiMaj <= perfect.Delivery.SemiMaj || result.Delivery.SemiMaj >= result.Nominal.SemiMaj {
+		t.Fatalf("invalid delivery %s for a nominal %s", result.Delivery, result.Nominal)
+	}
+	// The samples only depend on the seed.
+	again, err := testTCMPlan(escape(), true, knowledge, execution).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, Δvs := range result.Samples {
+		if Δvs[0] != again.Samples[i][0] || Δvs[1] != again.Samples[i][1] {
+			t.Fatalf("sample #%d differs with the same seed", i)
+		}
+	}
+
+	// Invalid plans
+	if _, err = testTCMPlan(escape(), false, nil, Dispersions{}).Run(); err == nil {
+		t.Fatal("plan without STM accepted")
+	}
+	if _, err = testTCMPlan(NewOrbitFromOE(7000, 0.01, 30, 80, 40, 0, Earth), true, nil, Dispersions{}).Run(); err == nil {
+		t.Fatal("plan of an elliptical orbit accepted")
+	}
+	plan := testTCMPlan(escape(), true, nil, Dispersions{})
+	plan.TCMs[1].DT = plan.Encounter.Add(time.Minute)
+	if _, err = plan.Run(); err == nil {
+		t.Fatal("TCM after the encounter accepted")
+	}
+
+	// Exports
+	f, err := ioutil.TempFile("", "tcm*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	defer os.Remove(csvSuffix(f.Name(), "-stats"))
+	if err = result.ExportCSV(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(f.Name())
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 3+200 || strings.Count(lines[3], ",") != 3 {
+		t.Fatalf("invalid CSV export\n%s", data)
+	}
+	data, _ = ioutil.ReadFile(csvSuffix(f.Name(), "-stats"))
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1+3 {
+		t.Fatalf("invalid CSV statistics\n%s", data)
+	}
+	if err = result.ExportJSON(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadFile(f.Name())
+	var imported TCMResult
+	if err = json.Unmarshal(data, &imported); err != nil || len(imported.Samples) != 200 || imported.Total.P99 != result.Total.P99 {
+		t.Fatalf("invalid JSON export (%v)", err)
+	}
+}
+
+func TestTCMPlanTarget(t *testing.T) {
+	// A heliocentric approach of Mars is targeted in the B-plane of Mars.
+	start := time.Date(2016, 3, 24, 20, 41, 48, 0, time.UTC)
+	R, V := Mars.HelioOrbit(start).RV()
+	plan := func(target CelestialObject) TCMPlan {
+		o := NewOrbitFromRV([]float64{R[0] - 500000, R[1] + 200000, R[2] + 10000}, []float64{V[0] + 3, V[1] - 0.5, V[2] + 0.2}, Sun)
+		p := testTCMPlan(o, true, nil, Dispersions{})
+		p.Mission = NewPreciseMission(NewEmptySC("tcm", 0), o, start, start.Add(-1), Perturbations{}, time.Minute, true, ExportConfig{})
+		p.Target = target
+		p.Encounter = start.Add(4 * time.Hour)
+		p.TCMs = []TCM{{start.Add(20 * time.Minute), nil}}
+		return p
+	}
+	if _, err := plan(CelestialObject{}).Run(); err == nil {
+		t.Fatal("B-plane of a heliocentric elliptical orbit accepted")
+	}
+	result, err := plan(Mars).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Nominal.SemiMaj <= 0 || result.Delivery.SemiMaj > 1e-6*result.Nominal.SemiMaj {
+		t.Fatalf("invalid delivery %s for a nominal %s", result.Delivery, result.Nominal)
+	}
+	if math.Abs(result.Delivery.BR-result.Nominal.BR) > 1e-3 || math.Abs(result.Delivery.BT-result.Nominal.BT) > 1e-3 {
+		t.Fatalf("delivery %s not on the nominal %s", result.Delivery, result.Nominal)
+	}
+}
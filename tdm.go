@@ -0,0 +1,459 @@
+package smd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* CCSDS Tracking Data Messages (CCSDS 503.0-B-1) in KVN and XML. */
+
+const (
+	// TDMRange is the TDM keyword of the range, in km or in RU (cf. RANGE_UNITS).
+	TDMRange = "RANGE"
+	// TDMRangeRate is the TDM keyword of the instantaneous range rate, in km/s.
+	TDMRangeRate = "DOPPLER_INSTANTANEOUS"
+	// TDMIntegratedDoppler is the TDM keyword of the range rate averaged over the integration interval, in km/s.
+	TDMIntegratedDoppler = "DOPPLER_INTEGRATED"
+	// TDMAngle1 is the TDM keyword of the first angle (right ascension or azimuth), in degrees.
+	TDMAngle1 = "ANGLE_1"
+	// TDMAngle2 is the TDM keyword of the second angle (declination or elevation), in degrees.
+	TDMAngle2 = "ANGLE_2"
+)
+
+// TDMObservation is an observation of a CCSDS Tracking Data Message.
+type TDMObservation struct {
+	Keyword string // e.g. RANGE or DOPPLER_INSTANTANEOUS
+	Epoch   time.Time
+	Value   float64
+}
+
+// TDMSegment is a segment of a CCSDS Tracking Data Message, i.e. the observations of a given tracking path.
+// A segment has a single range unit and angle type, so a station measuring both RA/Dec and az/el angles, or both
+// the range in km and in RU, needs several segments.
+type TDMSegment struct {
+	TimeSystem          string
+	Participants        []string // The first participant is the (receiving) station, the second is the spacecraft and the third is the transmitter of three-way links.
+	Mode                string
+	Path                string
+	RangeUnits          string
+	RangeModulus        float64 // In RU
+	AngleType           string  // RADEC or AZEL
+	IntegrationInterval float64 // Of the integrated Doppler, in seconds
+	Observations        []TDMObservation
+}
+
+// NewTDMSegment returns a segment of the one way observations of the spacecraft from the station.
+func NewTDMSegment(station, spacecraft string) TDMSegment {
+	return TDMSegment{"UTC", []string{station, spacecraft}, "SEQUENTIAL", "1,2", "km", 0, "", 0, nil}
+}
+
+// NewStationTDMSegment returns a segment of the observations of the spacecraft by the provided station, whose path,
+// range units, angle type and integration interval are set from the link and measurement types of the station.
+// Returns an error for a three-way link without a transmitter.
+func NewStationTDMSegment(st Station, spacecraft string) (TDMSegment, error) {
+	seg := NewTDMSegment(st.Name, spacecraft)
+	switch st.Link.Type {
+	case TwoWay:
+		seg.Path = "1,2,1"
+	case ThreeWay:
+		if st.Link.Transmitter == nil {
+			return seg, fmt.Errorf("three-way link of %s without a transmitter", st.Name)
+		}
+		seg.Participants = append(seg.Participants, st.Link.Transmitter.Name)
+		seg.Path = "3,2,1"
+	}
+	for _, t := range st.MeasurementTypes() {
+		switch t {
+		case MeasDSNRange:
+			seg.RangeUnits = "RU"
+			seg.RangeModulus = st.Link.RangeModulus
+		case MeasRightAscension, MeasDeclination:
+			seg.AngleType = "RADEC"
+		case MeasAzimuth, MeasElevation:
+			seg.AngleType = "AZEL"
+		case MeasIntegratedDoppler:
+			seg.IntegrationInterval = st.Link.countTime()
+		}
+	}
+	return seg, nil
+}
+
+// AddMeasurement adds the values of the measurement to this segment (its range and range rate if it has no types).
+func (s *TDMSegment) AddMeasurement(m Measurement) {
+	if len(m.Types) == 0 {
+		s.Observations = append(s.Observations, TDMObservation{TDMRange, m.State.DT, m.Range}, TDMObservation{TDMRangeRate, m.State.DT, m.RangeRate})
+		return
+	}
+	for i, t := range m.Types {
+		s.Observations = append(s.Observations, TDMObservation{tdmKeyword(t), m.State.DT, m.Values[i]})
+	}
+}
+
+// tdmKeyword returns the TDM keyword of the provided measurement type.
+func tdmKeyword(t MeasurementType) string {
+	switch t {
+	case MeasRange, MeasDSNRange:
+		return TDMRange
+	case MeasRangeRate:
+		return TDMRangeRate
+	case MeasIntegratedDoppler:
+		return TDMIntegratedDoppler
+	case MeasRightAscension, MeasAzimuth:
+		return TDMAngle1
+	default:
+		return TDMAngle2
+	}
+}
+
+// MeasurementType returns the measurement type of the provided keyword in this segment, as per its range units and
+// angle type.
+func (s TDMSegment) MeasurementType(keyword string) (MeasurementType, error) {
+	switch keyword {
+	case TDMRange:
+		if s.RangeUnits == "RU" {
+			return MeasDSNRange, nil
+		}
+		return MeasRange, nil
+	case TDMRangeRate:
+		return MeasRangeRate, nil
+	case TDMIntegratedDoppler:
+		return MeasIntegratedDoppler, nil
+	case TDMAngle1, TDMAngle2:
+		switch s.AngleType {
+		case "RADEC":
+			if keyword == TDMAngle1 {
+				return MeasRightAscension, nil
+			}
+			return MeasDeclination, nil
+		case "AZEL":
+			if keyword == TDMAngle1 {
+				return MeasAzimuth, nil
+			}
+			return MeasElevation, nil
+		}
+		return MeasRange, fmt.Errorf("unsupported angle type `%s`", s.AngleType)
+	}
+	return MeasRange, fmt.Errorf("unsupported observation `%s`", keyword)
+}
+
+// Station returns the name of the (receiving) station of this segment.
+func (s TDMSegment) Station() string {
+	if len(s.Participants) == 0 {
+		return ""
+	}
+	return s.Participants[0]
+}
+
+// LinkType returns the type of link of this segment from its path.
+func (s TDMSegment) LinkType() LinkType {
+	path := strings.Split(strings.Replace(s.Path, " ", "", -1), ",")
+	switch {
+	case len(path) == 3 && path[0] == path[2]:
+		return TwoWay
+	case len(path) == 3:
+		return ThreeWay
+	default:
+		return OneWay
+	}
+}
+
+// Transmitter returns the name of the transmitting station of this segment, which is the station itself unless
+// this is a three-way link.
+func (s TDMSegment) Transmitter() string {
+	if s.LinkType() != ThreeWay {
+		return s.Station()
+	}
+	num, err := strconv.Atoi(strings.TrimSpace(strings.Split(s.Path, ",")[0]))
+	if err != nil || num < 1 || num > len(s.Participants) {
+		return ""
+	}
+	return s.Participants[num-1]
+}
+
+func (s *TDMSegment) set(key, value string) error {
+	switch {
+	case key == "TIME_SYSTEM":
+		s.TimeSystem = value
+		if _, err := TimeScaleFromString(value); err != nil {
+			return err
+		}
+	case key == "MODE":
+		s.Mode = value
+	case key == "PATH":
+		s.Path = value
+	case key == "RANGE_UNITS":
+		s.RangeUnits = value
+		if value != "km" && value != "RU" {
+			return fmt.Errorf("unsupported range units `%s` (only km and RU are supported)", value)
+		}
+	case key == "RANGE_MODULUS":
+		var err error
+		if s.RangeModulus, err = parseCCSDSFloat(value); err != nil {
+			return err
+		}
+	case key == "ANGLE_TYPE":
+		s.AngleType = value
+		if value != "RADEC" && value != "AZEL" {
+			return fmt.Errorf("unsupported angle type `%s` (only RADEC and AZEL are supported)", value)
+		}
+	case key == "INTEGRATION_INTERVAL":
+		var err error
+		if s.IntegrationInterval, err = parseCCSDSFloat(value); err != nil {
+			return err
+		}
+	case strings.HasPrefix(key, "PARTICIPANT_"):
+		num, err := strconv.Atoi(key[len("PARTICIPANT_"):])
+		if err != nil || num < 1 {
+			return fmt.Errorf("invalid participant keyword `%s`", key)
+		}
+		for len(s.Participants) < num {
+			s.Participants = append(s.Participants, "")
+		}
+		s.Participants[num-1] = value
+	}
+	// Other metadata keywords are ignored.
+	return nil
+}
+
+// toUTC converts the epochs of the observations from the time system of the segment to UTC.
+func (s *TDMSegment) toUTC() error {
+	scale, err := TimeScaleFromString(s.TimeSystem)
+	if err != nil {
+		return err
+	}
+	for i := range s.Observations {
+		s.Observations[i].Epoch = NewEpoch(s.Observations[i].Epoch, scale).UTC()
+	}
+	s.TimeSystem = UTC.String()
+	return nil
+}
+
+func (s TDMSegment) metadata() [][2]string {
+	meta := [][2]string{{"TIME_SYSTEM", s.TimeSystem}}
+	for i, participant := range s.Participants {
+		meta = append(meta, [2]string{fmt.Sprintf("PARTICIPANT_%d", i+1), participant})
+	}
+	for _, kv := range [][2]string{{"MODE", s.Mode}, {"PATH", s.Path}, {"RANGE_UNITS", s.RangeUnits}, {"ANGLE_TYPE", s.AngleType}} {
+		if kv[1] != "" {
+			meta = append(meta, kv)
+		}
+	}
+	if s.RangeModulus > 0 {
+		meta = append(meta, [2]string{"RANGE_MODULUS", ccsdsFloat(s.RangeModulus)})
+	}
+	if s.IntegrationInterval > 0 {
+		meta = append(meta, [2]string{"INTEGRATION_INTERVAL", ccsdsFloat(s.IntegrationInterval)})
+	}
+	return meta
+}
+
+// TDM is a CCSDS Tracking Data Message.
+type TDM struct {
+	Originator string
+	Created    time.Time
+	Segments   []TDMSegment
+}
+
+// NewTDM returns an empty TDM.
+func NewTDM() *TDM {
+	return &TDM{ccsdsOriginator, time.Now().UTC(), nil}
+}
+
+// WriteKVN writes the TDM in the KVN format.
+func (t TDM) WriteKVN(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "CCSDS_TDM_VERS = 1.0\nCREATION_DATE = %s\nORIGINATOR = %s\n", ccsdsDate(t.Created), t.Originator)
+	for _, seg := range t.Segments {
+		fmt.Fprintln(bw, "\nMETA_START")
+		for _, kv := range seg.metadata() {
+			fmt.Fprintf(bw, "%s = %s\n", kv[0], kv[1])
+		}
+		fmt.Fprintln(bw, "META_STOP\nDATA_START")
+		for _, obs := range seg.Observations {
+			fmt.Fprintf(bw, "%s = %s %s\n", obs.Keyword, ccsdsDate(obs.Epoch), ccsdsFloat(obs.Value))
+		}
+		fmt.Fprintln(bw, "DATA_STOP")
+	}
+	return bw.Flush()
+}
+
+// tdmXML is the XML representation of a TDM.
+type tdmXML struct {
+	XMLName    xml.Name        `xml:"tdm"`
+	ID         string          `xml:"id,attr"`
+	Version    string          `xml:"version,attr"`
+	Created    string          `xml:"header>CREATION_DATE"`
+	Originator string          `xml:"header>ORIGINATOR"`
+	Segments   []tdmXMLSegment `xml:"body>segment"`
+}
+
+type tdmXMLSegment struct {
+	Metadata     xmlElements   `xml:"metadata"`
+	Observations []xmlElements `xml:"data>observation"`
+}
+
+// xmlElements stores all the child elements, e.g. the keywords of the metadata or of an observation.
+type xmlElements struct {
+	Elements []xmlElement `xml:",any"`
+}
+
+type xmlElement struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// WriteXML writes the TDM in the XML format.
+func (t TDM) WriteXML(w io.Writer) error {
+	x := tdmXML{ID: "CCSDS_TDM_VERS", Version: "1.0", Created: ccsdsDate(t.Created), Originator: t.Originator}
+	x.Segments = make([]tdmXMLSegment, len(t.Segments))
+	for i, seg := range t.Segments {
+		for _, kv := range seg.metadata() {
+			x.Segments[i].Metadata.Elements = append(x.Segments[i].Metadata.Elements, xmlElement{xml.Name{Local: kv[0]}, kv[1]})
+		}
+		x.Segments[i].Observations = make([]xmlElements, len(seg.Observations))
+		for j, obs := range seg.Observations {
+			x.Segments[i].Observations[j].Elements = []xmlElement{{xml.Name{Local: "EPOCH"}, ccsdsDate(obs.Epoch)}, {xml.Name{Local: obs.Keyword}, ccsdsFloat(obs.Value)}}
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(x); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ParseTDM returns the TDM from its KVN or XML representation.
+// The epochs are converted to UTC from the time system of each segment.
+func ParseTDM(r io.Reader) (*TDM, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return parseTDMXML(data)
+	}
+	t := &TDM{}
+	var seg *TDMSegment
+	inMeta, inData := false, false
+	err = readKVN(bytes.NewReader(data), "TDM", func(key, value string, fields []string) (err error) {
+		switch {
+		case key == "META_START":
+			t.Segments = append(t.Segments, TDMSegment{})
+			seg = &t.Segments[len(t.Segments)-1]
+			inMeta = true
+		case key == "META_STOP":
+			inMeta = false
+		case key == "DATA_START":
+			if seg == nil {
+				return errors.New("DATA_START before META_START")
+			}
+			inData = true
+		case key == "DATA_STOP":
+			inData = false
+		case inMeta:
+			err = seg.set(key, value)
+		case inData:
+			var obs TDMObservation
+			if obs, err = parseTDMObservation(key, strings.Fields(value)); err == nil {
+				seg.Observations = append(seg.Observations, obs)
+			}
+		case key == "ORIGINATOR":
+			t.Originator = value
+		case key == "CREATION_DATE":
+			t.Created, err = parseCCSDSDate(value)
+		default:
+			err = fmt.Errorf("unexpected keyword `%s`", key)
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range t.Segments {
+		if err := t.Segments[i].toUTC(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func parseTDMXML(data []byte) (*TDM, error) {
+	x := tdmXML{}
+	if err := xml.Unmarshal(data, &x); err != nil {
+		return nil, err
+	}
+	if x.ID != "CCSDS_TDM_VERS" {
+		return nil, fmt.Errorf("not a CCSDS TDM (id `%s`)", x.ID)
+	}
+	t := &TDM{Originator: strings.TrimSpace(x.Originator), Segments: make([]TDMSegment, len(x.Segments))}
+	var err error
+	if t.Created, err = parseCCSDSDate(strings.TrimSpace(x.Created)); err != nil {
+		return nil, err
+	}
+	for i, xseg := range x.Segments {
+		seg := &t.Segments[i]
+		for _, elt := range xseg.Metadata.Elements {
+			if err := seg.set(elt.XMLName.Local, strings.TrimSpace(elt.Value)); err != nil {
+				return nil, err
+			}
+		}
+		for _, xobs := range xseg.Observations {
+			var epoch, keyword, value string
+			for _, elt := range xobs.Elements {
+				switch elt.XMLName.Local {
+				case "EPOCH":
+					epoch = strings.TrimSpace(elt.Value)
+				case "COMMENT":
+				default:
+					keyword = elt.XMLName.Local
+					value = strings.TrimSpace(elt.Value)
+				}
+			}
+			obs, err := parseTDMObservation(keyword, []string{epoch, value})
+			if err != nil {
+				return nil, err
+			}
+			seg.Observations = append(seg.Observations, obs)
+		}
+		if err := seg.toUTC(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func parseTDMObservation(keyword string, fields []string) (obs TDMObservation, err error) {
+	if keyword == "" || len(fields) != 2 {
+		return obs, fmt.Errorf("invalid %s observation `%s`", keyword, strings.Join(fields, " "))
+	}
+	obs.Keyword = keyword
+	if obs.Epoch, err = parseCCSDSDate(fields[0]); err != nil {
+		return
+	}
+	obs.Value, err = parseCCSDSFloat(fields[1])
+	return
+}
+
+// LoadTDM returns the TDM stored in the provided file (KVN or XML).
+func LoadTDM(filename string) (*TDM, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTDM(f)
+}
@@ -0,0 +1,108 @@
+package smd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTDM(t *testing.T) {
+	kvn := `CCSDS_TDM_VERS = 1.0
+COMMENT Example adapted from CCSDS 503.0-B-1
+CREATION_DATE = 2005-160T20:15:00
+ORIGINATOR = NASA/JPL
+META_START
+TIME_SYSTEM = UTC
+PARTICIPANT_1 = DSS-25
+PARTICIPANT_2 = yyyy-nnnA
+MODE = SEQUENTIAL
+PATH = 1,2,1
+RANGE_UNITS = km
+META_STOP
+DATA_START
+RANGE = 2005-159T17:41:00 7.4798882e+04
+DOPPLER_INSTANTANEOUS = 2005-159T17:41:00 -1.4e-2
+RANGE = 2005-159T17:41:01 7.4798868e+04
+DATA_STOP
+`
+	tdm, err := ParseTDM(strings.NewReader(kvn))
+	if err != nil {
+		t.Fatalf("could not parse TDM: %s", err)
+	}
+	if len(tdm.Segments) != 1 || tdm.Originator != "NASA/JPL" {
+		t.Fatalf("invalid TDM: %+v", tdm)
+	}
+	seg := tdm.Segments[0]
+	if seg.Station() != "DSS-25" || seg.Path != "1,2,1" || len(seg.Observations) != 3 {
+		t.Fatalf("invalid segment: %+v", seg)
+	}
+	exp := TDMObservation{TDMRangeRate, time.Date(2005, 6, 8, 17, 41, 0, 0, time.UTC), -1.4e-2}
+	if obs := seg.Observations[1]; obs.Keyword != exp.Keyword || !obs.Epoch.Equal(exp.Epoch) || obs.Value != exp.Value {
+		t.Fatalf("invalid observation: %+v", obs)
+	}
+	// Round trips
+	for _, asXML := range []bool{false, true} {
+		var buf bytes.Buffer
+		if asXML {
+			err = tdm.WriteXML(&buf)
+		} else {
+			err = tdm.WriteKVN(&buf)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		tdmIn, err := ParseTDM(&buf)
+		if err != nil {
+			t.Fatalf("could not parse written TDM (XML=%v): %s\n%s", asXML, err, buf.String())
+		}
+		segIn := tdmIn.Segments[0]
+		if len(tdmIn.Segments) != 1 || segIn.Station() != "DSS-25" || segIn.Participants[1] != "yyyy-nnnA" || segIn.RangeUnits != "km" || len(segIn.Observations) != 3 {
+			t.Fatalf("invalid TDM (XML=%v): %+v", asXML, tdmIn)
+		}
+		for i, obs := range segIn.Observations {
+			if obs.Keyword != seg.Observations[i].Keyword || !obs.Epoch.Equal(seg.Observations[i].Epoch) || obs.Value != seg.Observations[i].Value {
+				t.Fatalf("invalid observation #%d (XML=%v): %+v", i, asXML, obs)
+			}
+		}
+	}
+	// Unsupported units
+	if _, err := ParseTDM(strings.NewReader(strings.Replace(kvn, "RANGE_UNITS = km", "RANGE_UNITS = s", 1))); err == nil {
+		t.Fatal("range units accepted")
+	}
+}
+
+func TestTDMSegmentAddMeasurement(t *testing.T) {
+	dt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	seg := NewTDMSegment(DSS34Canberra.Name, "sc")
+	seg.AddMeasurement(Measurement{Visible: true, Range: 1000, RangeRate: -1, State: State{DT: dt}, Station: DSS34Canberra})
+	if seg.Station() != DSS34Canberra.Name || len(seg.Observations) != 2 || seg.Observations[0].Value != 1000 || seg.Observations[1].Keyword != TDMRangeRate || !seg.Observations[1].Epoch.Equal(dt) {
+		t.Fatalf("invalid segment: %+v", seg)
+	}
+}
+
+func TestTDMTimeSystem(t *testing.T) {
+	kvn := `CCSDS_TDM_VERS = 1.0
+CREATION_DATE = 2017-01-01T00:00:00
+ORIGINATOR = SMD
+META_START
+TIME_SYSTEM = TAI
+PARTICIPANT_1 = DSS-34
+PARTICIPANT_2 = sc
+META_STOP
+DATA_START
+RANGE = 2017-01-01T00:01:37 1000
+DATA_STOP
+`
+	tdm, err := ParseTDM(strings.NewReader(kvn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg := tdm.Segments[0]
+	if exp := time.Date(2017, 1, 1, 0, 1, 0, 0, time.UTC); seg.TimeSystem != "UTC" || !seg.Observations[0].Epoch.Equal(exp) {
+		t.Fatalf("invalid conversion to UTC: %+v", seg)
+	}
+	if _, err := ParseTDM(strings.NewReader(strings.Replace(kvn, "= TAI", "= TCB", 1))); err == nil {
+		t.Fatal("TCB accepted")
+	}
+}
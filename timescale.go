@@ -0,0 +1,281 @@
+package smd
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+/* Time scales (UTC, TAI, TT, TDB and GPS) and Julian dates. Algorithms from Vallado, 4th edition, section 3.5. */
+
+// TimeScale is a time scale in which an epoch can be expressed.
+type TimeScale uint8
+
+const (
+	// UTC is the Coordinated Universal Time, i.e. TAI minus the leap seconds.
+	UTC TimeScale = iota
+	// TAI is the International Atomic Time.
+	TAI
+	// TT is the Terrestrial Time (TAI + 32.184 s), used for the JDE.
+	TT
+	// TDB is the Barycentric Dynamical Time, used for the planetary ephemerides.
+	TDB
+	// GPS is the GPS time (TAI - 19 s).
+	GPS
+)
+
+const (
+	ttMinusTAI  = 32.184 // seconds
+	taiMinusGPS = 19     // seconds
+	unixJD      = 2440587.5
+)
+
+func (s TimeScale) String() string {
+	switch s {
+	case UTC:
+		return "UTC"
+	case TAI:
+		return "TAI"
+	case TT:
+		return "TT"
+	case TDB:
+		return "TDB"
+	case GPS:
+		return "GPS"
+	default:
+		panic(fmt.Errorf("unknown time scale %d", s))
+	}
+}
+
+// TimeScaleFromString returns the time scale from its name (e.g. as used by CCSDS), case insensitive.
+func TimeScaleFromString(name string) (TimeScale, error) {
+	switch strings.ToUpper(name) {
+	case "UTC":
+		return UTC, nil
+	case "TAI":
+		return TAI, nil
+	case "TT", "TDT":
+		return TT, nil
+	case "TDB":
+		return TDB, nil
+	case "GPS":
+		return GPS, nil
+	default:
+		return UTC, fmt.Errorf("unsupported time scale `%s`", name)
+	}
+}
+
+// leapSeconds stores TAI-UTC from the provided date onward (IERS Bulletin C).
+var leapSeconds = []struct {
+	dt  time.Time
+	ΔAT float64
+}{
+	{time.Date(1972, 1, 1, 0, 0, 0, 0, time.UTC), 10}, {time.Date(1972, 7, 1, 0, 0, 0, 0, time.UTC), 11},
+	{time.Date(1973, 1, 1, 0, 0, 0, 0, time.UTC), 12}, {time.Date(1974, 1, 1, 0, 0, 0, 0, time.UTC), 13},
+	{time.Date(1975, 1, 1, 0, 0, 0, 0, time.UTC), 14}, {time.Date(1976, 1, 1, 0, 0, 0, 0, time.UTC), 15},
+	{time.Date(1977, 1, 1, 0, 0, 0, 0, time.UTC), 16}, {time.Date(1978, 1, 1, 0, 0, 0, 0, time.UTC), 17},
+	{time.Date(1979, 1, 1, 0, 0, 0, 0, time.UTC), 18}, {time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC), 19},
+	{time.Date(1981, 7, 1, 0, 0, 0, 0, time.UTC), 20}, {time.Date(1982, 7, 1, 0, 0, 0, 0, time.UTC), 21},
+	{time.Date(1983, 7, 1, 0, 0, 0, 0, time.UTC), 22}, {time.Date(1985, 7, 1, 0, 0, 0, 0, time.UTC), 23},
+	{time.Date(1988, 1, 1, 0, 0, 0, 0, time.UTC), 24}, {time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC), 25},
+	{time.Date(1991, 1, 1, 0, 0, 0, 0, time.UTC), 26}, {time.Date(1992, 7, 1, 0, 0, 0, 0, time.UTC), 27},
+	{time.Date(1993, 7, 1, 0, 0, 0, 0, time.UTC), 28}, {time.Date(1994, 7, 1, 0, 0, 0, 0, time.UTC), 29},
+	{time.Date(1996, 1, 1, 0, 0, 0, 0, time.UTC), 30}, {time.Date(1997, 7, 1, 0, 0, 0, 0, time.UTC), 31},
+	{time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC), 32}, {time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC), 33},
+	{time.Date(2009, 1, 1, 0, 0, 0, 0, time.UTC), 34}, {time.Date(2012, 7, 1, 0, 0, 0, 0, time.UTC), 35},
+	{time.Date(2015, 7, 1, 0, 0, 0, 0, time.UTC), 36}, {time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), 37},
+}
+
+// LeapSeconds returns TAI-UTC in seconds at the provided UTC time.
+// WARNING: The 1972 value is returned before 1972 (UTC was not an integer offset of TAI back then).
+func LeapSeconds(dt time.Time) float64 {
+	for i := len(leapSeconds) - 1; i > 0; i-- {
+		if !dt.Before(leapSeconds[i].dt) {
+			return leapSeconds[i].ΔAT
+		}
+	}
+	return leapSeconds[0].ΔAT
+}
+
+// tdbMinusTT returns TDB-TT in seconds at the provided TT time, using the usual two term approximation.
+func tdbMinusTT(tt time.Time) float64 {
+	g := (357.53 + 0.98560028*tt.Sub(j2000).Hours()/24) * deg2rad
+	return 0.001657*math.Sin(g) + 0.000014*math.Sin(2*g)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Floor(s*1e9 + 0.5))
+}
+
+// Epoch is an instant which can be expressed in any of the supported time scales.
+type Epoch struct {
+	utc time.Time
+}
+
+// NewEpoch returns the epoch whose clock reading in the provided time scale is that of dt (its location is ignored).
+func NewEpoch(dt time.Time, scale TimeScale) Epoch {
+	dt = dt.UTC()
+	var tai time.Time
+	switch scale {
+	case UTC:
+		return Epoch{dt}
+	case TAI:
+		tai = dt
+	case TT:
+		tai = dt.Add(-seconds(ttMinusTAI))
+	case TDB:
+		tai = dt.Add(-seconds(ttMinusTAI + tdbMinusTT(dt)))
+	case GPS:
+		tai = dt.Add(seconds(taiMinusGPS))
+	default:
+		panic(fmt.Errorf("unknown time scale %d", scale))
+	}
+	// The leap seconds are defined in UTC, so let's iterate once in case TAI is close to a leap second.
+	utc := tai.Add(-seconds(LeapSeconds(tai)))
+	return Epoch{tai.Add(-seconds(LeapSeconds(utc)))}
+}
+
+// EpochFromJD returns the epoch from its Julian date in the provided time scale.
+func EpochFromJD(jd float64, scale TimeScale) Epoch {
+	return NewEpoch(jdToTime(jd), scale)
+}
+
+// UTC returns this epoch as a UTC time.
+func (e Epoch) UTC() time.Time {
+	return e.utc
+}
+
+// In returns the clock reading of this epoch in the provided time scale. Note that the location is always time.UTC.
+func (e Epoch) In(scale TimeScale) time.Time {
+	if scale == UTC {
+		return e.utc
+	}
+	tai := e.utc.Add(seconds(LeapSeconds(e.utc)))
+	switch scale {
+	case TAI:
+		return tai
+	case TT:
+		return tai.Add(seconds(ttMinusTAI))
+	case TDB:
+		tt := tai.Add(seconds(ttMinusTAI))
+		return tt.Add(seconds(tdbMinusTT(tt)))
+	case GPS:
+		return tai.Add(-seconds(taiMinusGPS))
+	default:
+		panic(fmt.Errorf("unknown time scale %d", scale))
+	}
+}
+
+// JD returns the Julian date of this epoch in the provided time scale.
+func (e Epoch) JD(scale TimeScale) float64 {
+	return timeToJD(e.In(scale))
+}
+
+// MJD returns the modified Julian date of this epoch in the provided time scale.
+func (e Epoch) MJD(scale TimeScale) float64 {
+	return e.JD(scale) - mjdOffset
+}
+
+// JDE returns the Julian ephemeris date, i.e. the Julian date in TT.
+func (e Epoch) JDE() float64 {
+	return e.JD(TT)
+}
+
+// Add returns the epoch after the provided duration of elapsed time (i.e. accounting for leap seconds).
+func (e Epoch) Add(d time.Duration) Epoch {
+	return NewEpoch(e.In(TAI).Add(d), TAI)
+}
+
+// Sub returns the elapsed time between both epochs (i.e. accounting for leap seconds).
+func (e Epoch) Sub(o Epoch) time.Duration {
+	return e.In(TAI).Sub(o.In(TAI))
+}
+
+// Equal returns whether both epochs are the same instant.
+func (e Epoch) Equal(o Epoch) bool {
+	return e.utc.Equal(o.utc)
+}
+
+func (e Epoch) String() string {
+	return e.utc.Format("2006-01-02T15:04:05.000 UTC")
+}
+
+// ParseEpoch parses a date time optionally followed by its time scale (UTC by default), e.g. "2017-01-01 00:00:00 TDB",
+// or a Julian date prefixed by "JD" and optionally followed by its time scale, e.g. "JD 2457754.5 TT".
+func ParseEpoch(value string) (Epoch, error) {
+	fields := strings.Fields(value)
+	scale := UTC
+	if len(fields) > 1 {
+		if s, err := TimeScaleFromString(fields[len(fields)-1]); err == nil {
+			scale = s
+			fields = fields[:len(fields)-1]
+		}
+	}
+	if len(fields) == 0 {
+		return Epoch{}, fmt.Errorf("invalid epoch `%s`", value)
+	}
+	if strings.ToUpper(fields[0]) == "JD" && len(fields) == 2 {
+		jd, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return Epoch{}, fmt.Errorf("invalid Julian date `%s`", fields[1])
+		}
+		return EpochFromJD(jd, scale), nil
+	}
+	dtStr := strings.Join(fields, " ")
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999", "2006-01-02 15:04", "2006-01-02"} {
+		if dt, err := time.Parse(layout, dtStr); err == nil {
+			return NewEpoch(dt, scale), nil
+		}
+	}
+	return Epoch{}, fmt.Errorf("invalid epoch `%s`", value)
+}
+
+// EpochFromConfig reads the epoch of the provided key either as a JDE (i.e. a Julian date in TT), or as a date time
+// optionally followed by its time scale (e.g. "2015-02-03 00:00:00 TDB", UTC by default, cf. ParseEpoch), and returns
+// it in UTC.
+func EpochFromConfig(v *viper.Viper, key string) (dt time.Time, err error) {
+	switch value := v.Get(key).(type) {
+	case float64:
+		dt = EpochFromJD(value, TT).UTC()
+	case int64:
+		dt = EpochFromJD(float64(value), TT).UTC()
+	case time.Time:
+		dt = value.UTC()
+	case string:
+		epoch, err := ParseEpoch(value)
+		if err != nil {
+			return dt, fmt.Errorf("could not parse date time in `%s`: %s", key, err)
+		}
+		dt = epoch.UTC()
+	}
+	if dt == (time.Time{}) {
+		return dt, fmt.Errorf("could not parse date time in `%s`", key)
+	}
+	return dt, nil
+}
+
+// MustEpochFromConfig returns the epoch of the provided key (cf. EpochFromConfig), and exits the program with the
+// error otherwise, as the commands do for an invalid scenario.
+func MustEpochFromConfig(v *viper.Viper, key string) time.Time {
+	dt, err := EpochFromConfig(v, key)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	return dt
+}
+
+// timeToJD returns the Julian date of the provided time (without any time scale conversion).
+func timeToJD(dt time.Time) float64 {
+	return unixJD + (float64(dt.Unix())+float64(dt.Nanosecond())/1e9)/86400
+}
+
+// jdToTime returns the time of the provided Julian date (without any time scale conversion).
+func jdToTime(jd float64) time.Time {
+	days, frac := math.Modf(jd - unixJD)
+	return time.Unix(int64(days)*86400, 0).Add(seconds(frac * 86400)).UTC()
+}
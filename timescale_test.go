@@ -0,0 +1,106 @@
+package smd
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+	"github.com/spf13/viper"
+)
+
+func TestEpochTimeScales(t *testing.T) {
+	// Example 3-7 from Vallado, 4th edition.
+	utc := time.Date(2004, 5, 14, 16, 43, 0, 0, time.UTC)
+	e := NewEpoch(utc, UTC)
+	for _, exp := range []struct {
+		scale TimeScale
+		dt    time.Time
+	}{
+		{UTC, utc},
+		{TAI, time.Date(2004, 5, 14, 16, 43, 32, 0, time.UTC)},
+		{TT, time.Date(2004, 5, 14, 16, 44, 4, 184000000, time.UTC)},
+		{GPS, time.Date(2004, 5, 14, 16, 43, 13, 0, time.UTC)},
+	} {
+		if got := e.In(exp.scale); !got.Equal(exp.dt) {
+			t.Fatalf("%s: %s != %s", exp.scale, got, exp.dt)
+		}
+		if back := NewEpoch(exp.dt, exp.scale); !back.Equal(e) {
+			t.Fatalf("%s: %s != %s", exp.scale, back, e)
+		}
+	}
+	if Δ := e.In(TDB).Sub(e.In(TT)).Seconds(); !floats.EqualWithinAbs(Δ, 0.0013, 1e-4) {
+		t.Fatalf("TDB-TT = %f s", Δ)
+	}
+	if back := NewEpoch(e.In(TDB), TDB); back.Sub(e).Seconds() > 1e-6 {
+		t.Fatalf("TDB: %s != %s", back, e)
+	}
+	if jde := e.JDE(); !floats.EqualWithinAbs(jde, 2453140.197270, 1e-6) {
+		t.Fatalf("JDE = %f", jde)
+	}
+	if back := EpochFromJD(e.JD(TT), TT); back.Sub(e) > 100*time.Microsecond || e.Sub(back) > 100*time.Microsecond {
+		t.Fatalf("JD round trip: %s != %s", back, e)
+	}
+	if mjd := e.MJD(UTC); !floats.EqualWithinAbs(mjd, 53139.696527778, 1e-8) {
+		t.Fatalf("MJD = %f", mjd)
+	}
+	// Elapsed time over the 2016 leap second.
+	before := NewEpoch(time.Date(2016, 12, 31, 23, 59, 59, 0, time.UTC), UTC)
+	after := NewEpoch(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), UTC)
+	if Δ := after.Sub(before); Δ != 2*time.Second {
+		t.Fatalf("expected two seconds over the leap second, got %s", Δ)
+	}
+	if !before.Add(2 * time.Second).Equal(after) {
+		t.Fatalf("%s + 2s != %s", before, after)
+	}
+	if LeapSeconds(before.UTC()) != 36 || LeapSeconds(after.UTC()) != 37 {
+		t.Fatal("invalid leap seconds")
+	}
+}
+
+func TestParseEpoch(t *testing.T) {
+	exp := NewEpoch(time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC), TDB)
+	for _, value := range []string{"2017-01-01 12:00:00 TDB", "2017-01-01T12:00:00 tdb", "JD 2457755.0 TDB"} {
+		e, err := ParseEpoch(value)
+		if err != nil {
+			t.Fatalf("%s: %s", value, err)
+		}
+		if !e.Equal(exp) {
+			t.Fatalf("%s: %s != %s", value, e, exp)
+		}
+	}
+	if e, err := ParseEpoch("2017-01-01 12:00:00"); err != nil || !e.UTC().Equal(time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("UTC should be the default: %s (%v)", e, err)
+	}
+	for _, value := range []string{"", "JD abc", "2017-13-01 TT", "yesterday"} {
+		if _, err := ParseEpoch(value); err == nil {
+			t.Fatalf("`%s` accepted", value)
+		}
+	}
+	if _, err := TimeScaleFromString("TCB"); err == nil {
+		t.Fatal("TCB accepted")
+	}
+}
+
+func TestEpochFromConfig(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(strings.NewReader(`[mission]
+start = "2015-02-03 00:01:07.184 TT"
+end = 2457056.5
+typo = "2015-02-31 00:00:00"`)); err != nil {
+		t.Fatal(err)
+	}
+	if dt, err := EpochFromConfig(v, "mission.start"); err != nil || math.Abs(dt.Sub(testStart).Seconds()) > 1e-6 {
+		t.Fatalf("start %s instead of %s (%v)", dt, testStart, err)
+	}
+	if dt, err := EpochFromConfig(v, "mission.end"); err != nil || math.Abs(dt.Sub(testStart).Seconds()+67.184) > 1e-3 {
+		t.Fatalf("end %s (%v)", dt, err)
+	}
+	for _, key := range []string{"mission.typo", "mission.unset"} {
+		if _, err := EpochFromConfig(v, key); err == nil {
+			t.Fatalf("invalid `%s` accepted", key)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package smd
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// TLE is a NORAD two-line element set.
+type TLE struct {
+	Name           string
+	SatNum         int
+	Classification byte
+	IntlDesignator string
+	Epoch          time.Time // UTC
+	NDot           float64   // first derivative of the mean motion divided by two, in rev/day^2
+	NDDot          float64   // second derivative of the mean motion divided by six, in rev/day^3
+	BStar          float64   // drag term, in inverse Earth radii
+	ElementSet     int
+	Inclination    float64 // deg
+	RAAN           float64 // deg
+	Eccentricity   float64
+	ArgPerigee     float64 // deg
+	MeanAnomaly    float64 // deg
+	MeanMotion     float64 // rev/day
+	RevNum         int
+	sgp4           *sgp4Record
+}
+
+// String implements the Stringer interface.
+func (t TLE) String() string {
+	return fmt.Sprintf("TLE %05d (%s) @ %s: i=%.4f Ω=%.4f e=%.7f ω=%.4f M=%.4f n=%.8f rev/day", t.SatNum, t.Name, t.Epoch.Format(time.RFC3339), t.Inclination, t.RAAN, t.Eccentricity, t.ArgPerigee, t.MeanAnomaly, t.MeanMotion)
+}
+
+// TEME returns the position (km) and velocity (km/s) in the True Equator Mean Equinox frame at the provided
+// time using SGP4 (or SDP4 for periods over 225 minutes).
+// WARNING: A given TLE must not be propagated concurrently because SDP4 caches the resonance integration.
+func (t TLE) TEME(dt time.Time) (R, V []float64, err error) {
+	return t.sgp4.propagate(dt.Sub(t.Epoch).Minutes())
+}
+
+// Orbit returns the SGP4 orbit about the Earth at the provided time, converted from the TEME to the GCRF.
+func (t TLE) Orbit(dt time.Time) (*Orbit, error) {
+	R, V, err := t.TEME(dt)
+	if err != nil {
+		return nil, err
+	}
+	R, V = TEME2GCRF(R, V, dt)
+	return NewOrbitFromRV(R, V, Earth), nil
+}
+
+// ParseTLE returns the two-line element set from the provided lines, after validating their checksums.
+func ParseTLE(line1, line2 string) (t TLE, err error) {
+	line1 = strings.TrimRight(line1, " \r\n")
+	line2 = strings.TrimRight(line2, " \r\n")
+	if len(line1) < 69 || line1[0] != '1' {
+		return t, fmt.Errorf("invalid TLE line 1 `%s`", line1)
+	}
+	if len(line2) < 69 || line2[0] != '2' {
+		return t, fmt.Errorf("invalid TLE line 2 `%s`", line2)
+	}
+	for _, line := range []string{line1, line2} {
+		if err = tleChecksum(line); err != nil {
+			return
+		}
+	}
+	p := tleParser{}
+	t.SatNum = p.int(line1[2:7], "satellite number")
+	t.Classification = line1[7]
+	t.IntlDesignator = strings.TrimSpace(line1[9:17])
+	year := p.int(line1[18:20], "epoch year")
+	if year < 57 {
+		year += 2000
+	} else {
+		year += 1900
+	}
+	epochDays := p.float(line1[20:32], "epoch day")
+	t.NDot = p.float(line1[33:43], "first derivative of mean motion")
+	t.NDDot = p.exponent(line1[44:52], "second derivative of mean motion")
+	t.BStar = p.exponent(line1[53:61], "BSTAR")
+	t.ElementSet = p.int(line1[64:68], "element set number")
+	if satNum := p.int(line2[2:7], "satellite number"); satNum != t.SatNum && p.err == nil {
+		return t, fmt.Errorf("TLE satellite numbers differ: %d != %d", t.SatNum, satNum)
+	}
+	t.Inclination = p.float(line2[8:16], "inclination")
+	t.RAAN = p.float(line2[17:25], "RAAN")
+	t.Eccentricity = p.float("0."+strings.TrimSpace(line2[26:33]), "eccentricity")
+	t.ArgPerigee = p.float(line2[34:42], "argument of perigee")
+	t.MeanAnomaly = p.float(line2[43:51], "mean anomaly")
+	t.MeanMotion = p.float(line2[52:63], "mean motion")
+	t.RevNum = p.int(line2[63:68], "revolution number")
+	if p.err != nil {
+		return t, p.err
+	}
+	// Day 1.0 is January 1st at midnight.
+	yearStart := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	t.Epoch = yearStart.Add(time.Duration((epochDays - 1) * 24 * float64(time.Hour))).Round(time.Microsecond)
+	err = t.initSGP4(timeToJD(yearStart) - 1 + epochDays)
+	return
+}
+
+// initSGP4 initializes the SGP4 propagator of this TLE given the Julian date of its epoch.
+func (t *TLE) initSGP4(jdEpoch float64) (err error) {
+	t.sgp4, err = newSGP4(jdEpoch, t.BStar, t.Eccentricity, t.ArgPerigee*deg2rad, t.Inclination*deg2rad, t.MeanAnomaly*deg2rad, t.MeanMotion/xpdotp, t.RAAN*deg2rad)
+	if err != nil {
+		err = fmt.Errorf("TLE %05d: %s", t.SatNum, err)
+	}
+	return
+}
+
+// LoadTLEs returns all the two-line element sets of the provided file.
+// The lines may be preceded by the name of the object (i.e. three-line element sets).
+func LoadTLEs(filename string) ([]TLE, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), " \r"); len(strings.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ParseTLEs(lines)
+}
+
+// ParseTLEs returns all the two-line element sets of the provided lines, which may include the names of the objects.
+func ParseTLEs(lines []string) ([]TLE, error) {
+	var tles []TLE
+	name := ""
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "1 ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "2 ") {
+			tle, err := ParseTLE(line, lines[i+1])
+			if err != nil {
+				return nil, err
+			}
+			tle.Name = name
+			tles = append(tles, tle)
+			name = ""
+			i++
+			continue
+		}
+		if name != "" {
+			return nil, fmt.Errorf("unexpected TLE line `%s`", line)
+		}
+		name = strings.TrimSpace(strings.TrimPrefix(line, "0 "))
+	}
+	if name != "" {
+		return nil, fmt.Errorf("no element set for `%s`", name)
+	}
+	return tles, nil
+}
+
+// TLEFromConfig returns the two-line element set of the orbit of the provided configuration, or nil if none is set.
+// The TLE is either read from the `orbit.tle` file (the first one, or the one of satellite `orbit.satnum`), or from
+// the `orbit.tle1` and `orbit.tle2` lines.
+func TLEFromConfig(v *viper.Viper) (*TLE, error) {
+	if filename := v.GetString("orbit.tle"); len(filename) > 0 {
+		tles, err := LoadTLEs(filename)
+		if err != nil {
+			return nil, fmt.Errorf("could not load TLE file `%s`: %s", filename, err)
+		}
+		satNum := v.GetInt("orbit.satnum")
+		for _, tle := range tles {
+			if satNum == 0 || tle.SatNum == satNum {
+				return &tle, nil
+			}
+		}
+		return nil, fmt.Errorf("no TLE for satellite %d in `%s`", satNum, filename)
+	}
+	if line1 := v.GetString("orbit.tle1"); len(line1) > 0 {
+		tle, err := ParseTLE(line1, v.GetString("orbit.tle2"))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse TLE: %s", err)
+		}
+		return &tle, nil
+	}
+	return nil, nil
+}
+
+// tleChecksum returns an error if the modulo 10 checksum of the line is invalid.
+func tleChecksum(line string) error {
+	sum := 0
+	for _, c := range line[:68] {
+		if c >= '0' && c <= '9' {
+			sum += int(c - '0')
+		} else if c == '-' {
+			sum++
+		}
+	}
+	if expected := int(line[68] - '0'); sum%10 != expected {
+		return fmt.Errorf("invalid checksum for TLE line `%s`: expected %d got %d", line, expected, sum%10)
+	}
+	return nil
+}
+
+// tleParser parses the fields of a TLE and stores the first error.
+type tleParser struct {
+	err error
+}
+
+func (p *tleParser) float(s, field string) float64 {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "-.") || strings.HasPrefix(s, "+.") {
+		s = s[:1] + "0" + s[1:]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("invalid TLE %s `%s`", field, s)
+	}
+	return v
+}
+
+func (p *tleParser) int(s, field string) int {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("invalid TLE %s `%s`", field, s)
+	}
+	return v
+}
+
+// exponent parses the TLE fields with an implied leading decimal point and an exponent, e.g. " 12345-3" is 0.12345e-3.
+func (p *tleParser) exponent(s, field string) float64 {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	sign := 1.
+	if s[0] == '-' || s[0] == '+' {
+		if s[0] == '-' {
+			sign = -1
+		}
+		s = s[1:]
+	}
+	expIdx := strings.LastIndexAny(s, "+-")
+	if expIdx <= 0 {
+		if p.err == nil {
+			p.err = fmt.Errorf("invalid TLE %s `%s`", field, s)
+		}
+		return 0
+	}
+	mantissa := p.float("0."+s[:expIdx], field)
+	exp := p.float(s[expIdx:], field)
+	return sign * mantissa * math.Pow(10, exp)
+}
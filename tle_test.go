@@ -0,0 +1,117 @@
+package smd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gonum/floats"
+	"github.com/spf13/viper"
+)
+
+func TestParseTLE(t *testing.T) {
+	tle, err := ParseTLE("1 25544U 98067A   08264.51782528 -.00002182  00000-0 -11606-4 0  2927", "2 25544  51.6416 247.4627 0006703 130.5360 325.0288 15.72125391563537")
+	if err != nil {
+		t.Fatalf("could not parse TLE: %s", err)
+	}
+	if tle.SatNum != 25544 || tle.IntlDesignator != "98067A" || tle.Classification != 'U' || tle.ElementSet != 292 || tle.RevNum != 56353 {
+		t.Fatalf("invalid identifiers: %s", tle)
+	}
+	exp := []float64{-0.00002182, 0, -0.11606e-4, 51.6416, 247.4627, 0.0006703, 130.5360, 325.0288, 15.72125391}
+	got := []float64{tle.NDot, tle.NDDot, tle.BStar, tle.Inclination, tle.RAAN, tle.Eccentricity, tle.ArgPerigee, tle.MeanAnomaly, tle.MeanMotion}
+	if !floats.EqualApprox(exp, got, 1e-12) {
+		t.Fatalf("invalid elements: %+v instead of %+v", got, exp)
+	}
+	expEpoch := time.Date(2008, 9, 20, 12, 25, 40, 104192000, time.UTC)
+	if diff := tle.Epoch.Sub(expEpoch); diff > time.Microsecond || diff < -time.Microsecond {
+		t.Fatalf("invalid epoch: %s instead of %s", tle.Epoch, expEpoch)
+	}
+	// Invalid checksum
+	if _, err := ParseTLE("1 25544U 98067A   08264.51782528 -.00002182  00000-0 -11606-4 0  2928", "2 25544  51.6416 247.4627 0006703 130.5360 325.0288 15.72125391563537"); err == nil {
+		t.Fatal("invalid checksum accepted")
+	}
+	// Different satellites
+	if _, err := ParseTLE("1 25544U 98067A   08264.51782528 -.00002182  00000-0 -11606-4 0  2927", "2 00005  34.2682 348.7242 1859667 331.7664  19.3264 10.82419157413667"); err == nil {
+		t.Fatal("TLE of different satellites accepted")
+	}
+	tles, err := ParseTLEs([]string{"ISS (ZARYA)", "1 25544U 98067A   08264.51782528 -.00002182  00000-0 -11606-4 0  2927", "2 25544  51.6416 247.4627 0006703 130.5360 325.0288 15.72125391563537", "1 00005U 58002B   00179.78495062  .00000023  00000-0  28098-4 0  4753", "2 00005  34.2682 348.7242 1859667 331.7664  19.3264 10.82419157413667"})
+	if err != nil {
+		t.Fatalf("could not parse TLEs: %s", err)
+	}
+	if len(tles) != 2 || tles[0].Name != "ISS (ZARYA)" || tles[1].Name != "" || tles[1].SatNum != 5 {
+		t.Fatalf("invalid TLEs: %+v", tles)
+	}
+	// TLE from the configuration
+	v := viper.New()
+	if tle, err := TLEFromConfig(v); tle != nil || err != nil {
+		t.Fatalf("TLE %v (%v) without any TLE key", tle, err)
+	}
+	v.Set("orbit.tle1", "1 25544U 98067A   08264.51782528 -.00002182  00000-0 -11606-4 0  2927")
+	v.Set("orbit.tle2", "2 25544  51.6416 247.4627 0006703 130.5360 325.0288 15.72125391563537")
+	if tle, err := TLEFromConfig(v); err != nil || tle.SatNum != 25544 {
+		t.Fatalf("invalid TLE %v from the configuration (%v)", tle, err)
+	}
+	v.Set("orbit.tle", "does-not-exist.tle")
+	if _, err := TLEFromConfig(v); err == nil {
+		t.Fatal("missing TLE file accepted")
+	}
+}
+
+func TestSGP4(t *testing.T) {
+	// Verification vectors from Vallado et al., "Revisiting Spacetrack Report #3", AIAA 2006-6753.
+	for _, tc := range []struct {
+		line1, line2 string
+		tsince       []float64 // minutes
+		states       [][]float64
+	}{
+		{"1 00005U 58002B   00179.78495062  .00000023  00000-0  28098-4 0  4753",
+			"2 00005  34.2682 348.7242 1859667 331.7664  19.3264 10.82419157413667",
+			[]float64{0, 360},
+			[][]float64{{7022.46529266, -1400.08296755, 0.03995155, 1.893841015, 6.405893759, 4.534807250},
+				{-7154.03120202, -3783.17682504, -3536.19412294, 4.741887409, -4.151817765, -2.093935425}}},
+		{"1 06251U 62025E   06176.82412014  .00008885  00000-0  12808-3 0  3985",
+			"2 06251  58.0579  54.0425 0030035 139.1568 221.1854 15.56387291  6774",
+			[]float64{0, 120},
+			[][]float64{{3988.31022699, 5498.96657235, 0.90055879, -3.290032738, 2.357652820, 6.496623475},
+				{-3935.69800083, 409.10980837, 5471.33577327, -3.374784183, -6.635211043, -1.942056221}}},
+		{"1 04632U 70093B   04031.91070959 -.00000084  00000-0  10000-3 0  9955",
+			"2 04632  11.4628 273.1101 1450506 207.6000 143.9350  1.20231981 44145",
+			[]float64{0, -5184},
+			[][]float64{{2334.11450085, -41920.44035349, -0.03867437, 2.826321032, -0.065091664, 0.570936053},
+				{-29020.02587128, 13819.84419063, -5713.33679183, -1.768068390, -3.235371192, -0.395206135}}},
+		{"1 24208U 96044A   06177.04061740 -.00000094  00000-0  10000-3 0  1600",
+			"2 24208   3.8536  80.0121 0026640 311.0977  48.3000  1.00778054 36119",
+			[]float64{0, 720},
+			[][]float64{{7534.10987189, 41266.39266843, -0.10801028, -3.027168008, 0.558848996, 0.207982755},
+				{-6874.77975542, -41530.38329422, -46.60245459, 3.027415087, -0.494671177, -0.207337260}}},
+		{"1 23599U 95029B   06171.76535463  .00085586  12891-6  12956-2 0  2905",
+			"2 23599   6.9327   0.2849 5782022 274.4436  25.2425  4.47796565123555",
+			[]float64{0, 720},
+			[][]float64{{9892.63794341, 35.76144969, -1.08228838, 3.556643237, 6.456009375, 0.783610890},
+				{7140.41945884, 20539.25485336, 2501.21469368, -2.293173684, 2.333507912, 0.282716311}}},
+	} {
+		tle, err := ParseTLE(tc.line1, tc.line2)
+		if err != nil {
+			t.Fatalf("could not parse TLE: %s", err)
+		}
+		for i, tsince := range tc.tsince {
+			R, V, err := tle.sgp4.propagate(tsince)
+			if err != nil {
+				t.Fatalf("%05d @ %f min: %s", tle.SatNum, tsince, err)
+			}
+			got := append(R, V...)
+			if !floats.EqualApprox(got, tc.states[i], 1e-4) {
+				t.Fatalf("%05d @ %f min:\n%+v\ninstead of\n%+v", tle.SatNum, tsince, got, tc.states[i])
+			}
+		}
+		// Check the time conversion and the orbit.
+		dt := tle.Epoch.Add(time.Duration(tc.tsince[1]) * time.Minute)
+		o, err := tle.Orbit(dt)
+		if err != nil {
+			t.Fatalf("%05d: %s", tle.SatNum, err)
+		}
+		R, V := TEME2GCRF(tc.states[1][0:3], tc.states[1][3:6], dt)
+		if !floats.EqualApprox(append(o.R(), o.V()...), append(R, V...), 1e-3) || !o.Origin.Equals(Earth) {
+			t.Fatalf("%05d: invalid orbit %s", tle.SatNum, o)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package smd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+/* Simulation of the tracking data of a station network along a truth trajectory. The noiseless measurements of each
+station are first gathered over the whole trajectory and grouped in passes (i.e. consecutive states where the station
+sees the spacecraft), so that the passes can be scheduled before being sampled. The noise and biases are then added in
+a deterministic order from the seeded source of the simulator, so that a given seed always generates the same file. */
+
+// TrackingSchedule defines how and when a station tracks the spacecraft.
+type TrackingSchedule struct {
+	Cadence     time.Duration               // Time between two measurements, every state of the trajectory if zero
+	Acquisition time.Duration               // Time skipped at the start of each pass before the first measurement
+	MinPass     time.Duration               // Passes shorter than MinPass are not tracked
+	MaxPass     time.Duration               // Tracking duration of each pass (after the acquisition), unlimited if zero
+	MaxPasses   int                         // Number of tracked passes (the first ones), unlimited if zero
+	Sigma       map[MeasurementType]float64 // σ of the noise of each type, defaults to the noise of the station
+	Bias        map[MeasurementType]float64 // Constant bias of each type
+}
+
+// TrackingScheduleFromConfig returns the schedule of the provided station from the `tracking` section of the provided
+// configuration, overridden by its `tracking.<key>` section, with the keys: cadence, acquisition, min_pass, max_pass
+// (durations, e.g. "60s"), max_passes, and `<type>_sigma` and `<type>_bias` for each measurement type (e.g.
+// range_bias, in the units of that type). Unlike in the station network, the `<type>_sigma` are standard deviations.
+func TrackingScheduleFromConfig(v *viper.Viper, key string) (sched TrackingSchedule, err error) {
+	sched.Sigma = make(map[MeasurementType]float64)
+	sched.Bias = make(map[MeasurementType]float64)
+	for _, prefix := range []string{"tracking.", "tracking." + key + "."} {
+		for _, duration := range []struct {
+			key   string
+			value *time.Duration
+		}{{"cadence", &sched.Cadence}, {"acquisition", &sched.Acquisition}, {"min_pass", &sched.MinPass}, {"max_pass", &sched.MaxPass}} {
+			if v.IsSet(prefix + duration.key) {
+				if *duration.value = v.GetDuration(prefix + duration.key); *duration.value < 0 {
+					return sched, fmt.Errorf("negative `%s%s`", prefix, duration.key)
+				}
+			}
+		}
+		if v.IsSet(prefix + "max_passes") {
+			sched.MaxPasses = v.GetInt(prefix + "max_passes")
+		}
+		for measType := MeasRange; measType <= MeasDSNRange; measType++ {
+			if name := prefix + measType.String() + "_sigma"; v.IsSet(name) {
+				if sched.Sigma[measType] = v.GetFloat64(name); sched.Sigma[measType] < 0 {
+					return sched, fmt.Errorf("negative `%s`", name)
+				}
+			}
+			if name := prefix + measType.String() + "_bias"; v.IsSet(name) {
+				sched.Bias[measType] = v.GetFloat64(name)
+			}
+		}
+	}
+	return
+}
+
+// TrackingSimulator generates the measurements of stations along a truth trajectory. Use NewTrackingSimulator to
+// initialize.
+type TrackingSimulator struct {
+	Stations  []Station
+	Schedules []TrackingSchedule // Schedule of each station
+	rng       *rand.Rand
+}
+
+// NewTrackingSimulator returns a new simulator of the provided stations, whose noise is drawn from the provided seed.
+func NewTrackingSimulator(stations []Station, schedules []TrackingSchedule, seed int64) (*TrackingSimulator, error) {
+	if len(stations) != len(schedules) {
+		return nil, fmt.Errorf("%d schedules for %d stations", len(schedules), len(stations))
+	}
+	return &TrackingSimulator{stations, schedules, NewRand(seed)}, nil
+}
+
+// Simulate returns the measurements of the stations along the states of the provided channel, until it is closed.
+func (t *TrackingSimulator) Simulate(states <-chan State) (*ODMeasurements, error) {
+	// The passes of each station, i.e. their visible noiseless measurements.
+	passes := make([][][]Measurement, len(t.Stations))
+	wasVisible := make([]bool, len(t.Stations))
+	for state := range states {
+		for pos, st := range t.Stations {
+			// The noise is only drawn from the source of the simulator (cf. corrupt).
+			m := st.noiselessMeasurement(state.DT, state)
+			if !m.Visible {
+				wasVisible[pos] = false
+				continue
+			}
+			if !wasVisible[pos] {
+				passes[pos] = append(passes[pos], nil)
+				wasVisible[pos] = true
+			}
+			last := len(passes[pos]) - 1
+			passes[pos][last] = append(passes[pos][last], m)
+		}
+	}
+	meas := NewODMeasurements(t.Stations)
+	for pos, st := range t.Stations {
+		sched := t.Schedules[pos]
+		tracked := 0
+		for _, pass := range passes[pos] {
+			if sched.MaxPasses > 0 && tracked == sched.MaxPasses {
+				break
+			}
+			start, end := pass[0].Epoch, pass[len(pass)-1].Epoch
+			if end.Sub(start) < sched.MinPass {
+				continue
+			}
+			tracked++
+			start = start.Add(sched.Acquisition)
+			if sched.MaxPass > 0 && start.Add(sched.MaxPass).Before(end) {
+				end = start.Add(sched.MaxPass)
+			}
+			var prevDT time.Time
+			for _, m := range pass {
+				if m.Epoch.Before(start) || m.Epoch.After(end) || (!prevDT.IsZero() && m.Epoch.Sub(prevDT) < sched.Cadence) {
+					continue
+				}
+				prevDT = m.Epoch
+				if err := t.corrupt(&m, st, sched); err != nil {
+					return nil, fmt.Errorf("station %s: %s", st.Name, err)
+				}
+				if err := meas.Add(m); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if meas.Count == 0 {
+		return nil, errors.New("no station sees the spacecraft")
+	}
+	meas.Sort()
+	return meas, nil
+}
+
+// corrupt sets the observations of the measurement from its true values, with the noise and bias of the schedule.
+func (t *TrackingSimulator) corrupt(m *Measurement, st Station, sched TrackingSchedule) error {
+	m.Range, m.RangeRate = m.TrueRange, m.TrueRangeRate
+	m.Values = make([]float64, len(m.TrueValues))
+	for i, measType := range m.MeasurementTypes() {
+		σ, set := sched.Sigma[measType]
+		if !set {
+			σ2, err := st.variance(measType)
+			if err != nil {
+				return err
+			}
+			σ = math.Sqrt(σ2)
+		}
+		m.Values[i] = m.TrueValues[i] + sched.Bias[measType] + σ*t.rng.NormFloat64()
+		switch measType {
+		case MeasRange:
+			m.Range = m.Values[i]
+		case MeasRangeRate:
+			m.RangeRate = m.Values[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package smd
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// simulateTracking returns the measurements of the simulator along a day of the two body trajectory.
+func simulateTracking(t *testing.T, stations []Station, schedules []TrackingSchedule, seed int64) *ODMeasurements {
+	simulator, err := NewTrackingSimulator(stations, schedules, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meas *ODMeasurements
+	mission := testMission(NewEmptySC("truth", 0), testGEO(), testStart, Perturbations{}, false)
+	propagateStates(mission, testStart.Add(24*time.Hour), func(states <-chan State) {
+		meas, err = simulator.Simulate(states)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meas
+}
+
+func TestTrackingSimulator(t *testing.T) {
+	stations := []Station{DSS13Goldstone, DSS34Canberra}
+	bias := 0.5
+	schedules := []TrackingSchedule{
+		{Cadence: time.Minute, Acquisition: 10 * time.Minute, MaxPass: time.Hour, MaxPasses: 1, Bias: map[MeasurementType]float64{MeasRange: bias}},
+		{Cadence: 5 * time.Minute, Sigma: map[MeasurementType]float64{MeasRange: 0, MeasRangeRate: 0}},
+	}
+	if _, err := NewTrackingSimulator(stations, schedules[:1], 42); err == nil {
+		t.Fatal("missing schedule accepted")
+	}
+	meas := simulateTracking(t, stations, schedules, 42)
+	again := simulateTracking(t, stations, schedules, 42)
+	other := simulateTracking(t, stations, schedules, 7)
+	unscheduled := simulateTracking(t, stations, make([]TrackingSchedule, 2), 42)
+	if again.Count != meas.Count || meas.Count >= unscheduled.Count {
+		t.Fatalf("%d measurements with the same seed, %d without schedule, instead of %d", again.Count, unscheduled.Count, meas.Count)
+	}
+	sameAsOther := true
+	var first, prevDT [2]time.Time
+	rangeErr := 0.
+	count := 0
+	for _, dt := range meas.Epochs {
+		measurements, _ := meas.At(dt)
+		againMeas, _ := again.At(dt)
+		otherMeas, _ := other.At(dt)
+		for pos, m := range measurements {
+			if m.Station.Name == "" {
+				continue
+			}
+			// The same seed generates the same measurements.
+			if m.Range != againMeas[pos].Range || m.RangeRate != againMeas[pos].RangeRate {
+				t.Fatalf("different measurements with the same seed at %s", dt)
+			}
+			if m.Range != otherMeas[pos].Range {
+				sameAsOther = false
+			}
+			if !prevDT[pos].IsZero() && dt.Sub(prevDT[pos]) < schedules[pos].Cadence {
+				t.Fatalf("%s: measurements at %s and %s within the cadence", m.Station.Name, prevDT[pos], dt)
+			}
+			if first[pos].IsZero() {
+				first[pos] = dt
+			}
+			prevDT[pos] = dt
+			if pos == 0 {
+				rangeErr += m.Range - m.TrueRange
+				count++
+			} else if m.Range != m.TrueRange || m.Values[1] != m.TrueValues[1] {
+				t.Fatalf("%s: noise despite its null σ at %s", m.Station.Name, dt)
+			}
+		}
+	}
+	if sameAsOther {
+		t.Fatal("different seeds generate the same measurements")
+	}
+	// The noise is only drawn from the source of the simulator, and not from that of the stations.
+	src := NewRand(1)
+	withRand := []Station{DSS13Goldstone, DSS34Canberra}
+	for pos := range withRand {
+		withRand[pos].Rand = src
+	}
+	seeded := simulateTracking(t, withRand, schedules, 42)
+	seededMeas, _ := seeded.At(meas.Epochs[0])
+	firstMeas, _ := meas.At(meas.Epochs[0])
+	if seeded.Count != meas.Count || seededMeas[0].Range != firstMeas[0].Range || src.NormFloat64() != NewRand(1).NormFloat64() {
+		t.Fatal("the source of the stations changes the simulated measurements")
+	}
+	// The first station only tracks the first hour of its first pass, after the acquisition.
+	var visible time.Time
+	for _, dt := range unscheduled.Epochs {
+		if measurements, _ := unscheduled.At(dt); measurements[0].Station.Name != "" {
+			visible = dt
+			break
+		}
+	}
+	if first[0].Sub(visible) < schedules[0].Acquisition || prevDT[0].Sub(first[0]) > schedules[0].MaxPass {
+		t.Fatalf("%s tracked from %s to %s (visible from %s)", stations[0].Name, first[0], prevDT[0], visible)
+	}
+	if count == 0 || math.Abs(rangeErr/float64(count)-bias) > 0.1 {
+		t.Fatalf("invalid range bias %f over %d measurements", rangeErr/float64(count), count)
+	}
+
+	// The exported file is read by the orbit determination.
+	for _, ext := range []string{".csv", ".tdm"} {
+		f, err := ioutil.TempFile("", "meas*"+ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		defer os.Remove(f.Name())
+		if err = meas.Export(f.Name(), "truth"); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadODMeasurements(f.Name(), stations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Count != meas.Count || len(loaded.Epochs) != len(meas.Epochs) {
+			t.Fatalf("%s: loaded %d measurements instead of %d", ext, loaded.Count, meas.Count)
+		}
+		loadedMeas, _ := loaded.At(meas.Epochs[0])
+		simulated, _ := meas.At(meas.Epochs[0])
+		for pos := range simulated {
+			if simulated[pos].Station.Name != "" && math.Abs(loadedMeas[pos].StateVector().At(0, 0)-simulated[pos].Range) > 1e-5 {
+				t.Fatalf("%s: invalid range %f instead of %f", ext, loadedMeas[pos].StateVector().At(0, 0), simulated[pos].Range)
+			}
+		}
+	}
+}
+
+func TestTrackingScheduleFromConfig(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(strings.NewReader(`[tracking]
+cadence = "60s"
+acquisition = "5m"
+range_sigma = 1e-3
+range_bias = 0.01
+[tracking.DSS65]
+cadence = "10s"
+max_passes = 2
+rate_bias = 1e-6`)); err != nil {
+		t.Fatal(err)
+	}
+	sched, err := TrackingScheduleFromConfig(v, "DSS34")
+	if err != nil || sched.Cadence != time.Minute || sched.Acquisition != 5*time.Minute || sched.Sigma[MeasRange] != 1e-3 || sched.Bias[MeasRange] != 0.01 {
+		t.Fatalf("invalid schedule %+v (%v)", sched, err)
+	}
+	sched, err = TrackingScheduleFromConfig(v, "DSS65")
+	if err != nil || sched.Cadence != 10*time.Second || sched.MaxPasses != 2 || sched.Bias[MeasRangeRate] != 1e-6 || sched.Bias[MeasRange] != 0.01 {
+		t.Fatalf("invalid overridden schedule %+v (%v)", sched, err)
+	}
+	v.Set("tracking.min_pass", "-1m")
+	if _, err = TrackingScheduleFromConfig(v, "DSS34"); err == nil {
+		t.Fatal("negative duration accepted")
+	}
+}
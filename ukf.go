@@ -0,0 +1,488 @@
+package smd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+/* Unscented Kalman filters, cf. Wan and van der Merwe, "The unscented Kalman filter for nonlinear estimation", 2000,
+and van der Merwe and Wan, "The square-root unscented Kalman filter for state and parameter estimation", 2001. The
+covariance is stored as its Cholesky factorization: the UKF factorizes the updated covariance, whereas the square-root
+UKF directly updates the factor with QR decompositions and rank one updates. */
+
+// unscentedFilter estimates a state from its sigma points.
+type unscentedFilter struct {
+	x          *mat64.Vector   // state
+	S          *mat64.Cholesky // square root of the covariance
+	γ          float64         // sigma point scaling
+	Wm, Wc     []float64       // mean and covariance weights
+	squareRoot bool
+}
+
+// newUnscentedFilter returns a new UKF from the initial state and covariance, and the spread of the sigma points α,
+// the prior knowledge of the distribution β (2 for Gaussians) and the secondary scaling κ.
+func newUnscentedFilter(x0 *mat64.Vector, P0 mat64.Symmetric, α, β, κ float64, squareRoot bool) (*unscentedFilter, error) {
+	n := x0.Len()
+	λ := α*α*(float64(n)+κ) - float64(n)
+	if float64(n)+λ <= 0 {
+		return nil, fmt.Errorf("invalid sigma point scaling (α=%f, κ=%f)", α, κ)
+	}
+	Wm := make([]float64, 2*n+1)
+	Wc := make([]float64, 2*n+1)
+	Wm[0] = λ / (float64(n) + λ)
+	Wc[0] = Wm[0] + 1 - α*α + β
+	for i := 1; i <= 2*n; i++ {
+		Wm[i] = 1 / (2 * (float64(n) + λ))
+		Wc[i] = Wm[i]
+	}
+	var S mat64.Cholesky
+	if !S.Factorize(P0) {
+		return nil, errors.New("initial covariance is not positive definite")
+	}
+	x := mat64.NewVector(n, nil)
+	x.CopyVec(x0)
+	return &unscentedFilter{x, &S, math.Sqrt(float64(n) + λ), Wm, Wc, squareRoot}, nil
+}
+
+// Covariance returns the covariance of the state.
+func (f *unscentedFilter) Covariance() *mat64.SymDense {
+	var P mat64.SymDense
+	P.FromCholesky(f.S)
+	return &P
+}
+
+// sigmaPoints returns the sigma points of the current state.
+func (f *unscentedFilter) sigmaPoints() []*mat64.Vector {
+	n := f.x.Len()
+	var U mat64.TriDense
+	U.UFromCholesky(f.S)
+	χ := make([]*mat64.Vector, 2*n+1)
+	χ[0] = mat64.NewVector(n, nil)
+	χ[0].CopyVec(f.x)
+	for i := 0; i < n; i++ {
+		// P = U^T*U, so the rows of U are the columns of a square root of P.
+		χ[i+1] = mat64.NewVector(n, nil)
+		χ[i+n+1] = mat64.NewVector(n, nil)
+		for j := 0; j < n; j++ {
+			χ[i+1].SetVec(j, f.x.At(j, 0)+f.γ*U.At(i, j))
+			χ[i+n+1].SetVec(j, f.x.At(j, 0)-f.γ*U.At(i, j))
+		}
+	}
+	return χ
+}
+
+// covarianceRoot returns the square root of the weighted covariance of the provided deviations from the mean, with
+// an additive noise of square root √Q (whose columns are added, may be nil).
+func (f *unscentedFilter) covarianceRoot(D []*mat64.Vector, sqrtQ mat64.Matrix) (*mat64.Cholesky, error) {
+	n := D[0].Len()
+	q := 0
+	if sqrtQ != nil {
+		_, q = sqrtQ.Dims()
+	}
+	var S mat64.Cholesky
+	if !f.squareRoot {
+		P := mat64.NewSymDense(n, nil)
+		for k, d := range D {
+			P.SymRankOne(P, f.Wc[k], d)
+		}
+		if sqrtQ != nil {
+			var Q mat64.Dense
+			Q.Mul(sqrtQ, sqrtQ.T())
+			for i := 0; i < n; i++ {
+				for j := i; j < n; j++ {
+					P.SetSym(i, j, P.At(i, j)+Q.At(i, j))
+				}
+			}
+		}
+		if !S.Factorize(P) {
+			return nil, errors.New("covariance is not positive definite")
+		}
+		return &S, nil
+	}
+	// The QR decomposition of the weighted deviations (without the central one) and of the noise leads to the
+	// triangular square root, which is then updated with the central deviation.
+	A := mat64.NewDense(len(D)-1+q, n, nil)
+	for k := 1; k < len(D); k++ {
+		for j := 0; j < n; j++ {
+			A.Set(k-1, j, math.Sqrt(f.Wc[k])*D[k].At(j, 0))
+		}
+	}
+	for k := 0; k < q; k++ {
+		for j := 0; j < n; j++ {
+			A.Set(len(D)-1+k, j, sqrtQ.At(j, k))
+		}
+	}
+	var qr mat64.QR
+	qr.Factorize(A)
+	var R mat64.Dense
+	R.RFromQR(&qr)
+	U := mat64.NewTriDense(n, true, nil)
+	for i := 0; i < n; i++ {
+		// Flip the rows with a negative diagonal, which does not change U^T*U.
+		sign := 1.
+		if R.At(i, i) < 0 {
+			sign = -1
+		}
+		for j := i; j < n; j++ {
+			U.SetTri(i, j, sign*R.At(i, j))
+		}
+	}
+	S.SetFromU(U)
+	if f.Wc[0] < 0 {
+		// The central weight is negative for α < 1, so it is a downdate.
+		var x mat64.Vector
+		x.ScaleVec(math.Sqrt(-f.Wc[0]), D[0])
+		return choleskyDowndate(&S, &x)
+	}
+	if !S.SymRankOne(&S, f.Wc[0], D[0]) {
+		return nil, errors.New("covariance square root update failed")
+	}
+	return &S, nil
+}
+
+// deviations returns the deviations of the provided points from their weighted mean, and that mean.
+func (f *unscentedFilter) deviations(points []*mat64.Vector) ([]*mat64.Vector, *mat64.Vector) {
+	mean := mat64.NewVector(points[0].Len(), nil)
+	for k, p := range points {
+		mean.AddScaledVec(mean, f.Wm[k], p)
+	}
+	D := make([]*mat64.Vector, len(points))
+	for k, p := range points {
+		D[k] = mat64.NewVector(p.Len(), nil)
+		D[k].SubVec(p, mean)
+	}
+	return D, mean
+}
+
+// Predict propagates the sigma points with the provided function and adds the process noise of square root √Q (may
+// be nil).
+func (f *unscentedFilter) Predict(propagate func(*mat64.Vector) *mat64.Vector, sqrtQ mat64.Matrix) error {
+	χ := f.sigmaPoints()
+	for k := range χ {
+		χ[k] = propagate(χ[k])
+	}
+	D, x := f.deviations(χ)
+	S, err := f.covarianceRoot(D, sqrtQ)
+	if err != nil {
+		return err
+	}
+	f.x, f.S = x, S
+	return nil
+}
+
+// innovations returns the sigma points, the deviations of their residuals from the mean residual, and the square
+// root of the covariance of the residuals, with the variance R of each row.
+func (f *unscentedFilter) innovations(residuals func(*mat64.Vector) *mat64.Vector, R []float64) (χ, E []*mat64.Vector, νMean *mat64.Vector, Sy *mat64.Cholesky, err error) {
+	χ = f.sigmaPoints()
+	ν := make([]*mat64.Vector, len(χ))
+	for k := range χ {
+		ν[k] = residuals(χ[k])
+	}
+	E, νMean = f.deviations(ν)
+	m := νMean.Len()
+	sqrtR := mat64.NewDense(m, m, nil)
+	for i, σ2 := range R {
+		sqrtR.Set(i, i, math.Sqrt(σ2))
+	}
+	if Sy, err = f.covarianceRoot(E, sqrtR); err != nil {
+		err = fmt.Errorf("innovation %s", err)
+	}
+	return
+}
+
+// Innovation returns the mean residual of the sigma points and its covariance, without updating the state.
+func (f *unscentedFilter) Innovation(residuals func(*mat64.Vector) *mat64.Vector, R []float64) (*mat64.Vector, *mat64.SymDense, error) {
+	_, _, νMean, Sy, err := f.innovations(residuals, R)
+	if err != nil {
+		return nil, nil, err
+	}
+	var Pyy mat64.SymDense
+	Pyy.FromCholesky(Sy)
+	return νMean, &Pyy, nil
+}
+
+// Update updates the state from the residuals of each sigma point, i.e. the observations minus the measurements
+// computed from that sigma point, with the variance R of each row. Returns the mean residual and its covariance.
+func (f *unscentedFilter) Update(residuals func(*mat64.Vector) *mat64.Vector, R []float64) (*mat64.Vector, *mat64.SymDense, error) {
+	χ, E, νMean, Sy, err := f.innovations(residuals, R)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := νMean.Len()
+	// The measurement deviations are the opposite of the residual deviations.
+	n := f.x.Len()
+	Pxy := mat64.NewDense(n, m, nil)
+	for k := range χ {
+		var D mat64.Vector
+		D.SubVec(χ[k], f.x)
+		var DE mat64.Dense
+		DE.Outer(-f.Wc[k], &D, E[k])
+		Pxy.Add(Pxy, &DE)
+	}
+	// K = Pxy*Pyy^-1, i.e. K^T = Pyy^-1*Pxy^T
+	var Kt mat64.Dense
+	if err := Kt.SolveCholesky(Sy, Pxy.T()); err != nil {
+		return nil, nil, fmt.Errorf("gain: %s", err)
+	}
+	var Kν mat64.Vector
+	Kν.MulVec(Kt.T(), νMean)
+	f.x.AddVec(f.x, &Kν)
+	var Pyy mat64.SymDense
+	Pyy.FromCholesky(Sy)
+	if f.squareRoot {
+		// Downdate the square root with each column of K*Sy^T.
+		var Uy mat64.TriDense
+		Uy.UFromCholesky(Sy)
+		var KSy mat64.Dense
+		KSy.Mul(Kt.T(), Uy.T())
+		for j := 0; j < m; j++ {
+			if f.S, err = choleskyDowndate(f.S, KSy.ColView(j)); err != nil {
+				return nil, nil, err
+			}
+		}
+		return νMean, &Pyy, nil
+	}
+	var KPyy, KPyyKt mat64.Dense
+	KPyy.Mul(Kt.T(), &Pyy)
+	KPyyKt.Mul(&KPyy, &Kt)
+	P := f.Covariance()
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			P.SetSym(i, j, P.At(i, j)-(KPyyKt.At(i, j)+KPyyKt.At(j, i))/2)
+		}
+	}
+	var S mat64.Cholesky
+	if !S.Factorize(P) {
+		return nil, nil, errors.New("updated covariance is not positive definite")
+	}
+	f.S = &S
+	return νMean, &Pyy, nil
+}
+
+// choleskyDowndate returns the Cholesky factorization of P-x*x^T from that of P. The downdate of Cholesky.SymRankOne
+// (with a negative alpha) is not used because it returns an invalid factorization.
+func choleskyDowndate(S *mat64.Cholesky, x *mat64.Vector) (*mat64.Cholesky, error) {
+	n := S.Size()
+	var U mat64.TriDense
+	U.UFromCholesky(S)
+	w := make([]float64, n)
+	for i := range w {
+		w[i] = x.At(i, 0)
+	}
+	for k := 0; k < n; k++ {
+		Ukk := U.At(k, k)
+		r2 := Ukk*Ukk - w[k]*w[k]
+		if r2 <= 0 {
+			return nil, errors.New("downdated covariance is not positive definite")
+		}
+		r := math.Sqrt(r2)
+		c, s := r/Ukk, w[k]/Ukk
+		U.SetTri(k, k, r)
+		for j := k + 1; j < n; j++ {
+			Ukj := (U.At(k, j) - s*w[j]) / c
+			U.SetTri(k, j, Ukj)
+			w[j] = c*w[j] - s*Ukj
+		}
+	}
+	var downdated mat64.Cholesky
+	downdated.SetFromU(&U)
+	return &downdated, nil
+}
+
+// runUKF processes the measurements with an unscented Kalman filter, whose sigma points are propagated with the
+// perturbations of the mission. The estimates are the deviations from its reference trajectory.
+func (od *OrbitDetermination) runUKF(stationRows []int, rowNames []string, noiseR *mat64.SymDense) (*ODResult, error) {
+	conf := od.Config
+	α, β, κ := conf.UKFAlpha, conf.UKFBeta, conf.UKFKappa
+	if α == 0 {
+		α, β = 1, 2
+	}
+	R0, V0 := od.Mission.Orbit.RV()
+	x0 := mat64.NewVector(6, []float64{R0[0], R0[1], R0[2], V0[0], V0[1], V0[2]})
+	ukf, err := newUnscentedFilter(x0, conf.P0, α, β, κ, conf.UKFSquareRoot)
+	if err != nil {
+		return nil, err
+	}
+	origin := od.Mission.Orbit.Origin
+	perts := od.Mission.perts
+	step := od.Mission.step
+	toOrbit := func(x *mat64.Vector) Orbit {
+		return *NewOrbitFromRV([]float64{x.At(0, 0), x.At(1, 0), x.At(2, 0)}, []float64{x.At(3, 0), x.At(4, 0), x.At(5, 0)}, origin)
+	}
+
+	stateEstChan := make(chan (State), 1)
+	od.Mission.RegisterStateChan(stateEstChan)
+	go od.Mission.PropagateUntil(od.End.Add(step), true)
+
+	result := &ODResult{RowNames: rowNames}
+	epochs := od.Measurements.Epochs
+	var prevDT, prevMeasDT time.Time
+	process := func(state State, measurements []Measurement, exists bool) error {
+		if result.Measurements == 0 && !exists {
+			return fmt.Errorf("the filter should start at the first measurement: %s (got) %s (exp)", state.DT, epochs[0])
+		}
+		predCovar := ukf.Covariance()
+		if !prevDT.IsZero() {
+			// Propagate the sigma points to this state.
+			from := prevDT
+			var sqrtQ mat64.Matrix
+			if conf.SNC && state.DT.Sub(prevMeasDT).Seconds() < conf.SNCDisableTime {
+				sqrtQ = sncNoiseRoot(conf.Q, conf.SNCRIC, toOrbit(ukf.x), state.DT.Sub(from).Seconds())
+			}
+			err := ukf.Predict(func(x *mat64.Vector) *mat64.Vector {
+				est := NewOrbitEstimate("sigma", toOrbit(x), perts, from, step)
+				est.PropagateUntil(state.DT)
+				R, V := est.Orbit.RV()
+				return mat64.NewVector(6, []float64{R[0], R[1], R[2], V[0], V[1], V[2]})
+			}, sqrtQ)
+			if err != nil {
+				return fmt.Errorf("prediction #%05d: %s", result.Measurements, err)
+			}
+			predCovar = ukf.Covariance()
+		}
+		prevDT = state.DT
+		odEst := ODEstimate{DT: state.DT, Orbit: state.Orbit}
+		var residual, innov, meas *mat64.Vector
+		if exists {
+			// Stack the rows of the visible measurements.
+			type row struct {
+				pos      int // position in the residuals
+				measType MeasurementType
+				obs      float64
+				station  Station
+			}
+			var rows []row
+			var R []float64
+			for measPos, measurement := range measurements {
+				if !measurement.Visible {
+					continue
+				}
+				observed := measurement.StateVector()
+				for i, measType := range measurement.MeasurementTypes() {
+					rows = append(rows, row{stationRows[measPos] + i, measType, observed.At(i, 0), measurement.Station})
+					R = append(R, noiseR.At(stationRows[measPos]+i, stationRows[measPos]+i))
+				}
+			}
+			residualsOf := func(rows []row) func(x *mat64.Vector) *mat64.Vector {
+				return func(x *mat64.Vector) *mat64.Vector {
+					o := toOrbit(x)
+					ν := mat64.NewVector(len(rows), nil)
+					for i, r := range rows {
+						computed, _, _ := r.station.Observe(r.measType, state.DT, o)
+						// The angles are wrapped so that the difference with the observation is the smallest one.
+						ν.SetVec(i, r.measType.Difference(r.obs, computed))
+					}
+					return ν
+				}
+			}
+			// Pre-fit residuals of the predicted sigma points, and editing of the outliers.
+			νPrior, Pyy, err := ukf.Innovation(residualsOf(rows), R)
+			if err != nil {
+				return fmt.Errorf("update #%05d: %s", result.Measurements, err)
+			}
+			status := make([]ODRowStatus, len(rows))
+			for i := range status {
+				status[i] = RowAccepted
+			}
+			νσ := editInnovations(νPrior, Pyy, status, conf.EditSigma)
+			if odEst.NIS, err = normalizedInnovation(νPrior, Pyy, status); err != nil {
+				return fmt.Errorf("update #%05d: %s", result.Measurements, err)
+			}
+			var accepted []row
+			var acceptedR []float64
+			for i, r := range rows {
+				if status[i] == RowAccepted {
+					accepted = append(accepted, r)
+					acceptedR = append(acceptedR, R[i])
+				}
+			}
+			if count := len(rows) - len(accepted); count > 0 {
+				log.Printf("[WARNING] #%05d %d measurement(s) rejected\n", result.Measurements, count)
+				result.Rejected += count
+			}
+			νMean := mat64.NewVector(len(rows), nil)
+			if len(accepted) > 0 {
+				νAccepted, _, err := ukf.Update(residualsOf(accepted), acceptedR)
+				if err != nil {
+					return fmt.Errorf("update #%05d: %s", result.Measurements, err)
+				}
+				for i, j := 0, 0; i < len(rows); i++ {
+					if status[i] == RowAccepted {
+						νMean.SetVec(i, νAccepted.At(j, 0))
+						j++
+					}
+				}
+			}
+			// Post-fit residuals (including those of the rejected rows)
+			νPost := residualsOf(rows)(ukf.x)
+			residual = mat64.NewVector(len(rowNames), nil)
+			innov = mat64.NewVector(len(rowNames), nil)
+			meas = mat64.NewVector(len(rowNames), nil)
+			odEst.Prefit = mat64.NewVector(len(rowNames), nil)
+			odEst.PrefitSigma = mat64.NewVector(len(rowNames), nil)
+			odEst.Rows = make([]ODRowStatus, len(rowNames))
+			for i, r := range rows {
+				residual.SetVec(r.pos, νPost.At(i, 0))
+				innov.SetVec(r.pos, νMean.At(i, 0))
+				meas.SetVec(r.pos, r.obs)
+				odEst.Prefit.SetVec(r.pos, νPrior.At(i, 0))
+				odEst.PrefitSigma.SetVec(r.pos, νσ.At(i, 0))
+				odEst.Rows[r.pos] = status[i]
+			}
+			for _, measurement := range measurements {
+				if measurement.Visible && !measurement.Station.PerformMeasurement(state.DT, State{DT: state.DT, Orbit: toOrbit(ukf.x)}).Visible {
+					result.VisibilityErrors++
+				}
+			}
+			prevMeasDT = state.DT
+			result.Measurements++
+		}
+		// The estimate is the deviation from the reference trajectory.
+		Rref, Vref := state.Orbit.RV()
+		dev := mat64.NewVector(6, nil)
+		for i := 0; i < 3; i++ {
+			dev.SetVec(i, ukf.x.At(i, 0)-Rref[i])
+			dev.SetVec(i+3, ukf.x.At(i+3, 0)-Vref[i])
+		}
+		odEst.Estimate = odEstimate{dev, meas, innov, ukf.Covariance(), predCovar}
+		odEst.Residual = residual
+		result.Estimates = append(result.Estimates, odEst)
+		return nil
+	}
+
+	for state := range stateEstChan {
+		measurements, exists := od.Measurements.At(state.DT.Truncate(time.Second))
+		// After an error, the remaining states are only consumed so that the propagation ends.
+		if err == nil {
+			err = process(state, measurements, exists)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// sncNoiseRoot returns the square root of the discrete SNC noise over Δt, i.e. Γ*√Q where Q is the diagonal SNC noise,
+// defined in the RIC frame of the orbit if ric is set.
+func sncNoiseRoot(Q mat64.Symmetric, ric bool, o Orbit, Δt float64) *mat64.Dense {
+	sqrtQ := mat64.NewDense(3, 3, nil)
+	for i := 0; i < 3; i++ {
+		sqrtQ.Set(i, i, math.Sqrt(Q.At(i, i)))
+	}
+	if ric {
+		var rotated mat64.Dense
+		rotated.Mul(LocalFrameDCM(o, RIC).T(), sqrtQ)
+		sqrtQ = &rotated
+	}
+	Γ := mat64.NewDense(6, 3, nil)
+	Γ.Stack(ScaledDenseIdentity(3, Δt*Δt/2), ScaledDenseIdentity(3, Δt))
+	var ΓsqrtQ mat64.Dense
+	ΓsqrtQ.Mul(Γ, sqrtQ)
+	return &ΓsqrtQ
+}
@@ -0,0 +1,83 @@
+package smd
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestUnscentedFilter(t *testing.T) {
+	// A linear system must lead to the same estimate as the Kalman filter, with either variant.
+	P0 := mat64.NewSymDense(2, []float64{4, 1, 1, 2})
+	for _, squareRoot := range []bool{false, true} {
+		ukf, err := newUnscentedFilter(mat64.NewVector(2, []float64{1, -1}), P0, 1, 2, 0, squareRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// x+ = [1 1; 0 1]*x with Q = diag(0.25, 0)
+		sqrtQ := mat64.NewDense(2, 1, []float64{0.5, 0})
+		if err = ukf.Predict(func(x *mat64.Vector) *mat64.Vector {
+			return mat64.NewVector(2, []float64{x.At(0, 0) + x.At(1, 0), x.At(1, 0)})
+		}, sqrtQ); err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(ukf.x.At(0, 0)) > 1e-12 || math.Abs(ukf.Covariance().At(0, 0)-8.25) > 1e-12 || math.Abs(ukf.Covariance().At(0, 1)-3) > 1e-12 {
+			t.Fatalf("invalid prediction (squareRoot=%t): %v %v", squareRoot, ukf.x.RawVector().Data, ukf.Covariance())
+		}
+		// y = x0 = 2 with R = 0.75: K = [8.25 3]/9
+		ν, Pyy, err := ukf.Update(func(x *mat64.Vector) *mat64.Vector {
+			return mat64.NewVector(1, []float64{2 - x.At(0, 0)})
+		}, []float64{0.75})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(ν.At(0, 0)-2) > 1e-12 || math.Abs(Pyy.At(0, 0)-9) > 1e-12 {
+			t.Fatalf("invalid innovation (squareRoot=%t): %f %f", squareRoot, ν.At(0, 0), Pyy.At(0, 0))
+		}
+		P := ukf.Covariance()
+		if math.Abs(ukf.x.At(0, 0)-2*8.25/9) > 1e-12 || math.Abs(ukf.x.At(1, 0)+1-2*3./9) > 1e-12 {
+			t.Fatalf("invalid update (squareRoot=%t): %v", squareRoot, ukf.x.RawVector().Data)
+		}
+		if math.Abs(P.At(0, 0)-(8.25-8.25*8.25/9)) > 1e-12 || math.Abs(P.At(1, 1)-(2-3*3./9)) > 1e-12 {
+			t.Fatalf("invalid covariance (squareRoot=%t): %v", squareRoot, P)
+		}
+	}
+	// With α < 1, the central weight of the covariance is negative. Both variants must lead to the same covariance,
+	// and to the variance of the square of a Gaussian for y = x0^2 (i.e. 2*P00^2 for a zero mean).
+	var covariances []*mat64.SymDense
+	for _, squareRoot := range []bool{false, true} {
+		ukf, err := newUnscentedFilter(mat64.NewVector(2, []float64{1, -1}), P0, 1e-3, 2, 0, squareRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ukf.Wc[0] >= 0 {
+			t.Fatalf("central weight %f is not negative", ukf.Wc[0])
+		}
+		sqrtQ := mat64.NewDense(2, 1, []float64{0.5, 0})
+		if err = ukf.Predict(func(x *mat64.Vector) *mat64.Vector {
+			return mat64.NewVector(2, []float64{x.At(0, 0) + x.At(1, 0), x.At(1, 0)})
+		}, sqrtQ); err != nil {
+			t.Fatalf("squareRoot=%t: %s", squareRoot, err)
+		}
+		if math.Abs(ukf.x.At(0, 0)) > 1e-6 || math.Abs(ukf.Covariance().At(0, 0)-8.25) > 1e-6 || math.Abs(ukf.Covariance().At(0, 1)-3) > 1e-6 {
+			t.Fatalf("invalid prediction (squareRoot=%t): %v %v", squareRoot, ukf.x.RawVector().Data, ukf.Covariance())
+		}
+		ν, Pyy, err := ukf.Update(func(x *mat64.Vector) *mat64.Vector {
+			return mat64.NewVector(1, []float64{2 - x.At(0, 0)*x.At(0, 0)})
+		}, []float64{0.75})
+		if err != nil {
+			t.Fatalf("squareRoot=%t: %s", squareRoot, err)
+		}
+		if math.Abs(ν.At(0, 0)-(2-8.25)) > 1e-6 || math.Abs(Pyy.At(0, 0)-(2*8.25*8.25+0.75)) > 1e-3 {
+			t.Fatalf("invalid innovation (squareRoot=%t): %f %f", squareRoot, ν.At(0, 0), Pyy.At(0, 0))
+		}
+		covariances = append(covariances, ukf.Covariance())
+	}
+	if !mat64.EqualApprox(covariances[0], covariances[1], 1e-6) {
+		t.Fatalf("square root covariance differs:\n%v\n%v", mat64.Formatted(covariances[0]), mat64.Formatted(covariances[1]))
+	}
+	if _, err := newUnscentedFilter(mat64.NewVector(2, nil), P0, 1e-3, 2, -2, false); err == nil {
+		t.Fatal("invalid scaling accepted")
+	}
+}
@@ -197,3 +197,17 @@ func TestToHyperbolic(t *testing.T) {
 		t.Fatal("cleared was false for hyperbolic orbit")
 	}
 }
+
+func TestOrbitTargetMean(t *testing.T) {
+	// Same mean orbit at two different anomalies: the J2 short period terms change the osculating elements.
+	target := NewOrbitFromMeanOE(7000, 0.01, 50, 20, 30, 0, Earth)
+	o := NewOrbitFromMeanOE(7000, 0.01, 50, 20, 30, 90, Earth)
+	for _, osculating := range []bool{false, true} {
+		wp := NewOrbitTarget(*target, nil, Ruggiero, OptiΔaCL)
+		wp.SetEpsilons(1, 1e-4, Deg2rad(0.01))
+		wp.SetOsculating(osculating)
+		if _, reached := wp.ThrustDirection(*o, time.Unix(0, 0)); reached == osculating {
+			t.Fatalf("target reached=%t with osculating=%t", reached, osculating)
+		}
+	}
+}
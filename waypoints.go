@@ -139,12 +139,14 @@ func NewReachDistance(distance float64, further bool, action *WaypointAction) *R
 }
 
 // OrbitTarget allows to target an orbit.
+// The target is reached when the mean elements (cf. MeanEqualsWithin) match, unless SetOsculating is used.
 type OrbitTarget struct {
-	target  Orbit
-	ctrl    *OptimalΔOrbit
-	action  *WaypointAction
-	xprt    *ThurstAngleExport
-	cleared bool
+	target     Orbit
+	ctrl       *OptimalΔOrbit
+	action     *WaypointAction
+	xprt       *ThurstAngleExport
+	osculating bool
+	cleared    bool
 }
 
 // String implements the Waypoint interface.
@@ -170,9 +172,19 @@ func (wp *OrbitTarget) SetEpsilons(distanceε, eccentricityε, angleε float64)
 	wp.ctrl.SetEpsilons(distanceε, eccentricityε, angleε)
 }
 
+// SetOsculating allows to compare the osculating elements to the target instead of the mean ones, e.g. if the
+// mission does not include the J2 perturbation.
+func (wp *OrbitTarget) SetOsculating(osculating bool) {
+	wp.osculating = osculating
+}
+
 // ThrustDirection implements the optimal orbit target.
 func (wp *OrbitTarget) ThrustDirection(o Orbit, dt time.Time) (ThrustControl, bool) {
-	if ok, err := wp.target.EqualsWithin(o, wp.ctrl.Distanceε, wp.ctrl.Eccentricityε, wp.ctrl.Angleε); ok {
+	equalsWithin := wp.target.MeanEqualsWithin
+	if wp.osculating {
+		equalsWithin = wp.target.EqualsWithin
+	}
+	if ok, err := equalsWithin(o, wp.ctrl.Distanceε, wp.ctrl.Eccentricityε, wp.ctrl.Angleε); ok {
 		wp.cleared = true
 	} else if wp.ctrl.cleared {
 		fmt.Printf("[WARNING] OrbitTarget reached @%s *but* %s: %s\n", dt, err, o.String())
@@ -195,7 +207,7 @@ func NewOrbitTarget(target Orbit, action *WaypointAction, meth ControlLawType, l
 	if target.Periapsis() < target.Origin.Radius || target.Apoapsis() < target.Origin.Radius {
 		fmt.Printf("[WARNING] Target orbit on collision course with %s\n", target.Origin)
 	}
-	return &OrbitTarget{target, NewOptimalΔOrbit(target, meth, laws), action, nil, false}
+	return &OrbitTarget{target, NewOptimalΔOrbit(target, meth, laws), action, nil, false, false}
 }
 
 // HohmannTransfer allows to perform an Hohmann transfer.